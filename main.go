@@ -13,7 +13,8 @@ var version string = "NONE"
 func main() {
 	cmd.Version = version
 	if os.Geteuid() == 0 {
-		fmt.Printf(`WARNING: Running as root.
+		// Warnings go to stderr so they don't corrupt machine-readable output.
+		fmt.Fprintf(os.Stderr, `WARNING: Running as root.
 The root user does not respect read-only files. You can (and eventually will)
 accidentally corrupt your Dud cache by overwriting an artifact linked to the
 cache. Please consider running as a non-root user.
@@ -28,6 +28,22 @@ type Artifact struct {
 	// the Artifact is committed, its checksum is updated, but the Artifact is
 	// not moved to the Cache. The checkout operation is a no-op.
 	SkipCache bool `yaml:"skip-cache,omitempty" json:"skip-cache,omitempty"`
+	// If Persist is true then the Artifact is not deleted before its Stage
+	// runs. Instead, any links to the Cache are replaced with writable copies,
+	// so the Stage's command can update the Artifact incrementally. Only
+	// applies to Stage outputs.
+	Persist bool `yaml:",omitempty" json:"persist,omitempty"`
+	// If Optional is true then the Artifact may not exist. Committing an
+	// absent optional Artifact sets Absent instead of failing. Only applies to
+	// Stage outputs.
+	Optional bool `yaml:",omitempty" json:"optional,omitempty"`
+	// Absent is true if the Artifact is optional and did not exist when it was
+	// last committed. Absent Artifacts have no checksum.
+	Absent bool `yaml:",omitempty" json:"absent,omitempty"`
+	// Validate is a shell command that checks the Artifact after its Stage's
+	// command runs. If the command fails, the Artifact is rejected and the
+	// Stage isn't committed. Only applies to Stage outputs.
+	Validate string `yaml:",omitempty" json:"validate,omitempty"`
 }
 
 type oldArtifact struct {
@@ -36,6 +52,10 @@ type oldArtifact struct {
 	IsDir            bool
 	DisableRecursion bool
 	SkipCache        bool
+	Persist          bool
+	Optional         bool
+	Absent           bool
+	Validate         string
 }
 
 // UnmarshalJSON enables backwards-compatibility with the original Artifact
@@ -136,6 +156,9 @@ func (stat Status) String() string {
 	}
 	switch stat.WorkspaceFileStatus {
 	case fsutil.StatusAbsent:
+		if stat.Absent {
+			return "absent (optional)"
+		}
 		if stat.HasChecksum {
 			if stat.ChecksumInCache {
 				return "missing from workspace"
@@ -240,6 +240,23 @@ func TestArtifactStatusString(t *testing.T) {
 		}
 	})
 
+	t.Run("optional artifact committed as absent", func(t *testing.T) {
+		status := Status{
+			Artifact:            Artifact{Optional: true, Absent: true},
+			WorkspaceFileStatus: fsutil.StatusAbsent,
+			HasChecksum:         false,
+			ChecksumInCache:     false,
+			ContentsMatch:       true,
+		}
+
+		want := "absent (optional)"
+
+		got := status.String()
+		if got != want {
+			t.Fatalf("Status.String() got %#v, want %#v", got, want)
+		}
+	})
+
 	t.Run("directory but SkipCache true", func(t *testing.T) {
 		status := Status{
 			Artifact:            Artifact{SkipCache: true, IsDir: true},
@@ -0,0 +1,120 @@
+package artifact
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kevin-hanselman/dud/src/fsutil"
+)
+
+// FileChangeStates lists the possible states of a FileChange.
+var FileChangeStates = []string{"added", "removed", "modified", "type-changed"}
+
+// A FileChange describes a file or directory inside a directory Artifact that
+// differs from the committed version of the Artifact.
+type FileChange struct {
+	// Path is the path of the changed file, relative to the project root. If
+	// the path is a directory summarizing changes below a depth limit (see
+	// Status.ChangedFiles), it ends with a path separator.
+	Path string `json:"path"`
+	// State is one of FileChangeStates.
+	State string `json:"state"`
+}
+
+// A FileDiff is a FileChange annotated with the sizes of the file before and
+// after the change. For directories, sizes are the total size of all files
+// inside the directory.
+type FileDiff struct {
+	FileChange
+	// OldSize is the committed size of the file in bytes. It is zero for added
+	// files and -1 if unknown (e.g. the file isn't in the cache).
+	OldSize int64 `json:"old-size"`
+	// NewSize is the current size of the file in bytes. It is zero for
+	// removed files and -1 if unknown.
+	NewSize int64 `json:"new-size"`
+}
+
+// ChangedFiles returns the files inside a directory Artifact that were added,
+// removed, modified, or changed type (e.g. a file replaced by a directory),
+// sorted by path. It relies on a fully-populated ChildrenStatus tree (i.e.
+// from a Status call without short-circuiting). If states is not empty, only
+// changes in the given states are returned. If maxDepth is greater than zero,
+// changes nested more than maxDepth levels inside the Artifact are collapsed
+// into their ancestor directory at maxDepth. A collapsed directory takes the
+// state of its changes if they all share the same state, and is "modified"
+// otherwise.
+func (stat Status) ChangedFiles(maxDepth int, states map[string]bool) []FileChange {
+	var changes []FileChange
+	for name, child := range stat.ChildrenStatus {
+		walkChanges(filepath.Join(stat.Path, name), *child, func(path, state string, _ Status) {
+			changes = append(changes, FileChange{Path: path, State: state})
+		})
+	}
+
+	collapsed := make(map[string]string)
+	for _, change := range changes {
+		if len(states) > 0 && !states[change.State] {
+			continue
+		}
+		path := change.Path
+		if maxDepth > 0 {
+			relPath := strings.TrimPrefix(change.Path, stat.Path+string(filepath.Separator))
+			parts := strings.Split(relPath, string(filepath.Separator))
+			if len(parts) > maxDepth {
+				path = filepath.Join(stat.Path, filepath.Join(parts[:maxDepth]...)) +
+					string(filepath.Separator)
+			}
+		}
+		if state, ok := collapsed[path]; ok && state != change.State {
+			collapsed[path] = "modified"
+		} else {
+			collapsed[path] = change.State
+		}
+	}
+
+	out := make([]FileChange, 0, len(collapsed))
+	for path, state := range collapsed {
+		out = append(out, FileChange{Path: path, State: state})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Path < out[j].Path
+	})
+	return out
+}
+
+// WalkChanges calls visit for every changed file in the Artifact, including
+// the Artifact itself if it isn't a directory (or is no longer one). Changed
+// directories are descended into, unless they were removed or changed type,
+// in which case visit is called for the directory itself. Like ChangedFiles,
+// WalkChanges relies on a fully-populated ChildrenStatus tree. The path passed
+// to visit is relative to the project root, and the Status is that of the
+// changed file; its Artifact holds the committed state of the file, if any.
+func (stat Status) WalkChanges(visit func(path, state string, status Status)) {
+	walkChanges(stat.Path, stat, visit)
+}
+
+func walkChanges(path string, stat Status, visit func(path, state string, status Status)) {
+	isDir := stat.WorkspaceFileStatus == fsutil.StatusDirectory
+	var state string
+	switch {
+	case stat.ContentsMatch:
+		return
+	case stat.WorkspaceFileStatus == fsutil.StatusAbsent:
+		state = "removed"
+	case stat.HasChecksum && stat.IsDir != isDir:
+		state = "type-changed"
+	case isDir:
+		// Recurse into modified or untracked sub-directories to find the
+		// files that changed.
+		for name, child := range stat.ChildrenStatus {
+			walkChanges(filepath.Join(path, name), *child, visit)
+		}
+		return
+	case !stat.HasChecksum:
+		state = "added"
+	default:
+		state = "modified"
+	}
+	visit(path, state, stat)
+}
@@ -0,0 +1,143 @@
+package artifact
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/fsutil"
+)
+
+func TestChangedFiles(t *testing.T) {
+	upToDate := func(name string) *Status {
+		return &Status{
+			Artifact:            Artifact{Path: name},
+			WorkspaceFileStatus: fsutil.StatusLink,
+			HasChecksum:         true,
+			ChecksumInCache:     true,
+			ContentsMatch:       true,
+		}
+	}
+	modified := func(name string) *Status {
+		return &Status{
+			Artifact:            Artifact{Path: name},
+			WorkspaceFileStatus: fsutil.StatusRegularFile,
+			HasChecksum:         true,
+			ChecksumInCache:     true,
+		}
+	}
+	added := func(name string) *Status {
+		return &Status{
+			Artifact:            Artifact{Path: name},
+			WorkspaceFileStatus: fsutil.StatusRegularFile,
+		}
+	}
+
+	// data/
+	// ├── a.csv        up-to-date
+	// ├── b.csv        modified
+	// ├── c.csv        removed
+	// ├── d            type-changed (file replaced by a directory)
+	// ├── new/         untracked directory
+	// │   └── x.csv    added
+	// └── sub/
+	//     ├── e.csv    modified
+	//     └── deep/
+	//         └── f.csv  added
+	status := Status{
+		Artifact:            Artifact{Path: "data", IsDir: true},
+		WorkspaceFileStatus: fsutil.StatusDirectory,
+		HasChecksum:         true,
+		ChecksumInCache:     true,
+		ChildrenStatus: map[string]*Status{
+			"a.csv": upToDate("a.csv"),
+			"b.csv": modified("b.csv"),
+			"c.csv": {
+				Artifact:            Artifact{Path: "c.csv"},
+				WorkspaceFileStatus: fsutil.StatusAbsent,
+				HasChecksum:         true,
+				ChecksumInCache:     true,
+			},
+			"d": {
+				Artifact:            Artifact{Path: "d"},
+				WorkspaceFileStatus: fsutil.StatusDirectory,
+				HasChecksum:         true,
+				ChecksumInCache:     true,
+			},
+			"new": {
+				Artifact:            Artifact{Path: "new", IsDir: true},
+				WorkspaceFileStatus: fsutil.StatusDirectory,
+				ChildrenStatus: map[string]*Status{
+					"x.csv": added("x.csv"),
+				},
+			},
+			"sub": {
+				Artifact:            Artifact{Path: "sub", IsDir: true},
+				WorkspaceFileStatus: fsutil.StatusDirectory,
+				HasChecksum:         true,
+				ChecksumInCache:     true,
+				ChildrenStatus: map[string]*Status{
+					"e.csv": modified("e.csv"),
+					"deep": {
+						Artifact:            Artifact{Path: "deep", IsDir: true},
+						WorkspaceFileStatus: fsutil.StatusDirectory,
+						ChildrenStatus: map[string]*Status{
+							"f.csv": added("f.csv"),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	tests := map[string]struct {
+		maxDepth int
+		states   map[string]bool
+		want     []FileChange
+	}{
+		"all changes": {
+			want: []FileChange{
+				{Path: "data/b.csv", State: "modified"},
+				{Path: "data/c.csv", State: "removed"},
+				{Path: "data/d", State: "type-changed"},
+				{Path: "data/new/x.csv", State: "added"},
+				{Path: "data/sub/deep/f.csv", State: "added"},
+				{Path: "data/sub/e.csv", State: "modified"},
+			},
+		},
+		"filtered by state": {
+			states: map[string]bool{"added": true, "removed": true},
+			want: []FileChange{
+				{Path: "data/c.csv", State: "removed"},
+				{Path: "data/new/x.csv", State: "added"},
+				{Path: "data/sub/deep/f.csv", State: "added"},
+			},
+		},
+		"depth limit collapses directories": {
+			maxDepth: 1,
+			want: []FileChange{
+				{Path: "data/b.csv", State: "modified"},
+				{Path: "data/c.csv", State: "removed"},
+				{Path: "data/d", State: "type-changed"},
+				{Path: "data/new/", State: "added"},
+				{Path: "data/sub/", State: "modified"},
+			},
+		},
+		"state filter applies before depth limit": {
+			maxDepth: 2,
+			states:   map[string]bool{"added": true},
+			want: []FileChange{
+				{Path: "data/new/x.csv", State: "added"},
+				{Path: "data/sub/deep/", State: "added"},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := status.ChangedFiles(test.maxDepth, test.states)
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Fatalf("ChangedFiles -want +got:\n%s", diff)
+			}
+		})
+	}
+}
@@ -7,11 +7,13 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/cheggaaa/pb/v3"
 	"github.com/kevin-hanselman/dud/src/agglog"
 	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/fsutil"
 	"github.com/kevin-hanselman/dud/src/strategy"
 	"github.com/mattn/go-isatty"
 )
@@ -64,6 +66,15 @@ type Cache interface {
 	Status(workDir string, art artifact.Artifact, shortCircuit bool) (artifact.Status, error)
 	Fetch(remoteSrc string, arts map[string]*artifact.Artifact) error
 	Push(remoteDst string, arts map[string]*artifact.Artifact) error
+	Verify(remoteDst string, arts map[string]*artifact.Artifact) error
+	VerifyLocal(arts map[string]*artifact.Artifact) error
+	ResolveChild(dirArt artifact.Artifact, path string) (artifact.Artifact, error)
+	DirFiles(dirArt artifact.Artifact) (map[string]artifact.Artifact, error)
+	Diff(workDir string, art artifact.Artifact) ([]artifact.FileDiff, error)
+	DiffCommitted(oldArt, newArt *artifact.Artifact) ([]artifact.FileDiff, error)
+	FetchManifests(remoteSrc string, arts map[string]*artifact.Artifact) error
+	CommitObject(data []byte) (string, error)
+	ReadObject(checksum string) ([]byte, error)
 }
 
 // A LocalCache is a Cache that uses a directory on a local filesystem.
@@ -90,6 +101,34 @@ func (ch LocalCache) PathForChecksum(checksum string) (string, error) {
 	return filepath.Join(checksum[:2], checksum[2:]), nil
 }
 
+// checksumForLink returns the checksum of the cached object a workspace link
+// points to. If the link does not point to an object in the cache, ok is false.
+func (ch LocalCache) checksumForLink(linkPath string) (cksum string, ok bool, err error) {
+	target, err := os.Readlink(linkPath)
+	if err != nil {
+		return
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(linkPath), target)
+	}
+	if target, err = filepath.Abs(target); err != nil {
+		return
+	}
+	relPath, err := filepath.Rel(ch.dir, target)
+	if err != nil {
+		return "", false, nil
+	}
+	prefix, rest := filepath.Split(relPath)
+	if len(prefix) != 3 || len(rest) < 1 || strings.Contains(prefix[:2], "..") {
+		return "", false, nil
+	}
+	exists, err := fsutil.Exists(target, false)
+	if err != nil || !exists {
+		return "", false, err
+	}
+	return prefix[:2] + rest, true, nil
+}
+
 type directoryManifest struct {
 	Path     string                        `json:"path,"`
 	Contents map[string]*artifact.Artifact `json:"contents,"`
@@ -106,6 +145,22 @@ func readDirManifest(path string) (man directoryManifest, err error) {
 	return
 }
 
+// loadManifest reads the manifest of a committed directory Artifact from the
+// cache.
+func (ch LocalCache) loadManifest(dirArt artifact.Artifact) (man directoryManifest, err error) {
+	status, cachePath, _, err := checksumStatus(ch, dirArt)
+	if err != nil {
+		return man, err
+	}
+	if !status.HasChecksum {
+		return man, InvalidChecksumError{dirArt.Checksum}
+	}
+	if !status.ChecksumInCache {
+		return man, MissingFromCacheError{dirArt.Checksum}
+	}
+	return readDirManifest(filepath.Join(ch.dir, cachePath))
+}
+
 // InvalidChecksumError is an error case where a valid checksum was expected
 // but not found.
 type InvalidChecksumError struct {
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"os"
 	"path/filepath"
 	"testing"
 )
@@ -38,3 +39,67 @@ func TestPathForChecksum(t *testing.T) {
 		}
 	})
 }
+
+func TestChecksumForLinkIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+	rootDir := t.TempDir()
+	ch, err := NewLocalCache(filepath.Join(rootDir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cachedPath := filepath.Join(rootDir, "cache", "12", "3456789")
+	if err := os.MkdirAll(filepath.Dir(cachedPath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cachedPath, []byte("foo"), cacheFilePerms); err != nil {
+		t.Fatal(err)
+	}
+	otherPath := filepath.Join(rootDir, "other.txt")
+	if err := os.WriteFile(otherPath, []byte("foo"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		target string
+		want   string
+		wantOk bool
+	}{
+		"relative link into cache": {
+			target: filepath.Join("..", "cache", "12", "3456789"),
+			want:   "123456789",
+			wantOk: true,
+		},
+		"absolute link into cache": {
+			target: cachedPath,
+			want:   "123456789",
+			wantOk: true,
+		},
+		"link outside cache": {
+			target: otherPath,
+		},
+		"dangling link into cache": {
+			target: filepath.Join(rootDir, "cache", "ab", "cdef"),
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			linkPath := filepath.Join(rootDir, "workspace", "link")
+			if err := os.MkdirAll(filepath.Dir(linkPath), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(linkPath)
+			if err := os.Symlink(test.target, linkPath); err != nil {
+				t.Fatal(err)
+			}
+			got, ok, err := ch.checksumForLink(linkPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want || ok != test.wantOk {
+				t.Fatalf("checksumForLink() = %#v, %v, want %#v, %v", got, ok, test.want, test.wantOk)
+			}
+		})
+	}
+}
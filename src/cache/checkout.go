@@ -24,7 +24,7 @@ func (cache LocalCache) Checkout(
 	strat strategy.CheckoutStrategy,
 	progress *pb.ProgressBar,
 ) (err error) {
-	if art.SkipCache {
+	if art.SkipCache || art.Absent {
 		return
 	}
 	if progress == nil {
@@ -74,6 +74,19 @@ func checkoutFile(
 	if err := os.MkdirAll(filepath.Dir(workPath), 0o755); err != nil {
 		return err
 	}
+	// A link to another version of the file in the cache can be replaced
+	// without losing anything, e.g. when checking out a different git branch.
+	if !status.ContentsMatch && status.WorkspaceFileStatus == fsutil.StatusLink {
+		_, ok, err := ch.checksumForLink(workPath)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if err := os.Remove(workPath); err != nil {
+				return err
+			}
+		}
+	}
 	cachePath = filepath.Join(ch.dir, cachePath)
 	switch strat {
 	case strategy.CopyStrategy:
@@ -3,8 +3,10 @@ package cache
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/kevin-hanselman/dud/src/agglog"
 	"github.com/kevin-hanselman/dud/src/artifact"
 	"github.com/kevin-hanselman/dud/src/fsutil"
 	"github.com/kevin-hanselman/dud/src/strategy"
@@ -249,3 +251,51 @@ func assertErrorMatches(t *testing.T, want, got error) {
 		}
 	}
 }
+
+func TestCheckoutReplacesStaleCacheLinkIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	for _, strat := range []strategy.CheckoutStrategy{strategy.LinkStrategy, strategy.CopyStrategy} {
+		t.Run(strat.String(), func(t *testing.T) {
+			cache, err := NewLocalCache(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			workDir := t.TempDir()
+			workPath := filepath.Join(workDir, "data.txt")
+
+			commitVersion := func(contents string) artifact.Artifact {
+				if err := os.WriteFile(workPath, []byte(contents), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				art := artifact.Artifact{Path: "data.txt"}
+				err := cache.Commit(workDir, &art, strategy.CopyStrategy, agglog.NewNullLogger())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Remove(workPath); err != nil {
+					t.Fatal(err)
+				}
+				return art
+			}
+			oldArt := commitVersion("old")
+			newArt := commitVersion("new")
+
+			if err := cache.Checkout(workDir, oldArt, strategy.LinkStrategy, nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := cache.Checkout(workDir, newArt, strat, nil); err != nil {
+				t.Fatal(err)
+			}
+			contents, err := os.ReadFile(workPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(contents) != "new" {
+				t.Fatalf("got contents %#v, want %#v", string(contents), "new")
+			}
+		})
+	}
+}
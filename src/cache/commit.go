@@ -7,7 +7,6 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-	"fmt"
 
 	"github.com/cheggaaa/pb/v3"
 	"github.com/kevin-hanselman/dud/src/agglog"
@@ -20,13 +19,26 @@ import (
 )
 
 // Commit calculates the checksum of the artifact, moves it to the cache, then
-// performs a checkout.
+// performs a checkout. If the artifact is optional and doesn't exist in the
+// workspace, Commit marks it as absent and clears its checksum.
 func (ch LocalCache) Commit(
 	workspaceDir string,
 	art *artifact.Artifact,
 	strat strategy.CheckoutStrategy,
 	logger *agglog.AggLogger,
 ) (err error) {
+	if art.Optional {
+		_, err := os.Lstat(filepath.Join(workspaceDir, art.Path))
+		if os.IsNotExist(err) {
+			art.Checksum = ""
+			art.Absent = true
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "commit %s", art.Path)
+		}
+	}
+	art.Absent = false
 	if err := os.MkdirAll(ch.dir, 0o755); err != nil {
 		return errors.Wrapf(err, "commit %s", art.Path)
 	}
@@ -91,6 +103,29 @@ var canRenameFileBetweenDirs = func(srcDir, dstDir string) (bool, error) {
 	return renameErr == nil, nil
 }
 
+// checksumLinkTarget sets the artifact's checksum to that of the file the link
+// at linkPath points to.
+func checksumLinkTarget(linkPath string, art *artifact.Artifact) error {
+	targetPath, err := os.Readlink(linkPath)
+	if err != nil {
+		return errors.Wrapf(err, "%s: could not read symlink for commit", linkPath)
+	}
+	if !filepath.IsAbs(targetPath) {
+		targetPath = filepath.Join(filepath.Dir(linkPath), targetPath)
+	}
+	f, err := os.Open(targetPath)
+	if err != nil {
+		return errors.Wrapf(err, "%s: could not open symlink target for checksum", targetPath)
+	}
+	defer f.Close()
+	cksum, err := checksum.Checksum(f)
+	if err != nil {
+		return errors.Wrapf(err, "%s: could not checksum link target", targetPath)
+	}
+	art.Checksum = cksum
+	return nil
+}
+
 func commitFileArtifact(
 	ch LocalCache,
 	workspaceDir string,
@@ -110,34 +145,26 @@ func commitFileArtifact(
 	if status.ContentsMatch {
 		return nil
 	}
-	// Accept regular file OR an up-to-date link (points to the right cache target)
-
-	if status.WorkspaceFileStatus == fsutil.StatusLink && !status.ContentsMatch {
-		// Try to follow the symlink and compute the checksum from the target file
-		fmt.Printf("link points to: %s, expected checksum: %s, status.ContentsMatch: %v\n", workPath, art.Checksum, status.ContentsMatch)
-		targetPath, err := os.Readlink(workPath)
+	if status.WorkspaceFileStatus == fsutil.StatusLink {
+		// A link into the cache (e.g. an output committed by 'dud run' whose
+		// checksum was never recorded) already names its contents.
+		cksum, ok, err := ch.checksumForLink(workPath)
 		if err != nil {
-			return errors.Wrapf(err, "%s: could not read symlink for commit", workPath)
-		}
-		// If targetPath is not absolute, resolve relative to workPath's dir
-		if !filepath.IsAbs(targetPath) {
-			targetPath = filepath.Join(filepath.Dir(workPath), targetPath)
+			return err
 		}
-		f, err := os.Open(targetPath)
-		if err != nil {
-			return errors.Wrapf(err, "%s: could not open symlink target for checksum", targetPath)
+		if ok {
+			art.Checksum = cksum
+			return nil
 		}
-		defer f.Close()
-		cksum, err := checksum.Checksum(f)
-		if err != nil {
-			return errors.Wrapf(err, "%s: could not checksum link target", targetPath)
+		// Any other link to a regular file is followed, and the target's
+		// contents are checksummed without being moved to the cache.
+		if targetInfo, err := os.Stat(workPath); err == nil && targetInfo.Mode().IsRegular() {
+			return checksumLinkTarget(workPath, art)
 		}
-		art.Checksum = cksum
-		// Optionally: also update status.ContentsMatch, etc.
-		return nil // treat as success
 	}
-
-
+	if status.WorkspaceFileStatus != fsutil.StatusRegularFile {
+		return errors.New("not a regular file")
+	}
 
 	fileInfo, err := os.Stat(workPath)
 	if err != nil {
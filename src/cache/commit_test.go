@@ -3,10 +3,12 @@ package cache
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/kevin-hanselman/dud/src/agglog"
 	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/checksum"
 	"github.com/kevin-hanselman/dud/src/fsutil"
 	"github.com/kevin-hanselman/dud/src/strategy"
 	"github.com/kevin-hanselman/dud/src/testutil"
@@ -165,6 +167,97 @@ func TestFileCommitIntegration(t *testing.T) {
 	})
 }
 
+func TestCommitLinkOutsideCacheIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+	workDir := t.TempDir()
+	ch, err := NewLocalCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := agglog.NewNullLogger()
+	if err := os.WriteFile(filepath.Join(workDir, "target.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	linkPath := filepath.Join(workDir, "link.txt")
+	if err := os.Symlink("target.txt", linkPath); err != nil {
+		t.Fatal(err)
+	}
+	wantChecksum, err := checksum.Checksum(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, strat := range []strategy.CheckoutStrategy{strategy.LinkStrategy, strategy.CopyStrategy} {
+		t.Run(strat.String(), func(t *testing.T) {
+			art := artifact.Artifact{Path: "link.txt"}
+			if err := ch.Commit(workDir, &art, strat, logger); err != nil {
+				t.Fatal(err)
+			}
+			if art.Checksum != wantChecksum {
+				t.Fatalf("checksum = %#v, want %#v", art.Checksum, wantChecksum)
+			}
+			// The link is left alone.
+			target, err := os.Readlink(linkPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if target != "target.txt" {
+				t.Fatalf("link target = %#v, want %#v", target, "target.txt")
+			}
+		})
+	}
+}
+
+func TestOptionalCommitIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+	workDir := t.TempDir()
+	ch, err := NewLocalCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := agglog.NewNullLogger()
+	art := artifact.Artifact{Path: "maybe.txt", Checksum: "stale", Optional: true}
+
+	if err := ch.Commit(workDir, &art, strategy.LinkStrategy, logger); err != nil {
+		t.Fatal(err)
+	}
+	want := artifact.Artifact{Path: "maybe.txt", Optional: true, Absent: true}
+	if diff := cmp.Diff(want, art); diff != "" {
+		t.Fatalf("committed absent artifact -want +got:\n%s", diff)
+	}
+	status, err := ch.Status(workDir, art, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := status.String(); got != "absent (optional)" || !status.ContentsMatch {
+		t.Fatalf("status = %q (ContentsMatch = %v), want up-to-date absence", got, status.ContentsMatch)
+	}
+	if err := ch.Checkout(workDir, art, strategy.LinkStrategy, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(workDir, "maybe.txt"), []byte("here"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	status, err = ch.Status(workDir, art, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.ContentsMatch {
+		t.Fatal("absent artifact that now exists should not be up-to-date")
+	}
+	if err := ch.Commit(workDir, &art, strategy.LinkStrategy, logger); err != nil {
+		t.Fatal(err)
+	}
+	if art.Absent || art.Checksum == "" {
+		t.Fatalf("committed present artifact = %+v, want a checksum and no absence", art)
+	}
+}
+
 func testCommitIntegration(in testInput, expectedOut testExpectedOutput, t *testing.T) {
 	// TODO: Consider checking the logs instead of throwing them away.
 	logger := agglog.NewNullLogger()
@@ -0,0 +1,228 @@
+package cache
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/pkg/errors"
+)
+
+// Diff compares the Artifact in the workspace with its committed version and
+// returns the files that were added, removed, modified, or changed type,
+// sorted by path. A file Artifact yields at most one FileDiff for the
+// Artifact itself. Directory Artifacts are compared file-by-file against
+// their committed manifests using the same concurrent machinery as Status.
+func (ch LocalCache) Diff(workspaceDir string, art artifact.Artifact) ([]artifact.FileDiff, error) {
+	status, err := ch.Status(workspaceDir, art, false)
+	if err != nil {
+		return nil, err
+	}
+	diffs := []artifact.FileDiff{}
+	status.WalkChanges(func(path, state string, fileStatus artifact.Status) {
+		if err != nil {
+			return
+		}
+		diff := artifact.FileDiff{
+			FileChange: artifact.FileChange{Path: path, State: state},
+		}
+		if state != "added" {
+			diff.OldSize, err = ch.committedSize(fileStatus.Artifact)
+			if err != nil {
+				return
+			}
+		}
+		if state != "removed" {
+			diff.NewSize, err = workspaceSize(filepath.Join(workspaceDir, path))
+			if err != nil {
+				return
+			}
+		}
+		diffs = append(diffs, diff)
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "diff %s", art.Path)
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Path < diffs[j].Path
+	})
+	return diffs, nil
+}
+
+// DiffCommitted compares two committed versions of an Artifact, such as the
+// versions recorded by a stage file at two git revisions, and returns the
+// files that were added, removed, modified, or changed type, sorted by path.
+// A nil Artifact, or one without a checksum, is treated as absent. Directory
+// Artifacts are compared file-by-file using their manifests, which must be in
+// the cache. File sizes are read from the cache, and are -1 if the file isn't
+// in the cache.
+func (ch LocalCache) DiffCommitted(oldArt, newArt *artifact.Artifact) ([]artifact.FileDiff, error) {
+	oldArt, newArt = committedOrNil(oldArt), committedOrNil(newArt)
+	diffs := []artifact.FileDiff{}
+	if oldArt == nil && newArt == nil {
+		return diffs, nil
+	}
+	var artPath string
+	if newArt != nil {
+		artPath = newArt.Path
+	} else {
+		artPath = oldArt.Path
+	}
+	if err := ch.diffCommitted(artPath, oldArt, newArt, &diffs); err != nil {
+		return nil, errors.Wrapf(err, "diff %s", artPath)
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Path < diffs[j].Path
+	})
+	return diffs, nil
+}
+
+func committedOrNil(art *artifact.Artifact) *artifact.Artifact {
+	if art == nil || art.Checksum == "" {
+		return nil
+	}
+	return art
+}
+
+func (ch LocalCache) diffCommitted(
+	path string,
+	oldArt, newArt *artifact.Artifact,
+	diffs *[]artifact.FileDiff,
+) (err error) {
+	diff := artifact.FileDiff{FileChange: artifact.FileChange{Path: path}}
+	switch {
+	case oldArt == nil && newArt == nil:
+		return nil
+	case oldArt != nil && newArt != nil &&
+		oldArt.Checksum == newArt.Checksum && oldArt.IsDir == newArt.IsDir:
+		return nil
+	case newArt == nil:
+		diff.State = "removed"
+	case oldArt == nil && newArt.IsDir:
+		return ch.diffManifests(path, oldArt, newArt, diffs)
+	case oldArt == nil:
+		diff.State = "added"
+	case oldArt.IsDir != newArt.IsDir:
+		diff.State = "type-changed"
+	case oldArt.IsDir:
+		return ch.diffManifests(path, oldArt, newArt, diffs)
+	default:
+		diff.State = "modified"
+	}
+	if oldArt != nil {
+		if diff.OldSize, err = ch.committedSize(*oldArt); err != nil {
+			return err
+		}
+	}
+	if newArt != nil {
+		if diff.NewSize, err = ch.committedSize(*newArt); err != nil {
+			return err
+		}
+	}
+	*diffs = append(*diffs, diff)
+	return nil
+}
+
+// diffManifests compares the contents of two committed directory Artifacts,
+// either of which may be nil.
+func (ch LocalCache) diffManifests(
+	path string,
+	oldDir, newDir *artifact.Artifact,
+	diffs *[]artifact.FileDiff,
+) error {
+	var oldMan, newMan directoryManifest
+	var err error
+	if oldDir != nil {
+		if oldMan, err = ch.loadManifest(*oldDir); err != nil {
+			return err
+		}
+	}
+	if newDir != nil {
+		if newMan, err = ch.loadManifest(*newDir); err != nil {
+			return err
+		}
+	}
+	for name, oldChild := range oldMan.Contents {
+		childPath := filepath.Join(path, name)
+		if err := ch.diffCommitted(childPath, oldChild, newMan.Contents[name], diffs); err != nil {
+			return err
+		}
+	}
+	for name, newChild := range newMan.Contents {
+		if _, ok := oldMan.Contents[name]; ok {
+			continue
+		}
+		childPath := filepath.Join(path, name)
+		if err := ch.diffCommitted(childPath, nil, newChild, diffs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// committedSize returns the total size of the committed Artifact's files in
+// the cache, or -1 if any of them are missing from the cache.
+func (ch LocalCache) committedSize(art artifact.Artifact) (int64, error) {
+	files := map[string]artifact.Artifact{art.Path: art}
+	if art.IsDir {
+		var err error
+		files, err = ch.DirFiles(art)
+		if _, ok := errors.Cause(err).(MissingFromCacheError); ok {
+			return -1, nil
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	var total int64
+	for _, file := range files {
+		cachePath, err := ch.PathForChecksum(file.Checksum)
+		if _, ok := err.(InvalidChecksumError); ok {
+			return -1, nil
+		} else if err != nil {
+			return 0, err
+		}
+		info, err := os.Stat(filepath.Join(ch.dir, cachePath))
+		if os.IsNotExist(err) {
+			return -1, nil
+		} else if err != nil {
+			return 0, err
+		}
+		total += info.Size()
+	}
+	return total, nil
+}
+
+// workspaceSize returns the size of the file at path, or the total size of
+// all files inside it if it's a directory. Links are followed, unless they're
+// dead.
+func workspaceSize(path string) (total int64, err error) {
+	info, err := statFollow(path)
+	if err != nil {
+		return 0, err
+	}
+	if !info.IsDir() {
+		return info.Size(), nil
+	}
+	err = filepath.WalkDir(path, func(childPath string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		info, err := statFollow(childPath)
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	return total, err
+}
+
+func statFollow(path string) (fs.FileInfo, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return os.Lstat(path)
+	}
+	return info, err
+}
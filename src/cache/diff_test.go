@@ -0,0 +1,168 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/strategy"
+)
+
+func TestDiffIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	dirs, art, cache := setupDirTest(t)
+	defer os.RemoveAll(dirs.CacheDir)
+	defer os.RemoveAll(dirs.WorkDir)
+
+	if err := cache.Commit(dirs.WorkDir, &art, strategy.CopyStrategy, logger); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("up-to-date", func(t *testing.T) {
+		diffs, err := cache.Diff(dirs.WorkDir, art)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(diffs) != 0 {
+			t.Fatalf("expected no diffs, got %v", diffs)
+		}
+	})
+
+	t.Run("changed files", func(t *testing.T) {
+		workPath := func(path string) string {
+			return filepath.Join(dirs.WorkDir, path)
+		}
+		if err := os.WriteFile(workPath("foo/1.txt"), []byte("111"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Remove(workPath("foo/2.txt")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Remove(workPath("foo/3.txt")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Mkdir(workPath("foo/3.txt"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(workPath("foo/3.txt/x"), []byte("xy"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.RemoveAll(workPath("foo/bar")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(workPath("foo/new.txt"), []byte("abcd"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		diffs, err := cache.Diff(dirs.WorkDir, art)
+		if err != nil {
+			t.Fatal(err)
+		}
+		newDiff := func(path, state string, oldSize, newSize int64) artifact.FileDiff {
+			return artifact.FileDiff{
+				FileChange: artifact.FileChange{Path: path, State: state},
+				OldSize:    oldSize,
+				NewSize:    newSize,
+			}
+		}
+		want := []artifact.FileDiff{
+			newDiff("foo/1.txt", "modified", 1, 3),
+			newDiff("foo/2.txt", "removed", 1, 0),
+			newDiff("foo/3.txt", "type-changed", 1, 2),
+			newDiff("foo/bar", "removed", 5, 0),
+			newDiff("foo/new.txt", "added", 0, 4),
+		}
+		if diff := cmp.Diff(want, diffs); diff != "" {
+			t.Fatalf("Diff -want +got:\n%s", diff)
+		}
+	})
+}
+
+func TestDiffCommittedIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	dirs, oldArt, cache := setupDirTest(t)
+	defer os.RemoveAll(dirs.CacheDir)
+	defer os.RemoveAll(dirs.WorkDir)
+
+	if err := cache.Commit(dirs.WorkDir, &oldArt, strategy.CopyStrategy, logger); err != nil {
+		t.Fatal(err)
+	}
+
+	workPath := func(path string) string {
+		return filepath.Join(dirs.WorkDir, path)
+	}
+	if err := os.WriteFile(workPath("foo/1.txt"), []byte("111"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(workPath("foo/bar")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(workPath("foo/new.txt"), []byte("abcd"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	newArt := artifact.Artifact{Path: oldArt.Path, IsDir: true}
+	if err := cache.Commit(dirs.WorkDir, &newArt, strategy.CopyStrategy, logger); err != nil {
+		t.Fatal(err)
+	}
+
+	newDiff := func(path, state string, oldSize, newSize int64) artifact.FileDiff {
+		return artifact.FileDiff{
+			FileChange: artifact.FileChange{Path: path, State: state},
+			OldSize:    oldSize,
+			NewSize:    newSize,
+		}
+	}
+
+	t.Run("changed directory", func(t *testing.T) {
+		diffs, err := cache.DiffCommitted(&oldArt, &newArt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []artifact.FileDiff{
+			newDiff("foo/1.txt", "modified", 1, 3),
+			newDiff("foo/bar", "removed", 5, 0),
+			newDiff("foo/new.txt", "added", 0, 4),
+		}
+		if diff := cmp.Diff(want, diffs); diff != "" {
+			t.Fatalf("DiffCommitted -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("same version", func(t *testing.T) {
+		diffs, err := cache.DiffCommitted(&newArt, &newArt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(diffs) != 0 {
+			t.Fatalf("expected no diffs, got %v", diffs)
+		}
+	})
+
+	t.Run("added directory lists its files", func(t *testing.T) {
+		diffs, err := cache.DiffCommitted(nil, &newArt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(diffs) != 6 {
+			t.Fatalf("expected 6 added files, got %v", diffs)
+		}
+		for _, diff := range diffs {
+			if diff.State != "added" {
+				t.Fatalf("expected only added files, got %v", diffs)
+			}
+		}
+	})
+}
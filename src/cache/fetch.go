@@ -16,6 +16,25 @@ import (
 func (ch LocalCache) Fetch(
 	remoteSrc string,
 	artifacts map[string]*artifact.Artifact,
+) error {
+	return ch.fetch(remoteSrc, artifacts, false)
+}
+
+// FetchManifests downloads only the directory manifests of the given
+// Artifacts (and of all sub-directories) from a remote location to the local
+// cache. This is enough to compare directory Artifacts file-by-file without
+// downloading their contents.
+func (ch LocalCache) FetchManifests(
+	remoteSrc string,
+	artifacts map[string]*artifact.Artifact,
+) error {
+	return ch.fetch(remoteSrc, artifacts, true)
+}
+
+func (ch LocalCache) fetch(
+	remoteSrc string,
+	artifacts map[string]*artifact.Artifact,
+	manifestsOnly bool,
 ) error {
 	fetchFiles := make(map[string]struct{})
 	dirArtifacts := make(map[string]*artifact.Artifact)
@@ -24,7 +43,7 @@ func (ch LocalCache) Fetch(
 	// prevent Artifacts with the same relative path from clobbering each
 	// other.
 	for _, art := range artifacts {
-		if art.SkipCache {
+		if art.SkipCache || art.Absent || (manifestsOnly && !art.IsDir) {
 			continue
 		}
 		status, cachePath, _, err := checksumStatus(ch, *art)
@@ -75,5 +94,5 @@ func (ch LocalCache) Fetch(
 		return nil
 	}
 	// Don't wrap any error here because we're recursing.
-	return ch.Fetch(remoteSrc, children)
+	return ch.fetch(remoteSrc, children, manifestsOnly)
 }
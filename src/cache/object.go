@@ -0,0 +1,32 @@
+package cache
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// CommitObject saves data to the cache as a content-addressed object and
+// returns its checksum. Objects hold project metadata, such as snapshots,
+// that doesn't correspond to files in the workspace. Like any other cached
+// file, an object can be pushed and fetched using an Artifact with the
+// object's checksum.
+func (ch LocalCache) CommitObject(data []byte) (string, error) {
+	cksum, err := ch.commitBytes(bytes.NewReader(data), "")
+	return cksum, errors.Wrap(err, "commit object")
+}
+
+// ReadObject returns the contents of the object with the given checksum.
+func (ch LocalCache) ReadObject(checksum string) ([]byte, error) {
+	cachePath, err := ch.PathForChecksum(checksum)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(ch.dir, cachePath))
+	if os.IsNotExist(err) {
+		return nil, MissingFromCacheError{checksum}
+	}
+	return data, errors.Wrapf(err, "read object %s", checksum)
+}
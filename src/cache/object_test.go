@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestObjectIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	ch, err := NewLocalCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cksum, err := ch.CommitObject([]byte("snapshot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ch.ReadObject(cksum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "snapshot" {
+		t.Fatalf("ReadObject() = %#v, want %#v", string(data), "snapshot")
+	}
+
+	again, err := ch.CommitObject([]byte("snapshot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != cksum {
+		t.Fatalf("committing the same object twice gave checksums %s and %s", cksum, again)
+	}
+
+	_, err = ch.ReadObject("123456789")
+	if !errors.Is(err, MissingFromCacheError{"123456789"}) {
+		t.Fatalf("expected MissingFromCacheError, got %#v", err)
+	}
+}
@@ -20,20 +20,59 @@ import (
 // so it's convenient to pass stage.Outputs directly. This also eases testing,
 // because transcribing the map into a slice would introduce non-determinism.
 func (ch LocalCache) Push(remoteDst string, arts map[string]*artifact.Artifact) error {
+	pushFiles, err := gatherArtifactsToPush(ch, arts, "push")
+	if err != nil {
+		return err
+	}
+	if len(pushFiles) > 0 {
+		return errors.Wrap(remoteCopy(ch.dir, remoteDst, pushFiles), "push")
+	}
+	return nil
+}
+
+// Verify checks that the Artifacts have been pushed to a remote cache. It
+// returns an error if any of the Artifacts' files are missing from the remote
+// cache. Like Push, Verify requires the Artifacts to be in the local cache.
+func (ch LocalCache) Verify(remoteDst string, arts map[string]*artifact.Artifact) error {
+	pushFiles, err := gatherArtifactsToPush(ch, arts, "verify")
+	if err != nil {
+		return err
+	}
+	if len(pushFiles) > 0 {
+		if err := remoteCheck(ch.dir, remoteDst, pushFiles); err != nil {
+			return errors.Wrapf(err, "verify against %s", remoteDst)
+		}
+	}
+	return nil
+}
+
+// VerifyLocal checks that the Artifacts are fully present in the local cache,
+// including every file inside directory Artifacts. It returns a
+// MissingFromCacheError (wrapped with the Artifact's path) for the first
+// missing file found.
+func (ch LocalCache) VerifyLocal(arts map[string]*artifact.Artifact) error {
+	_, err := gatherArtifactsToPush(ch, arts, "verify")
+	return err
+}
+
+// gatherArtifactsToPush returns the set of cache files, relative to the cache
+// directory, needed to push the Artifacts. The operation names the caller in
+// errors.
+func gatherArtifactsToPush(
+	ch LocalCache,
+	arts map[string]*artifact.Artifact,
+	operation string,
+) (map[string]struct{}, error) {
 	progress := newProgress(progressTemplateCount, 0, "Gathering files")
 	progress.Start()
+	defer progress.Finish()
 	pushFiles := make(map[string]struct{})
 	for _, art := range arts {
 		if err := gatherFilesToPush(ch, *art, pushFiles, progress); err != nil {
-			progress.Finish()
-			return errors.Wrapf(err, "push %s", art.Path)
+			return nil, errors.Wrapf(err, "%s %s", operation, art.Path)
 		}
 	}
-	progress.Finish()
-	if len(pushFiles) > 0 {
-		return errors.Wrap(remoteCopy(ch.dir, remoteDst, pushFiles), "push")
-	}
-	return nil
+	return pushFiles, nil
 }
 
 func gatherFilesToPush(
@@ -42,7 +81,7 @@ func gatherFilesToPush(
 	filesToPush map[string]struct{},
 	progress *pb.ProgressBar,
 ) error {
-	if art.SkipCache {
+	if art.SkipCache || art.Absent {
 		return nil
 	}
 	status, cachePath, _, err := checksumStatus(ch, art)
@@ -91,6 +130,38 @@ var remoteCopy = func(src, dst string, fileSet map[string]struct{}) error {
 		src,
 		dst,
 	)
+	if err := runWithFileList(cmd, fileSet); err != nil {
+		return err
+	}
+
+	// Ensure any local files that were created end up as read-only. Try to
+	// chmod all files, ignoring "no such file" errors which are probably due
+	// to the destination being remote. This is important even for push,
+	// because the "remote" might be a local directory.
+	return setFilePerms(dst, fileSet, cacheFilePerms)
+}
+
+var remoteCheck = func(src, dst string, fileSet map[string]struct{}) error {
+	cmd := exec.Command(
+		"rclone",
+		"--config",
+		".dud/rclone.conf",
+		"check",
+		// Only check that the files exist in dst; dst may hold many other
+		// files.
+		"--one-way",
+		"--size-only",
+		"--files-from",
+		"-",
+		src,
+		dst,
+	)
+	return runWithFileList(cmd, fileSet)
+}
+
+// runWithFileList runs an rclone command, writing the files in fileSet to its
+// standard input for use with "--files-from -".
+func runWithFileList(cmd *exec.Cmd, fileSet map[string]struct{}) error {
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	stdin, err := cmd.StdinPipe()
@@ -111,15 +182,7 @@ var remoteCopy = func(src, dst string, fileSet map[string]struct{}) error {
 		}
 	}()
 
-	if err := cmd.Wait(); err != nil {
-		return err
-	}
-
-	// Ensure any local files that were created end up as read-only. Try to
-	// chmod all files, ignoring "no such file" errors which are probably due
-	// to the destination being remote. This is important even for push,
-	// because the "remote" might be a local directory.
-	return setFilePerms(dst, fileSet, cacheFilePerms)
+	return cmd.Wait()
 }
 
 func setFilePerms(commonDir string, fileSet map[string]struct{}, mode fs.FileMode) error {
@@ -141,3 +141,100 @@ func TestPushIntegration(t *testing.T) {
 		assertCacheDirsEqual(dirs.CacheDir, fakeRemote, t)
 	})
 }
+
+func TestVerifyIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	remoteCheckOrig := remoteCheck
+	defer func() { remoteCheck = remoteCheckOrig }()
+
+	// mockRemoteCheck checks that every file exists in dst.
+	remoteCheck = func(src, dst string, fileSet map[string]struct{}) error {
+		for file := range fileSet {
+			if _, err := os.Stat(filepath.Join(dst, file)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	dirs, art, ch := setupDirTest(t)
+	defer os.RemoveAll(dirs.CacheDir)
+	defer os.RemoveAll(dirs.WorkDir)
+
+	if err := ch.Commit(dirs.WorkDir, &art, strategy.LinkStrategy, logger); err != nil {
+		t.Fatal(err)
+	}
+
+	fakeRemote := filepath.Join(dirs.WorkDir, "fake_remote")
+	arts := map[string]*artifact.Artifact{"art": &art}
+
+	t.Run("error if not pushed", func(t *testing.T) {
+		if err := ch.Verify(fakeRemote, arts); err == nil {
+			t.Fatal("expected Verify to return error")
+		}
+	})
+
+	t.Run("pushed", func(t *testing.T) {
+		remoteCopyOrig := remoteCopy
+		remoteCopy = mockRemoteCopy
+		defer func() { remoteCopy = remoteCopyOrig }()
+
+		if err := ch.Push(fakeRemote, arts); err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.Verify(fakeRemote, arts); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestVerifyLocalIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	dirs, art, ch := setupDirTest(t)
+	defer os.RemoveAll(dirs.CacheDir)
+	defer os.RemoveAll(dirs.WorkDir)
+
+	if err := ch.Commit(dirs.WorkDir, &art, strategy.LinkStrategy, logger); err != nil {
+		t.Fatal(err)
+	}
+	arts := map[string]*artifact.Artifact{"art": &art}
+
+	t.Run("all files cached", func(t *testing.T) {
+		if err := ch.VerifyLocal(arts); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("error if a file is missing", func(t *testing.T) {
+		files, err := ch.DirFiles(art)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var missing string
+		for _, file := range files {
+			missing = file.Checksum
+			break
+		}
+		cachePath, err := ch.PathForChecksum(missing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Remove(filepath.Join(dirs.CacheDir, cachePath)); err != nil {
+			t.Fatal(err)
+		}
+		err = ch.VerifyLocal(arts)
+		if _, ok := errors.Cause(err).(MissingFromCacheError); !ok {
+			t.Fatalf("expected MissingFromCacheError, got %v", err)
+		}
+	})
+}
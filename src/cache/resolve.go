@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/pkg/errors"
+)
+
+// ResolveChild returns the Artifact recorded for path in the committed
+// manifest of the directory Artifact dirArt. Both path and dirArt.Path are
+// relative to the project root, and path must lie inside dirArt.Path. The
+// returned Artifact's Path is set to path.
+func (ch LocalCache) ResolveChild(dirArt artifact.Artifact, path string) (
+	child artifact.Artifact,
+	err error,
+) {
+	errPrefix := fmt.Sprintf("resolve %s in %s", path, dirArt.Path)
+	if !dirArt.IsDir {
+		return child, errors.Wrap(errors.New("not a directory artifact"), errPrefix)
+	}
+	relPath, err := filepath.Rel(dirArt.Path, path)
+	if err != nil {
+		return child, errors.Wrap(err, errPrefix)
+	}
+	if relPath == "." || strings.HasPrefix(relPath, "..") {
+		return child, fmt.Errorf("%s: path is not inside the directory", errPrefix)
+	}
+
+	current := dirArt
+	for _, part := range strings.Split(relPath, string(filepath.Separator)) {
+		if !current.IsDir {
+			return child, fmt.Errorf("%s: %s is not a directory", errPrefix, current.Path)
+		}
+		status, cachePath, _, err := checksumStatus(ch, current)
+		if err != nil {
+			return child, errors.Wrap(err, errPrefix)
+		}
+		if !status.HasChecksum {
+			return child, errors.Wrap(InvalidChecksumError{current.Checksum}, errPrefix)
+		}
+		if !status.ChecksumInCache {
+			return child, errors.Wrap(MissingFromCacheError{current.Checksum}, errPrefix)
+		}
+		man, err := readDirManifest(filepath.Join(ch.dir, cachePath))
+		if err != nil {
+			return child, errors.Wrap(err, errPrefix)
+		}
+		next, ok := man.Contents[part]
+		if !ok {
+			return child, fmt.Errorf("%s: not found in directory manifest", errPrefix)
+		}
+		current = *next
+	}
+	current.Path = path
+	return current, nil
+}
+
+// DirFiles returns every file Artifact recorded in the committed manifests of
+// the directory Artifact dirArt, including those in sub-directories. The
+// returned map is keyed by path relative to the project root, and each
+// Artifact's Path is set accordingly.
+func (ch LocalCache) DirFiles(dirArt artifact.Artifact) (map[string]artifact.Artifact, error) {
+	files := make(map[string]artifact.Artifact)
+	if err := ch.addDirFiles(dirArt, files); err != nil {
+		return nil, errors.Wrapf(err, "list files in %s", dirArt.Path)
+	}
+	return files, nil
+}
+
+func (ch LocalCache) addDirFiles(dirArt artifact.Artifact, files map[string]artifact.Artifact) error {
+	if !dirArt.IsDir {
+		return errors.New("not a directory artifact")
+	}
+	man, err := ch.loadManifest(dirArt)
+	if err != nil {
+		return err
+	}
+	for name, child := range man.Contents {
+		child := *child
+		child.Path = filepath.Join(dirArt.Path, name)
+		if child.IsDir {
+			if err := ch.addDirFiles(child, files); err != nil {
+				return err
+			}
+			continue
+		}
+		files[child.Path] = child
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/checksum"
+	"github.com/kevin-hanselman/dud/src/strategy"
+)
+
+func TestResolveChildIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	dirs, art, cache := setupDirTest(t)
+	defer os.RemoveAll(dirs.CacheDir)
+	defer os.RemoveAll(dirs.WorkDir)
+
+	if err := cache.Commit(dirs.WorkDir, &art, strategy.CopyStrategy, logger); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("file in directory", func(t *testing.T) {
+		child, err := cache.ResolveChild(art, "foo/3.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := checksum.Checksum(bytes.NewBufferString("3"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(artifact.Artifact{Path: "foo/3.txt", Checksum: want}, child); diff != "" {
+			t.Fatalf("artifact -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("file in sub-directory", func(t *testing.T) {
+		child, err := cache.ResolveChild(art, "foo/bar/7.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := checksum.Checksum(bytes.NewBufferString("7"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if child.Checksum != want {
+			t.Fatalf("checksum = %#v, want %#v", child.Checksum, want)
+		}
+	})
+
+	t.Run("sub-directory", func(t *testing.T) {
+		child, err := cache.ResolveChild(art, "foo/bar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !child.IsDir {
+			t.Fatal("expected a directory artifact")
+		}
+		if child.Checksum == "" {
+			t.Fatal("expected checksum to be set")
+		}
+	})
+
+	t.Run("error on missing file", func(t *testing.T) {
+		if _, err := cache.ResolveChild(art, "foo/bar/9.txt"); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("error on path outside directory", func(t *testing.T) {
+		if _, err := cache.ResolveChild(art, "other/1.txt"); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("error on uncommitted directory", func(t *testing.T) {
+		uncommitted := artifact.Artifact{Path: "foo", IsDir: true}
+		if _, err := cache.ResolveChild(uncommitted, "foo/1.txt"); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestDirFilesIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	dirs, art, cache := setupDirTest(t)
+	defer os.RemoveAll(dirs.CacheDir)
+	defer os.RemoveAll(dirs.WorkDir)
+
+	if err := cache.Commit(dirs.WorkDir, &art, strategy.CopyStrategy, logger); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := cache.DirFiles(art)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotPaths []string
+	for path, file := range files {
+		gotPaths = append(gotPaths, path)
+		if file.Path != path {
+			t.Fatalf("artifact path = %#v, want %#v", file.Path, path)
+		}
+	}
+	sort.Strings(gotPaths)
+	var wantPaths []string
+	for i := 1; i <= 5; i++ {
+		wantPaths = append(wantPaths, fmt.Sprintf("foo/%d.txt", i))
+	}
+	for i := 4; i <= 8; i++ {
+		wantPaths = append(wantPaths, fmt.Sprintf("foo/bar/%d.txt", i))
+	}
+	sort.Strings(wantPaths)
+	if diff := cmp.Diff(wantPaths, gotPaths); diff != "" {
+		t.Fatalf("paths -want +got:\n%s", diff)
+	}
+
+	want, err := checksum.Checksum(bytes.NewBufferString("7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := files["foo/bar/7.txt"].Checksum; got != want {
+		t.Fatalf("checksum = %#v, want %#v", got, want)
+	}
+
+	t.Run("error on uncommitted directory", func(t *testing.T) {
+		uncommitted := artifact.Artifact{Path: "foo", IsDir: true}
+		if _, err := cache.DirFiles(uncommitted); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
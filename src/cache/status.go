@@ -22,6 +22,20 @@ func (ch LocalCache) Status(workspaceDir string, art artifact.Artifact, shortCir
 	status artifact.Status,
 	err error,
 ) {
+	// An optional Artifact committed as absent is up-to-date as long as it's
+	// still absent.
+	if art.Absent {
+		exists, err := fsutil.Exists(filepath.Join(workspaceDir, art.Path), false)
+		if err != nil {
+			return status, errors.Wrapf(err, "status %s", art.Path)
+		}
+		if !exists {
+			status.Artifact = art
+			status.WorkspaceFileStatus = fsutil.StatusAbsent
+			status.ContentsMatch = true
+			return status, nil
+		}
+	}
 	if art.IsDir {
 		activeSharedWorkers := make(chan struct{}, maxSharedWorkers)
 		status, err = dirArtifactStatus(
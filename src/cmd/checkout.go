@@ -7,6 +7,9 @@ import (
 
 func init() {
 	rootCmd.AddCommand(checkoutCmd)
+	addSelectionFlags(checkoutCmd)
+	addRevFlag(checkoutCmd)
+	addTagFlag(checkoutCmd)
 	checkoutCmd.Flags().BoolVarP(
 		&useCopyStrategy,
 		"copy",
@@ -32,10 +35,21 @@ var checkoutCmd = &cobra.Command{
 
 For each stage file passed in, checkout makes the stage's output artifacts
 available in the workspace. By default, checkout creates symlinks to the cache,
-but copies of the cached artifacts can be checked out using --copy. If no
-stage files are passed in, checkout will act on all stages in the index. By
-default, checkout will act recursively on all stages upstream of the given
-stage(s).`,
+but copies of the cached artifacts can be checked out using --copy. Links to
+other committed versions of an artifact are replaced. If no stage files are
+passed in, checkout will act on all stages in the index. By default, checkout
+will act recursively on all stages upstream of the given stage(s). With
+--downstream, checkout also acts on all stages downstream of the given
+stage(s).
+
+With --rev, checkout reads the index and stage files from the given git
+revision instead of the working tree, and checks out the artifacts committed
+at that revision. The working tree's stage files are left untouched, so 'dud
+status' will report the checked out artifacts as modified until you check out
+the current revision again.
+
+With --tag, checkout checks out the artifacts recorded in the given tag (see
+'dud tag --help'). As with --rev, the stage files are left untouched.`,
 	Run: func(cmd *cobra.Command, paths []string) {
 		strat := strategy.LinkStrategy
 		if useCopyStrategy {
@@ -47,21 +61,27 @@ stage(s).`,
 			fatal(err)
 		}
 
-		if len(idx) == 0 {
+		if idx.Len() == 0 {
 			fatal(emptyIndexError{})
 		}
 
 		if len(paths) == 0 {
 			// Ignore disableRecursion flag when no args passed.
 			disableRecursion = false
-			for path := range idx {
-				paths = append(paths, path)
-			}
+			paths = idx.SortStagePaths()
+		}
+
+		paths, err = idx.SelectStages(paths, selectUpstream, selectDownstream)
+		if err != nil {
+			fatal(err)
+		}
+
+		if err := runHook("pre-checkout", rootDir, paths); err != nil {
+			fatal(err)
 		}
 
 		checkedOut := make(map[string]bool)
 		for _, path := range paths {
-			inProgress := make(map[string]bool)
 			if err := idx.Checkout(
 				path,
 				ch,
@@ -69,12 +89,15 @@ stage(s).`,
 				strat,
 				!disableRecursion,
 				checkedOut,
-				inProgress,
 				logger,
 			); err != nil {
 				fatal(err)
 			}
 			logger.Info.Println()
 		}
+
+		if err := runHook("post-checkout", rootDir, paths); err != nil {
+			fatal(err)
+		}
 	},
 }
@@ -37,20 +37,21 @@ recursively on all stages upstream of the given stage(s).`,
 		}
 
 		if len(paths) == 0 { // By default, commit all Stages.
-			for path := range idx {
-				paths = append(paths, path)
-			}
+			paths = idx.SortStagePaths()
 		}
 
 		if len(paths) == 0 {
 			fatal(emptyIndexError{})
 		}
 
+		if err := runHook("pre-commit", rootDir, paths); err != nil {
+			fatal(err)
+		}
+
 		committed := make(map[string]bool)
 		written := make(map[string]bool)
 		for _, path := range paths {
-			inProgress := make(map[string]bool)
-			err := idx.Commit(path, ch, rootDir, strat, committed, inProgress, logger)
+			err := idx.Commit(path, ch, rootDir, strat, committed, logger)
 			if err != nil {
 				fatal(err)
 			}
@@ -58,12 +59,17 @@ recursively on all stages upstream of the given stage(s).`,
 				if written[path] {
 					continue
 				}
-				if err := idx[path].ToFile(path); err != nil {
+				stg, _ := idx.Stage(path)
+				if err := stg.ToFile(path); err != nil {
 					fatal(err)
 				}
 				written[path] = true
 			}
 			logger.Info.Println()
 		}
+
+		if err := runHook("post-commit", rootDir, paths); err != nil {
+			fatal(err)
+		}
 	},
 }
@@ -0,0 +1,249 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/cache"
+	"github.com/kevin-hanselman/dud/src/gitutil"
+	"github.com/kevin-hanselman/dud/src/index"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	diffCmd.Flags().StringVar(
+		&diffFormat,
+		"format",
+		"text",
+		"output format: \"text\" or \"json\"",
+	)
+	diffCmd.Flags().StringVar(
+		&diffRevRange,
+		"rev-range",
+		"",
+		"compare the artifacts committed at two git revisions, given as \"old..new\"",
+	)
+	rootCmd.AddCommand(diffCmd)
+}
+
+// splitRevRange splits a revision range of the form "old..new" into its two
+// revisions. As in git, an omitted revision defaults to HEAD.
+func splitRevRange(revRange string) (oldRev, newRev string, err error) {
+	oldRev, newRev, ok := strings.Cut(revRange, "..")
+	if !ok || strings.HasPrefix(newRev, ".") || (oldRev == "" && newRev == "") {
+		return "", "", fmt.Errorf("invalid --rev-range value %#v (want \"old..new\")", revRange)
+	}
+	if oldRev == "" {
+		oldRev = "HEAD"
+	}
+	if newRev == "" {
+		newRev = "HEAD"
+	}
+	return oldRev, newRev, nil
+}
+
+// diffOutput is the JSON output of 'dud diff'.
+type diffOutput struct {
+	Artifacts []index.ArtifactDiff `json:"artifacts"`
+	Summary   index.DiffSummary    `json:"summary"`
+}
+
+func writeDiff(writer io.Writer, diffs []index.ArtifactDiff) {
+	if len(diffs) == 0 {
+		fmt.Fprintln(writer, "no changes")
+		return
+	}
+	tabWriter := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	for _, artDiff := range diffs {
+		fmt.Fprintf(tabWriter, "%s (%s)\n", artDiff.Path, artDiff.Stage)
+		for _, file := range artDiff.Files {
+			var sizes string
+			switch file.State {
+			case "added":
+				sizes = formatSize(file.NewSize)
+			case "removed":
+				sizes = formatSize(file.OldSize)
+			default:
+				sizes = formatSize(file.OldSize) + " -> " + formatSize(file.NewSize)
+			}
+			fmt.Fprintf(tabWriter, "  %s\t%s\t%s\n", file.State, file.Path, sizes)
+		}
+		fmt.Fprintln(tabWriter)
+	}
+	tabWriter.Flush()
+
+	summary := index.SummarizeDiff(diffs)
+	files := summary.Added + summary.Removed + summary.Modified + summary.TypeChanged
+	delta := summary.NewBytes - summary.OldBytes
+	sign := "+"
+	if delta < 0 {
+		sign = "-"
+		delta = -delta
+	}
+	fmt.Fprintf(
+		writer,
+		"%d file(s) changed (%d added, %d removed, %d modified, %d type-changed), %s -> %s (%s%s)\n",
+		files,
+		summary.Added,
+		summary.Removed,
+		summary.Modified,
+		summary.TypeChanged,
+		formatSize(summary.OldBytes),
+		formatSize(summary.NewBytes),
+		sign,
+		formatSize(delta),
+	)
+}
+
+// diffRevisions compares the committed outputs selected by targets at two git
+// revisions, fetching any missing directory manifests from the remote cache.
+func diffRevisions(
+	rootDir string,
+	ch cache.Cache,
+	oldRev, newRev string,
+	targets []string,
+) ([]index.ArtifactDiff, error) {
+	for _, rev := range []string{oldRev, newRev} {
+		if _, err := gitutil.ResolveCommit(rootDir, rev); err != nil {
+			return nil, err
+		}
+	}
+	oldIdx, err := index.FromRevision(rootDir, oldRev, indexPath)
+	if err != nil {
+		return nil, err
+	}
+	newIdx, err := index.FromRevision(rootDir, newRev, indexPath)
+	if err != nil {
+		return nil, err
+	}
+	if remote := viper.GetString("remote"); remote != "" {
+		// Key by checksum, as the same path may hold different directories at
+		// each revision.
+		manifests := make(map[string]*artifact.Artifact)
+		for _, idx := range []index.Index{oldIdx, newIdx} {
+			for _, art := range idx.ManifestOutputs() {
+				manifests[art.Checksum] = art
+			}
+		}
+		if err := ch.FetchManifests(remote, manifests); err != nil {
+			return nil, err
+		}
+	}
+	return index.DiffIndexes(oldIdx, newIdx, targets, ch)
+}
+
+// formatSize returns a human-readable size, or "?" if the size is unknown
+// (i.e. negative).
+func formatSize(size int64) string {
+	if size < 0 {
+		return "?"
+	}
+	return datasize.ByteSize(size).HR()
+}
+
+var diffFormat, diffRevRange string
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [flags] [stage_file|artifact]...",
+	Short: "Show changes to artifacts in the workspace or between git revisions",
+	Long: `Diff shows the files that changed in the workspace since they were committed,
+or between two git revisions.
+
+For each stage file passed in, diff compares the stage's outputs in the
+workspace with their committed versions. Artifact paths may also be passed
+in, including paths inside directory artifacts, to limit the comparison to
+those paths. If no arguments are passed in, diff will act on all stages in the
+index.
+
+Diff lists every file that was added, removed, modified, or changed type (e.g.
+a file replaced by a directory) along with its size, followed by a summary of
+the number of changed files and bytes. Directory artifacts are compared
+file-by-file against their committed manifests. The committed size of a file
+is shown as "?" if the file isn't in the local cache.
+
+With --rev-range old..new (e.g. "main..HEAD", or two commit hashes), diff
+instead compares the artifacts committed at those git revisions. As in git, an
+omitted revision defaults to HEAD. The index and stage files are read from each
+revision using git, and directory manifests missing from the local cache are
+fetched from the remote cache, if one is configured. Only the manifests are
+fetched, so sizes of files that aren't in the local cache are shown as "?".
+Stage and artifact paths limit the comparison as above; they may refer to
+stages or artifacts that exist at either revision.
+
+--format json prints a single JSON object for tooling:
+
+  {
+    "artifacts": [             // sorted by path; unchanged outputs omitted
+      {
+        "stage": "prep.yaml",
+        "path": "data",
+        "files": [             // sorted by path
+          {
+            "path": "data/train.csv",
+            "state": "modified",   // "added", "removed", "modified",
+                                   // "type-changed"
+            "old-size": 1024,      // -1 if unknown
+            "new-size": 2048       // -1 if unknown
+          }
+        ]
+      }
+    ],
+    "summary": {
+      "added": 0,
+      "removed": 0,
+      "modified": 1,
+      "type-changed": 0,
+      "old-bytes": 1024,       // files of unknown size are not counted
+      "new-bytes": 2048
+    }
+  }`,
+	Run: func(_ *cobra.Command, paths []string) {
+		if diffFormat != "text" && diffFormat != "json" {
+			fatal(fmt.Errorf("invalid --format value %#v (want \"text\" or \"json\")", diffFormat))
+		}
+
+		var oldRev, newRev string
+		if diffRevRange != "" {
+			var err error
+			if oldRev, newRev, err = splitRevRange(diffRevRange); err != nil {
+				fatal(err)
+			}
+		}
+
+		rootDir, ch, idx, err := prepare(paths)
+		if err != nil {
+			fatal(err)
+		}
+
+		var diffs []index.ArtifactDiff
+		if diffRevRange == "" {
+			if idx.Len() == 0 {
+				fatal(emptyIndexError{})
+			}
+			diffs, err = idx.Diff(paths, ch, rootDir)
+		} else {
+			diffs, err = diffRevisions(rootDir, ch, oldRev, newRev, paths)
+		}
+		if err != nil {
+			fatal(err)
+		}
+
+		if diffFormat == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			out := diffOutput{Artifacts: diffs, Summary: index.SummarizeDiff(diffs)}
+			if err := encoder.Encode(out); err != nil {
+				fatal(err)
+			}
+			return
+		}
+		writeDiff(os.Stdout, diffs)
+	},
+}
@@ -7,6 +7,8 @@ import (
 
 func init() {
 	rootCmd.AddCommand(fetchCmd)
+	addRevFlag(fetchCmd)
+	addTagFlag(fetchCmd)
 	fetchCmd.Flags().BoolVarP(
 		&disableRecursion,
 		"single-stage",
@@ -32,6 +34,10 @@ remote cache specified in the Dud config file. If no stage files are passed
 in, fetch will act on all stages in the index. By default, fetch will act
 recursively on all stages upstream of the given stage(s).
 
+With --rev, fetch reads the index and stage files from the given git revision
+instead of the working tree, and fetches the artifacts committed at that
+revision. With --tag, fetch fetches the artifacts recorded in the given tag.
+
 This command requires rclone to be installed on your machine. Visit
 https://rclone.org/ for more information and installation instructions.`,
 	Run: func(cmd *cobra.Command, paths []string) {
@@ -48,14 +54,15 @@ https://rclone.org/ for more information and installation instructions.`,
 		if len(paths) == 0 {
 			// Ignore disableRecursion flag when no args passed.
 			disableRecursion = false
-			for path := range idx {
-				paths = append(paths, path)
-			}
+			paths = idx.SortStagePaths()
+		}
+
+		if err := runHook("pre-fetch", rootDir, paths); err != nil {
+			fatal(err)
 		}
 
 		fetched := make(map[string]bool)
 		for _, path := range paths {
-			inProgress := make(map[string]bool)
 			if err := idx.Fetch(
 				path,
 				ch,
@@ -63,12 +70,15 @@ https://rclone.org/ for more information and installation instructions.`,
 				!disableRecursion,
 				remote,
 				fetched,
-				inProgress,
 				logger,
 			); err != nil {
 				fatal(err)
 			}
 			logger.Info.Println()
 		}
+
+		if err := runHook("post-fetch", rootDir, paths); err != nil {
+			fatal(err)
+		}
 	},
 }
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"path/filepath"
+
+	"github.com/kevin-hanselman/dud/src/strategy"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	rootCmd.AddCommand(getCmd)
+	addRevFlag(getCmd)
+	addTagFlag(getCmd)
+	getCmd.Flags().StringVarP(
+		&getOutput,
+		"output",
+		"o",
+		"",
+		"destination path (default: the artifact's base name in the current directory)",
+	)
+	getCmd.Flags().BoolVar(
+		&getLink,
+		"link",
+		false,
+		"link to the cache instead of copying",
+	)
+}
+
+var (
+	getOutput string
+	getLink   bool
+)
+
+var getCmd = &cobra.Command{
+	Use:   "get [flags] <artifact>",
+	Short: "Copy a committed artifact to any path",
+	Long: `Get copies the committed version of a single artifact to any path.
+
+The artifact may be a stage output or a file or directory inside a directory
+output. With --rev, the artifact is looked up in the index and stage files at
+the given git revision, without touching the working tree; this is the easiest
+way to retrieve an artifact from a past experiment. With --tag, the artifact
+is looked up in the given tag instead (see 'dud tag --help'). If a remote
+cache is configured, the artifact is fetched first. For paths inside a
+directory output, only the directory manifests and the requested files are
+fetched.
+
+The artifact is copied to the path given by --output, which must not exist.
+Use --link to create links to the cache instead of copies.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// The destination is relative to the working directory, so it must be
+		// resolved before prepare() changes directories.
+		dest := getOutput
+		if dest == "" {
+			dest = filepath.Base(args[0])
+		}
+		dest, err := filepath.Abs(dest)
+		if err != nil {
+			fatal(err)
+		}
+
+		strat := strategy.CopyStrategy
+		if getLink {
+			strat = strategy.LinkStrategy
+		}
+
+		_, ch, idx, err := prepare(args)
+		if err != nil {
+			fatal(err)
+		}
+
+		if err := idx.Get(args[0], ch, viper.GetString("remote"), dest, strat); err != nil {
+			fatal(err)
+		}
+		logger.Info.Printf("got %s at %s\n", args[0], dest)
+	},
+}
@@ -9,6 +9,7 @@ var onlyStages bool
 
 func init() {
 	rootCmd.AddCommand(graphCmd)
+	addSelectionFlags(graphCmd)
 	graphCmd.Flags().BoolVar(
 		&onlyStages,
 		"stages-only",
@@ -28,7 +29,10 @@ will act on all stages in the index.
 
 You can pipe the output of this command to 'dot' from the graphviz package to
 generate images of the stage graph. Visit https://graphviz.org for more
-information about Graphviz and for installation instructions.`,
+information about Graphviz and for installation instructions.
+
+With --downstream, graph also includes all stages downstream of the given
+stage(s).`,
 	Example: "dud graph | dot -Tpng -o dud.png",
 	Run: func(cmd *cobra.Command, paths []string) {
 		_, _, idx, err := prepare(paths)
@@ -36,14 +40,17 @@ information about Graphviz and for installation instructions.`,
 			fatal(err)
 		}
 
-		if len(idx) == 0 {
+		if idx.Len() == 0 {
 			fatal(emptyIndexError{})
 		}
 
 		if len(paths) == 0 { // By default, run on the entire Index
-			for path := range idx {
-				paths = append(paths, path)
-			}
+			paths = idx.SortStagePaths()
+		}
+
+		paths, err = idx.SelectStages(paths, selectUpstream, selectDownstream)
+		if err != nil {
+			fatal(err)
 		}
 
 		graph := gographviz.NewEscape()
@@ -51,8 +58,7 @@ information about Graphviz and for installation instructions.`,
 			fatal(err)
 		}
 		for _, path := range paths {
-			inProgress := make(map[string]bool)
-			if err := idx.Graph(path, inProgress, graph, onlyStages); err != nil {
+			if err := idx.Graph(path, graph, onlyStages); err != nil {
 				fatal(err)
 			}
 		}
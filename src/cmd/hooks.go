@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"github.com/kevin-hanselman/dud/src/hook"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// loadHooks returns the hooks defined in the config.
+func loadHooks() (hook.Hooks, error) {
+	hooks := hook.Hooks(viper.GetStringMapString("hooks"))
+	return hooks, errors.Wrap(hooks.Validate(), "config")
+}
+
+// runHook runs the named hook from the config, if any, for an operation on the
+// given stages.
+func runHook(name, rootDir string, stages []string) error {
+	hooks, err := loadHooks()
+	if err != nil {
+		return err
+	}
+	return hooks.Run(name, hook.Context{RootDir: rootDir, Stages: stages})
+}
@@ -0,0 +1,427 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/cache"
+	"github.com/kevin-hanselman/dud/src/fsutil"
+	"github.com/kevin-hanselman/dud/src/gitutil"
+	"github.com/kevin-hanselman/dud/src/index"
+	"github.com/kevin-hanselman/dud/src/stage"
+	"github.com/kevin-hanselman/dud/src/strategy"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.Flags().StringVarP(
+		&importOutput,
+		"output",
+		"o",
+		"",
+		"destination path (default: the artifact's base name in the current directory)",
+	)
+	importCmd.Flags().BoolVarP(
+		&useCopyStrategy,
+		"copy",
+		"c",
+		false,
+		"copy the artifact instead of linking to the cache",
+	)
+}
+
+var importOutput string
+
+var importCmd = &cobra.Command{
+	Use:   "import [flags] <project>[@rev] <artifact>",
+	Short: "Import a committed artifact from another project",
+	Long: `Import copies a committed artifact from another Dud project into this project,
+and creates a stage that records where it came from.
+
+The other project is given as a local path or a git URL, optionally followed
+by @ and a git revision (a branch, tag, or commit). The artifact is a path in
+the other project, relative to its root; it may be a stage output or a path
+inside a directory output. The artifact's checksum is read from the other
+project's stage files: from its working tree for a local path without a
+revision, and otherwise from the given revision, or the default branch of a
+git URL.
+
+The artifact is read from the other project's local cache when possible, and
+otherwise fetched from its remote cache. Local paths, with or without a
+revision, work without network access as long as the artifact is in the
+other project's local cache.
+
+The artifact is committed to this project's cache and checked out at the
+path given by --output, which must not exist. The new stage file is the output
+path with ".yaml" appended, and it is added to the index. The stage records
+the source project, revision, and the git commit the artifact was imported
+from. Run 'dud update' to import the artifact again, for example after the
+revision moves to a new commit.
+
+Examples:
+
+  dud import ../upstream data/clean -o data/upstream-clean
+  dud import ../upstream@v2 data/clean/train.csv -o data/train.csv
+  dud import https://example.com/org/upstream.git@main models/model.bin`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		source, rev := splitImportSource(args[0])
+		artPath := filepath.Clean(args[1])
+
+		// Both the destination and a local source are relative to the working
+		// directory, so they must be resolved before prepare() changes
+		// directories.
+		dest := importOutput
+		if dest == "" {
+			dest = filepath.Base(artPath)
+		}
+		dest, err := filepath.Abs(dest)
+		if err != nil {
+			fatal(err)
+		}
+		isLocal, err := fsutil.Exists(source, true)
+		if err != nil {
+			fatal(err)
+		}
+		if isLocal {
+			if source, err = filepath.Abs(source); err != nil {
+				fatal(err)
+			}
+		}
+
+		rootDir, ch, idx, err := prepare(nil)
+		if err != nil {
+			fatal(err)
+		}
+
+		outPath, err := filepath.Rel(rootDir, dest)
+		if err != nil {
+			fatal(err)
+		}
+		if strings.HasPrefix(outPath, "..") {
+			fatal(fmt.Errorf("output %s is outside of the project root", dest))
+		}
+		if isLocal {
+			if source == rootDir {
+				fatal(errors.New("cannot import from the project itself"))
+			}
+			// Record local sources relative to the project root, so the
+			// project can be moved along with its sources.
+			if !filepath.IsAbs(args[0]) {
+				if source, err = filepath.Rel(rootDir, source); err != nil {
+					fatal(err)
+				}
+			}
+		}
+		for _, path := range []string{outPath, outPath + ".yaml"} {
+			exists, err := fsutil.Exists(path, false)
+			if err != nil {
+				fatal(err)
+			}
+			if exists {
+				fatal(fmt.Errorf("%s already exists", path))
+			}
+		}
+
+		stagePath := outPath + ".yaml"
+		newStage := stage.Stage{
+			WorkingDir: ".",
+			Import: &stage.ImportSource{
+				Source: source,
+				Rev:    rev,
+				Path:   artPath,
+			},
+			Outputs: map[string]*artifact.Artifact{
+				outPath: {Path: outPath},
+			},
+		}
+		if err := newStage.Validate(stagePath); err != nil {
+			fatal(err)
+		}
+		if err := idx.AddStage(newStage, stagePath); err != nil {
+			fatal(err)
+		}
+		stg, _ := idx.Stage(stagePath)
+
+		if _, err := importArtifact(rootDir, ch, stg); err != nil {
+			fatal(err)
+		}
+		if err := stg.ToFile(stagePath); err != nil {
+			fatal(err)
+		}
+		if err := idx.ToFile(indexPath); err != nil {
+			fatal(err)
+		}
+		if err := updateMergeDriverAttributes(rootDir, []string{stagePath}); err != nil {
+			fatal(err)
+		}
+		logger.Info.Printf("imported %s from %s to %s\n", artPath, args[0], outPath)
+		logger.Info.Printf("added stage %s to the index\n", stagePath)
+	},
+}
+
+// splitImportSource splits a "<project>[@rev]" argument into the project and
+// the revision. The argument is used as-is if it names an existing path, or if
+// the last "@" can't start a revision, as in "git@example.com:org/repo.git"
+// and "ssh://git@example.com/org/repo.git".
+func splitImportSource(arg string) (source, rev string) {
+	if _, err := os.Stat(arg); err == nil {
+		return arg, ""
+	}
+	i := strings.LastIndex(arg, "@")
+	if i <= 0 || i == len(arg)-1 {
+		return arg, ""
+	}
+	source, rev = arg[:i], arg[i+1:]
+	// Git revisions can't contain colons.
+	if strings.Contains(rev, ":") {
+		return arg, ""
+	}
+	// The "@" separates the user from the host in URLs like ssh://git@host/repo.
+	if _, afterScheme, ok := strings.Cut(source, "://"); ok && !strings.Contains(afterScheme, "/") {
+		return arg, ""
+	}
+	return source, rev
+}
+
+// importConfig holds the settings read from another project's config file.
+type importConfig struct {
+	Cache  string `yaml:"cache"`
+	Remote string `yaml:"remote"`
+}
+
+// readImportConfig reads the config file of the project at rootDir. Unlike
+// readConfig, it doesn't modify this project's config.
+func readImportConfig(rootDir string) (cfg importConfig, err error) {
+	data, err := os.ReadFile(filepath.Join(rootDir, ".dud", "config.yaml"))
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+	if err = yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, errors.Wrapf(err, "read config of %s", rootDir)
+	}
+	if cfg.Cache == "" {
+		cfg.Cache = ".dud/cache"
+	}
+	if !filepath.IsAbs(cfg.Cache) {
+		cfg.Cache = filepath.Join(rootDir, cfg.Cache)
+	}
+	return
+}
+
+// An importSource is another Dud project opened for importing Artifacts.
+type importSource struct {
+	// rootDir is the root of the project's working tree. For git URLs, it is
+	// a temporary clone of the repository.
+	rootDir string
+	idx     index.Index
+	// cacheDir is the project's local cache, or empty if it has none.
+	cacheDir string
+	remote   string
+	// commit is the git commit the Index was read from, if any.
+	commit string
+	// cleanup removes any temporary files created to open the project.
+	cleanup func()
+}
+
+// openImportSource opens the project at source, a local path or a git URL,
+// and reads its Index as of the git revision rev. For local paths, an empty
+// rev reads the Index from the working tree. For git URLs, an empty rev reads
+// the Index from the default branch.
+func openImportSource(source, rev string) (src importSource, err error) {
+	src.cleanup = func() {}
+	// Open local repositories directly, so their local cache can be used.
+	source = strings.TrimPrefix(source, "file://")
+	defer func() {
+		if err != nil {
+			src.cleanup()
+			err = errors.Wrapf(err, "open project %s", source)
+		}
+	}()
+
+	// Local paths without a .dud directory, such as bare repositories, have
+	// no working tree to read from, so they are cloned like git URLs.
+	isLocal, err := fsutil.Exists(filepath.Join(source, ".dud"), false)
+	if err != nil {
+		return
+	}
+	if isLocal {
+		if src.rootDir, err = filepath.Abs(source); err != nil {
+			return
+		}
+		var cfg importConfig
+		if cfg, err = readImportConfig(src.rootDir); err != nil {
+			return
+		}
+		src.cacheDir, src.remote = cfg.Cache, cfg.Remote
+		if rev == "" {
+			err = inDir(src.rootDir, func() (err error) {
+				src.idx, err = index.FromFile(indexPath)
+				return
+			})
+			return
+		}
+		if src.commit, err = gitutil.ResolveCommit(src.rootDir, rev); err != nil {
+			return
+		}
+		src.idx, err = index.FromRevision(src.rootDir, src.commit, indexPath)
+		return
+	}
+
+	tempDir, err := os.MkdirTemp("", "dud-import-")
+	if err != nil {
+		return
+	}
+	src.cleanup = func() { os.RemoveAll(tempDir) }
+	src.rootDir = filepath.Join(tempDir, "project")
+	if err = gitutil.Clone(source, src.rootDir); err != nil {
+		return
+	}
+	if rev == "" {
+		rev = "HEAD"
+	}
+	// Only the default branch is checked out in a fresh clone; other
+	// branches are only available as remote-tracking branches.
+	src.commit, err = gitutil.ResolveCommit(src.rootDir, rev)
+	if err != nil {
+		var originErr error
+		src.commit, originErr = gitutil.ResolveCommit(src.rootDir, "origin/"+rev)
+		if originErr != nil {
+			return
+		}
+		err = nil
+	}
+	if _, err = gitutil.Run(src.rootDir, "checkout", "--quiet", "--detach", src.commit); err != nil {
+		return
+	}
+	// The clone has no local cache, so the project's remote is the only way
+	// to get its Artifacts.
+	var cfg importConfig
+	if cfg, err = readImportConfig(src.rootDir); err != nil {
+		return
+	}
+	src.remote = cfg.Remote
+	err = inDir(src.rootDir, func() (err error) {
+		src.idx, err = index.FromFile(indexPath)
+		return
+	})
+	return
+}
+
+// get copies the committed Artifact at artPath in the source project to dest.
+// The Artifact is read from the project's local cache if possible. Otherwise,
+// it is fetched from the project's remote cache into ch.
+func (src importSource) get(artPath string, ch cache.Cache, dest string) error {
+	if src.cacheDir != "" {
+		srcCache, err := cache.NewLocalCache(src.cacheDir)
+		if err != nil {
+			return err
+		}
+		err = src.idx.Get(artPath, srcCache, "", dest, strategy.CopyStrategy)
+		var missingErr cache.MissingFromCacheError
+		if !errors.As(err, &missingErr) || src.remote == "" {
+			return err
+		}
+		logger.Debug.Printf("%s: %v; fetching from %s\n", artPath, err, src.remote)
+		if err := os.RemoveAll(dest); err != nil {
+			return err
+		}
+	}
+	if src.remote == "" {
+		return fmt.Errorf("get %s: project has no local cache or remote", artPath)
+	}
+	// The remote is defined in the project's own rclone config, which is read
+	// relative to the working directory.
+	return inDir(src.rootDir, func() error {
+		return src.idx.Get(artPath, ch, src.remote, dest, strategy.CopyStrategy)
+	})
+}
+
+// importArtifact imports the Output of the import Stage stg from its source
+// project, commits it to ch, and checks it out. The Stage's Output checksum,
+// import commit, and Stage checksum are updated. A failed import leaves the
+// existing Output in place. importArtifact returns true if the Stage changed.
+func importArtifact(rootDir string, ch cache.LocalCache, stg *stage.Stage) (bool, error) {
+	imp := stg.Import
+	var art *artifact.Artifact
+	for _, out := range stg.Outputs {
+		art = out
+	}
+	errPrefix := fmt.Sprintf("import %s", art.Path)
+
+	src, err := openImportSource(imp.Source, imp.Rev)
+	if err != nil {
+		return false, errors.Wrap(err, errPrefix)
+	}
+	defer src.cleanup()
+
+	tempDir, err := os.MkdirTemp(filepath.Join(rootDir, ".dud"), "import-")
+	if err != nil {
+		return false, errors.Wrap(err, errPrefix)
+	}
+	defer os.RemoveAll(tempDir)
+	tempPath := filepath.Join(tempDir, "artifact")
+	if err := src.get(imp.Path, ch, tempPath); err != nil {
+		return false, errors.Wrapf(err, "%s from %s", errPrefix, imp.Source)
+	}
+	info, err := os.Stat(tempPath)
+	if err != nil {
+		return false, errors.Wrap(err, errPrefix)
+	}
+
+	workPath := filepath.Join(rootDir, art.Path)
+	if err := os.RemoveAll(workPath); err != nil {
+		return false, errors.Wrap(err, errPrefix)
+	}
+	if err := os.MkdirAll(filepath.Dir(workPath), 0o755); err != nil {
+		return false, errors.Wrap(err, errPrefix)
+	}
+	if err := os.Rename(tempPath, workPath); err != nil {
+		return false, errors.Wrap(err, errPrefix)
+	}
+
+	newArt := *art
+	newArt.Checksum = ""
+	newArt.IsDir = info.IsDir()
+	strat := strategy.LinkStrategy
+	if useCopyStrategy {
+		strat = strategy.CopyStrategy
+	}
+	if err := ch.Commit(rootDir, &newArt, strat, logger); err != nil {
+		return false, errors.Wrap(err, errPrefix)
+	}
+
+	changed := newArt.Checksum != art.Checksum || src.commit != imp.Commit
+	*art = newArt
+	imp.Commit = src.commit
+	stgChecksum, err := stg.CalculateChecksum()
+	if err != nil {
+		return false, errors.Wrap(err, errPrefix)
+	}
+	changed = changed || stgChecksum != stg.Checksum
+	stg.Checksum = stgChecksum
+	return changed, nil
+}
+
+// inDir calls fn with dir as the working directory, then changes back to the
+// original working directory.
+func inDir(dir string, fn func() error) error {
+	origDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	fnErr := fn()
+	if err := os.Chdir(origDir); err != nil {
+		return err
+	}
+	return fnErr
+}
@@ -6,12 +6,18 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var initMergeDriver bool
+
 func init() {
-	rootCmd.AddCommand(&cobra.Command{
+	initCmd := &cobra.Command{
 		Use:   "init",
 		Short: "Initialize a Dud project",
-		Long:  `Init initializes a Dud project in the current directory.`,
-		Args:  cobra.NoArgs,
+		Long: `Init initializes a Dud project in the current directory.
+
+With --merge-driver, init also registers 'dud merge-driver' as the git merge
+driver for stage files (see 'dud merge-driver --help'). Stage files are routed
+to the merge driver as they are added to the index.`,
+		Args: cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
 			if err := os.MkdirAll(".dud/cache", 0o755); err != nil {
 				fatal(err)
@@ -34,6 +40,24 @@ func init() {
 #
 # For more info, see the rclone docs:
 # https://rclone.org/docs/#syntax-of-remote-paths
+
+# Hooks are shell commands run in the project root before ("pre-") and after
+# ("post-") commit, run, checkout, push, and fetch, and around each stage
+# command executed by run ("pre-stage" and "post-stage"). A failing pre-hook
+# aborts the operation. Hooks receive their context as JSON on stdin, and in
+# the DUD_HOOK, DUD_OPERATION, DUD_ROOT, DUD_STAGES, and DUD_STAGE environment
+# variables. For example:
+#
+# hooks:
+#   pre-commit: ./scripts/lint-stages.sh
+#   post-push: ./scripts/update-catalog.sh
+
+# When stage files are merged with 'dud merge-driver', 'merge-policy' decides
+# how artifacts committed differently on both sides are resolved: keep "ours",
+# keep "theirs", or leave them "uncommitted" (the default) to be re-run or
+# re-committed after the merge.
+#
+# merge-policy: uncommitted
 `
 
 			if err := os.WriteFile(".dud/config.yaml", []byte(dudConf), 0o644); err != nil {
@@ -58,8 +82,21 @@ func init() {
 				fatal(err)
 			}
 
+			if initMergeDriver {
+				if err := installMergeDriver(".", nil); err != nil {
+					fatal(err)
+				}
+			}
+
 			logger.Info.Println(`Dud project initialized.
 See .dud/config.yaml and .dud/rclone.conf to customize the project.`)
 		},
-	})
+	}
+	initCmd.Flags().BoolVar(
+		&initMergeDriver,
+		"merge-driver",
+		false,
+		"register 'dud merge-driver' for stage files in git",
+	)
+	rootCmd.AddCommand(initCmd)
 }
@@ -0,0 +1,423 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/kevin-hanselman/dud/src/cache"
+	"github.com/kevin-hanselman/dud/src/fsutil"
+	"github.com/kevin-hanselman/dud/src/gitutil"
+	"github.com/kevin-hanselman/dud/src/index"
+	"github.com/kevin-hanselman/dud/src/strategy"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	rootCmd.AddCommand(installHooksCmd)
+	installHooksCmd.Flags().StringVar(
+		&installHooksPrePush,
+		"pre-push",
+		"push",
+		"what the pre-push hook does: push artifacts, or verify they were pushed",
+	)
+	installHooksCmd.Flags().BoolVarP(
+		&useCopyStrategy,
+		"copy",
+		"c",
+		false,
+		"copy artifacts instead of linking when checking out",
+	)
+
+	rootCmd.AddCommand(gitHookCmd)
+	gitHookCmd.Flags().BoolVar(
+		&gitHookVerify,
+		"verify",
+		false,
+		"pre-push: verify artifacts were pushed instead of pushing them",
+	)
+	gitHookCmd.Flags().BoolVarP(
+		&useCopyStrategy,
+		"copy",
+		"c",
+		false,
+		"copy artifacts instead of linking",
+	)
+}
+
+var (
+	installHooksPrePush string
+	gitHookVerify       bool
+)
+
+// gitHookNames are the git hooks installed by install-hooks.
+var gitHookNames = []string{"post-checkout", "post-merge", "pre-push"}
+
+// gitHookMarker identifies hooks written by install-hooks.
+const gitHookMarker = "# Installed by 'dud install-hooks'."
+
+// chainedHookSuffix is appended to the name of a hook that install-hooks
+// replaced. The replaced hook is run before Dud's.
+const chainedHookSuffix = ".pre-dud"
+
+// nullRev is the revision git passes to hooks in place of a missing commit.
+const nullRev = "0000000000000000000000000000000000000000"
+
+var gitHookTemplate = template.Must(template.New("hook").Parse(`#!/bin/sh
+` + gitHookMarker + `
+# Any hook previously installed here was moved to {{.Name}}` + chainedHookSuffix + `,
+# and is run first. Re-run 'dud install-hooks' to update this hook.
+{{- if .Stdin}}
+input=$(cat)
+{{- end}}
+chained="$(dirname "$0")/{{.Name}}` + chainedHookSuffix + `"
+if [ -x "$chained" ]; then
+	{{if .Stdin}}printf '%s\n' "$input" | {{end}}"$chained" "$@" || exit $?
+fi
+if ! command -v dud >/dev/null 2>&1; then
+	echo "dud not found in PATH; skipping the Dud {{.Name}} hook" >&2
+	exit 0
+fi
+{{- if .ProjectDir}}
+cd {{.ProjectDir}} || exit $?
+{{- end}}
+{{if .Stdin}}printf '%s\n' "$input" | {{end}}dud git-hook {{.Args}}"$@"
+`))
+
+var installHooksCmd = &cobra.Command{
+	Use:   "install-hooks [flags]",
+	Short: "Install git hooks that check out and push artifacts",
+	Long: `Install-hooks installs git hooks that keep artifacts in sync with git.
+
+  post-checkout  after 'git checkout' or 'git switch', check out the stages
+                 whose stage files changed
+  post-merge     after 'git merge' or 'git pull', check out the stages whose
+                 stage files changed
+  pre-push       before 'git push', push the artifacts committed at the
+                 pushed revisions to the remote cache
+
+If a remote cache is configured, the post-checkout and post-merge hooks fetch
+the changed stages before checking them out. Only the changed stages are
+checked out; upstream stages are not. With --pre-push=verify, the pre-push
+hook doesn't push anything, and instead aborts the git push if any of the
+artifacts are missing from the remote cache. If no remote cache is configured,
+the pre-push hook does nothing. Use 'git push --no-verify' to skip it.
+
+Install-hooks may be run more than once; it updates the hooks it previously
+installed. Existing hooks are kept: each is renamed with a "` + chainedHookSuffix + `" suffix
+and run before Dud's hook. The hooks require dud to be in your PATH, and do
+nothing if it isn't.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if installHooksPrePush != "push" && installHooksPrePush != "verify" {
+			fatal(fmt.Errorf(
+				"invalid --pre-push %#v (want \"push\" or \"verify\")",
+				installHooksPrePush,
+			))
+		}
+		rootDir, err := getProjectRootDir()
+		if err != nil {
+			fatal(err)
+		}
+		installed, err := installGitHooks(rootDir)
+		if err != nil {
+			fatal(err)
+		}
+		for _, path := range installed {
+			logger.Info.Printf("installed %s\n", path)
+		}
+	},
+}
+
+// installGitHooks installs or updates the git hooks for the project at rootDir
+// and returns their paths.
+func installGitHooks(rootDir string) ([]string, error) {
+	hooksDir, err := gitutil.HooksDir(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	prefix, err := gitutil.Prefix(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(hooksDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	installed := make([]string, 0, len(gitHookNames))
+	for _, name := range gitHookNames {
+		script, err := gitHookScript(name, prefix)
+		if err != nil {
+			return installed, err
+		}
+		path := filepath.Join(hooksDir, name)
+		if err := chainGitHook(path); err != nil {
+			return installed, err
+		}
+		if err := os.WriteFile(path, script, 0o755); err != nil {
+			return installed, err
+		}
+		// WriteFile doesn't change the permissions of existing files.
+		if err := os.Chmod(path, 0o755); err != nil {
+			return installed, err
+		}
+		installed = append(installed, path)
+	}
+	return installed, nil
+}
+
+// gitHookScript returns the script for the named hook. The script changes to
+// projectDir, which is relative to the root of the git repository, before
+// running Dud.
+func gitHookScript(name, projectDir string) ([]byte, error) {
+	args := name + " "
+	if useCopyStrategy && name != "pre-push" {
+		args += "--copy "
+	}
+	if installHooksPrePush == "verify" && name == "pre-push" {
+		args += "--verify "
+	}
+	if projectDir != "" {
+		projectDir = "'" + strings.ReplaceAll(projectDir, "'", `'\''`) + "'"
+	}
+	var buf bytes.Buffer
+	err := gitHookTemplate.Execute(&buf, struct {
+		Name, Args, ProjectDir string
+		Stdin                  bool
+	}{
+		Name:       name,
+		Args:       args,
+		ProjectDir: projectDir,
+		Stdin:      name == "pre-push",
+	})
+	return buf.Bytes(), err
+}
+
+// chainGitHook moves the hook at path aside so Dud's hook can run it, unless
+// the hook doesn't exist or was installed by Dud.
+func chainGitHook(path string) error {
+	contents, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(contents, []byte(gitHookMarker)) {
+		return nil
+	}
+	chainedPath := path + chainedHookSuffix
+	exists, err := fsutil.Exists(chainedPath, false)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf(
+			"cannot install %s: both it and %s exist; merge them or remove one",
+			path,
+			chainedPath,
+		)
+	}
+	return os.Rename(path, chainedPath)
+}
+
+var gitHookCmd = &cobra.Command{
+	Use:    "git-hook [flags] <hook> [hook_args]...",
+	Short:  "Run the Dud part of a git hook",
+	Long:   `Git-hook is run by the git hooks installed by install-hooks.`,
+	Hidden: true,
+	Args:   cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		switch name, hookArgs := args[0], args[1:]; name {
+		case "post-checkout":
+			// Git passes the previous and new HEAD, and a flag that is 1 for
+			// branch checkouts and 0 for file checkouts.
+			if len(hookArgs) != 3 {
+				fatal(fmt.Errorf("%s: expected 3 arguments, got %d", name, len(hookArgs)))
+			}
+			if hookArgs[2] == "0" {
+				return
+			}
+			err = checkoutChangedStages(hookArgs[0], hookArgs[1])
+		case "post-merge":
+			// Git passes a flag that is 1 for squash merges, which don't
+			// move HEAD.
+			if len(hookArgs) > 0 && hookArgs[0] == "1" {
+				err = checkoutChangedStages("HEAD", "")
+			} else {
+				err = checkoutChangedStages("ORIG_HEAD", "HEAD")
+			}
+		case "pre-push":
+			err = pushRevisions(os.Stdin)
+		default:
+			err = fmt.Errorf("unknown git hook %#v", name)
+		}
+		if err != nil {
+			fatal(err)
+		}
+	},
+}
+
+// checkoutChangedStages checks out the Stages whose stage files differ
+// between the from and to revisions. If to is empty, the working tree is
+// used.
+func checkoutChangedStages(from, to string) error {
+	rootDir, ch, idx, err := prepare(nil)
+	if err != nil {
+		return err
+	}
+
+	var paths []string
+	if from == nullRev {
+		// There was no previous HEAD, e.g. after a clone.
+		paths = idx.SortStagePaths()
+	} else {
+		changed, err := gitutil.ChangedFiles(rootDir, from, to)
+		if err != nil {
+			return err
+		}
+		for _, path := range changed {
+			if _, ok := idx.Stage(path); ok {
+				paths = append(paths, path)
+			}
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	if remote := viper.GetString("remote"); remote != "" {
+		fetched := make(map[string]bool)
+		for _, path := range paths {
+			if err := idx.Fetch(
+				path,
+				ch,
+				rootDir,
+				false,
+				remote,
+				fetched,
+				logger,
+			); err != nil {
+				return err
+			}
+		}
+	}
+
+	strat := strategy.LinkStrategy
+	if useCopyStrategy {
+		strat = strategy.CopyStrategy
+	}
+	if err := runHook("pre-checkout", rootDir, paths); err != nil {
+		return err
+	}
+	checkedOut := make(map[string]bool)
+	for _, path := range paths {
+		if err := idx.Checkout(
+			path,
+			ch,
+			rootDir,
+			strat,
+			false,
+			checkedOut,
+			logger,
+		); err != nil {
+			return err
+		}
+	}
+	return runHook("post-checkout", rootDir, paths)
+}
+
+// pushRevisions pushes the Artifacts committed at the revisions git is about
+// to push, which are read from the pre-push hook's input. With --verify, it
+// checks that the Artifacts were already pushed instead.
+func pushRevisions(input io.Reader) error {
+	rootDir, ch, _, err := prepare(nil)
+	if err != nil {
+		return err
+	}
+	remote := viper.GetString("remote")
+	if remote == "" {
+		logger.Info.Println("no remote cache configured; not pushing artifacts")
+		return nil
+	}
+
+	// Each line of input reads: <local ref> <local sha> <remote ref> <remote sha>
+	revs := make(map[string]bool)
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 4 || fields[1] == nullRev {
+			// Malformed, or deleting a remote ref.
+			continue
+		}
+		revs[fields[1]] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for rev := range revs {
+		if !gitutil.Exists(rootDir, rev, indexPath) {
+			continue
+		}
+		idx, err := index.FromRevision(rootDir, rev, indexPath)
+		if err != nil {
+			return err
+		}
+		paths := idx.SortStagePaths()
+		if gitHookVerify {
+			err = verifyPushed(idx, ch, remote, paths)
+		} else {
+			err = pushStages(idx, ch, rootDir, remote, paths)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "revision %s", rev)
+		}
+	}
+	return nil
+}
+
+func pushStages(
+	idx index.Index,
+	ch cache.Cache,
+	rootDir, remote string,
+	paths []string,
+) error {
+	if err := runHook("pre-push", rootDir, paths); err != nil {
+		return err
+	}
+	pushed := make(map[string]bool)
+	for _, path := range paths {
+		if err := idx.Push(
+			path,
+			ch,
+			rootDir,
+			false,
+			remote,
+			pushed,
+			logger,
+		); err != nil {
+			return err
+		}
+	}
+	return runHook("post-push", rootDir, paths)
+}
+
+func verifyPushed(idx index.Index, ch cache.Cache, remote string, paths []string) error {
+	for _, path := range paths {
+		stg, _ := idx.Stage(path)
+		logger.Info.Printf("verifying stage %s\n", path)
+		if err := ch.Verify(remote, stg.Outputs); err != nil {
+			return errors.Wrapf(err, "stage %s", path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kevin-hanselman/dud/src/gitutil"
+	"github.com/kevin-hanselman/dud/src/stage"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	rootCmd.AddCommand(mergeDriverCmd)
+	mergeDriverCmd.Flags().BoolVar(
+		&mergeDriverInstall,
+		"install",
+		false,
+		"register the merge driver in the git config and .gitattributes, then exit",
+	)
+	mergeDriverCmd.Flags().StringVar(
+		&mergeDriverPolicy,
+		"policy",
+		"",
+		"how to resolve conflicting commits: ours, theirs, or uncommitted\n"+
+			"(default: the 'merge-policy' config value, or uncommitted)",
+	)
+}
+
+var (
+	mergeDriverInstall bool
+	mergeDriverPolicy  string
+)
+
+var mergeDriverCmd = &cobra.Command{
+	Use:   "merge-driver [flags] <base> <ours> <theirs> [<path>]",
+	Short: "Merge stage files as a git merge driver",
+	Long: `Merge-driver performs a three-way merge of a stage file for git.
+
+Git calls the merge driver with the common ancestor (base), our version, and
+their version of a file, along with the file's path in the project. The merged
+stage file replaces our version.
+
+Changes to the stage definition (its command, inputs, outputs, etc.) are
+merged like any other change; if both sides changed the same part of the
+definition differently, the merge fails. Artifacts committed differently on
+both sides are resolved according to the merge policy:
+
+  ours         keep our side's commit
+  theirs       keep their side's commit
+  uncommitted  mark the artifact uncommitted, so it must be re-run or
+               re-committed after the merge (default)
+
+The policy is read from the 'merge-policy' config value, and may be
+overridden with --policy.
+
+Files that aren't stage files, and stage files with conflicting definitions,
+fall back to git's line-based merge, leaving conflict markers for you to
+resolve.
+
+Use --install (or 'dud init --merge-driver') to register the merge driver in
+the git config, and to route each stage file in the index to it in the
+project's .gitattributes. Once the merge driver is registered, 'dud stage add'
+and 'dud import' add their stage files to .gitattributes as well. Other YAML
+files are left to git's default merge.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if mergeDriverInstall {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.RangeArgs(3, 4)(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if mergeDriverInstall {
+			rootDir, _, idx, err := prepare(nil)
+			if err != nil {
+				fatal(err)
+			}
+			if err := installMergeDriver(rootDir, idx.SortStagePaths()); err != nil {
+				fatal(err)
+			}
+			logger.Info.Println("Registered the Dud merge driver for stage files.")
+			return
+		}
+
+		basePath, oursPath, theirsPath := args[0], args[1], args[2]
+		stagePath := oursPath
+		if len(args) == 4 {
+			stagePath = args[3]
+		}
+
+		policy, err := getMergePolicy(stagePath)
+		if err != nil {
+			fatal(err)
+		}
+
+		merged, err := mergeStageFiles(stagePath, basePath, oursPath, theirsPath, policy)
+		if err != nil {
+			logger.Info.Printf("%s: %v; falling back to a line-based merge\n", stagePath, err)
+			if err := mergeLines(basePath, oursPath, theirsPath); err != nil {
+				fatal(fmt.Errorf("%s: conflicts remain; resolve them and commit the result", stagePath))
+			}
+			return
+		}
+		if err := merged.ToFile(oursPath); err != nil {
+			fatal(err)
+		}
+	},
+}
+
+// getMergePolicy returns the merge policy from the command line, or else from
+// the config of the project containing stagePath.
+func getMergePolicy(stagePath string) (stage.MergePolicy, error) {
+	if mergeDriverPolicy != "" {
+		return stage.ParseMergePolicy(mergeDriverPolicy)
+	}
+	absPath, err := filepath.Abs(stagePath)
+	if err != nil {
+		return "", err
+	}
+	rootDir, err := findProjectRootDir(filepath.Dir(absPath))
+	if err != nil {
+		return "", err
+	}
+	viper.SetDefault("merge-policy", string(stage.MergeUncommitted))
+	if err := readConfig(rootDir); err != nil {
+		return "", err
+	}
+	return stage.ParseMergePolicy(viper.GetString("merge-policy"))
+}
+
+// mergeStageFiles loads the three versions of the stage file at stagePath and
+// merges them. An empty base is treated as an empty Stage, as git uses an
+// empty base when both sides added the file.
+func mergeStageFiles(
+	stagePath, basePath, oursPath, theirsPath string,
+	policy stage.MergePolicy,
+) (merged stage.Stage, err error) {
+	var stages [3]stage.Stage
+	for i, path := range []string{basePath, oursPath, theirsPath} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return merged, err
+		}
+		if i == 0 && len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+		stages[i], err = stage.FromBytes(stagePath, data)
+		if err != nil {
+			return merged, err
+		}
+	}
+	merged, err = stage.Merge(stages[0], stages[1], stages[2], policy)
+	if err != nil {
+		return merged, err
+	}
+	if err := merged.Validate(stagePath); err != nil {
+		return merged, errors.Wrap(err, "merged stage is invalid")
+	}
+	return merged, nil
+}
+
+// mergeLines merges the files with 'git merge-file', writing the result (with
+// any conflict markers) to oursPath. It returns an error if the merge had
+// conflicts.
+func mergeLines(basePath, oursPath, theirsPath string) error {
+	_, err := gitutil.Run(
+		".",
+		"merge-file",
+		"-L", "ours",
+		"-L", "base",
+		"-L", "theirs",
+		oursPath,
+		basePath,
+		theirsPath,
+	)
+	return err
+}
+
+// mergeDriverHeader precedes the merge driver's lines in .gitattributes.
+const mergeDriverHeader = "# Merge Dud stage files with 'dud merge-driver'."
+
+// installMergeDriver registers the merge driver in the git config of the
+// repository containing rootDir, and routes the given stage files to it in
+// rootDir/.gitattributes. It is safe to call more than once.
+func installMergeDriver(rootDir string, stagePaths []string) error {
+	if _, err := gitutil.Run(rootDir, "config", "merge.dud.name", "Dud stage file merge driver"); err != nil {
+		return err
+	}
+	if _, err := gitutil.Run(
+		rootDir,
+		"config",
+		"merge.dud.driver",
+		"dud merge-driver %O %A %B %P",
+	); err != nil {
+		return err
+	}
+	return addMergeDriverAttributes(rootDir, stagePaths)
+}
+
+// updateMergeDriverAttributes routes the given stage files to the merge driver
+// in rootDir/.gitattributes, if the merge driver is registered in the git
+// config.
+func updateMergeDriverAttributes(rootDir string, stagePaths []string) error {
+	if _, err := gitutil.Run(rootDir, "config", "--get", "merge.dud.driver"); err != nil {
+		return nil
+	}
+	return addMergeDriverAttributes(rootDir, stagePaths)
+}
+
+// mergeDriverAttribute returns the .gitattributes line that routes the stage
+// file at stagePath (relative to the project root) to the merge driver.
+func mergeDriverAttribute(stagePath string) string {
+	// Anchor the pattern to the project root so it only matches this file.
+	pattern := "/" + filepath.ToSlash(stagePath)
+	if strings.ContainsAny(pattern, " \t\"\\") {
+		pattern = strconv.Quote(pattern)
+	}
+	return pattern + " merge=dud"
+}
+
+// addMergeDriverAttributes appends a line to rootDir/.gitattributes for each of
+// the given stage files that isn't already routed to the merge driver.
+func addMergeDriverAttributes(rootDir string, stagePaths []string) error {
+	attrPath := filepath.Join(rootDir, ".gitattributes")
+	attrs, err := os.ReadFile(attrPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	existing := make(map[string]bool)
+	for _, line := range strings.Split(string(attrs), "\n") {
+		existing[strings.Join(strings.Fields(line), " ")] = true
+	}
+	var missing []string
+	for _, stagePath := range stagePaths {
+		line := mergeDriverAttribute(stagePath)
+		if !existing[line] {
+			existing[line] = true
+			missing = append(missing, line)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if len(attrs) > 0 && !bytes.HasSuffix(attrs, []byte("\n")) {
+		buf.WriteString("\n")
+	}
+	if !existing[mergeDriverHeader] {
+		buf.WriteString(mergeDriverHeader + "\n")
+	}
+	for _, line := range missing {
+		buf.WriteString(line + "\n")
+	}
+	attrFile, err := os.OpenFile(attrPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer attrFile.Close()
+	_, err = attrFile.Write(buf.Bytes())
+	return err
+}
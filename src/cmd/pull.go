@@ -6,6 +6,8 @@ import (
 
 func init() {
 	rootCmd.AddCommand(pullCmd)
+	addRevFlag(pullCmd)
+	addTagFlag(pullCmd)
 	pullCmd.Flags().BoolVarP(
 		&useCopyStrategy,
 		"copy",
@@ -27,6 +29,10 @@ var pullCmd = &cobra.Command{
 	Short: "Fetch artifacts from the remote and checkout",
 	Long: `Pull runs fetch followed by checkout.
 
+With --rev, both steps read the index and stage files from the given git
+revision instead of the working tree. With --tag, both steps operate on the
+artifacts recorded in the given tag. See 'dud checkout --help'.
+
 This command requires rclone to be installed on your machine. Visit
 https://rclone.org/ for more information and installation instructions.`,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -39,21 +39,22 @@ https://rclone.org/ for more information and installation instructions.`,
 			fatal(noRemoteError{})
 		}
 
-		if len(idx) == 0 {
+		if idx.Len() == 0 {
 			fatal(emptyIndexError{})
 		}
 
 		if len(paths) == 0 {
 			// Ignore disableRecursion flag when no args passed.
 			disableRecursion = false
-			for path := range idx {
-				paths = append(paths, path)
-			}
+			paths = idx.SortStagePaths()
+		}
+
+		if err := runHook("pre-push", rootDir, paths); err != nil {
+			fatal(err)
 		}
 
 		pushed := make(map[string]bool)
 		for _, path := range paths {
-			inProgress := make(map[string]bool)
 			if err := idx.Push(
 				path,
 				ch,
@@ -61,12 +62,15 @@ https://rclone.org/ for more information and installation instructions.`,
 				!disableRecursion,
 				remote,
 				pushed,
-				inProgress,
 				logger,
 			); err != nil {
 				fatal(err)
 			}
 			logger.Info.Println()
 		}
+
+		if err := runHook("post-push", rootDir, paths); err != nil {
+			fatal(err)
+		}
 	},
 }
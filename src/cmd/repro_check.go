@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/kevin-hanselman/dud/src/index"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(reproCheckCmd)
+	addSelectionFlags(reproCheckCmd)
+}
+
+func writeReproResults(writer io.Writer, stagePath string, results []index.ReproResult) {
+	fmt.Fprintf(writer, "%s\n", stagePath)
+	for _, res := range results {
+		if res.Reproduced() {
+			fmt.Fprintf(writer, "  %s\treproduced\n", res.Path)
+			continue
+		}
+		if res.NewChecksum == "" {
+			fmt.Fprintf(writer, "  %s\tnot reproduced (missing)\n", res.Path)
+			continue
+		}
+		fmt.Fprintf(writer, "  %s\tnot reproduced\n", res.Path)
+		for _, files := range []struct {
+			label string
+			paths []string
+		}{
+			{"added", res.Added},
+			{"removed", res.Removed},
+			{"modified", res.Modified},
+		} {
+			if len(files.paths) > 0 {
+				fmt.Fprintf(writer, "    %s:\t%s\n", files.label, strings.Join(files.paths, ", "))
+			}
+		}
+	}
+}
+
+var reproCheckCmd = &cobra.Command{
+	Use:   "repro-check [flags] [stage_file]...",
+	Short: "Verify that stages reproduce their committed outputs",
+	Long: `Repro-check verifies that stages reproduce their committed outputs.
+
+For each stage file passed in, repro-check reruns the stage's command in a
+scratch copy of the workspace and compares the resulting outputs with the
+checksums recorded in the stage file. Inputs owned by other stages are checked
+out from the cache at their recorded checksums, so upstream stages are not
+rerun. All other inputs are taken from the workspace, and must match their
+recorded checksums. The workspace, stage files, and cache are left untouched.
+
+Repro-check reports, for each output, whether it was reproduced exactly. For
+directory outputs that differ, the files that were added, removed, or modified
+are listed. If no stage files are passed in, repro-check will act on all
+stages in the index. Repro-check exits with an error if any output was not
+reproduced.`,
+	Run: func(cmd *cobra.Command, paths []string) {
+		rootDir, ch, idx, err := prepare(paths)
+		if err != nil {
+			fatal(err)
+		}
+
+		if idx.Len() == 0 {
+			fatal(emptyIndexError{})
+		}
+
+		if len(paths) == 0 {
+			paths = idx.SortStagePaths()
+		}
+
+		paths, err = idx.SelectStages(paths, selectUpstream, selectDownstream)
+		if err != nil {
+			fatal(err)
+		}
+
+		failures := 0
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, path := range paths {
+			if stg, _ := idx.Stage(path); stg.Command == "" {
+				logger.Info.Printf("skipping stage %s (no command)\n", path)
+				continue
+			}
+			logger.Info.Printf("rerunning stage %s\n", path)
+			results, err := idx.ReproCheck(path, ch, rootDir, logger)
+			if err != nil {
+				fatal(err)
+			}
+			for _, res := range results {
+				if !res.Reproduced() {
+					failures++
+				}
+			}
+			writeReproResults(writer, path, results)
+			fmt.Fprintln(writer)
+		}
+		writer.Flush()
+
+		if failures > 0 {
+			fatal(errors.Errorf("%d output(s) not reproduced", failures))
+		}
+	},
+}
@@ -13,7 +13,9 @@ import (
 	"github.com/kevin-hanselman/dud/src/agglog"
 	"github.com/kevin-hanselman/dud/src/cache"
 	"github.com/kevin-hanselman/dud/src/fsutil"
+	"github.com/kevin-hanselman/dud/src/gitutil"
 	"github.com/kevin-hanselman/dud/src/index"
+	"github.com/kevin-hanselman/dud/src/tag"
 	"github.com/mitchellh/go-homedir"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -24,6 +26,9 @@ import (
 const (
 	indexPath = ".dud/index"
 	lockPath  = ".dud/lock"
+	tagsDir   = ".dud/tags"
+
+	snapshotLogPath = ".dud/snapshots"
 )
 
 type emptyIndexError struct{}
@@ -133,7 +138,7 @@ func init() {
 
 // Main is the entry point to the cobra CLI.
 func Main() {
-	logger.Info.Println("[dud-fork] Running custom forked Dud CLI!")
+	fmt.Fprintln(os.Stderr, "[dud-fork] Running custom forked Dud CLI!")
 
 	if err := rootCmd.Execute(); err != nil {
 		fatal(err)
@@ -221,7 +226,12 @@ func getProjectRootDir() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	return findProjectRootDir(dirname)
+}
 
+// findProjectRootDir returns the closest directory containing a .dud directory,
+// starting at dirname and moving up the directory tree.
+func findProjectRootDir(dirname string) (string, error) {
 	for {
 		dudFolderExists, err := fsutil.Exists(filepath.Join(dirname, ".dud"), false)
 		if err != nil {
@@ -325,7 +335,74 @@ func prepare(paths []string) (rootDir string, ch cache.LocalCache, idx index.Ind
 		return
 	}
 
+	if gitRev != "" && fromTag != "" {
+		err = errors.New("--rev and --tag cannot be used together")
+		return
+	}
+	if fromTag != "" {
+		var t tag.Tag
+		if t, err = tag.FromFile(tagsDir, fromTag); err != nil {
+			return
+		}
+		idx, err = index.FromTag(t)
+		return
+	}
+	if gitRev != "" {
+		if _, err = gitutil.ResolveCommit(rootDir, gitRev); err != nil {
+			return
+		}
+		idx, err = index.FromRevision(rootDir, gitRev, indexPath)
+		return
+	}
 	idx, err = index.FromFile(indexPath)
 	return
 }
 
+// gitRev is the git revision to read the index and stage files from, if set.
+// See addRevFlag.
+var gitRev string
+
+// addRevFlag adds a flag to cmd for reading the index and stage files from a
+// git revision instead of the working tree.
+func addRevFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(
+		&gitRev,
+		"rev",
+		"",
+		"read the index and stage files from this git revision",
+	)
+}
+
+// fromTag is the name of the tag to read artifacts from, if set. See
+// addTagFlag.
+var fromTag string
+
+// addTagFlag adds a flag to cmd for operating on the artifacts recorded in a
+// tag instead of the index.
+func addTagFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(
+		&fromTag,
+		"tag",
+		"",
+		"operate on the artifacts recorded in this tag (see 'dud tag')",
+	)
+}
+
+var selectUpstream, selectDownstream bool
+
+// addSelectionFlags adds flags to cmd for expanding the given stages to
+// include all upstream and/or downstream stages.
+func addSelectionFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(
+		&selectUpstream,
+		"upstream",
+		false,
+		"also select all stages upstream of the given stage(s)",
+	)
+	cmd.Flags().BoolVar(
+		&selectDownstream,
+		"downstream",
+		false,
+		"also select all stages downstream of the given stage(s)",
+	)
+}
@@ -1,11 +1,16 @@
 package cmd
 
 import (
+	"fmt"
+
+	"github.com/kevin-hanselman/dud/src/hook"
+	"github.com/kevin-hanselman/dud/src/index"
 	"github.com/spf13/cobra"
 )
 
 func init() {
 	rootCmd.AddCommand(runCmd)
+	addSelectionFlags(runCmd)
 	runCmd.Flags().BoolVarP(
 		&runSingleStage,
 		"single-stage",
@@ -13,12 +18,48 @@ func init() {
 		false,
 		"disable recursive operation on upstream stages",
 	)
+	runCmd.Flags().BoolVarP(
+		&runForce,
+		"force",
+		"f",
+		false,
+		"run the given stage(s) even if they are up-to-date",
+	)
+	runCmd.Flags().StringVar(
+		&runFrom,
+		"from",
+		"",
+		"force-run the given stage and all stages downstream of it",
+	)
+	runCmd.Flags().BoolVarP(
+		&runWatch,
+		"watch",
+		"w",
+		false,
+		"watch inputs and stage files, and rerun affected stages on changes",
+	)
+	runCmd.Flags().BoolVar(
+		&runSandbox,
+		"sandbox",
+		false,
+		"run commands in a temporary tree containing only declared inputs",
+	)
+	runCmd.Flags().StringVar(
+		&runCheckWrites,
+		"check-writes",
+		"",
+		"report (\"warn\") or fail on (\"fail\") writes outside declared outputs",
+	)
+	runCmd.Flags().Lookup("check-writes").NoOptDefVal = "warn"
 }
 
-var runSingleStage bool
+var (
+	runSingleStage, runForce, runWatch, runSandbox bool
+	runFrom, runCheckWrites                        string
+)
 
 var runCmd = &cobra.Command{
-	Use:   "run [flags] [stage_file]...",
+	Use:   "run [flags] [stage_file | artifact]...",
 	Short: "Run stages or pipelines",
 	Long: `Run runs stages or pipelines.
 
@@ -26,31 +67,173 @@ For each stage passed in, run executes a stage's command if it is out-of-date.
 If no stage files are passed in, run will act on all stages in the index. By
 default, run will act recursively on all stages upstream of the given stage,
 and thus run will execute a stage's command if any upstream stages are
-out-of-date.`,
+out-of-date.
+
+With --downstream, run also acts on all stages downstream of the given
+stage(s), in dependency order. This is useful for propagating a change through
+the rest of a pipeline.
+
+Artifacts may be passed in place of stage files, in which case run acts on the
+stage that owns each artifact. With --force, run executes the commands of the
+given stage(s) even if they are up-to-date. With --from, run forces the given
+stage (or the owner of the given artifact) and all stages downstream of it to
+run. Forced stages never restore their outputs from the run cache.
+
+Before executing a stage's command, run deletes the stage's outputs, so files
+the command no longer produces aren't committed along with the new outputs.
+Outputs marked 'persist: true' in the stage file are kept instead, and any of
+their files linked to the cache are replaced with writable copies. This is
+useful for outputs that a command updates incrementally, such as checkpoints.
+After the command succeeds, each output's 'validate' command (if any) is run;
+if any validation fails, the stage is not committed.
+
+With --sandbox, each command runs in a temporary directory tree outside of the
+project that only contains the stage's declared inputs, copies of its
+persisted outputs, and its working directory. Committed inputs are linked from
+the cache, unless the workspace holds a different version; other inputs are
+read-only copies. Afterwards, the declared outputs are moved back into the
+workspace. A stage fails if it doesn't produce all of its
+declared outputs, and the error lists any undeclared files the command
+produced. This helps catch stages that read or write files they don't declare.
+
+With --check-writes, run snapshots the workspace (excluding .dud) before and
+after each command, and reports any files the command created or modified
+other than its stage's outputs. Writes inside directory outputs that own the
+stage's inputs are not reported, nor are writes by the "pre-stage" and
+"post-stage" hooks. Use --check-writes=fail to make reported writes fail the
+stage instead.
+
+With --watch, run keeps running after the initial run. It watches the stage
+files of the selected stages (and of upstream stages, unless --single-stage is
+set) along with all of their inputs not owned by other stages. When any of
+these change, run reruns the affected stages and all stages downstream of
+them, then prints a one-line summary. Failed stages don't stop the watch. Only
+the initial run is affected by --force and --from. Press Ctrl-C to stop
+watching.
+
+The "pre-run" and "post-run" hooks in the config file run before and after
+the selected stages (once per cycle with --watch), and the "pre-stage" and
+"post-stage" hooks run around each stage command that is executed. See the
+config file created by 'dud init' for details.`,
 	Run: func(cmd *cobra.Command, paths []string) {
-		rootDir, ch, idx, err := prepare(paths)
+		// Adjust the --from path along with the positional args, so it is
+		// relative to the project root like the rest.
+		args := paths
+		if runFrom != "" {
+			args = append(args[:len(args):len(args)], runFrom)
+		}
+		rootDir, ch, idx, err := prepare(args)
 		if err != nil {
 			fatal(err)
 		}
+		paths = args[:len(paths)]
+		from := ""
+		if runFrom != "" {
+			from = args[len(args)-1]
+		}
 
-		if len(idx) == 0 {
+		if idx.Len() == 0 {
 			fatal(emptyIndexError{})
 		}
 
-		if len(paths) == 0 {
-			for path := range idx {
-				paths = append(paths, path)
+		if runWatch {
+			if err := watchRun(rootDir, ch, idx, paths, from); err != nil {
+				fatal(err)
 			}
+			return
+		}
+
+		paths, opts, err := selectRunStages(idx, paths, from)
+		if err != nil {
+			fatal(err)
+		}
+
+		hookCtx := hook.Context{RootDir: rootDir, Stages: paths}
+		if err := opts.Hooks.Run("pre-run", hookCtx); err != nil {
+			fatal(err)
 		}
 
 		ran := make(map[string]bool)
 		for _, path := range paths {
-			inProgress := make(map[string]bool)
-			err := idx.Run(path, ch, rootDir, !runSingleStage, ran, inProgress, logger)
+			err := idx.Run(path, ch, rootDir, opts, ran, logger)
 			if err != nil {
 				fatal(err)
 			}
 			logger.Info.Println()
 		}
+
+		if err := opts.Hooks.Run("post-run", hookCtx); err != nil {
+			fatal(err)
+		}
 	},
 }
+
+// selectRunStages resolves the targets passed to run into the Stages to run,
+// in order, along with the options to run them with. If from is not empty, it
+// is resolved like a target, and it and all Stages downstream of it are
+// forced to run.
+func selectRunStages(
+	idx index.Index,
+	targets []string,
+	from string,
+) (paths []string, opts index.RunOptions, err error) {
+	opts = index.RunOptions{
+		Recursive: !runSingleStage,
+		Force:     make(map[string]bool),
+		Sandbox:   runSandbox,
+	}
+
+	opts.Hooks, err = loadHooks()
+	if err != nil {
+		return
+	}
+
+	switch runCheckWrites {
+	case "":
+	case "warn":
+		opts.CheckWrites = true
+	case "fail":
+		opts.CheckWrites = true
+		opts.FailOnUndeclaredWrites = true
+	default:
+		err = fmt.Errorf("invalid --check-writes value %#v (want \"warn\" or \"fail\")", runCheckWrites)
+		return
+	}
+
+	paths, err = idx.ResolveTargets(targets)
+	if err != nil {
+		return
+	}
+
+	paths, err = idx.SelectStages(paths, selectUpstream, selectDownstream)
+	if err != nil {
+		return
+	}
+
+	if from != "" {
+		var fromPaths, downstream []string
+		fromPaths, err = idx.ResolveTargets([]string{from})
+		if err != nil {
+			return
+		}
+		downstream, err = idx.SelectStages(fromPaths, false, true)
+		if err != nil {
+			return
+		}
+		for _, path := range downstream {
+			opts.Force[path] = true
+		}
+		paths = append(paths, downstream...)
+	}
+
+	if len(paths) == 0 {
+		paths = idx.SortStagePaths()
+	}
+
+	if runForce {
+		for _, path := range paths {
+			opts.Force[path] = true
+		}
+	}
+	return
+}
@@ -0,0 +1,499 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/cache"
+	"github.com/kevin-hanselman/dud/src/index"
+	"github.com/kevin-hanselman/dud/src/snapshot"
+	"github.com/kevin-hanselman/dud/src/strategy"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Save and restore the committed state of the whole project",
+	Long: `Snapshot is a group of commands for saving and restoring the committed
+state of the whole project.
+
+A snapshot records the contents of every stage file in the index, including
+each stage's checksum and the checksums of its outputs. Snapshots are saved in
+the cache as content-addressed objects, so they can be pushed to and fetched
+from the remote cache like any other artifact. Each snapshot is identified by
+its checksum, or any unique prefix of it; "latest" names the most recently
+saved snapshot.
+
+Saved snapshots are listed in .dud/snapshots, which may be committed to git to
+share them.`,
+}
+
+var (
+	snapshotMessage             string
+	snapshotPush, snapshotForce bool
+)
+
+var saveSnapshotCmd = &cobra.Command{
+	Use:   "save [flags]",
+	Short: "Save a snapshot of all stages",
+	Long: `Save records the committed state of all stages in the index as a snapshot, and
+prints the snapshot's checksum.
+
+With --push, the snapshot and all committed outputs are pushed to the remote
+cache.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		_, ch, idx, err := prepare(nil)
+		if err != nil {
+			fatal(err)
+		}
+
+		if idx.Len() == 0 {
+			fatal(emptyIndexError{})
+		}
+
+		snap, err := snapshot.New(idx, snapshotMessage, time.Now())
+		if err != nil {
+			fatal(err)
+		}
+		cksum, err := snap.Save(ch)
+		if err != nil {
+			fatal(err)
+		}
+		if err := snapshot.AppendLog(snapshotLogPath, cksum, snap); err != nil {
+			fatal(err)
+		}
+		logger.Info.Printf("saved snapshot of %d stages\n", idx.Len())
+		fmt.Println(cksum)
+
+		if snapshotPush {
+			if err := pushSnapshot(ch, idx, cksum); err != nil {
+				fatal(err)
+			}
+		}
+	},
+}
+
+var listSnapshotCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List saved snapshots",
+	Long:  `List prints the saved snapshots, newest first.`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		rootDir, err := getProjectRootDir()
+		if err != nil {
+			fatal(err)
+		}
+		entries, err := snapshot.ReadLog(filepath.Join(rootDir, snapshotLogPath))
+		if err != nil {
+			fatal(err)
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		for i := len(entries) - 1; i >= 0; i-- {
+			entry := entries[i]
+			fmt.Fprintf(
+				writer,
+				"%s\t%s\t%s\n",
+				entry.Checksum[:12],
+				entry.Created.Local().Format("2006-01-02 15:04:05"),
+				entry.Message,
+			)
+		}
+		if err := writer.Flush(); err != nil {
+			fatal(err)
+		}
+	},
+}
+
+var restoreSnapshotCmd = &cobra.Command{
+	Use:   "restore [flags] <snapshot>",
+	Short: "Restore stage files and outputs from a snapshot",
+	Long: `Restore rewrites all stage files and the index as recorded in a snapshot,
+then checks out the committed outputs of every stage.
+
+Restore refuses to run if it would lose uncommitted changes: changes to the
+outputs of any stage in the index, or to a stage file the snapshot would
+overwrite. Use --force to restore anyway, discarding those changes.
+
+Restore also refuses to replace a file that isn't a committed output of a
+stage in the index, unless --force is given.
+
+If a remote cache is configured, the snapshot and outputs missing from the
+local cache are fetched first. Outputs are checked out before the stage files
+and the index are rewritten, and if a checkout fails, the outputs already
+replaced are checked out again from the cache, so a failed restore leaves the
+project as it was. Stages added to the index after the snapshot was saved are removed from the
+index, but their stage files are left in place. Outputs that weren't committed
+when the snapshot was saved are skipped.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		rootDir, ch, oldIdx, err := prepare(nil)
+		if err != nil {
+			fatal(err)
+		}
+		remote := viper.GetString("remote")
+
+		entries, err := snapshot.ReadLog(snapshotLogPath)
+		if err != nil {
+			fatal(err)
+		}
+		cksum, err := snapshot.Resolve(entries, args[0])
+		if err != nil {
+			fatal(err)
+		}
+
+		snap, err := snapshot.Load(ch, cksum)
+		var missingErr cache.MissingFromCacheError
+		if errors.As(err, &missingErr) && remote != "" {
+			if err := ch.Fetch(remote, snapshotArtifact(cksum)); err != nil {
+				fatal(err)
+			}
+			snap, err = snapshot.Load(ch, cksum)
+		}
+		if err != nil {
+			fatal(err)
+		}
+		idx, err := snap.Index()
+		if err != nil {
+			fatal(err)
+		}
+
+		if !snapshotForce {
+			changed, err := unsavedStages(ch, rootDir, oldIdx, snap)
+			if err != nil {
+				fatal(err)
+			}
+			if len(changed) > 0 {
+				fatal(fmt.Errorf(
+					"uncommitted changes would be lost in stage(s): %s\n"+
+						"commit the changes, or use --force to restore anyway",
+					strings.Join(changed, ", "),
+				))
+			}
+		}
+
+		outputs := make(map[string]*artifact.Artifact)
+		for _, stagePath := range idx.SortStagePaths() {
+			for artPath, art := range committedOutputs(idx, stagePath) {
+				outputs[artPath] = art
+			}
+		}
+		if remote != "" {
+			logger.Info.Println("fetching outputs")
+			if err := ch.Fetch(remote, outputs); err != nil {
+				fatal(err)
+			}
+		}
+		if err := ch.VerifyLocal(outputs); err != nil {
+			fatal(errors.Wrap(err, "snapshot outputs are not all available; nothing was restored"))
+		}
+
+		strat := strategy.LinkStrategy
+		if useCopyStrategy {
+			strat = strategy.CopyStrategy
+		}
+		if err := restoreOutputs(ch, rootDir, oldIdx, idx, strat); err != nil {
+			fatal(err)
+		}
+
+		for stagePath, contents := range snap.Stages {
+			if err := os.MkdirAll(filepath.Dir(stagePath), 0o755); err != nil {
+				fatal(err)
+			}
+			if err := os.WriteFile(stagePath, []byte(contents), 0o644); err != nil {
+				fatal(err)
+			}
+		}
+		for _, stagePath := range oldIdx.SortStagePaths() {
+			if _, ok := idx.Stage(stagePath); !ok {
+				logger.Info.Printf("removed stage %s from the index\n", stagePath)
+			}
+		}
+		if err := idx.ToFile(indexPath); err != nil {
+			fatal(err)
+		}
+		logger.Info.Printf("restored snapshot %s\n", cksum)
+	},
+}
+
+// restoreOutputs checks out the committed outputs of every Stage in idx,
+// replacing the committed outputs of the Stages in oldIdx. Unless forced, it
+// refuses to replace anything else. If a checkout fails, the outputs checked
+// out so far are removed and oldIdx's outputs are checked out again.
+func restoreOutputs(
+	ch cache.Cache,
+	rootDir string,
+	oldIdx, idx index.Index,
+	strat strategy.CheckoutStrategy,
+) error {
+	oldOutputs := make(map[string]*artifact.Artifact)
+	for _, stagePath := range oldIdx.SortStagePaths() {
+		for artPath, art := range committedOutputs(oldIdx, stagePath) {
+			oldOutputs[artPath] = art
+		}
+	}
+	// Without --force, unsavedStages has already checked that oldIdx's
+	// outputs are all committed, so they can be replaced without losing
+	// anything. Anything else in the way is left for the user to deal with.
+	var outputs []*artifact.Artifact
+	for _, stagePath := range idx.SortStagePaths() {
+		stageOutputs := committedOutputs(idx, stagePath)
+		for _, artPath := range artifactPaths(stageOutputs) {
+			art := stageOutputs[artPath]
+			outputs = append(outputs, art)
+			if snapshotForce || isCommittedOutput(art.Path, oldOutputs) {
+				continue
+			}
+			if _, err := os.Lstat(filepath.Join(rootDir, art.Path)); err == nil {
+				return fmt.Errorf(
+					"restore would replace %s, which isn't a committed output; "+
+						"nothing was restored\nmove it aside, or use --force to restore anyway",
+					art.Path,
+				)
+			} else if !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	for i, art := range outputs {
+		logger.Info.Printf("restoring %s\n", art.Path)
+		// Checkout won't replace existing outputs, so remove them first.
+		err := os.RemoveAll(filepath.Join(rootDir, art.Path))
+		if err == nil {
+			err = ch.Checkout(rootDir, *art, strat, nil)
+		}
+		if err != nil {
+			return rollbackOutputs(ch, rootDir, outputs[:i+1], oldOutputs, strat, err)
+		}
+	}
+	return nil
+}
+
+// rollbackOutputs undoes a failed restoreOutputs by removing the outputs it
+// checked out and checking out oldOutputs again. It returns cause, along with
+// any error hit while rolling back.
+func rollbackOutputs(
+	ch cache.Cache,
+	rootDir string,
+	restored []*artifact.Artifact,
+	oldOutputs map[string]*artifact.Artifact,
+	strat strategy.CheckoutStrategy,
+	cause error,
+) error {
+	logger.Info.Println("restore failed; checking out the previous outputs")
+	for _, art := range restored {
+		if err := os.RemoveAll(filepath.Join(rootDir, art.Path)); err != nil {
+			return errors.Wrapf(cause, "rollback failed (%v)", err)
+		}
+	}
+	for _, artPath := range artifactPaths(oldOutputs) {
+		art := oldOutputs[artPath]
+		if _, err := os.Lstat(filepath.Join(rootDir, art.Path)); err == nil {
+			continue
+		}
+		if err := ch.Checkout(rootDir, *art, strat, nil); err != nil {
+			return errors.Wrapf(cause, "rollback failed (%v)", err)
+		}
+	}
+	return errors.Wrap(cause, "nothing was restored")
+}
+
+// artifactPaths returns the keys of arts in sorted order.
+func artifactPaths(arts map[string]*artifact.Artifact) []string {
+	paths := make([]string, 0, len(arts))
+	for artPath := range arts {
+		paths = append(paths, artPath)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// isCommittedOutput returns true if the path is one of the given outputs, or
+// is inside one of them.
+func isCommittedOutput(path string, outputs map[string]*artifact.Artifact) bool {
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if _, ok := outputs[dir]; ok {
+			return true
+		}
+		if dir == "." || dir == "/" {
+			return false
+		}
+	}
+}
+
+// unsavedStages returns the sorted paths of the Stages whose uncommitted
+// changes would be lost by restoring snap: Stages in idx whose outputs have
+// changed since they were last committed, Stages in idx and snap whose
+// definitions have changed, and stage files not in idx that differ from the
+// snapshot. Stage files not in snap are left in place by a restore.
+func unsavedStages(
+	ch cache.Cache,
+	rootDir string,
+	idx index.Index,
+	snap snapshot.Snapshot,
+) ([]string, error) {
+	stagePaths := idx.SortStagePaths()
+	status := make(index.Status)
+	for _, stagePath := range stagePaths {
+		if err := idx.Status(stagePath, ch, rootDir, status); err != nil {
+			return nil, err
+		}
+	}
+	var changed []string
+	for _, stg := range idx.StatusReport(status, index.StatusReportOptions{}).Stages {
+		_, rewritten := snap.Stages[stg.Path]
+		if (rewritten && stg.Definition != "up-to-date") || hasUncommittedOutputs(stg) {
+			changed = append(changed, stg.Path)
+		}
+	}
+	for stagePath, contents := range snap.Stages {
+		if _, ok := idx.Stage(stagePath); ok {
+			continue
+		}
+		existing, err := os.ReadFile(stagePath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if string(existing) != contents {
+			changed = append(changed, stagePath)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// hasUncommittedOutputs returns true if any of the Stage's outputs in the
+// workspace differ from what was last committed. Missing outputs have nothing
+// to lose, so they don't count.
+func hasUncommittedOutputs(stg index.StageReport) bool {
+	for _, art := range stg.Artifacts {
+		if art.Role == "output" && (art.State == "modified" || art.State == "not-committed") {
+			return true
+		}
+	}
+	return false
+}
+
+var pushSnapshotCmd = &cobra.Command{
+	Use:   "push [snapshot]",
+	Short: "Push a snapshot and its outputs to the remote cache",
+	Long: `Push uploads a snapshot and the committed outputs it records to the remote
+cache. If no snapshot is given, the latest snapshot is pushed.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		_, ch, _, err := prepare(nil)
+		if err != nil {
+			fatal(err)
+		}
+
+		id := "latest"
+		if len(args) > 0 {
+			id = args[0]
+		}
+		entries, err := snapshot.ReadLog(snapshotLogPath)
+		if err != nil {
+			fatal(err)
+		}
+		cksum, err := snapshot.Resolve(entries, id)
+		if err != nil {
+			fatal(err)
+		}
+		snap, err := snapshot.Load(ch, cksum)
+		if err != nil {
+			fatal(err)
+		}
+		idx, err := snap.Index()
+		if err != nil {
+			fatal(err)
+		}
+		if err := pushSnapshot(ch, idx, cksum); err != nil {
+			fatal(err)
+		}
+	},
+}
+
+// snapshotArtifact returns the cached object of the snapshot with the given
+// checksum as an Artifact, for use with Cache.Push and Cache.Fetch.
+func snapshotArtifact(cksum string) map[string]*artifact.Artifact {
+	return map[string]*artifact.Artifact{
+		cksum: {Path: "snapshot " + cksum, Checksum: cksum},
+	}
+}
+
+// committedOutputs returns the Outputs of the Stage that are committed to the
+// cache.
+func committedOutputs(idx index.Index, stagePath string) map[string]*artifact.Artifact {
+	stg, _ := idx.Stage(stagePath)
+	outputs := make(map[string]*artifact.Artifact, len(stg.Outputs))
+	for artPath, art := range stg.Outputs {
+		if art.Checksum != "" && !art.SkipCache && !art.Absent {
+			outputs[artPath] = art
+		}
+	}
+	return outputs
+}
+
+// pushSnapshot pushes the snapshot with the given checksum, and the committed
+// outputs of the Stages it records, to the remote cache.
+func pushSnapshot(ch cache.Cache, idx index.Index, cksum string) error {
+	remote := viper.GetString("remote")
+	if remote == "" {
+		return noRemoteError{}
+	}
+	for _, stagePath := range idx.SortStagePaths() {
+		logger.Info.Printf("pushing stage %s\n", stagePath)
+		if err := ch.Push(remote, committedOutputs(idx, stagePath)); err != nil {
+			return err
+		}
+	}
+	logger.Info.Printf("pushing snapshot %s\n", cksum)
+	return ch.Push(remote, snapshotArtifact(cksum))
+}
+
+func init() {
+	saveSnapshotCmd.Flags().StringVarP(
+		&snapshotMessage,
+		"message",
+		"m",
+		"",
+		"describe the snapshot",
+	)
+	saveSnapshotCmd.Flags().BoolVar(
+		&snapshotPush,
+		"push",
+		false,
+		"push the snapshot and all committed outputs to the remote cache",
+	)
+	restoreSnapshotCmd.Flags().BoolVarP(
+		&snapshotForce,
+		"force",
+		"f",
+		false,
+		"restore even if uncommitted changes would be lost, discarding them",
+	)
+	restoreSnapshotCmd.Flags().BoolVarP(
+		&useCopyStrategy,
+		"copy",
+		"c",
+		false,
+		"copy artifacts instead of linking",
+	)
+
+	snapshotCmd.AddCommand(saveSnapshotCmd)
+	snapshotCmd.AddCommand(listSnapshotCmd)
+	snapshotCmd.AddCommand(restoreSnapshotCmd)
+	snapshotCmd.AddCommand(pushSnapshotCmd)
+	rootCmd.AddCommand(snapshotCmd)
+}
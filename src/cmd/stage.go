@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -41,6 +42,59 @@ command: python train.py
 # project root.
 working-dir: .
 
+# Stages that must run before this Stage, even though this Stage doesn't
+# consume any of their outputs (e.g. database migrations). These order-only
+# dependencies are respected by 'dud run', 'dud checkout', and 'dud graph', but
+# changes to the listed Stages never cause this Stage to run.
+after:
+  - migrate.yaml
+
+# 'always-run' tells 'dud run' to run this Stage's command every time, even if
+# the Stage is up-to-date. Always-run Stages never restore their outputs from
+# the run cache. Defaults to false when omitted.
+always-run: true
+
+# 'frozen' tells 'dud run' to never run this Stage, even if it is out-of-date.
+# Use 'dud stage freeze' and 'dud stage unfreeze' to toggle this flag. Unlike
+# the rest of the Stage definition, it is not included in the Stage's checksum.
+# Defaults to false when omitted.
+frozen: true
+
+# Probe commands whose output the Stage's outputs implicitly depend on, such
+# as tool versions. Probes are run in the working directory above. The Stage
+# is out-of-date whenever a probe's output (stdout and stderr) changes.
+env-deps:
+  - python --version
+  - pip freeze
+
+# The recorded output of each probe in 'env-deps', written during 'dud commit'.
+# Like Artifact checksums, it is not included in the Stage's checksum.
+env-state:
+  python --version:
+    checksum: abcdefghijklmnopqrstuvwxyz1234567890
+    # Short, single-line output is also recorded verbatim for reporting.
+    output: Python 3.11.4
+  pip freeze:
+    checksum: abcdefghijklmnopqrstuvwxyz1234567890
+
+# 'import' marks a Stage created by 'dud import', whose single output is
+# copied from an Artifact committed in another Dud project. Import Stages have
+# no command or inputs; run 'dud update' to import the Artifact again. (This
+# Stage couldn't actually be an import Stage; the field is shown for reference.)
+import:
+  # The local path or git URL of the other project. Relative paths are relative
+  # to this project's root directory.
+  source: ../upstream
+  # The git revision of the other project to import from. When omitted, local
+  # projects are imported from their working tree and git URLs from their
+  # default branch.
+  rev: main
+  # The git commit the Artifact was last imported from, written during
+  # 'dud import' and 'dud update'. It is not included in the Stage's checksum.
+  commit: 0123456789abcdef0123456789abcdef01234567
+  # The path of the Artifact in the other project, relative to its root.
+  path: data/clean
+
 # The set of Artifacts which the Stage requires to run 'command' above.
 inputs:
   # The Artifact path. All paths are relative to the project's root
@@ -73,6 +127,30 @@ outputs:
     # for declaring Stage outputs which can be safely stored in source control
     # rather than Dud. This option is implicit for Artifacts in 'inputs'.
     skip-cache: true
+
+  checkpoints:
+    is-dir: true
+    # 'persist' tells 'dud run' not to delete this Artifact before running
+    # the Stage's command. By default, all outputs are deleted first so that
+    # stale files don't get committed. Persisted outputs are instead converted
+    # to writable copies, so the command can update them incrementally.
+    # Defaults to false when omitted. Only applicable to outputs.
+    persist: true
+
+  report.csv:
+    # 'optional' tells Dud that the Stage's command may not produce this
+    # Artifact. If the Artifact doesn't exist during 'dud commit', Dud records
+    # 'absent: true' instead of a checksum, and 'dud status' reports it as
+    # absent. Defaults to false when omitted. Only applicable to outputs.
+    optional: true
+
+    # 'validate' is a shell command that checks this Artifact after 'dud run'
+    # executes the Stage's command, and before the Stage is committed. It runs
+    # in the Stage's working directory with the Artifact's path (relative to
+    # the working directory) in the DUD_OUTPUT environment variable. If the
+    # command fails, the Stage is not committed. Absent optional outputs are
+    # not validated. Only applicable to outputs.
+    validate: csvlint "$DUD_OUTPUT"
 ` + "```",
 }
 
@@ -131,7 +209,9 @@ var addStageCmd = &cobra.Command{
 
 Add loads each stage file passed on the command line, validates its contents,
 checks if it conflicts with any stages already in the index, then adds the
-stage to the index file.`,
+stage to the index file. Stages listed in a stage's 'after' list must already
+be in the index (or be added in the same command), and no stage may depend on
+itself.`,
 	Args: cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, paths []string) {
 		rootDir, _, idx, err := prepare(paths)
@@ -149,17 +229,25 @@ stage to the index file.`,
 			}
 			logger.Info.Printf("Added %s to the index.", path)
 		}
+		if err := idx.Validate(); err != nil {
+			fatal(err)
+		}
 
 		if err := idx.ToFile(filepath.Join(rootDir, indexPath)); err != nil {
 			fatal(err)
 		}
+		if err := updateMergeDriverAttributes(rootDir, paths); err != nil {
+			fatal(err)
+		}
 	},
 }
 
 var removeStageCmd = &cobra.Command{
-	Use:     "remove stage_file...",
-	Short:   "Remove one or more stage files from the index",
-	Long:    `Remove removes one or more stage files from the index.`,
+	Use:   "remove stage_file...",
+	Short: "Remove one or more stage files from the index",
+	Long: `Remove removes one or more stage files from the index.
+
+A stage cannot be removed while another stage lists it in 'after'.`,
 	Aliases: []string{"rm"},
 	Args:    cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, paths []string) {
@@ -174,6 +262,9 @@ var removeStageCmd = &cobra.Command{
 			}
 			logger.Info.Printf("Removed %s from the index.", path)
 		}
+		if err := idx.Validate(); err != nil {
+			fatal(err)
+		}
 
 		if err := idx.ToFile(filepath.Join(rootDir, indexPath)); err != nil {
 			fatal(err)
@@ -181,6 +272,59 @@ var removeStageCmd = &cobra.Command{
 	},
 }
 
+// setStagesFrozen sets the frozen flag in each of the given stage files.
+func setStagesFrozen(paths []string, frozen bool) {
+	_, _, idx, err := prepare(paths)
+	if err != nil {
+		fatal(err)
+	}
+
+	for _, path := range paths {
+		if _, ok := idx.Stage(path); !ok {
+			fatal(fmt.Errorf("stage %s is not in the index", path))
+		}
+		if err := stage.SetFrozen(path, frozen); err != nil {
+			fatal(err)
+		}
+		if frozen {
+			logger.Info.Printf("Froze %s.", path)
+		} else {
+			logger.Info.Printf("Unfroze %s.", path)
+		}
+	}
+}
+
+var freezeStageCmd = &cobra.Command{
+	Use:   "freeze stage_file...",
+	Short: "Prevent one or more stages from running",
+	Long: `Freeze prevents one or more stages from running.
+
+Freeze sets 'frozen: true' in each stage file passed in. Frozen stages are
+never run by 'dud run', even if they are out-of-date or forced; their outputs
+are used as-is. The frozen flag is not part of the stage's checksum, so
+freezing or unfreezing a stage doesn't mark its definition as modified.
+Freeze edits only the flag's line, preserving the rest of the file's contents
+and comments.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, paths []string) {
+		setStagesFrozen(paths, true)
+	},
+}
+
+var unfreezeStageCmd = &cobra.Command{
+	Use:   "unfreeze stage_file...",
+	Short: "Allow one or more frozen stages to run",
+	Long: `Unfreeze allows one or more frozen stages to run.
+
+Unfreeze removes the 'frozen' flag from each stage file passed in. Unfreeze
+edits only the flag's line, preserving the rest of the file's contents and
+comments.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, paths []string) {
+		setStagesFrozen(paths, false)
+	},
+}
+
 var (
 	stageOutputs, stageInputs []string
 	stageWorkingDir           string
@@ -214,6 +358,8 @@ func init() {
 	stageCmd.AddCommand(genStageCmd)
 	stageCmd.AddCommand(addStageCmd)
 	stageCmd.AddCommand(removeStageCmd)
+	stageCmd.AddCommand(freezeStageCmd)
+	stageCmd.AddCommand(unfreezeStageCmd)
 	rootCmd.AddCommand(stageCmd)
 }
 
@@ -6,8 +6,10 @@ import (
 	"io"
 	"os"
 	"sort"
+	"strings"
 	"text/tabwriter"
 
+	"github.com/kevin-hanselman/dud/src/artifact"
 	"github.com/kevin-hanselman/dud/src/index"
 	"github.com/kevin-hanselman/dud/src/stage"
 	"github.com/spf13/cobra"
@@ -15,10 +17,53 @@ import (
 
 func init() {
 	statusCmd.Flags().BoolVar(&debugStatus, "debug", false, "print verbose JSON instead of regular output")
+	statusCmd.Flags().StringVar(
+		&statusFormat,
+		"format",
+		"text",
+		"output format: \"text\", \"json\", or \"porcelain\"",
+	)
+	statusCmd.Flags().BoolVar(
+		&statusPorcelain,
+		"porcelain",
+		false,
+		"print a stable, line-oriented format (same as --format porcelain)",
+	)
+	statusCmd.Flags().BoolVar(
+		&statusCheck,
+		"check",
+		false,
+		"exit with an error if any stage or artifact is not up-to-date",
+	)
+	statusCmd.Flags().BoolVar(
+		&statusFiles,
+		"files",
+		false,
+		"list the changed files inside directory artifacts",
+	)
+	statusCmd.Flags().IntVar(
+		&statusFileDepth,
+		"depth",
+		0,
+		"with --files, collapse changes nested deeper than this many levels (0 means no limit)",
+	)
+	statusCmd.Flags().StringSliceVar(
+		&statusFileStates,
+		"state",
+		nil,
+		"with --files, only list changes in these states: "+
+			"\"added\", \"removed\", \"modified\", or \"type-changed\"",
+	)
 	rootCmd.AddCommand(statusCmd)
+	addSelectionFlags(statusCmd)
 }
 
-func writeStageStatus(writer io.Writer, stagePath string, status stage.Status) error {
+func writeStageStatus(
+	writer io.Writer,
+	stagePath string,
+	status stage.Status,
+	opts index.StatusReportOptions,
+) error {
 	var stageFileStatus string
 	if status.ChecksumMatches {
 		stageFileStatus = "up-to-date"
@@ -27,15 +72,58 @@ func writeStageStatus(writer io.Writer, stagePath string, status stage.Status) e
 	} else {
 		stageFileStatus = "not checksummed"
 	}
+	if status.Frozen {
+		stageFileStatus += " (frozen)"
+	} else if status.AlwaysRun {
+		stageFileStatus += " (always runs)"
+	}
 	fmt.Fprintf(writer, "%s\tstage definition %s\n", stagePath, stageFileStatus)
-	for path, artStatus := range status.ArtifactStatus {
+	for _, path := range sortedKeys(status.ArtifactStatus) {
+		artStatus := status.ArtifactStatus[path]
 		fmt.Fprintf(writer, "  %s\t%s\n", path, artStatus)
+		if opts.Files && artStatus.IsDir {
+			for _, change := range artStatus.ChangedFiles(opts.FileDepth, opts.FileStates) {
+				fmt.Fprintf(writer, "    %s\t%s\n", change.Path, change.State)
+			}
+		}
+	}
+	for _, probeCmd := range sortedKeys(status.EnvDepStatus) {
+		fmt.Fprintf(writer, "  $ %s\t%s\n", probeCmd, status.EnvDepStatus[probeCmd])
+	}
+	for _, path := range sortedKeys(status.UpstreamInputsMatch) {
+		if status.UpstreamInputsMatch[path] {
+			fmt.Fprintf(writer, "  %s\tup-to-date with upstream\n", path)
+		} else {
+			fmt.Fprintf(writer, "  %s\tmodified upstream\n", path)
+		}
 	}
 	return nil
 }
 
+func isFileChangeState(state string) bool {
+	for _, valid := range artifact.FileChangeStates {
+		if state == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of a map in sorted order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 var (
-	debugStatus bool
+	debugStatus, statusPorcelain, statusCheck, statusFiles bool
+	statusFormat                                           string
+	statusFileDepth                                        int
+	statusFileStates                                       []string
 
 	statusCmd = &cobra.Command{
 		Use:     "status [flags] [stage_file]...",
@@ -46,50 +134,178 @@ var (
 For each stage file passed in, status will print the current state of the
 stage. If no stage files are passed in, status will act on all stages in the
 index. By default, status will act recursively on all stages upstream of the
-given stage(s).`,
+given stage(s). With --downstream, status also reports on all stages
+downstream of the given stage(s).
+
+The default text output is meant for humans and may change between versions.
+For scripts, use --format json or --porcelain.
+
+--format json prints a single JSON object with the following schema. The
+schema's "version" only changes when a field is removed or the meaning of a
+field or state changes; new fields and states may be added at any time. All
+lists are sorted by path (or command, for env-deps).
+
+  {
+    "version": 1,
+    "up-to-date": false,       // true if every stage is up-to-date
+    "stages": [
+      {
+        "path": "train.yaml",
+        "definition": "modified",   // "up-to-date", "modified", "not-checksummed"
+        "frozen": false,
+        "always-run": false,
+        "up-to-date": false,        // definition, artifacts, and env-deps
+        "artifacts": [
+          {
+            "path": "model.pkl",
+            "role": "output",       // "output", "input", "upstream-input"
+            "state": "missing",     // "up-to-date", "absent", "not-committed",
+                                    // "missing", "modified"
+            "up-to-date": false,
+            "description": "missing from workspace", // human-readable
+            "files": [              // only with --files, for directories
+              {
+                "path": "model.pkl/weights.bin",
+                "state": "modified" // "added", "removed", "modified",
+                                    // "type-changed"
+              }
+            ]
+          }
+        ],
+        "env-deps": [
+          {
+            "command": "python --version",
+            "state": "changed",     // "up-to-date", "changed", "not-recorded"
+            "up-to-date": false,
+            "recorded": "Python 3.10.2",
+            "current": "Python 3.11.4"
+          }
+        ]
+      }
+    ]
+  }
+
+--porcelain (or --format porcelain) prints one line per stage definition,
+artifact, and env-dep, with tab-separated fields: the stage path, the kind of
+entry ("definition", an artifact role, "file", or "env-dep"), the entry's state
+(as above), and, except for definitions, the artifact path, file path, or
+env-dep command. Lines are sorted by stage path; within a stage, the
+definition comes first, followed by artifacts sorted by path (each followed by
+its changed files, with --files), then env-deps sorted by command.
+
+With --files, status lists the files inside each directory artifact that were
+added, removed, modified, or changed type (e.g. a file replaced by a
+directory) since the artifact was committed. --state limits the list to the
+given states, and --depth collapses changes nested more than N levels inside
+the artifact into their parent directory, which is printed with a trailing
+slash.
+
+With --check, status exits with an error if any selected stage or artifact is
+not up-to-date. This is useful as a CI gate, and can be combined with any
+output format.`,
 		Run: func(_ *cobra.Command, paths []string) {
+			if statusPorcelain {
+				if statusFormat != "text" && statusFormat != "porcelain" {
+					fatal(fmt.Errorf("--porcelain conflicts with --format %s", statusFormat))
+				}
+				statusFormat = "porcelain"
+			}
+			switch statusFormat {
+			case "text", "json", "porcelain":
+			default:
+				fatal(fmt.Errorf(
+					"invalid --format value %#v (want \"text\", \"json\", or \"porcelain\")",
+					statusFormat,
+				))
+			}
+
+			reportOpts := index.StatusReportOptions{
+				Files:     statusFiles,
+				FileDepth: statusFileDepth,
+			}
+			if statusFileDepth < 0 {
+				fatal(fmt.Errorf("invalid --depth value %d (must not be negative)", statusFileDepth))
+			}
+			if len(statusFileStates) > 0 {
+				reportOpts.FileStates = make(map[string]bool)
+				for _, state := range statusFileStates {
+					if !isFileChangeState(state) {
+						fatal(fmt.Errorf(
+							"invalid --state value %#v (want one of %s)",
+							state,
+							strings.Join(artifact.FileChangeStates, ", "),
+						))
+					}
+					reportOpts.FileStates[state] = true
+				}
+			}
+
 			rootDir, ch, idx, err := prepare(paths)
 			if err != nil {
 				fatal(err)
 			}
 
-			if len(idx) == 0 {
+			if idx.Len() == 0 {
 				fatal(emptyIndexError{})
 			}
 
 			if len(paths) == 0 { // By default, check status of everything in the Index.
-				for path := range idx {
-					paths = append(paths, path)
-				}
+				paths = idx.SortStagePaths()
+			}
+
+			paths, err = idx.SelectStages(paths, selectUpstream, selectDownstream)
+			if err != nil {
+				fatal(err)
 			}
 
 			sort.Strings(paths)
 
 			indexStatus := make(index.Status)
 			for _, path := range paths {
-				inProgress := make(map[string]bool)
-				err := idx.Status(path, ch, rootDir, indexStatus, inProgress)
+				err := idx.Status(path, ch, rootDir, indexStatus)
 				if err != nil {
 					fatal(err)
 				}
 			}
 
-			if debugStatus {
+			// The report is needed for --check regardless of the output format.
+			report := idx.StatusReport(indexStatus, reportOpts)
+			switch {
+			case debugStatus:
 				encoder := json.NewEncoder(os.Stdout)
 				if err := encoder.Encode(indexStatus); err != nil {
 					fatal(err)
 				}
-				return
+			case statusFormat == "json":
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(report); err != nil {
+					fatal(err)
+				}
+			case statusFormat == "porcelain":
+				if err := report.WritePorcelain(os.Stdout); err != nil {
+					fatal(err)
+				}
+			default:
+				writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				for _, path := range sortedKeys(indexStatus) {
+					if err := writeStageStatus(writer, path, indexStatus[path], reportOpts); err != nil {
+						fatal(err)
+					}
+					fmt.Fprintln(writer)
+				}
+				writer.Flush()
 			}
 
-			writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-			for path, stageStatus := range indexStatus {
-				if err := writeStageStatus(writer, path, stageStatus); err != nil {
-					fatal(err)
+			if statusCheck && !report.UpToDate {
+				notUpToDate := 0
+				for _, stg := range report.Stages {
+					if !stg.UpToDate {
+						notUpToDate++
+					}
 				}
-				fmt.Fprintln(writer)
+				fatal(fmt.Errorf("%d stage(s) not up-to-date", notUpToDate))
 			}
-			writer.Flush()
 		},
 	}
 )
@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/kevin-hanselman/dud/src/tag"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(tagCmd)
+	addRevFlag(tagCmd)
+	tagCmd.Flags().BoolVarP(
+		&tagList,
+		"list",
+		"l",
+		false,
+		"list tags",
+	)
+	tagCmd.Flags().BoolVarP(
+		&tagDelete,
+		"delete",
+		"d",
+		false,
+		"delete the given tags",
+	)
+	tagCmd.Flags().BoolVarP(
+		&tagForce,
+		"force",
+		"f",
+		false,
+		"replace an existing tag",
+	)
+	tagCmd.Flags().StringVarP(
+		&tagMessage,
+		"message",
+		"m",
+		"",
+		"describe the tag",
+	)
+}
+
+var (
+	tagList, tagDelete, tagForce bool
+	tagMessage                   string
+)
+
+var tagCmd = &cobra.Command{
+	Use:   "tag [flags] <name> [stage_file|artifact]...",
+	Short: "Create, list, or delete named versions of artifacts",
+	Long: `Tag creates, lists, and deletes named versions of artifacts.
+
+A tag records the committed checksums of the given stages' outputs, or of the
+given outputs, under a name such as "dataset-v3". If no stages or artifacts
+are given, all stages in the index are tagged. Tags are stored as files in
+.dud/tags, which can be committed to git and shared. With --rev, the checksums
+are read from the index and stage files at the given git revision.
+
+Checkout, fetch, pull, and get accept --tag to operate on the artifacts
+recorded in a tag instead of those in the index. For example:
+
+  dud tag -m "cleaned, deduplicated" dataset-v3 data/clean
+  dud fetch --tag dataset-v3
+  dud get --tag dataset-v3 data/clean/train.csv -o train.csv
+
+Run tag with --list, or without arguments, to list all tags. Use --delete to
+delete tags; deleting a tag doesn't delete any artifacts.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		switch {
+		case tagList:
+			return cobra.NoArgs(cmd, args)
+		case tagDelete:
+			return cobra.MinimumNArgs(1)(cmd, args)
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if tagList || len(args) == 0 {
+			rootDir, err := getProjectRootDir()
+			if err != nil {
+				fatal(err)
+			}
+			if err := listTags(filepath.Join(rootDir, tagsDir)); err != nil {
+				fatal(err)
+			}
+			return
+		}
+
+		if tagDelete {
+			rootDir, err := getProjectRootDir()
+			if err != nil {
+				fatal(err)
+			}
+			for _, name := range args {
+				if err := tag.Delete(filepath.Join(rootDir, tagsDir), name); err != nil {
+					fatal(err)
+				}
+				logger.Info.Printf("deleted tag %s\n", name)
+			}
+			return
+		}
+
+		name, targets := args[0], args[1:]
+		if err := tag.ValidateName(name); err != nil {
+			fatal(err)
+		}
+
+		_, _, idx, err := prepare(targets)
+		if err != nil {
+			fatal(err)
+		}
+
+		exists, err := tag.Exists(tagsDir, name)
+		if err != nil {
+			fatal(err)
+		}
+		if exists && !tagForce {
+			fatal(fmt.Errorf("tag %s already exists; use --force to replace it", name))
+		}
+
+		t, err := idx.Tag(name, targets)
+		if err != nil {
+			fatal(err)
+		}
+		t.Message = tagMessage
+		if err := t.ToFile(tagsDir); err != nil {
+			fatal(err)
+		}
+
+		numArts := 0
+		for _, arts := range t.Stages {
+			numArts += len(arts)
+		}
+		logger.Info.Printf("tagged %d artifacts as %s\n", numArts, name)
+	},
+}
+
+// listTags writes the name and message of each tag in dir to stdout.
+func listTags(dir string) error {
+	names, err := tag.List(dir)
+	if err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, name := range names {
+		t, err := tag.FromFile(dir, name)
+		if err != nil {
+			return err
+		}
+		if t.Message == "" {
+			fmt.Fprintln(writer, name)
+		} else {
+			fmt.Fprintf(writer, "%s\t%s\n", name, t.Message)
+		}
+	}
+	return writer.Flush()
+}
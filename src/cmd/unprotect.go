@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(unprotectCmd)
+}
+
+var unprotectCmd = &cobra.Command{
+	Use:   "unprotect artifact...",
+	Short: "Replace links to the cache with writable copies",
+	Long: `Unprotect replaces links to the cache with writable copies.
+
+By default, committed artifacts are read-only links to the cache. Unprotect
+replaces the given artifacts' links with writable copies of the cached files,
+so the artifacts can be edited in place. Each artifact must be a committed
+output of a stage, or a path inside a committed directory output. Directory
+artifacts are unprotected in their entirety.
+
+Note that 'dud run' removes links among a stage's outputs before running its
+command, so unprotecting is only necessary for editing outputs by hand.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, paths []string) {
+		rootDir, ch, idx, err := prepare(paths)
+		if err != nil {
+			fatal(err)
+		}
+
+		for _, path := range paths {
+			if err := idx.Unprotect(path, ch, rootDir); err != nil {
+				fatal(err)
+			}
+		}
+	},
+}
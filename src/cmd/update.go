@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(updateCmd)
+	updateCmd.Flags().StringVar(
+		&updateRev,
+		"rev",
+		"",
+		"import from this git revision of the source project from now on",
+	)
+	updateCmd.Flags().BoolVarP(
+		&useCopyStrategy,
+		"copy",
+		"c",
+		false,
+		"copy the artifact instead of linking to the cache",
+	)
+}
+
+var updateRev string
+
+var updateCmd = &cobra.Command{
+	Use:   "update [flags] [stage_file|artifact]...",
+	Short: "Import artifacts again from their source projects",
+	Long: `Update imports the artifacts of import stages again from their source projects
+(see 'dud import --help'), and records the new checksums in the stage files.
+
+If no stages are given, all import stages in the index are updated. The
+source project's revision is resolved again, so an import from a branch picks
+up the branch's latest commit. Use --rev to switch to another revision.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		rootDir, ch, idx, err := prepare(args)
+		if err != nil {
+			fatal(err)
+		}
+
+		stagePaths := args
+		if len(args) == 0 {
+			for _, stagePath := range idx.SortStagePaths() {
+				if stg, _ := idx.Stage(stagePath); stg.Import != nil {
+					stagePaths = append(stagePaths, stagePath)
+				}
+			}
+			if len(stagePaths) == 0 {
+				logger.Info.Println("no import stages to update")
+				return
+			}
+		} else if stagePaths, err = idx.ResolveTargets(args); err != nil {
+			fatal(err)
+		}
+
+		for _, stagePath := range stagePaths {
+			stg, _ := idx.Stage(stagePath)
+			if stg.Import == nil {
+				fatal(fmt.Errorf("stage %s is not an import stage", stagePath))
+			}
+			if updateRev != "" {
+				stg.Import.Rev = updateRev
+			}
+			changed, err := importArtifact(rootDir, ch, stg)
+			if err != nil {
+				fatal(err)
+			}
+			if !changed {
+				logger.Info.Printf("stage %s is up-to-date\n", stagePath)
+				continue
+			}
+			if err := stg.ToFile(stagePath); err != nil {
+				fatal(err)
+			}
+			logger.Info.Printf("updated stage %s\n", stagePath)
+		}
+	},
+}
@@ -0,0 +1,352 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/cache"
+	"github.com/kevin-hanselman/dud/src/hook"
+	"github.com/kevin-hanselman/dud/src/index"
+)
+
+// watchDebounce is how long run --watch waits for changes to settle before
+// starting a new cycle.
+const watchDebounce = 300 * time.Millisecond
+
+// runSummary records the outcome of one run --watch cycle.
+type runSummary struct {
+	ran, upToDate, failed, skipped []string
+}
+
+func (s runSummary) String() string {
+	out := fmt.Sprintf(
+		"[%s] ran %d, up-to-date %d, failed %d",
+		time.Now().Format("15:04:05"),
+		len(s.ran),
+		len(s.upToDate),
+		len(s.failed),
+	)
+	if len(s.failed) > 0 {
+		out += fmt.Sprintf(" (%s)", strings.Join(s.failed, ", "))
+	}
+	if len(s.skipped) > 0 {
+		out += fmt.Sprintf(", skipped %d", len(s.skipped))
+	}
+	return out
+}
+
+// runStages runs each of the given Stages in order. Unlike a regular run, a
+// failure doesn't stop the remaining Stages from running; only Stages
+// downstream of the failure are skipped.
+func runStages(
+	idx index.Index,
+	paths []string,
+	ch cache.Cache,
+	rootDir string,
+	opts index.RunOptions,
+	runLogger *agglog.AggLogger,
+) (summary runSummary) {
+	graph := idx.DAG()
+	ran := make(map[string]bool)
+	failed := make(map[string]bool)
+	for _, path := range paths {
+		if _, ok := ran[path]; ok {
+			continue
+		}
+		blocked := false
+		for _, upstream := range graph.UpstreamClosure(path) {
+			if failed[upstream] {
+				blocked = true
+				break
+			}
+		}
+		if blocked {
+			summary.skipped = append(summary.skipped, path)
+			continue
+		}
+		if err := idx.Run(path, ch, rootDir, opts, ran, runLogger); err != nil {
+			logger.Error.Println(err)
+			for _, stagePath := range failedStages(idx, path, opts.Recursive, ran) {
+				failed[stagePath] = true
+			}
+		}
+	}
+	for path, didRun := range ran {
+		if didRun {
+			summary.ran = append(summary.ran, path)
+		} else {
+			summary.upToDate = append(summary.upToDate, path)
+		}
+	}
+	for path := range failed {
+		summary.failed = append(summary.failed, path)
+	}
+	sort.Strings(summary.ran)
+	sort.Strings(summary.upToDate)
+	sort.Strings(summary.failed)
+	return
+}
+
+// runHookedStages runs the given Stages like runStages, surrounded by the
+// pre-run and post-run hooks, and prints a summary. If the pre-run hook fails,
+// no Stages are run. The post-run hook only runs if no Stages failed.
+func runHookedStages(
+	idx index.Index,
+	paths []string,
+	ch cache.Cache,
+	rootDir string,
+	opts index.RunOptions,
+	runLogger *agglog.AggLogger,
+) {
+	hookCtx := hook.Context{RootDir: rootDir, Stages: paths}
+	if err := opts.Hooks.Run("pre-run", hookCtx); err != nil {
+		logger.Error.Println(err)
+		return
+	}
+	summary := runStages(idx, paths, ch, rootDir, opts, runLogger)
+	logger.Info.Println(summary)
+	if len(summary.failed) > 0 {
+		return
+	}
+	if err := opts.Hooks.Run("post-run", hookCtx); err != nil {
+		logger.Error.Println(err)
+	}
+}
+
+// watchRun implements run --watch. It runs the Stages selected by targets and
+// from, then reruns affected Stages whenever their stage files or orphan inputs
+// change. It returns when interrupted.
+func watchRun(
+	rootDir string,
+	ch cache.Cache,
+	idx index.Index,
+	targets []string,
+	from string,
+) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	// Per-stage messages are only shown in verbose mode; each cycle prints a
+	// summary instead.
+	runLogger := &agglog.AggLogger{
+		Error: logger.Error,
+		Info:  logger.Debug,
+		Debug: logger.Debug,
+	}
+
+	watchedDirs := make(map[string]bool)
+	var (
+		watched map[string][]string
+		changed map[string]bool
+	)
+	for cycle := 0; ; cycle++ {
+		// Reload the Index to pick up changes to stage files. If a stage file
+		// is invalid (e.g. mid-edit), don't run anything until it's fixed.
+		var err error
+		if cycle > 0 {
+			idx, err = index.FromFile(indexPath)
+		}
+		var (
+			paths []string
+			opts  index.RunOptions
+		)
+		if err == nil {
+			paths, opts, err = selectRunStages(idx, targets, from)
+		}
+		if err != nil {
+			logger.Error.Println(err)
+		} else {
+			scope := paths
+			if opts.Recursive {
+				scope = idx.DAG().UpstreamClosure(paths...)
+			}
+			if cycle > 0 {
+				opts.Force = nil
+				paths = affectedStages(idx, scope, changed)
+			}
+			if len(paths) > 0 {
+				runHookedStages(idx, paths, ch, rootDir, opts, runLogger)
+			}
+			watched = watchedPaths(idx, scope)
+			for path := range watched {
+				if err := watchPath(watcher, watchedDirs, path); err != nil {
+					logger.Error.Println(err)
+				}
+			}
+		}
+		if cycle == 0 {
+			logger.Info.Printf("watching %d path(s) for changes; press Ctrl-C to stop\n", len(watched))
+		}
+
+		var ok bool
+		changed, ok = waitForChanges(watcher, watched, interrupt)
+		if !ok {
+			return nil
+		}
+	}
+}
+
+// watchedPaths maps the stage files and orphan inputs of the given Stages to
+// the Stages they affect.
+func watchedPaths(idx index.Index, stagePaths []string) map[string][]string {
+	watched := idx.OrphanInputs(stagePaths)
+	for _, stagePath := range stagePaths {
+		watched[stagePath] = append(watched[stagePath], stagePath)
+	}
+	return watched
+}
+
+// affectedStages returns the Stages in scope that are changed or downstream of
+// a changed Stage, in the order they should run.
+func affectedStages(idx index.Index, scope []string, changed map[string]bool) []string {
+	inScope := make(map[string]bool, len(scope))
+	for _, path := range scope {
+		inScope[path] = true
+	}
+	roots := []string{}
+	for path := range changed {
+		if inScope[path] {
+			roots = append(roots, path)
+		}
+	}
+	affected, err := idx.SelectStages(roots, false, true)
+	if err != nil {
+		logger.Error.Println(err)
+		return nil
+	}
+	paths := []string{}
+	for _, path := range affected {
+		if inScope[path] {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// watchPath adds the directories needed to observe changes to path. Files are
+// observed through their parent directory, as many editors replace files
+// rather than writing them in place. Directories are watched recursively.
+func watchPath(watcher *fsnotify.Watcher, watchedDirs map[string]bool, path string) error {
+	add := func(dir string) error {
+		if watchedDirs[dir] {
+			return nil
+		}
+		if err := watcher.Add(dir); err != nil {
+			return err
+		}
+		watchedDirs[dir] = true
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		// Watch the parent even if path doesn't exist yet, so its creation
+		// is noticed.
+		parent := filepath.Dir(path)
+		if _, err := os.Stat(parent); err != nil {
+			return nil
+		}
+		return add(parent)
+	}
+	return filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return add(p)
+		}
+		return nil
+	})
+}
+
+// waitForChanges blocks until one or more watched paths change, then waits
+// for the changes to settle. It returns the Stages affected by the changes,
+// or false if interrupted.
+func waitForChanges(
+	watcher *fsnotify.Watcher,
+	watched map[string][]string,
+	interrupt <-chan os.Signal,
+) (map[string]bool, bool) {
+	changed := make(map[string]bool)
+	var settled <-chan time.Time
+	for {
+		select {
+		case <-interrupt:
+			return nil, false
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil, false
+			}
+			// Ignore events that don't change contents.
+			if event.Has(fsnotify.Chmod) && !event.Has(fsnotify.Write) {
+				continue
+			}
+			name := filepath.Clean(event.Name)
+			found := false
+			for path, stagePaths := range watched {
+				if name == path || strings.HasPrefix(name, path+string(filepath.Separator)) {
+					found = true
+					for _, stagePath := range stagePaths {
+						changed[stagePath] = true
+					}
+				}
+			}
+			if found {
+				logger.Debug.Printf("changed: %s\n", name)
+				settled = time.After(watchDebounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil, false
+			}
+			logger.Error.Println(err)
+		case <-settled:
+			return changed, true
+		}
+	}
+}
+
+// failedStages returns the Stages that failed after running path with
+// Index.Run: the Stage whose error stopped the run, and the Stages between it
+// and path. Run handles Stages in topological order and stops at the first
+// error, so the Stage that failed is the first one not recorded in ran.
+func failedStages(idx index.Index, path string, recursive bool, ran map[string]bool) []string {
+	order := []string{path}
+	if recursive {
+		var err error
+		if order, err = idx.SelectStages(order, true, false); err != nil {
+			return []string{path}
+		}
+	}
+	graph := idx.DAG()
+	for _, stagePath := range order {
+		if _, ok := ran[stagePath]; ok {
+			continue
+		}
+		var failed []string
+		upstreamOfPath := graph.UpstreamClosure(path)
+		for _, downstream := range graph.DownstreamClosure(stagePath) {
+			i := sort.SearchStrings(upstreamOfPath, downstream)
+			if i < len(upstreamOfPath) && upstreamOfPath[i] == downstream {
+				failed = append(failed, downstream)
+			}
+		}
+		return failed
+	}
+	return []string{path}
+}
@@ -0,0 +1,224 @@
+// Package dag implements a directed graph of named nodes, along with the
+// queries Dud needs to order and select Stages.
+package dag
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// A Graph is a directed graph whose edges point from upstream nodes to
+// downstream nodes. Despite the package name, a Graph may contain cycles;
+// TopoSort and FindCycle report them. Not threadsafe.
+type Graph struct {
+	upstream   map[string]map[string]bool
+	downstream map[string]map[string]bool
+}
+
+// CycleError is returned when a Graph contains a cycle. Path lists the nodes
+// in the cycle in upstream-to-downstream order, starting and ending with the
+// same node.
+type CycleError struct {
+	Path []string
+}
+
+func (e CycleError) Error() string {
+	return "cycle detected: " + strings.Join(e.Path, " -> ")
+}
+
+// New returns an empty Graph.
+func New() *Graph {
+	return &Graph{
+		upstream:   make(map[string]map[string]bool),
+		downstream: make(map[string]map[string]bool),
+	}
+}
+
+// AddNode adds a node to the Graph. Adding an existing node has no effect.
+func (g *Graph) AddNode(node string) {
+	if _, ok := g.upstream[node]; ok {
+		return
+	}
+	g.upstream[node] = make(map[string]bool)
+	g.downstream[node] = make(map[string]bool)
+}
+
+// AddEdge adds an edge from upstream to downstream, adding either node if
+// necessary.
+func (g *Graph) AddEdge(upstream, downstream string) {
+	g.AddNode(upstream)
+	g.AddNode(downstream)
+	g.downstream[upstream][downstream] = true
+	g.upstream[downstream][upstream] = true
+}
+
+// HasNode returns true if the node is in the Graph.
+func (g *Graph) HasNode(node string) bool {
+	_, ok := g.upstream[node]
+	return ok
+}
+
+// Nodes returns all nodes in the Graph in sorted order.
+func (g *Graph) Nodes() []string {
+	nodes := make([]string, 0, len(g.upstream))
+	for node := range g.upstream {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// Upstream returns the nodes with edges into the given node, in sorted order.
+func (g *Graph) Upstream(node string) []string {
+	return sortedKeys(g.upstream[node])
+}
+
+// Downstream returns the nodes with edges out of the given node, in sorted
+// order.
+func (g *Graph) Downstream(node string) []string {
+	return sortedKeys(g.downstream[node])
+}
+
+// UpstreamClosure returns the given nodes and all nodes upstream of them, in
+// sorted order. Unknown nodes are ignored.
+func (g *Graph) UpstreamClosure(nodes ...string) []string {
+	return closure(g.upstream, nodes)
+}
+
+// DownstreamClosure returns the given nodes and all nodes downstream of
+// them, in sorted order. Unknown nodes are ignored.
+func (g *Graph) DownstreamClosure(nodes ...string) []string {
+	return closure(g.downstream, nodes)
+}
+
+// Subgraph returns a new Graph holding the given nodes and the edges between
+// them. Unknown nodes are ignored.
+func (g *Graph) Subgraph(nodes ...string) *Graph {
+	sub := New()
+	for _, node := range nodes {
+		if g.HasNode(node) {
+			sub.AddNode(node)
+		}
+	}
+	for node := range sub.upstream {
+		for down := range g.downstream[node] {
+			if sub.HasNode(down) {
+				sub.AddEdge(node, down)
+			}
+		}
+	}
+	return sub
+}
+
+// TopoSort returns all nodes in the Graph such that every node comes after
+// all nodes upstream of it. Ties are broken by sorting on node name, so the
+// order is deterministic. If the Graph contains a cycle, TopoSort returns a
+// CycleError.
+func (g *Graph) TopoSort() ([]string, error) {
+	inDegree := make(map[string]int, len(g.upstream))
+	ready := []string{}
+	for node, upstream := range g.upstream {
+		inDegree[node] = len(upstream)
+		if len(upstream) == 0 {
+			ready = append(ready, node)
+		}
+	}
+	sort.Strings(ready)
+
+	order := make([]string, 0, len(g.upstream))
+	for len(ready) > 0 {
+		node := ready[0]
+		ready = ready[1:]
+		order = append(order, node)
+		for _, down := range g.Downstream(node) {
+			inDegree[down]--
+			if inDegree[down] == 0 {
+				// Insert in sorted position to keep the order deterministic.
+				i := sort.SearchStrings(ready, down)
+				ready = append(ready, "")
+				copy(ready[i+1:], ready[i:])
+				ready[i] = down
+			}
+		}
+	}
+
+	if len(order) == len(g.upstream) {
+		return order, nil
+	}
+	// Every node left over is either in a cycle or downstream of one.
+	for _, node := range g.Nodes() {
+		if inDegree[node] == 0 {
+			continue
+		}
+		if cycle := g.FindCycle(node); cycle != nil {
+			return nil, CycleError{Path: cycle}
+		}
+	}
+	// A node can only remain unsorted if it is in or downstream of a cycle,
+	// so this is unreachable unless the Graph's edge maps are inconsistent.
+	return nil, errors.New("dag: unsorted nodes but no cycle found")
+}
+
+// FindCycle returns the shortest cycle that passes through the given node, or
+// nil if there is no such cycle. The cycle is listed in upstream-to-downstream
+// order and starts and ends with the given node.
+func (g *Graph) FindCycle(node string) []string {
+	parent := map[string]string{}
+	queue := []string{node}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, down := range g.Downstream(current) {
+			if down == node {
+				cycle := []string{node}
+				for n := current; n != node; n = parent[n] {
+					cycle = append(cycle, n)
+				}
+				cycle = append(cycle, node)
+				// The cycle was built backwards; reverse it.
+				for i, j := 0, len(cycle)-1; i < j; i, j = i+1, j-1 {
+					cycle[i], cycle[j] = cycle[j], cycle[i]
+				}
+				return cycle
+			}
+			if _, seen := parent[down]; seen {
+				continue
+			}
+			parent[down] = current
+			queue = append(queue, down)
+		}
+	}
+	return nil
+}
+
+func closure(edges map[string]map[string]bool, nodes []string) []string {
+	seen := make(map[string]bool)
+	stack := []string{}
+	for _, node := range nodes {
+		if _, ok := edges[node]; ok && !seen[node] {
+			seen[node] = true
+			stack = append(stack, node)
+		}
+	}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for next := range edges[node] {
+			if !seen[next] {
+				seen[next] = true
+				stack = append(stack, next)
+			}
+		}
+	}
+	return sortedKeys(seen)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,139 @@
+package dag
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// newDiamond returns a Graph shaped like so:
+//
+//	  a
+//	 / \
+//	b   c
+//	 \ /
+//	  d
+//	  |
+//	  e
+func newDiamond() *Graph {
+	g := New()
+	g.AddEdge("a", "b")
+	g.AddEdge("a", "c")
+	g.AddEdge("b", "d")
+	g.AddEdge("c", "d")
+	g.AddEdge("d", "e")
+	g.AddNode("lonely")
+	return g
+}
+
+func TestTopoSort(t *testing.T) {
+	t.Run("diamond", func(t *testing.T) {
+		order, err := newDiamond().TopoSort()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"a", "b", "c", "d", "e", "lonely"}
+		if diff := cmp.Diff(want, order); diff != "" {
+			t.Fatalf("order -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		g := newDiamond()
+		g.AddEdge("e", "b")
+		_, err := g.TopoSort()
+		cycleErr, ok := err.(CycleError)
+		if !ok {
+			t.Fatalf("expected CycleError, got %#v", err)
+		}
+		want := []string{"b", "d", "e", "b"}
+		if diff := cmp.Diff(want, cycleErr.Path); diff != "" {
+			t.Fatalf("cycle -want +got:\n%s", diff)
+		}
+		wantMsg := "cycle detected: b -> d -> e -> b"
+		if diff := cmp.Diff(wantMsg, err.Error()); diff != "" {
+			t.Fatalf("error -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("self-loop", func(t *testing.T) {
+		g := New()
+		g.AddEdge("a", "a")
+		_, err := g.TopoSort()
+		if diff := cmp.Diff("cycle detected: a -> a", err.Error()); diff != "" {
+			t.Fatalf("error -want +got:\n%s", diff)
+		}
+	})
+}
+
+func TestClosure(t *testing.T) {
+	g := newDiamond()
+
+	t.Run("upstream", func(t *testing.T) {
+		want := []string{"a", "b"}
+		if diff := cmp.Diff(want, g.UpstreamClosure("b")); diff != "" {
+			t.Fatalf("closure -want +got:\n%s", diff)
+		}
+		want = []string{"a", "b", "c", "d"}
+		if diff := cmp.Diff(want, g.UpstreamClosure("d")); diff != "" {
+			t.Fatalf("closure -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("downstream", func(t *testing.T) {
+		want := []string{"c", "d", "e"}
+		if diff := cmp.Diff(want, g.DownstreamClosure("c")); diff != "" {
+			t.Fatalf("closure -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("unknown nodes are ignored", func(t *testing.T) {
+		want := []string{"e"}
+		if diff := cmp.Diff(want, g.DownstreamClosure("e", "nope")); diff != "" {
+			t.Fatalf("closure -want +got:\n%s", diff)
+		}
+	})
+}
+
+func TestFindCycle(t *testing.T) {
+	t.Run("no cycle", func(t *testing.T) {
+		if cycle := newDiamond().FindCycle("a"); cycle != nil {
+			t.Fatalf("expected no cycle, got %v", cycle)
+		}
+	})
+
+	t.Run("shortest cycle through node", func(t *testing.T) {
+		g := newDiamond()
+		g.AddEdge("e", "a")
+		g.AddEdge("d", "a")
+		want := []string{"a", "b", "d", "a"}
+		if diff := cmp.Diff(want, g.FindCycle("a")); diff != "" {
+			t.Fatalf("cycle -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("node downstream of cycle", func(t *testing.T) {
+		g := newDiamond()
+		g.AddEdge("d", "b")
+		if cycle := g.FindCycle("e"); cycle != nil {
+			t.Fatalf("expected no cycle, got %v", cycle)
+		}
+	})
+}
+
+func TestSubgraph(t *testing.T) {
+	sub := newDiamond().Subgraph("b", "d", "e", "unknown")
+	if diff := cmp.Diff([]string{"b", "d", "e"}, sub.Nodes()); diff != "" {
+		t.Fatalf("Nodes -want +got:\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"b"}, sub.Upstream("d")); diff != "" {
+		t.Fatalf("Upstream(d) -want +got:\n%s", diff)
+	}
+	order, err := sub.TopoSort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"b", "d", "e"}, order); diff != "" {
+		t.Fatalf("TopoSort -want +got:\n%s", diff)
+	}
+}
@@ -0,0 +1,110 @@
+// Package gitutil wraps the local git binary for reading project files at past
+// revisions.
+package gitutil
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Run runs git with the given arguments in dir and returns its standard
+// output. If git fails, the returned error includes git's standard error.
+func Run(dir string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, fmt.Errorf("git %s: %s", strings.Join(args, " "), msg)
+	}
+	return stdout.Bytes(), nil
+}
+
+// Clone clones the git repository at url into dir, which must not exist or
+// must be empty. Local repository paths work without network access.
+func Clone(url, dir string) error {
+	_, err := Run("", "clone", "--quiet", "--", url, dir)
+	return err
+}
+
+// ResolveCommit returns the full hash of the commit that rev refers to.
+func ResolveCommit(dir, rev string) (string, error) {
+	out, err := Run(dir, "rev-parse", "--verify", "--quiet", "--end-of-options", rev+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("%s is not a git revision", rev)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Exists returns true if path existed at revision rev. The path is relative to
+// dir.
+func Exists(dir, rev, path string) bool {
+	_, err := Run(dir, "cat-file", "-e", objectName(rev, path))
+	return err == nil
+}
+
+// Show returns the contents of the file at path as of revision rev. The path
+// is relative to dir.
+func Show(dir, rev, path string) ([]byte, error) {
+	return Run(dir, "show", objectName(rev, path))
+}
+
+// ChangedFiles returns the paths of the files that differ between revisions
+// from and to. If to is empty, from is compared with the working tree. Paths
+// are relative to dir, and files outside of dir are ignored.
+func ChangedFiles(dir, from, to string) ([]string, error) {
+	args := []string{"diff", "--name-only", "--relative", "-z", from}
+	if to != "" {
+		args = append(args, to)
+	}
+	out, err := Run(dir, append(args, "--")...)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, path := range strings.Split(string(out), "\x00") {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// HooksDir returns the directory git runs hooks from for the repository
+// containing dir. It respects the core.hooksPath config.
+func HooksDir(dir string) (string, error) {
+	out, err := Run(dir, "rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return "", err
+	}
+	hooksDir := strings.TrimSpace(string(out))
+	if !filepath.IsAbs(hooksDir) {
+		hooksDir = filepath.Join(dir, hooksDir)
+	}
+	return hooksDir, nil
+}
+
+// Prefix returns the path of dir relative to the root of its git repository,
+// or an empty string if dir is the root.
+func Prefix(dir string) (string, error) {
+	out, err := Run(dir, "rev-parse", "--show-prefix")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSpace(string(out)), "/"), nil
+}
+
+// objectName returns git's name for the object at path as of revision rev.
+// The "./" prefix makes git resolve the path relative to the working
+// directory rather than the root of the git repository.
+func objectName(rev, path string) string {
+	return rev + ":./" + path
+}
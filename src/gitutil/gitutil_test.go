@@ -0,0 +1,117 @@
+package gitutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestShowIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	repoDir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		if _, err := Run(repoDir, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "-q")
+	git("config", "user.email", "dud@example.com")
+	git("config", "user.name", "Dud")
+
+	subDir := filepath.Join(repoDir, "project")
+	if err := os.Mkdir(subDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(subDir, "stage.yaml"), []byte("v1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	git("add", "-A")
+	git("commit", "-q", "-m", "first")
+	if err := os.WriteFile(filepath.Join(subDir, "stage.yaml"), []byte("v2"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	git("commit", "-q", "-a", "-m", "second")
+
+	t.Run("paths are relative to the given directory", func(t *testing.T) {
+		out, err := Show(subDir, "HEAD~1", "stage.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != "v1" {
+			t.Fatalf("Show() = %#v, want %#v", string(out), "v1")
+		}
+	})
+
+	t.Run("exists", func(t *testing.T) {
+		if !Exists(subDir, "HEAD", "stage.yaml") {
+			t.Fatal("expected stage.yaml to exist")
+		}
+		if Exists(subDir, "HEAD", "other.yaml") {
+			t.Fatal("expected other.yaml not to exist")
+		}
+	})
+
+	t.Run("clone", func(t *testing.T) {
+		cloneDir := filepath.Join(t.TempDir(), "clone")
+		if err := Clone(repoDir, cloneDir); err != nil {
+			t.Fatal(err)
+		}
+		out, err := os.ReadFile(filepath.Join(cloneDir, "project", "stage.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != "v2" {
+			t.Fatalf("cloned stage.yaml = %#v, want %#v", string(out), "v2")
+		}
+	})
+
+	t.Run("changed files", func(t *testing.T) {
+		if err := os.WriteFile(filepath.Join(repoDir, "other.txt"), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		git("add", "-A")
+		git("commit", "-q", "-m", "third")
+
+		changed, err := ChangedFiles(subDir, "HEAD~2", "HEAD")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]string{"stage.yaml"}, changed); diff != "" {
+			t.Fatalf("ChangedFiles() -want +got:\n%s", diff)
+		}
+
+		if err := os.WriteFile(filepath.Join(subDir, "stage.yaml"), []byte("v3"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		changed, err = ChangedFiles(subDir, "HEAD", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]string{"stage.yaml"}, changed); diff != "" {
+			t.Fatalf("ChangedFiles() -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("prefix", func(t *testing.T) {
+		prefix, err := Prefix(subDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prefix != "project" {
+			t.Fatalf("Prefix() = %#v, want %#v", prefix, "project")
+		}
+		prefix, err = Prefix(repoDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prefix != "" {
+			t.Fatalf("Prefix() = %#v, want %#v", prefix, "")
+		}
+	})
+}
@@ -0,0 +1,105 @@
+// Package hook runs user-configured shell commands before and after Dud
+// operations.
+package hook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Names lists all valid hook names. Each operation has a "pre-" hook, which
+// runs before the operation and aborts it on failure, and a "post-" hook,
+// which runs after the operation succeeds. The "stage" hooks run around each
+// Stage command executed by 'dud run'.
+var Names = []string{
+	"pre-commit", "post-commit",
+	"pre-run", "post-run",
+	"pre-checkout", "post-checkout",
+	"pre-push", "post-push",
+	"pre-fetch", "post-fetch",
+	"pre-stage", "post-stage",
+}
+
+// Hooks maps hook names to shell commands. A nil or empty Hooks runs nothing.
+type Hooks map[string]string
+
+// Context describes the operation a hook is running for. It is written to the
+// hook command's stdin as JSON, and its fields are also available to the
+// command as DUD_* environment variables.
+type Context struct {
+	// Hook is the name of the hook (e.g. "pre-commit").
+	Hook string `json:"hook"`
+	// Operation is the operation the hook is running for (e.g. "commit").
+	Operation string `json:"operation"`
+	// RootDir is the project root directory. Hook commands are run in this
+	// directory.
+	RootDir string `json:"root"`
+	// Stages holds the paths of the Stages the operation was invoked on. It is
+	// empty for Stage hooks.
+	Stages []string `json:"stages,omitempty"`
+	// Stage, Command, and WorkingDir describe the Stage whose command is being
+	// run. They are only set for Stage hooks.
+	Stage      string `json:"stage,omitempty"`
+	Command    string `json:"command,omitempty"`
+	WorkingDir string `json:"working-dir,omitempty"`
+}
+
+// Validate returns an error if any of the hooks has an unknown name.
+func (hooks Hooks) Validate() error {
+	for name := range hooks {
+		if !isValidName(name) {
+			return fmt.Errorf(
+				"unknown hook %q; expected one of [%s]",
+				name,
+				strings.Join(Names, ", "),
+			)
+		}
+	}
+	return nil
+}
+
+func isValidName(name string) bool {
+	for _, validName := range Names {
+		if name == validName {
+			return true
+		}
+	}
+	return false
+}
+
+// Run runs the named hook with the given Context, if the hook is configured.
+// The Context's Hook and Operation fields are set from name. An error is
+// returned if the hook command fails.
+func (hooks Hooks) Run(name string, ctx Context) error {
+	command := strings.TrimSpace(hooks[name])
+	if command == "" {
+		return nil
+	}
+	ctx.Hook = name
+	ctx.Operation = name[strings.Index(name, "-")+1:]
+	input, err := json.Marshal(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "%s hook", name)
+	}
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = ctx.RootDir
+	cmd.Env = append(
+		os.Environ(),
+		"DUD_HOOK="+ctx.Hook,
+		"DUD_OPERATION="+ctx.Operation,
+		"DUD_ROOT="+ctx.RootDir,
+		"DUD_STAGES="+strings.Join(ctx.Stages, " "),
+		"DUD_STAGE="+ctx.Stage,
+	)
+	cmd.Stdin = bytes.NewReader(append(input, '\n'))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return errors.Wrapf(cmd.Run(), "%s hook failed", name)
+}
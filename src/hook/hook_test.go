@@ -0,0 +1,91 @@
+package hook
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidate(t *testing.T) {
+	t.Run("known hooks", func(t *testing.T) {
+		hooks := Hooks{"pre-commit": "true", "post-stage": "true"}
+		if err := hooks.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("error on unknown hook", func(t *testing.T) {
+		hooks := Hooks{"pre-comit": "true"}
+		err := hooks.Validate()
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if !strings.HasPrefix(err.Error(), `unknown hook "pre-comit"`) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestRunIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	t.Run("passes context to the command", func(t *testing.T) {
+		rootDir := t.TempDir()
+		hooks := Hooks{
+			"pre-commit": `cat > context.json && echo "$DUD_HOOK $DUD_OPERATION $DUD_STAGES" > env.txt`,
+		}
+		ctx := Context{RootDir: rootDir, Stages: []string{"a.yaml", "b.yaml"}}
+		if err := hooks.Run("pre-commit", ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		env, err := os.ReadFile(filepath.Join(rootDir, "env.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff("pre-commit commit a.yaml b.yaml\n", string(env)); diff != "" {
+			t.Fatalf("environment -want +got:\n%s", diff)
+		}
+
+		input, err := os.ReadFile(filepath.Join(rootDir, "context.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Context
+		if err := json.Unmarshal(input, &got); err != nil {
+			t.Fatal(err)
+		}
+		want := Context{
+			Hook:      "pre-commit",
+			Operation: "commit",
+			RootDir:   rootDir,
+			Stages:    []string{"a.yaml", "b.yaml"},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("context -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("unconfigured hook is a no-op", func(t *testing.T) {
+		if err := Hooks(nil).Run("post-push", Context{RootDir: t.TempDir()}); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("error on failing command", func(t *testing.T) {
+		hooks := Hooks{"pre-stage": "exit 3"}
+		err := hooks.Run("pre-stage", Context{RootDir: t.TempDir()})
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if diff := cmp.Diff("pre-stage hook failed: exit status 3", err.Error()); diff != "" {
+			t.Fatalf("error -want +got:\n%s", diff)
+		}
+	})
+}
@@ -48,17 +48,16 @@ func TestCheckout(t *testing.T) {
 				"bar.bin": {Path: "bar.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stgA,
 			"bar.yaml": &stgB,
-		}
+		})
 
 		mockCache := mocks.Cache{}
 
 		expectOutputsCheckedOut(&stgA, &mockCache, rootDir, strat)
 
 		checkedOut := make(map[string]bool)
-		inProgress := make(map[string]bool)
 		if err := idx.Checkout(
 			"foo.yaml",
 			&mockCache,
@@ -66,7 +65,6 @@ func TestCheckout(t *testing.T) {
 			strat,
 			true,
 			checkedOut,
-			inProgress,
 			logger,
 		); err != nil {
 			t.Fatal(err)
@@ -101,10 +99,10 @@ func TestCheckout(t *testing.T) {
 				"bar.bin": {Path: "bar.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stgA,
 			"bar.yaml": &stgB,
-		}
+		})
 
 		mockCache := mocks.Cache{}
 
@@ -112,7 +110,6 @@ func TestCheckout(t *testing.T) {
 		expectOutputsCheckedOut(&stgB, &mockCache, rootDir, strat)
 
 		checkedOut := make(map[string]bool)
-		inProgress := make(map[string]bool)
 		if err := idx.Checkout(
 			"bar.yaml",
 			&mockCache,
@@ -120,7 +117,6 @@ func TestCheckout(t *testing.T) {
 			strat,
 			true,
 			checkedOut,
-			inProgress,
 			logger,
 		); err != nil {
 			t.Fatal(err)
@@ -173,11 +169,11 @@ func TestCheckout(t *testing.T) {
 				"bosh.bin": {Path: "bosh.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"bish.yaml": &stgA,
 			"bash.yaml": &stgB,
 			"bosh.yaml": &stgC,
-		}
+		})
 
 		mockCache := mocks.Cache{}
 
@@ -186,7 +182,6 @@ func TestCheckout(t *testing.T) {
 		expectOutputsCheckedOut(&stgC, &mockCache, rootDir, strat)
 
 		checkedOut := make(map[string]bool)
-		inProgress := make(map[string]bool)
 		if err := idx.Checkout(
 			"bosh.yaml",
 			&mockCache,
@@ -194,7 +189,6 @@ func TestCheckout(t *testing.T) {
 			strat,
 			true,
 			checkedOut,
-			inProgress,
 			logger,
 		); err != nil {
 			t.Fatal(err)
@@ -245,12 +239,12 @@ func TestCheckout(t *testing.T) {
 				"d.bin": {Path: "d.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"a.yaml": &stgA,
 			"b.yaml": &stgB,
 			"c.yaml": &stgC,
 			"d.yaml": &stgD,
-		}
+		})
 
 		mockCache := mocks.Cache{}
 		// Stage D is the only Stage that could possibly be checked out
@@ -259,7 +253,6 @@ func TestCheckout(t *testing.T) {
 		expectOutputsCheckedOut(&stgD, &mockCache, rootDir, strat)
 
 		checkedOut := make(map[string]bool)
-		inProgress := make(map[string]bool)
 		err := idx.Checkout(
 			"c.yaml",
 			&mockCache,
@@ -267,25 +260,20 @@ func TestCheckout(t *testing.T) {
 			strat,
 			true,
 			checkedOut,
-			inProgress,
 			logger,
 		)
 		if err == nil {
 			t.Fatal("expected error")
 		}
 
-		expectedError := "cycle detected"
+		expectedError := "cycle detected: c.yaml -> a.yaml -> b.yaml -> c.yaml"
 		if diff := cmp.Diff(expectedError, err.Error()); diff != "" {
 			t.Fatalf("error -want +got:\n%s", diff)
 		}
 
-		expectedInProgress := map[string]bool{
-			"c.yaml": true,
-			"b.yaml": true,
-			"a.yaml": true,
-		}
-		if diff := cmp.Diff(expectedInProgress, inProgress); diff != "" {
-			t.Fatalf("inProgress -want +got:\n%s", diff)
+		// The cycle is found before any Stage is processed.
+		if len(checkedOut) != 0 {
+			t.Fatalf("expected no stages to be processed, got %v", checkedOut)
 		}
 	})
 
@@ -308,17 +296,16 @@ func TestCheckout(t *testing.T) {
 				"bar.bin": {Path: "bar.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stgA,
 			"bar.yaml": &stgB,
-		}
+		})
 
 		mockCache := mocks.Cache{}
 
 		expectOutputsCheckedOut(&stgB, &mockCache, rootDir, strat)
 
 		checkedOut := make(map[string]bool)
-		inProgress := make(map[string]bool)
 		if err := idx.Checkout(
 			"bar.yaml",
 			&mockCache,
@@ -326,7 +313,6 @@ func TestCheckout(t *testing.T) {
 			strat,
 			false,
 			checkedOut,
-			inProgress,
 			logger,
 		); err != nil {
 			t.Fatal(err)
@@ -352,4 +338,49 @@ func TestCheckout(t *testing.T) {
 			t.Fatalf("checkedOut -want +got:\n%s", diff)
 		}
 	})
+
+	t.Run("order-only upstream stages are checked out", func(t *testing.T) {
+		stgA := stage.Stage{
+			Outputs: map[string]*artifact.Artifact{
+				"foo.bin": {Path: "foo.bin"},
+			},
+		}
+		stgB := stage.Stage{
+			After: []string{"foo.yaml"},
+			Outputs: map[string]*artifact.Artifact{
+				"bar.bin": {Path: "bar.bin"},
+			},
+		}
+		idx := newTestIndex(t, map[string]*stage.Stage{
+			"foo.yaml": &stgA,
+			"bar.yaml": &stgB,
+		})
+
+		mockCache := mocks.Cache{}
+		expectOutputsCheckedOut(&stgA, &mockCache, rootDir, strat)
+		expectOutputsCheckedOut(&stgB, &mockCache, rootDir, strat)
+
+		checkedOut := make(map[string]bool)
+		if err := idx.Checkout(
+			"bar.yaml",
+			&mockCache,
+			rootDir,
+			strat,
+			true,
+			checkedOut,
+			logger,
+		); err != nil {
+			t.Fatal(err)
+		}
+
+		mockCache.AssertExpectations(t)
+
+		expectedCheckedOutSet := map[string]bool{
+			"foo.yaml": true,
+			"bar.yaml": true,
+		}
+		if diff := cmp.Diff(expectedCheckedOutSet, checkedOut); diff != "" {
+			t.Fatalf("checkedOut -want +got:\n%s", diff)
+		}
+	})
 }
@@ -2,75 +2,75 @@ package index
 
 import (
 	"github.com/kevin-hanselman/dud/src/agglog"
-	"github.com/kevin-hanselman/dud/src/artifact"
 	"github.com/kevin-hanselman/dud/src/cache"
+	"github.com/kevin-hanselman/dud/src/stage"
 	"github.com/kevin-hanselman/dud/src/strategy"
 	"github.com/pkg/errors"
 )
 
-// Commit commits the given Stage's Outputs and recursively acts on all
-// upstream Stages.
+// Commit commits the given Stage's Outputs and all upstream Stages.
+// Upstream Stages are committed first.
 func (idx Index) Commit(
 	stagePath string,
 	ch cache.Cache,
 	rootDir string,
 	strat strategy.CheckoutStrategy,
 	committed map[string]bool,
-	inProgress map[string]bool,
 	logger *agglog.AggLogger,
 ) error {
-	if committed[stagePath] {
-		return nil
-	}
-
-	// If we've visited this Stage but haven't recorded its status (the check
-	// above), then we're in a cycle.
-	if inProgress[stagePath] {
-		return errors.New("cycle detected")
+	order, err := idx.upstreamOrder(stagePath, true, false, func(path string) bool {
+		return committed[path]
+	})
+	if err != nil {
+		return err
 	}
-	inProgress[stagePath] = true
-
-	stg, ok := idx[stagePath]
-	if !ok {
-		return unknownStageError{stagePath}
+	for _, path := range order {
+		if committed[path] {
+			continue
+		}
+		logger.Info.Printf("committing stage %s\n", path)
+		if err := idx.commitStage(path, idx.stages[path], ch, rootDir, strat, nil, logger); err != nil {
+			return err
+		}
+		committed[path] = true
 	}
+	return nil
+}
 
-	nonStageInputs := []*artifact.Artifact{}
-
+// commitStage commits a single Stage without acting on upstream Stages. Any
+// inputs owned by upstream Stages take their checksums from said Stages, so
+// the upstream Stages should be committed first. envProbes is recorded as the
+// Stage's EnvState; if it is nil, the Stage's probes are run to fill it in.
+func (idx Index) commitStage(
+	stagePath string,
+	stg *stage.Stage,
+	ch cache.Cache,
+	rootDir string,
+	strat strategy.CheckoutStrategy,
+	envProbes map[string]stage.EnvProbe,
+	logger *agglog.AggLogger,
+) (err error) {
 	for artPath, art := range stg.Inputs {
 		ownerPath, upstreamArt := idx.findOwner(artPath)
-		if ownerPath == "" {
-			// Collect all inputs not owned by a stage and checksum them AFTER
-			// announcing "committing stage..." to the user.
-			// TODO: This is hard to test because the only indication of committing
-			// artifacts is the progress bar, and the progress bar is hidden
-			// when the environment is non-interactive (e.g. the integration
-			// test scripts). To ease testing of this and similar UI things, it
-			// may be best to output a static message (e.g. "committing
-			// file.txt...") instead of the dynamic progress report in
-			// non-interactive settings.
-			nonStageInputs = append(nonStageInputs, art)
-		} else {
-			if err := idx.Commit(
-				ownerPath,
-				ch,
-				rootDir,
-				strat,
-				committed,
-				inProgress,
-				logger,
-			); err != nil {
-				return err
+		if ownerPath != "" {
+			art.Checksum, err = upstreamChecksum(ch, artPath, upstreamArt)
+			if err != nil {
+				return errors.Wrapf(err, "commit %s: input %s", stagePath, artPath)
 			}
-			art.Checksum = upstreamArt.Checksum
+			continue
 		}
-	}
-	logger.Info.Printf("committing stage %s\n", stagePath)
-	for _, art := range nonStageInputs {
 		// Always skip the cache for inputs. This is also enforced in
 		// stage.FromFile, but most tests obviously don't use FromFile to
 		// create Stages to test against. To be safe, it's best to force
 		// SkipCache to true here.
+		// TODO: Inputs not owned by a stage are checksummed AFTER announcing
+		// "committing stage..." to the user. This is hard to test because the
+		// only indication of committing artifacts is the progress bar, and
+		// the progress bar is hidden when the environment is non-interactive
+		// (e.g. the integration test scripts). To ease testing of this and
+		// similar UI things, it may be best to output a static message (e.g.
+		// "committing file.txt...") instead of the dynamic progress report in
+		// non-interactive settings.
 		art.SkipCache = true
 		if err := ch.Commit(rootDir, art, strat, logger); err != nil {
 			return err
@@ -81,12 +81,13 @@ func (idx Index) Commit(
 			return err
 		}
 	}
-	var err error
-	stg.Checksum, err = stg.CalculateChecksum()
-	if err != nil {
-		return err
+	if envProbes == nil {
+		envProbes, err = stg.ProbeEnv(rootDir)
+		if err != nil {
+			return errors.Wrapf(err, "commit %s", stagePath)
+		}
 	}
-	committed[stagePath] = true
-	delete(inProgress, stagePath)
-	return nil
+	stg.EnvState = envProbes
+	stg.Checksum, err = stg.CalculateChecksum()
+	return err
 }
@@ -53,10 +53,10 @@ func TestCommit(t *testing.T) {
 				"bar.bin": {Path: "bar.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stgA,
 			"bar.yaml": &stgB,
-		}
+		})
 
 		mockCache := mocks.Cache{}
 
@@ -67,14 +67,12 @@ func TestCommit(t *testing.T) {
 		mockCache.On("Commit", rootDir, &orphanCopy, strat, mock.AnythingOfType("*agglog.AggLogger")).Return(mockCommit).Once()
 
 		committed := make(map[string]bool)
-		inProgress := make(map[string]bool)
 		if err := idx.Commit(
 			"foo.yaml",
 			&mockCache,
 			rootDir,
 			strat,
 			committed,
-			inProgress,
 			logger,
 		); err != nil {
 			t.Fatal(err)
@@ -119,10 +117,10 @@ func TestCommit(t *testing.T) {
 				"bar.bin": {Path: "bar.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stgA,
 			"bar.yaml": &stgB,
-		}
+		})
 
 		mockCache := mocks.Cache{}
 
@@ -130,14 +128,12 @@ func TestCommit(t *testing.T) {
 		expectOutputsCommitted(&stgB, &mockCache, rootDir, strat)
 
 		committed := make(map[string]bool)
-		inProgress := make(map[string]bool)
 		if err := idx.Commit(
 			"bar.yaml",
 			&mockCache,
 			rootDir,
 			strat,
 			committed,
-			inProgress,
 			logger,
 		); err != nil {
 			t.Fatal(err)
@@ -174,6 +170,56 @@ func TestCommit(t *testing.T) {
 		}
 	})
 
+	t.Run("input inside upstream directory output", func(t *testing.T) {
+		dirArt := artifact.Artifact{Path: "data", IsDir: true}
+		subPathArt := artifact.Artifact{Path: "data/train.csv"}
+		stgA := stage.Stage{
+			Outputs: map[string]*artifact.Artifact{
+				"data": &dirArt,
+			},
+		}
+		stgB := stage.Stage{
+			Inputs: map[string]*artifact.Artifact{
+				"data/train.csv": &subPathArt,
+			},
+			Outputs: map[string]*artifact.Artifact{
+				"model.bin": {Path: "model.bin"},
+			},
+		}
+		idx := newTestIndex(t, map[string]*stage.Stage{
+			"a.yaml": &stgA,
+			"b.yaml": &stgB,
+		})
+
+		mockCache := mocks.Cache{}
+
+		expectOutputsCommitted(&stgA, &mockCache, rootDir, strat)
+		expectOutputsCommitted(&stgB, &mockCache, rootDir, strat)
+		committedDir := artifact.Artifact{Path: "data", IsDir: true, Checksum: "committed"}
+		mockCache.On("ResolveChild", committedDir, "data/train.csv").Return(
+			artifact.Artifact{Path: "data/train.csv", Checksum: "train_checksum"},
+			nil,
+		).Once()
+
+		committed := make(map[string]bool)
+		if err := idx.Commit(
+			"b.yaml",
+			&mockCache,
+			rootDir,
+			strat,
+			committed,
+			logger,
+		); err != nil {
+			t.Fatal(err)
+		}
+
+		mockCache.AssertExpectations(t)
+
+		if subPathArt.Checksum != "train_checksum" {
+			t.Fatalf("input checksum = %#v, want %#v", subPathArt.Checksum, "train_checksum")
+		}
+	})
+
 	t.Run("stages aren't repeated", func(t *testing.T) {
 		// stgA <-- stgB <-- stgC
 		//    ^---------------|
@@ -199,11 +245,11 @@ func TestCommit(t *testing.T) {
 				"bosh.bin": {Path: "bosh.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"bish.yaml": &stgA,
 			"bash.yaml": &stgB,
 			"bosh.yaml": &stgC,
-		}
+		})
 
 		mockCache := mocks.Cache{}
 
@@ -212,14 +258,12 @@ func TestCommit(t *testing.T) {
 		expectOutputsCommitted(&stgC, &mockCache, rootDir, strat)
 
 		committed := make(map[string]bool)
-		inProgress := make(map[string]bool)
 		if err := idx.Commit(
 			"bosh.yaml",
 			&mockCache,
 			rootDir,
 			strat,
 			committed,
-			inProgress,
 			logger,
 		); err != nil {
 			t.Fatal(err)
@@ -270,12 +314,12 @@ func TestCommit(t *testing.T) {
 				"d.bin": {Path: "d.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"a.yaml": &stgA,
 			"b.yaml": &stgB,
 			"c.yaml": &stgC,
 			"d.yaml": &stgD,
-		}
+		})
 
 		mockCache := mocks.Cache{}
 		// Stage D is the only Stage that could possibly be committed
@@ -284,32 +328,26 @@ func TestCommit(t *testing.T) {
 		expectOutputsCommitted(&stgD, &mockCache, rootDir, strat)
 
 		committed := make(map[string]bool)
-		inProgress := make(map[string]bool)
 		err := idx.Commit(
 			"c.yaml",
 			&mockCache,
 			rootDir,
 			strat,
 			committed,
-			inProgress,
 			logger,
 		)
 		if err == nil {
 			t.Fatal("expected error")
 		}
 
-		expectedError := "cycle detected"
+		expectedError := "cycle detected: c.yaml -> a.yaml -> b.yaml -> c.yaml"
 		if diff := cmp.Diff(expectedError, err.Error()); diff != "" {
 			t.Fatalf("error -want +got:\n%s", diff)
 		}
 
-		expectedInProgress := map[string]bool{
-			"c.yaml": true,
-			"b.yaml": true,
-			"a.yaml": true,
-		}
-		if diff := cmp.Diff(expectedInProgress, inProgress); diff != "" {
-			t.Fatalf("inProgress -want +got:\n%s", diff)
+		// The cycle is found before any Stage is processed.
+		if len(committed) != 0 {
+			t.Fatalf("expected no stages to be processed, got %v", committed)
 		}
 	})
 }
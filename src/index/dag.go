@@ -0,0 +1,196 @@
+package index
+
+import (
+	"sort"
+
+	"github.com/kevin-hanselman/dud/src/dag"
+	"github.com/pkg/errors"
+)
+
+// DAG returns the dependency graph of the Index. Each Stage is a node, and
+// each Stage has an edge to every Stage that owns one of its inputs, as well
+// as every Stage in its 'after' list. The Graph is shared by later calls, so
+// it must not be modified.
+func (idx Index) DAG() *dag.Graph {
+	return idx.dag(true).graph
+}
+
+// graphCache holds the dependency graphs of an Index, with and without
+// 'after' edges.
+type graphCache struct {
+	withAfter, withoutAfter *sortedGraph
+}
+
+// clear discards the cached graphs. It is safe to call on a nil graphCache.
+func (c *graphCache) clear() {
+	if c != nil {
+		*c = graphCache{}
+	}
+}
+
+// A sortedGraph is a dependency graph along with the position of each node
+// in its topological order. If the graph has a cycle, rank is nil.
+type sortedGraph struct {
+	graph *dag.Graph
+	rank  map[string]int
+}
+
+// dag returns the dependency graph of the Index, as described by DAG. If
+// withAfter is false, 'after' lists are ignored, so only the Stages owning a
+// Stage's inputs are upstream of it. The graph is built on first use and
+// cached until a Stage is added or removed.
+func (idx Index) dag(withAfter bool) *sortedGraph {
+	// A zero Index has no cache, so build the graph every time.
+	cached := new(*sortedGraph)
+	if idx.graphs != nil {
+		cached = &idx.graphs.withoutAfter
+		if withAfter {
+			cached = &idx.graphs.withAfter
+		}
+	}
+	if *cached != nil {
+		return *cached
+	}
+	graph := dag.New()
+	for stagePath, stg := range idx.stages {
+		graph.AddNode(stagePath)
+		for artPath := range stg.Inputs {
+			if ownerPath, _ := idx.findOwner(artPath); ownerPath != "" {
+				graph.AddEdge(ownerPath, stagePath)
+			}
+		}
+		if !withAfter {
+			continue
+		}
+		for _, afterPath := range stg.After {
+			if _, ok := idx.stages[afterPath]; ok {
+				graph.AddEdge(afterPath, stagePath)
+			}
+		}
+	}
+	sorted := &sortedGraph{graph: graph}
+	if order, err := graph.TopoSort(); err == nil {
+		sorted.rank = make(map[string]int, len(order))
+		for i, node := range order {
+			sorted.rank[node] = i
+		}
+	}
+	*cached = sorted
+	return sorted
+}
+
+// upstreamOrder returns the given Stage and, if recursive, all Stages
+// upstream of it, in topological order (i.e. every Stage comes after all
+// Stages upstream of it). withAfter is passed to dag. Stages for which done
+// returns true are left out along with everything upstream of them, as those
+// must have been handled first; this keeps acting on every Stage of a large
+// Index, one call per Stage, from revisiting the same Stages over and over.
+func (idx Index) upstreamOrder(
+	stagePath string,
+	recursive, withAfter bool,
+	done func(string) bool,
+) ([]string, error) {
+	if _, ok := idx.stages[stagePath]; !ok {
+		return nil, unknownStageError{stagePath}
+	}
+	if !recursive {
+		return []string{stagePath}, nil
+	}
+	sorted := idx.dag(withAfter)
+	graph := sorted.graph
+	if sorted.rank == nil {
+		// There's a cycle somewhere in the Index, but perhaps not upstream of
+		// the given Stage. Prefer reporting the cycle through the given Stage,
+		// if there is one.
+		order, err := graph.Subgraph(graph.UpstreamClosure(stagePath)...).TopoSort()
+		var cycleErr dag.CycleError
+		if errors.As(err, &cycleErr) {
+			if cycle := graph.FindCycle(stagePath); cycle != nil {
+				return nil, dag.CycleError{Path: cycle}
+			}
+		}
+		return order, err
+	}
+	seen := map[string]bool{stagePath: true}
+	order := []string{}
+	stack := []string{stagePath}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if done(node) {
+			continue
+		}
+		order = append(order, node)
+		for _, upstream := range graph.Upstream(node) {
+			if !seen[upstream] {
+				seen[upstream] = true
+				stack = append(stack, upstream)
+			}
+		}
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return sorted.rank[order[i]] < sorted.rank[order[j]]
+	})
+	return order, nil
+}
+
+// Validate returns an error if any Stage's 'after' list references a Stage
+// that isn't in the Index, or if the Index's dependency graph has a cycle.
+func (idx Index) Validate() error {
+	for _, stagePath := range idx.SortStagePaths() {
+		for _, afterPath := range idx.stages[stagePath].After {
+			if _, ok := idx.stages[afterPath]; !ok {
+				return errors.Wrapf(unknownStageError{afterPath}, "%s: after", stagePath)
+			}
+		}
+	}
+	if idx.dag(true).rank != nil {
+		return nil
+	}
+	_, err := idx.DAG().TopoSort()
+	return err
+}
+
+// SelectStages returns the given Stage paths, optionally expanded to include
+// all Stages upstream and/or downstream of them. If either expansion is
+// requested, the returned paths are in topological order (i.e. every Stage
+// comes after all Stages upstream of it); otherwise, paths is returned as-is.
+func (idx Index) SelectStages(
+	paths []string,
+	upstream bool,
+	downstream bool,
+) ([]string, error) {
+	for _, path := range paths {
+		if _, ok := idx.stages[path]; !ok {
+			return nil, unknownStageError{path}
+		}
+	}
+	if !upstream && !downstream {
+		return paths, nil
+	}
+	sorted := idx.dag(true)
+	graph := sorted.graph
+	selected := make(map[string]bool)
+	if upstream {
+		for _, path := range graph.UpstreamClosure(paths...) {
+			selected[path] = true
+		}
+	}
+	if downstream {
+		for _, path := range graph.DownstreamClosure(paths...) {
+			selected[path] = true
+		}
+	}
+	if sorted.rank == nil {
+		_, err := graph.TopoSort()
+		return nil, err
+	}
+	out := make([]string, 0, len(selected))
+	for path := range selected {
+		out = append(out, path)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return sorted.rank[out[i]] < sorted.rank[out[j]]
+	})
+	return out, nil
+}
@@ -0,0 +1,229 @@
+package index
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/stage"
+)
+
+func TestSelectStages(t *testing.T) {
+	// a.yaml --> b.yaml --> c.yaml
+	//        \-> d.yaml
+	newIndex := func(t *testing.T) Index {
+		return newTestIndex(t, map[string]*stage.Stage{
+			"a.yaml": {
+				Outputs: map[string]*artifact.Artifact{
+					"a": {Path: "a", IsDir: true},
+				},
+			},
+			"b.yaml": {
+				Inputs: map[string]*artifact.Artifact{
+					"a/b.in": {Path: "a/b.in"},
+				},
+				Outputs: map[string]*artifact.Artifact{
+					"b.bin": {Path: "b.bin"},
+				},
+			},
+			"c.yaml": {
+				Inputs: map[string]*artifact.Artifact{
+					"b.bin": {Path: "b.bin"},
+				},
+			},
+			"d.yaml": {
+				Inputs: map[string]*artifact.Artifact{
+					"a/d.in": {Path: "a/d.in"},
+				},
+			},
+		})
+	}
+
+	tests := map[string]struct {
+		paths                []string
+		upstream, downstream bool
+		want                 []string
+	}{
+		"no expansion": {
+			paths: []string{"c.yaml", "a.yaml"},
+			want:  []string{"c.yaml", "a.yaml"},
+		},
+		"upstream": {
+			paths:    []string{"c.yaml"},
+			upstream: true,
+			want:     []string{"a.yaml", "b.yaml", "c.yaml"},
+		},
+		"downstream": {
+			paths:      []string{"a.yaml"},
+			downstream: true,
+			want:       []string{"a.yaml", "b.yaml", "c.yaml", "d.yaml"},
+		},
+		"upstream and downstream": {
+			paths:      []string{"b.yaml"},
+			upstream:   true,
+			downstream: true,
+			want:       []string{"a.yaml", "b.yaml", "c.yaml"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := newIndex(t).SelectStages(test.paths, test.upstream, test.downstream)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Fatalf("paths -want +got:\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("error on unknown stage", func(t *testing.T) {
+		_, err := newIndex(t).SelectStages([]string{"nope.yaml"}, false, true)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	// a.yaml --> b.yaml, with c.yaml ordered after b.yaml.
+	newStages := func() map[string]*stage.Stage {
+		return map[string]*stage.Stage{
+			"a.yaml": {
+				Outputs: map[string]*artifact.Artifact{
+					"a.bin": {Path: "a.bin"},
+				},
+			},
+			"b.yaml": {
+				Inputs: map[string]*artifact.Artifact{
+					"a.bin": {Path: "a.bin"},
+				},
+				Outputs: map[string]*artifact.Artifact{
+					"b.bin": {Path: "b.bin"},
+				},
+			},
+			"c.yaml": {
+				After: []string{"b.yaml"},
+				Outputs: map[string]*artifact.Artifact{
+					"c.bin": {Path: "c.bin"},
+				},
+			},
+		}
+	}
+
+	t.Run("after edges are in the DAG", func(t *testing.T) {
+		idx := newTestIndex(t, newStages())
+		if err := idx.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := idx.SelectStages([]string{"c.yaml"}, true, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]string{"a.yaml", "b.yaml", "c.yaml"}, got); diff != "" {
+			t.Fatalf("paths -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("error on unknown after reference", func(t *testing.T) {
+		stages := newStages()
+		stages["c.yaml"].After = []string{"nope.yaml"}
+		err := newTestIndex(t, stages).Validate()
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		wantErr := `c.yaml: after: unknown stage "nope.yaml"`
+		if diff := cmp.Diff(wantErr, err.Error()); diff != "" {
+			t.Fatalf("error -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("error on cycle through after", func(t *testing.T) {
+		stages := newStages()
+		stages["a.yaml"].After = []string{"c.yaml"}
+		err := newTestIndex(t, stages).Validate()
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		wantErr := "cycle detected: a.yaml -> b.yaml -> c.yaml -> a.yaml"
+		if diff := cmp.Diff(wantErr, err.Error()); diff != "" {
+			t.Fatalf("error -want +got:\n%s", diff)
+		}
+	})
+}
+
+func TestUpstreamOrder(t *testing.T) {
+	// a.yaml --> b.yaml --> c.yaml
+	newIndex := func(t *testing.T) Index {
+		return newTestIndex(t, map[string]*stage.Stage{
+			"a.yaml": {
+				Outputs: map[string]*artifact.Artifact{
+					"a.bin": {Path: "a.bin"},
+				},
+			},
+			"b.yaml": {
+				Inputs: map[string]*artifact.Artifact{
+					"a.bin": {Path: "a.bin"},
+				},
+				Outputs: map[string]*artifact.Artifact{
+					"b.bin": {Path: "b.bin"},
+				},
+			},
+			"c.yaml": {
+				Inputs: map[string]*artifact.Artifact{
+					"b.bin": {Path: "b.bin"},
+				},
+			},
+		})
+	}
+	none := func(string) bool { return false }
+
+	t.Run("done stages and their upstream stages are skipped", func(t *testing.T) {
+		idx := newIndex(t)
+		done := map[string]bool{"b.yaml": true}
+		got, err := idx.upstreamOrder("c.yaml", true, false, func(path string) bool {
+			return done[path]
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]string{"c.yaml"}, got); diff != "" {
+			t.Fatalf("upstreamOrder() -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("graph is rebuilt after adding a stage", func(t *testing.T) {
+		idx := newIndex(t)
+		if _, err := idx.upstreamOrder("c.yaml", true, false, none); err != nil {
+			t.Fatal(err)
+		}
+		stg := stage.Stage{
+			Inputs: map[string]*artifact.Artifact{
+				"c.bin": {Path: "c.bin"},
+			},
+		}
+		idx.stages["c.yaml"].Outputs = map[string]*artifact.Artifact{
+			"c.bin": {Path: "c.bin"},
+		}
+		idx.owners.insert("c.yaml", "c.bin", idx.stages["c.yaml"].Outputs["c.bin"])
+		if err := idx.AddStage(stg, "d.yaml"); err != nil {
+			t.Fatal(err)
+		}
+		got, err := idx.upstreamOrder("d.yaml", true, false, none)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"a.yaml", "b.yaml", "c.yaml", "d.yaml"}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("upstreamOrder() -want +got:\n%s", diff)
+		}
+
+		if err := idx.RemoveStage("d.yaml"); err != nil {
+			t.Fatal(err)
+		}
+		if idx.DAG().HasNode("d.yaml") {
+			t.Fatal("removed stage d.yaml still in the DAG")
+		}
+	})
+}
@@ -0,0 +1,210 @@
+package index
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/cache"
+)
+
+// An ArtifactDiff lists the changed files of one of a Stage's outputs.
+type ArtifactDiff struct {
+	Stage string `json:"stage"`
+	Path  string `json:"path"`
+	// Files is sorted by path.
+	Files []artifact.FileDiff `json:"files"`
+}
+
+// A DiffSummary totals the changes in a set of ArtifactDiffs.
+type DiffSummary struct {
+	Added       int `json:"added"`
+	Removed     int `json:"removed"`
+	Modified    int `json:"modified"`
+	TypeChanged int `json:"type-changed"`
+	// OldBytes and NewBytes are the total sizes of the changed files before and
+	// after the changes. Files of unknown size are not counted.
+	OldBytes int64 `json:"old-bytes"`
+	NewBytes int64 `json:"new-bytes"`
+}
+
+// diffSelection is an output selected for diffing.
+type diffSelection struct {
+	stagePath string
+	// filters are the paths to keep, or nil to keep all changes.
+	filters []string
+}
+
+// Diff compares the workspace with the committed outputs selected by targets.
+// Each target is either a Stage path, selecting all of the Stage's outputs, or
+// an Artifact path owned by a Stage, selecting only the files at or below that
+// path. If targets is empty, all Stages are selected. Outputs without changes
+// are omitted, and the result is sorted by Artifact path.
+func (idx Index) Diff(targets []string, ch cache.Cache, rootDir string) ([]ArtifactDiff, error) {
+	selected, err := selectDiffOutputs(targets, idx)
+	if err != nil {
+		return nil, err
+	}
+	diffs := make([]ArtifactDiff, 0, len(selected))
+	for artPath, sel := range selected {
+		_, art := idx.findOwner(artPath)
+		files, err := ch.Diff(rootDir, *art)
+		if err != nil {
+			return nil, err
+		}
+		diffs = appendDiff(diffs, artPath, sel, files)
+	}
+	sortDiffs(diffs)
+	return diffs, nil
+}
+
+// DiffIndexes compares the committed outputs of two versions of an Index,
+// such as the Index at two git revisions. Targets are interpreted as in
+// Index.Diff, and may refer to Stages or Artifacts in either Index. Outputs
+// only present in one Index are reported as added or removed. Any directory
+// manifests missing from the cache must be fetched beforehand (see
+// ManifestOutputs).
+func DiffIndexes(oldIdx, newIdx Index, targets []string, ch cache.Cache) ([]ArtifactDiff, error) {
+	selected, err := selectDiffOutputs(targets, oldIdx, newIdx)
+	if err != nil {
+		return nil, err
+	}
+	diffs := make([]ArtifactDiff, 0, len(selected))
+	for artPath, sel := range selected {
+		_, oldArt := oldIdx.findOwner(artPath)
+		_, newArt := newIdx.findOwner(artPath)
+		files, err := ch.DiffCommitted(oldArt, newArt)
+		if err != nil {
+			return nil, err
+		}
+		diffs = appendDiff(diffs, artPath, sel, files)
+	}
+	sortDiffs(diffs)
+	return diffs, nil
+}
+
+// ManifestOutputs returns the committed directory outputs of all Stages in the
+// Index, keyed by path. These are the Artifacts whose manifests DiffIndexes
+// needs.
+func (idx Index) ManifestOutputs() map[string]*artifact.Artifact {
+	arts := make(map[string]*artifact.Artifact)
+	for _, stg := range idx.stages {
+		for artPath, art := range stg.Outputs {
+			if art.IsDir && art.Checksum != "" {
+				arts[artPath] = art
+			}
+		}
+	}
+	return arts
+}
+
+// selectDiffOutputs maps targets to the outputs they select, keyed by output
+// path, in any of the given Indexes.
+func selectDiffOutputs(targets []string, indexes ...Index) (map[string]*diffSelection, error) {
+	if len(targets) == 0 {
+		for _, idx := range indexes {
+			targets = append(targets, idx.SortStagePaths()...)
+		}
+	}
+	selected := make(map[string]*diffSelection)
+	for _, target := range targets {
+		target = filepath.Clean(target)
+		found := false
+		for _, idx := range indexes {
+			if stg, ok := idx.stages[target]; ok {
+				found = true
+				for artPath := range stg.Outputs {
+					selected[artPath] = &diffSelection{stagePath: target}
+				}
+				continue
+			}
+			stagePath, art := idx.findOwner(target)
+			if stagePath == "" {
+				continue
+			}
+			found = true
+			sel, ok := selected[art.Path]
+			if !ok {
+				sel = &diffSelection{stagePath: stagePath, filters: []string{}}
+				selected[art.Path] = sel
+			}
+			if sel.filters != nil && target != art.Path {
+				sel.filters = append(sel.filters, target)
+			} else {
+				sel.filters = nil
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s is neither a stage nor an artifact owned by a stage", target)
+		}
+	}
+	return selected, nil
+}
+
+// appendDiff appends the changes to the output at artPath, filtered by sel,
+// unless there are none.
+func appendDiff(
+	diffs []ArtifactDiff,
+	artPath string,
+	sel *diffSelection,
+	files []artifact.FileDiff,
+) []ArtifactDiff {
+	if sel.filters != nil {
+		files = filterFileDiffs(files, sel.filters)
+	}
+	if len(files) == 0 {
+		return diffs
+	}
+	return append(diffs, ArtifactDiff{Stage: sel.stagePath, Path: artPath, Files: files})
+}
+
+func sortDiffs(diffs []ArtifactDiff) {
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Path < diffs[j].Path
+	})
+}
+
+// filterFileDiffs returns the FileDiffs at, below, or above (e.g. a removed
+// directory containing) any of the given paths.
+func filterFileDiffs(files []artifact.FileDiff, paths []string) []artifact.FileDiff {
+	isRelated := func(a, b string) bool {
+		return a == b || strings.HasPrefix(a, b+string(filepath.Separator))
+	}
+	out := []artifact.FileDiff{}
+	for _, file := range files {
+		for _, path := range paths {
+			if isRelated(file.Path, path) || isRelated(path, file.Path) {
+				out = append(out, file)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// SummarizeDiff totals the changes in the given ArtifactDiffs.
+func SummarizeDiff(diffs []ArtifactDiff) (summary DiffSummary) {
+	for _, artDiff := range diffs {
+		for _, file := range artDiff.Files {
+			switch file.State {
+			case "added":
+				summary.Added++
+			case "removed":
+				summary.Removed++
+			case "modified":
+				summary.Modified++
+			case "type-changed":
+				summary.TypeChanged++
+			}
+			if file.OldSize > 0 {
+				summary.OldBytes += file.OldSize
+			}
+			if file.NewSize > 0 {
+				summary.NewBytes += file.NewSize
+			}
+		}
+	}
+	return
+}
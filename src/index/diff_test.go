@@ -0,0 +1,151 @@
+package index
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/mocks"
+	"github.com/kevin-hanselman/dud/src/stage"
+)
+
+func TestDiff(t *testing.T) {
+	rootDir := "project/root"
+
+	newDiff := func(path, state string, oldSize, newSize int64) artifact.FileDiff {
+		return artifact.FileDiff{
+			FileChange: artifact.FileChange{Path: path, State: state},
+			OldSize:    oldSize,
+			NewSize:    newSize,
+		}
+	}
+	dataDiffs := []artifact.FileDiff{
+		newDiff("data/test.csv", "removed", 10, 0),
+		newDiff("data/train/a.csv", "modified", 20, 30),
+		newDiff("data/train/b.csv", "added", 0, 5),
+	}
+
+	newIndex := func() Index {
+		return newTestIndex(t, map[string]*stage.Stage{
+			"prep.yaml": {
+				Outputs: map[string]*artifact.Artifact{
+					"data":       {Path: "data", IsDir: true},
+					"report.txt": {Path: "report.txt"},
+				},
+			},
+		})
+	}
+
+	t.Run("stage target diffs all outputs", func(t *testing.T) {
+		idx := newIndex()
+		stg, _ := idx.Stage("prep.yaml")
+		mockCache := mocks.Cache{}
+		mockCache.On("Diff", rootDir, *stg.Outputs["data"]).Return(dataDiffs, nil).Once()
+		mockCache.On("Diff", rootDir, *stg.Outputs["report.txt"]).Return(
+			[]artifact.FileDiff{},
+			nil,
+		).Once()
+
+		diffs, err := idx.Diff(nil, &mockCache, rootDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mockCache.AssertExpectations(t)
+		want := []ArtifactDiff{{Stage: "prep.yaml", Path: "data", Files: dataDiffs}}
+		if diff := cmp.Diff(want, diffs); diff != "" {
+			t.Fatalf("Diff -want +got:\n%s", diff)
+		}
+
+		wantSummary := DiffSummary{Added: 1, Removed: 1, Modified: 1, OldBytes: 30, NewBytes: 35}
+		if diff := cmp.Diff(wantSummary, SummarizeDiff(diffs)); diff != "" {
+			t.Fatalf("SummarizeDiff -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("artifact target inside directory filters files", func(t *testing.T) {
+		idx := newIndex()
+		stg, _ := idx.Stage("prep.yaml")
+		mockCache := mocks.Cache{}
+		mockCache.On("Diff", rootDir, *stg.Outputs["data"]).Return(dataDiffs, nil).Once()
+
+		diffs, err := idx.Diff([]string{"data/train"}, &mockCache, rootDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mockCache.AssertExpectations(t)
+		want := []ArtifactDiff{{Stage: "prep.yaml", Path: "data", Files: dataDiffs[1:]}}
+		if diff := cmp.Diff(want, diffs); diff != "" {
+			t.Fatalf("Diff -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("error on unknown target", func(t *testing.T) {
+		idx := newIndex()
+		mockCache := mocks.Cache{}
+		if _, err := idx.Diff([]string{"other.txt"}, &mockCache, rootDir); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestDiffIndexes(t *testing.T) {
+	oldIdx := newTestIndex(t, map[string]*stage.Stage{
+		"prep.yaml": {
+			Outputs: map[string]*artifact.Artifact{
+				"data":    {Path: "data", IsDir: true, Checksum: "old_data"},
+				"old.txt": {Path: "old.txt", Checksum: "old_txt"},
+			},
+		},
+	})
+	newIdx := newTestIndex(t, map[string]*stage.Stage{
+		"prep.yaml": {
+			Outputs: map[string]*artifact.Artifact{
+				"data": {Path: "data", IsDir: true, Checksum: "new_data"},
+			},
+		},
+		"train.yaml": {
+			Outputs: map[string]*artifact.Artifact{
+				"model.bin": {Path: "model.bin", Checksum: "model"},
+			},
+		},
+	})
+	oldStage, _ := oldIdx.Stage("prep.yaml")
+	newPrep, _ := newIdx.Stage("prep.yaml")
+	newTrain, _ := newIdx.Stage("train.yaml")
+
+	dataDiffs := []artifact.FileDiff{
+		{FileChange: artifact.FileChange{Path: "data/a.csv", State: "modified"}, OldSize: 1, NewSize: 2},
+	}
+	oldDiffs := []artifact.FileDiff{
+		{FileChange: artifact.FileChange{Path: "old.txt", State: "removed"}, OldSize: 3},
+	}
+	modelDiffs := []artifact.FileDiff{
+		{FileChange: artifact.FileChange{Path: "model.bin", State: "added"}, NewSize: -1},
+	}
+
+	mockCache := mocks.Cache{}
+	var noArt *artifact.Artifact
+	mockCache.On("DiffCommitted", oldStage.Outputs["data"], newPrep.Outputs["data"]).
+		Return(dataDiffs, nil).Once()
+	mockCache.On("DiffCommitted", oldStage.Outputs["old.txt"], noArt).
+		Return(oldDiffs, nil).Once()
+	mockCache.On("DiffCommitted", noArt, newTrain.Outputs["model.bin"]).
+		Return(modelDiffs, nil).Once()
+
+	diffs, err := DiffIndexes(oldIdx, newIdx, nil, &mockCache)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockCache.AssertExpectations(t)
+	want := []ArtifactDiff{
+		{Stage: "prep.yaml", Path: "data", Files: dataDiffs},
+		{Stage: "train.yaml", Path: "model.bin", Files: modelDiffs},
+		{Stage: "prep.yaml", Path: "old.txt", Files: oldDiffs},
+	}
+	if diff := cmp.Diff(want, diffs); diff != "" {
+		t.Fatalf("DiffIndexes -want +got:\n%s", diff)
+	}
+}
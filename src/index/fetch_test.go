@@ -44,17 +44,16 @@ func TestFetch(t *testing.T) {
 				"bar.bin": {Path: "bar.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stgA,
 			"bar.yaml": &stgB,
-		}
+		})
 
 		mockCache := mocks.Cache{}
 
 		expectOutputsFetched(&stgA, &mockCache, rootDir, remote)
 
 		fetched := make(map[string]bool)
-		inProgress := make(map[string]bool)
 		if err := idx.Fetch(
 			"foo.yaml",
 			&mockCache,
@@ -62,7 +61,6 @@ func TestFetch(t *testing.T) {
 			true,
 			remote,
 			fetched,
-			inProgress,
 			logger,
 		); err != nil {
 			t.Fatal(err)
@@ -97,10 +95,10 @@ func TestFetch(t *testing.T) {
 				"bar.bin": {Path: "bar.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stgA,
 			"bar.yaml": &stgB,
-		}
+		})
 
 		mockCache := mocks.Cache{}
 
@@ -108,7 +106,6 @@ func TestFetch(t *testing.T) {
 		expectOutputsFetched(&stgB, &mockCache, rootDir, remote)
 
 		fetched := make(map[string]bool)
-		inProgress := make(map[string]bool)
 		if err := idx.Fetch(
 			"bar.yaml",
 			&mockCache,
@@ -116,7 +113,6 @@ func TestFetch(t *testing.T) {
 			true,
 			remote,
 			fetched,
-			inProgress,
 			logger,
 		); err != nil {
 			t.Fatal(err)
@@ -162,11 +158,11 @@ func TestFetch(t *testing.T) {
 				"bosh.bin": {Path: "bosh.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"bish.yaml": &stgA,
 			"bash.yaml": &stgB,
 			"bosh.yaml": &stgC,
-		}
+		})
 
 		mockCache := mocks.Cache{}
 
@@ -175,7 +171,6 @@ func TestFetch(t *testing.T) {
 		expectOutputsFetched(&stgC, &mockCache, rootDir, remote)
 
 		fetched := make(map[string]bool)
-		inProgress := make(map[string]bool)
 		if err := idx.Fetch(
 			"bosh.yaml",
 			&mockCache,
@@ -183,7 +178,6 @@ func TestFetch(t *testing.T) {
 			true,
 			remote,
 			fetched,
-			inProgress,
 			logger,
 		); err != nil {
 			t.Fatal(err)
@@ -234,12 +228,12 @@ func TestFetch(t *testing.T) {
 				"d.bin": {Path: "d.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"a.yaml": &stgA,
 			"b.yaml": &stgB,
 			"c.yaml": &stgC,
 			"d.yaml": &stgD,
-		}
+		})
 
 		mockCache := mocks.Cache{}
 		// Stage D is the only Stage that could possibly be checked out
@@ -248,7 +242,6 @@ func TestFetch(t *testing.T) {
 		expectOutputsFetched(&stgD, &mockCache, rootDir, remote)
 
 		fetched := make(map[string]bool)
-		inProgress := make(map[string]bool)
 		err := idx.Fetch(
 			"c.yaml",
 			&mockCache,
@@ -256,25 +249,20 @@ func TestFetch(t *testing.T) {
 			true,
 			remote,
 			fetched,
-			inProgress,
 			logger,
 		)
 		if err == nil {
 			t.Fatal("expected error")
 		}
 
-		expectedError := "cycle detected"
+		expectedError := "cycle detected: c.yaml -> a.yaml -> b.yaml -> c.yaml"
 		if diff := cmp.Diff(expectedError, err.Error()); diff != "" {
 			t.Fatalf("error -want +got:\n%s", diff)
 		}
 
-		expectedInProgress := map[string]bool{
-			"c.yaml": true,
-			"b.yaml": true,
-			"a.yaml": true,
-		}
-		if diff := cmp.Diff(expectedInProgress, inProgress); diff != "" {
-			t.Fatalf("inProgress -want +got:\n%s", diff)
+		// The cycle is found before any Stage is processed.
+		if len(fetched) != 0 {
+			t.Fatalf("expected no stages to be processed, got %v", fetched)
 		}
 	})
 
@@ -297,17 +285,16 @@ func TestFetch(t *testing.T) {
 				"bar.bin": {Path: "bar.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stgA,
 			"bar.yaml": &stgB,
-		}
+		})
 
 		mockCache := mocks.Cache{}
 
 		expectOutputsFetched(&stgB, &mockCache, rootDir, remote)
 
 		fetched := make(map[string]bool)
-		inProgress := make(map[string]bool)
 		if err := idx.Fetch(
 			"bar.yaml",
 			&mockCache,
@@ -315,7 +302,6 @@ func TestFetch(t *testing.T) {
 			false,
 			remote,
 			fetched,
-			inProgress,
 			logger,
 		); err != nil {
 			t.Fatal(err)
@@ -11,14 +11,14 @@ import (
 func TestFindOwner(t *testing.T) {
 	t.Run("single stage", func(t *testing.T) {
 		targetArt := artifact.Artifact{Path: "bar.bin"}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stage.Stage{
 				Outputs: map[string]*artifact.Artifact{
 					"foo.bin": {Path: "foo.bin"},
 					"bar.bin": &targetArt,
 				},
 			},
-		}
+		})
 
 		owner, foundArt := idx.findOwner("bar.bin")
 
@@ -32,14 +32,14 @@ func TestFindOwner(t *testing.T) {
 	})
 
 	t.Run("no owner", func(t *testing.T) {
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stage.Stage{
 				Outputs: map[string]*artifact.Artifact{
 					"foo.bin": {Path: "foo.bin"},
 					"bar.bin": {Path: "bar.bin"},
 				},
 			},
-		}
+		})
 
 		owner, _ := idx.findOwner("other.bin")
 
@@ -50,13 +50,13 @@ func TestFindOwner(t *testing.T) {
 
 	t.Run("file in dir artifact", func(t *testing.T) {
 		targetArt := artifact.Artifact{Path: "foo", IsDir: true, DisableRecursion: true}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stage.Stage{
 				Outputs: map[string]*artifact.Artifact{
 					"foo": &targetArt,
 				},
 			},
-		}
+		})
 
 		owner, foundArt := idx.findOwner("foo/bar.bin")
 
@@ -71,14 +71,14 @@ func TestFindOwner(t *testing.T) {
 
 	t.Run("working dir doesn't affect artifact paths", func(t *testing.T) {
 		targetArt := artifact.Artifact{Path: "bar.bin"}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stage.Stage{
 				WorkingDir: "foo",
 				Outputs: map[string]*artifact.Artifact{
 					"foo/bar.bin": &targetArt,
 				},
 			},
-		}
+		})
 
 		owner, foundArt := idx.findOwner("foo/bar.bin")
 
@@ -92,13 +92,13 @@ func TestFindOwner(t *testing.T) {
 	})
 
 	t.Run("file in sub-dir of non-recursive dir artifact", func(t *testing.T) {
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stage.Stage{
 				Outputs: map[string]*artifact.Artifact{
 					"foo": {Path: "foo", IsDir: true, DisableRecursion: true},
 				},
 			},
-		}
+		})
 
 		owner, _ := idx.findOwner("foo/bar/test.bin")
 
@@ -109,13 +109,13 @@ func TestFindOwner(t *testing.T) {
 
 	t.Run("file in sub-dir of recursive dir artifact", func(t *testing.T) {
 		targetArt := artifact.Artifact{Path: "foo", IsDir: true}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stage.Stage{
 				Outputs: map[string]*artifact.Artifact{
 					"foo": &targetArt,
 				},
 			},
-		}
+		})
 
 		owner, foundArt := idx.findOwner("foo/bar/test.bin")
 
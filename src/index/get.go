@@ -0,0 +1,63 @@
+package index
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/cache"
+	"github.com/kevin-hanselman/dud/src/strategy"
+	"github.com/pkg/errors"
+)
+
+// Get checks out the committed version of the Artifact at artPath to dest,
+// which may be any path outside the project. artPath may be a Stage output or
+// a path inside a directory output. If remote is not empty, the Artifact is
+// first fetched from the remote cache. Only the parts of a directory output
+// needed to resolve artPath are fetched.
+func (idx Index) Get(
+	artPath string,
+	ch cache.Cache,
+	remote string,
+	dest string,
+	strat strategy.CheckoutStrategy,
+) error {
+	errPrefix := fmt.Sprintf("get %s", artPath)
+	ownerPath, ownerArt := idx.findOwner(artPath)
+	if ownerPath == "" {
+		return fmt.Errorf("%s: not owned by any stage", errPrefix)
+	}
+	if ownerArt.Absent {
+		return fmt.Errorf("%s: committed as absent by stage %s", errPrefix, ownerPath)
+	}
+	if ownerArt.Checksum == "" {
+		return fmt.Errorf("%s: not committed by stage %s", errPrefix, ownerPath)
+	}
+
+	art := *ownerArt
+	if ownerArt.Path != artPath {
+		if remote != "" {
+			manifests := map[string]*artifact.Artifact{ownerArt.Path: ownerArt}
+			if err := ch.FetchManifests(remote, manifests); err != nil {
+				return errors.Wrap(err, errPrefix)
+			}
+		}
+		var err error
+		art, err = ch.ResolveChild(*ownerArt, artPath)
+		if err != nil {
+			return errors.Wrap(err, errPrefix)
+		}
+	}
+	if remote != "" {
+		if err := ch.Fetch(remote, map[string]*artifact.Artifact{art.Path: &art}); err != nil {
+			return errors.Wrap(err, errPrefix)
+		}
+	}
+
+	dest, err := filepath.Abs(dest)
+	if err != nil {
+		return errors.Wrap(err, errPrefix)
+	}
+	art.Path = filepath.Base(dest)
+	return errors.Wrap(ch.Checkout(filepath.Dir(dest), art, strat, nil), errPrefix)
+}
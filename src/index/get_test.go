@@ -0,0 +1,95 @@
+package index
+
+import (
+	"testing"
+
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/mocks"
+	"github.com/kevin-hanselman/dud/src/stage"
+	"github.com/kevin-hanselman/dud/src/strategy"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestGet(t *testing.T) {
+	remote := "remote:bucket"
+	strat := strategy.CopyStrategy
+
+	newIndex := func() Index {
+		return newTestIndex(t, map[string]*stage.Stage{
+			"prep.yaml": {
+				Outputs: map[string]*artifact.Artifact{
+					"data":      {Path: "data", IsDir: true, Checksum: "data_checksum"},
+					"model.bin": {Path: "model.bin", Checksum: "model_checksum"},
+					"empty.txt": {Path: "empty.txt"},
+				},
+			},
+		})
+	}
+
+	t.Run("output", func(t *testing.T) {
+		idx := newIndex()
+		mockCache := mocks.Cache{}
+		art := artifact.Artifact{Path: "model.bin", Checksum: "model_checksum"}
+		mockCache.On("Fetch", remote, map[string]*artifact.Artifact{"model.bin": &art}).
+			Return(nil).Once()
+		dest := art
+		dest.Path = "old_model.bin"
+		mockCache.On("Checkout", "/tmp/models", dest, strat, mock.Anything).Return(nil).Once()
+
+		err := idx.Get("model.bin", &mockCache, remote, "/tmp/models/old_model.bin", strat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("file inside directory output fetches only what it needs", func(t *testing.T) {
+		idx := newIndex()
+		stg, _ := idx.Stage("prep.yaml")
+		dirArt := stg.Outputs["data"]
+		mockCache := mocks.Cache{}
+		mockCache.On("FetchManifests", remote, map[string]*artifact.Artifact{"data": dirArt}).
+			Return(nil).Once()
+		child := artifact.Artifact{Path: "data/train.csv", Checksum: "train_checksum"}
+		mockCache.On("ResolveChild", *dirArt, "data/train.csv").Return(child, nil).Once()
+		mockCache.On("Fetch", remote, map[string]*artifact.Artifact{"data/train.csv": &child}).
+			Return(nil).Once()
+		dest := child
+		dest.Path = "train.csv"
+		mockCache.On("Checkout", "/tmp", dest, strat, mock.Anything).Return(nil).Once()
+
+		err := idx.Get("data/train.csv", &mockCache, remote, "/tmp/train.csv", strat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("no fetch without a remote", func(t *testing.T) {
+		idx := newIndex()
+		mockCache := mocks.Cache{}
+		dest := artifact.Artifact{Path: "model.bin", Checksum: "model_checksum"}
+		mockCache.On("Checkout", "/tmp", dest, strat, mock.Anything).Return(nil).Once()
+
+		if err := idx.Get("model.bin", &mockCache, "", "/tmp/model.bin", strat); err != nil {
+			t.Fatal(err)
+		}
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("error on uncommitted output", func(t *testing.T) {
+		idx := newIndex()
+		mockCache := mocks.Cache{}
+		if err := idx.Get("empty.txt", &mockCache, remote, "/tmp/empty.txt", strat); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("error on unowned artifact", func(t *testing.T) {
+		idx := newIndex()
+		mockCache := mocks.Cache{}
+		if err := idx.Get("other.txt", &mockCache, remote, "/tmp/other.txt", strat); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
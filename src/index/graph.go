@@ -28,30 +28,13 @@ var stageTemplate string = `<
 // Graph creates a dependency graph starting from the given Stage.
 func (idx Index) Graph(
 	stagePath string,
-	inProgress map[string]bool,
 	graph *gographviz.Escape,
 	onlyStages bool,
 ) error {
-	// A subgraph MUST start with "cluster" for its "label" attribute to be displayed.
-	// Intuitive, I know.
-	// See: https://stackoverflow.com/a/7586857/857893
-	stageSubgraphName := "cluster_" + stagePath
-	if graph.IsNode(stagePath) {
-		return nil
-	}
-
-	// If we've visited this Stage but haven't recorded its status (the check
-	// above), then we're in a cycle.
-	if inProgress[stagePath] {
-		return errors.New("cycle detected")
-	}
-	inProgress[stagePath] = true
-
-	stg, ok := idx[stagePath]
-	if !ok {
-		return unknownStageError{stagePath}
+	order, err := idx.upstreamOrder(stagePath, true, true, graph.IsNode)
+	if err != nil {
+		return err
 	}
-
 	// Ensure the graph is directed, and disallow multiple edges between the same nodes.
 	if err := graph.SetDir(true); err != nil {
 		return errors.Wrapf(err, "graph %s", stagePath)
@@ -69,6 +52,26 @@ func (idx Index) Graph(
 	if err := graph.AddAttr(graph.Name, "compound", "true"); err != nil {
 		return errors.Wrapf(err, "graph %s", stagePath)
 	}
+	for _, path := range order {
+		// Skip Stages already drawn by an earlier call.
+		if graph.IsNode(path) {
+			continue
+		}
+		if err := idx.graphStage(path, graph, onlyStages); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// graphStage draws a single Stage and the edges to its dependencies.
+func (idx Index) graphStage(stagePath string, graph *gographviz.Escape, onlyStages bool) error {
+	// A subgraph MUST start with "cluster" for its "label" attribute to be displayed.
+	// Intuitive, I know.
+	// See: https://stackoverflow.com/a/7586857/857893
+	stageSubgraphName := "cluster_" + stagePath
+	stg := idx.stages[stagePath]
+
 	for artPath := range stg.Inputs {
 		ownerPath, _ := idx.findOwner(artPath)
 		hasOwner := ownerPath != ""
@@ -97,10 +100,16 @@ func (idx Index) Graph(
 				return err
 			}
 		}
-		if hasOwner {
-			if err := idx.Graph(ownerPath, inProgress, graph, onlyStages); err != nil {
-				return err
-			}
+	}
+	// Order-only dependencies are drawn as dashed edges between Stages.
+	for _, afterPath := range stg.After {
+		attrs := map[string]string{"style": "dashed"}
+		if !onlyStages {
+			attrs["ltail"] = stageSubgraphName
+			attrs["lhead"] = "cluster_" + afterPath
+		}
+		if err := graph.AddEdge(stagePath, afterPath, true, attrs); err != nil {
+			return err
 		}
 	}
 	if onlyStages {
@@ -133,6 +142,5 @@ func (idx Index) Graph(
 			return err
 		}
 	}
-	delete(inProgress, stagePath)
 	return nil
 }
@@ -60,15 +60,14 @@ func TestGraph(t *testing.T) {
 				"bar.bin": {Path: "bar.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stgA,
 			"bar.yaml": &stgB,
-		}
+		})
 
 		t.Run("only stages", func(t *testing.T) {
-			inProgress := make(map[string]bool)
 			graph := gographviz.NewEscape()
-			err := idx.Graph("foo.yaml", inProgress, graph, true)
+			err := idx.Graph("foo.yaml", graph, true)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -84,9 +83,8 @@ func TestGraph(t *testing.T) {
 		})
 
 		t.Run("full graph", func(t *testing.T) {
-			inProgress := make(map[string]bool)
 			graph := gographviz.NewEscape()
-			err := idx.Graph("foo.yaml", inProgress, graph, false)
+			err := idx.Graph("foo.yaml", graph, false)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -121,15 +119,14 @@ func TestGraph(t *testing.T) {
 				"bar.bin": {Path: "bar.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stgA,
 			"bar.yaml": &stgB,
-		}
+		})
 
 		t.Run("only stages", func(t *testing.T) {
-			inProgress := make(map[string]bool)
 			graph := gographviz.NewEscape()
-			err := idx.Graph("foo.yaml", inProgress, graph, true)
+			err := idx.Graph("foo.yaml", graph, true)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -147,9 +144,8 @@ func TestGraph(t *testing.T) {
 		})
 
 		t.Run("full graph", func(t *testing.T) {
-			inProgress := make(map[string]bool)
 			graph := gographviz.NewEscape()
-			err := idx.Graph("foo.yaml", inProgress, graph, false)
+			err := idx.Graph("foo.yaml", graph, false)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -196,16 +192,15 @@ func TestGraph(t *testing.T) {
 				"c.bin": {Path: "c.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"a.yaml": &stgA,
 			"b.yaml": &stgB,
 			"c.yaml": &stgC,
-		}
+		})
 
 		t.Run("only stages", func(t *testing.T) {
-			inProgress := make(map[string]bool)
 			graph := gographviz.NewEscape()
-			err := idx.Graph("c.yaml", inProgress, graph, true)
+			err := idx.Graph("c.yaml", graph, true)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -226,9 +221,8 @@ func TestGraph(t *testing.T) {
 		})
 
 		t.Run("full graph", func(t *testing.T) {
-			inProgress := make(map[string]bool)
 			graph := gographviz.NewEscape()
-			err := idx.Graph("c.yaml", inProgress, graph, false)
+			err := idx.Graph("c.yaml", graph, false)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -288,40 +282,94 @@ func TestGraph(t *testing.T) {
 				"d.bin": {Path: "d.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"a.yaml": &stgA,
 			"b.yaml": &stgB,
 			"c.yaml": &stgC,
 			"d.yaml": &stgD,
-		}
+		})
 
 		onlyStages := true
 
 		test := func(t *testing.T) {
-			inProgress := make(map[string]bool)
 			graph := gographviz.NewEscape()
-			err := idx.Graph("c.yaml", inProgress, graph, onlyStages)
+			err := idx.Graph("c.yaml", graph, onlyStages)
 			if err == nil {
 				t.Fatal("expected error")
 			}
 
-			expectedError := "cycle detected"
+			expectedError := "cycle detected: c.yaml -> a.yaml -> b.yaml -> c.yaml"
 			if diff := cmp.Diff(expectedError, err.Error()); diff != "" {
 				t.Fatalf("error -want +got:\n%s", diff)
 			}
-
-			expectedInProgress := map[string]bool{
-				"c.yaml": true,
-				"b.yaml": true,
-				"a.yaml": true,
-			}
-			if diff := cmp.Diff(expectedInProgress, inProgress); diff != "" {
-				t.Fatalf("inProgress -want +got:\n%s", diff)
-			}
 		}
 		t.Run("only stages", test)
 
 		onlyStages = false
 		t.Run("full graph", test)
 	})
+
+	t.Run("order-only dependencies", func(t *testing.T) {
+		stgA := stage.Stage{
+			After: []string{"bar.yaml"},
+			Outputs: map[string]*artifact.Artifact{
+				"foo.bin": {Path: "foo.bin"},
+			},
+		}
+		stgB := stage.Stage{
+			Outputs: map[string]*artifact.Artifact{
+				"bar.bin": {Path: "bar.bin"},
+			},
+		}
+		idx := newTestIndex(t, map[string]*stage.Stage{
+			"foo.yaml": &stgA,
+			"bar.yaml": &stgB,
+		})
+
+		t.Run("only stages", func(t *testing.T) {
+			graph := gographviz.NewEscape()
+			err := idx.Graph("foo.yaml", graph, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expectedGraph := gographviz.NewEscape()
+			check(t, expectedGraph.SetDir(true))
+			check(t, expectedGraph.SetStrict(true))
+			check(t, expectedGraph.Attrs.Add("compound", "true"))
+			check(t, expectedGraph.Attrs.Add("rankdir", "LR"))
+			check(t, expectedGraph.AddNode("", "bar.yaml", nil))
+			check(t, expectedGraph.AddNode("", "foo.yaml", nil))
+			check(t, expectedGraph.AddEdge("foo.yaml", "bar.yaml", true, map[string]string{"style": "dashed"}))
+
+			assertGraphsEqual(expectedGraph, graph, t)
+		})
+
+		t.Run("full graph", func(t *testing.T) {
+			graph := gographviz.NewEscape()
+			err := idx.Graph("foo.yaml", graph, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expectedGraph := gographviz.NewEscape()
+			check(t, expectedGraph.SetDir(true))
+			check(t, expectedGraph.SetStrict(true))
+			check(t, expectedGraph.Attrs.Add("compound", "true"))
+			check(t, expectedGraph.Attrs.Add("rankdir", "LR"))
+			check(t, expectedGraph.AddSubGraph("", "cluster_foo.yaml", nil))
+			check(t, expectedGraph.AddNode("cluster_foo.yaml", "foo.yaml", hiddenAttr))
+			check(t, expectedGraph.AddSubGraph("", "cluster_bar.yaml", nil))
+			check(t, expectedGraph.AddNode("cluster_bar.yaml", "bar.yaml", hiddenAttr))
+			check(t, expectedGraph.AddNode("cluster_foo.yaml", "foo.bin", nil))
+			check(t, expectedGraph.AddNode("cluster_bar.yaml", "bar.bin", nil))
+			check(t, expectedGraph.AddEdge("foo.yaml", "bar.yaml", true, map[string]string{
+				"ltail": "cluster_foo.yaml",
+				"lhead": "cluster_bar.yaml",
+				"style": "dashed",
+			}))
+
+			assertGraphsEqual(expectedGraph, graph, t)
+		})
+	})
 }
@@ -2,19 +2,32 @@ package index
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"sort"
 	"strings"
 
 	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/cache"
+	"github.com/kevin-hanselman/dud/src/gitutil"
 	"github.com/kevin-hanselman/dud/src/stage"
 	"github.com/pkg/errors"
 )
 
 // An Index holds an exhaustive set of Stages for a repository.
 // Not threadsafe.
-type Index map[string]*stage.Stage
+type Index struct {
+	stages map[string]*stage.Stage
+	// owners is a prefix tree of all Stage outputs, used to answer ownership
+	// queries without scanning every Stage.
+	owners *ownerNode
+	// graphs caches the dependency graphs of the Index, which are costly to
+	// build for large Indexes. It is cleared whenever a Stage is added or
+	// removed.
+	graphs *graphCache
+}
 
 type unknownStageError struct {
 	stagePath string
@@ -24,14 +37,31 @@ func (e unknownStageError) Error() string {
 	return fmt.Sprintf("unknown stage %#v", e.stagePath)
 }
 
+// New returns an empty Index.
+func New() Index {
+	return Index{
+		stages: make(map[string]*stage.Stage),
+		owners: new(ownerNode),
+		graphs: new(graphCache),
+	}
+}
+
 // AddStage adds the given Stage to the Index, with the given path as the key.
 func (idx *Index) AddStage(stg stage.Stage, path string) error {
-	if _, ok := (*idx)[path]; ok {
+	return idx.addStage(&stg, path)
+}
+
+func (idx *Index) addStage(stg *stage.Stage, path string) error {
+	if idx.stages == nil {
+		*idx = New()
+	}
+	if _, ok := idx.stages[path]; ok {
 		return fmt.Errorf("stage %s already in index", path)
 	}
-	for artPath := range stg.Outputs {
-		ownerPath, _ := idx.findOwner(artPath)
-		if ownerPath != "" {
+	// Check for conflicts in both directions: the new outputs must not be
+	// owned by existing outputs, and vice versa.
+	for artPath, art := range stg.Outputs {
+		if ownerPath, _ := idx.owners.find(artPath); ownerPath != "" {
 			return fmt.Errorf(
 				"%s: artifact %s already owned by %s",
 				path,
@@ -39,19 +69,46 @@ func (idx *Index) AddStage(stg stage.Stage, path string) error {
 				ownerPath,
 			)
 		}
+		if ownedPath, ownedArt := idx.owners.firstOwnedBy(artPath, art); ownedPath != "" {
+			return fmt.Errorf(
+				"%s: artifact %s conflicts with artifact %s owned by %s",
+				path,
+				artPath,
+				ownedArt.Path,
+				ownedPath,
+			)
+		}
+	}
+	idx.stages[path] = stg
+	for artPath, art := range stg.Outputs {
+		idx.owners.insert(path, artPath, art)
 	}
-	(*idx)[path] = &stg
+	idx.graphs.clear()
 	return nil
 }
 
+// RemoveStage removes the Stage with the given path from the Index.
 func (idx *Index) RemoveStage(path string) error {
-	if _, ok := (*idx)[path]; !ok {
+	if _, ok := idx.stages[path]; !ok {
 		return unknownStageError{path}
 	}
-	delete(*idx, path)
+	delete(idx.stages, path)
+	idx.owners.remove(path)
+	idx.graphs.clear()
 	return nil
 }
 
+// Stage returns the Stage with the given path, and whether it is in the Index.
+func (idx Index) Stage(path string) (*stage.Stage, bool) {
+	stg, ok := idx.stages[path]
+	return stg, ok
+}
+
+// Len returns the number of Stages in the Index.
+func (idx Index) Len() int {
+	return len(idx.stages)
+}
+
 // ToFile writes the Index to the specified file path.
 // To prevent the Index from going stale, Stages themselves aren't written to
 // the Index file; the Index only tracks their paths.
@@ -78,7 +135,7 @@ func (idx Index) ToFile(indexPath string) error {
 // SortStagePaths returns a sorted slice of Stage paths stored in the Index.
 func (idx Index) SortStagePaths() []string {
 	paths := []string{}
-	for stagePath := range idx {
+	for stagePath := range idx.stages {
 		paths = append(paths, stagePath)
 	}
 	sort.Strings(paths)
@@ -90,42 +147,136 @@ func (idx Index) SortStagePaths() []string {
 // TODO no tests
 func FromFile(path string) (Index, error) {
 	errPrefix := fmt.Sprintf("load index from %s", path)
-	var idx Index
 	file, err := os.Open(path)
 	if err != nil {
-		return idx, errors.Wrap(err, errPrefix)
+		return New(), errors.Wrap(err, errPrefix)
 	}
 	defer file.Close()
-	scanner := bufio.NewScanner(file)
-	idx = make(Index)
+	idx, err := fromReader(file, stage.FromFile)
+	return idx, errors.Wrap(err, errPrefix)
+}
+
+// FromRevision reads and returns the Index at indexPath as of the git revision
+// rev. The Stages in the Index are also read as of rev. rootDir is the project
+// root, and indexPath is relative to it.
+func FromRevision(rootDir, rev, indexPath string) (Index, error) {
+	errPrefix := fmt.Sprintf("load index from %s at revision %s", indexPath, rev)
+	if !gitutil.Exists(rootDir, rev, indexPath) {
+		return New(), fmt.Errorf("%s: index not found", errPrefix)
+	}
+	data, err := gitutil.Show(rootDir, rev, indexPath)
+	if err != nil {
+		return New(), errors.Wrap(err, errPrefix)
+	}
+	idx, err := fromReader(bytes.NewReader(data), func(stagePath string) (stage.Stage, error) {
+		data, err := gitutil.Show(rootDir, rev, stagePath)
+		if err != nil {
+			return stage.Stage{}, err
+		}
+		return stage.FromBytes(stagePath, data)
+	})
+	return idx, errors.Wrap(err, errPrefix)
+}
+
+// fromReader reads an Index from reader, using loadStage to load each Stage.
+func fromReader(reader io.Reader, loadStage func(string) (stage.Stage, error)) (Index, error) {
+	idx := New()
+	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 		if line == "" {
 			continue
 		}
-		stg, err := stage.FromFile(line)
+		stg, err := loadStage(line)
 		if err != nil {
-			return idx, errors.Wrap(err, errPrefix)
+			return idx, err
 		}
 		if err := idx.AddStage(stg, line); err != nil {
-			return idx, errors.Wrap(err, errPrefix)
+			return idx, err
 		}
 	}
 	if err := scanner.Err(); err != nil {
-		return idx, errors.Wrap(err, errPrefix)
+		return idx, err
 	}
-	return idx, nil
+	return idx, idx.Validate()
 }
 
-func (idx Index) findOwner(artPath string) (string, *artifact.Artifact) {
-	for stagePath, stg := range idx {
-		if art, ok := stg.Outputs[artPath]; ok {
-			return stagePath, art
+// ResolveTargets maps each target to a Stage path. Targets that are Stage
+// paths in the Index are returned as-is, and all other targets are treated as
+// Artifact paths and replaced by the path of the Stage that owns them.
+// Duplicate Stages are removed, but order is otherwise preserved.
+func (idx Index) ResolveTargets(targets []string) ([]string, error) {
+	seen := make(map[string]bool, len(targets))
+	stagePaths := make([]string, 0, len(targets))
+	for _, target := range targets {
+		stagePath := target
+		if _, ok := idx.stages[target]; !ok {
+			stagePath, _ = idx.findOwner(target)
+			if stagePath == "" {
+				return nil, fmt.Errorf(
+					"%s is neither a stage nor an artifact owned by a stage",
+					target,
+				)
+			}
+		}
+		if !seen[stagePath] {
+			seen[stagePath] = true
+			stagePaths = append(stagePaths, stagePath)
+		}
+	}
+	return stagePaths, nil
+}
+
+// OrphanInputs returns the inputs of the given Stages that aren't owned by
+// any Stage in the Index, mapped to the sorted paths of the given Stages that
+// declare them.
+func (idx Index) OrphanInputs(stagePaths []string) map[string][]string {
+	orphans := make(map[string][]string)
+	for _, stagePath := range stagePaths {
+		stg, ok := idx.stages[stagePath]
+		if !ok {
+			continue
 		}
-		art, ok := stage.FindDirArtifactOwnerForPath(artPath, stg.Outputs)
-		if ok {
-			return stagePath, art
+		for artPath := range stg.Inputs {
+			if ownerPath, _ := idx.findOwner(artPath); ownerPath == "" {
+				orphans[artPath] = append(orphans[artPath], stagePath)
+			}
 		}
 	}
-	return "", nil
+	for _, consumers := range orphans {
+		sort.Strings(consumers)
+	}
+	return orphans
+}
+
+// findOwner returns the path of the Stage that owns the given Artifact path,
+// along with the owning output Artifact. If no Stage owns the path, findOwner
+// returns an empty string and a nil Artifact.
+func (idx Index) findOwner(artPath string) (string, *artifact.Artifact) {
+	if idx.owners == nil {
+		return "", nil
+	}
+	return idx.owners.find(artPath)
+}
+
+// upstreamChecksum returns the checksum that the input at artPath should
+// record, given ownerArt, the output Artifact of the Stage that owns artPath.
+// If artPath is the owner's output itself, this is simply the owner's
+// checksum. If artPath lies inside a directory output, the checksum is
+// resolved through the directory's committed manifest, so the input tracks
+// only the contents of artPath rather than the entire directory. An empty
+// checksum is returned if the owner has not been committed.
+func upstreamChecksum(
+	ch cache.Cache,
+	artPath string,
+	ownerArt *artifact.Artifact,
+) (string, error) {
+	if ownerArt.Path == artPath || ownerArt.Checksum == "" {
+		return ownerArt.Checksum, nil
+	}
+	child, err := ch.ResolveChild(*ownerArt, artPath)
+	if err != nil {
+		return "", err
+	}
+	return child.Checksum, nil
 }
@@ -3,31 +3,48 @@ package index
 import (
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
+
 	"github.com/kevin-hanselman/dud/src/artifact"
 	"github.com/kevin-hanselman/dud/src/stage"
 )
 
+// newTestIndex returns an Index holding the given Stages. Unlike AddStage,
+// the Stage pointers are stored as-is, so tests can inspect the Stages after
+// acting on the Index.
+func newTestIndex(t *testing.T, stages map[string]*stage.Stage) Index {
+	idx := New()
+	for path, stg := range stages {
+		if err := idx.addStage(stg, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return idx
+}
+
 func TestAdd(t *testing.T) {
 	t.Run("add new stage", func(t *testing.T) {
-		idx := make(Index)
+		idx := New()
 		path := "foo/bar.dud"
 
 		if err := idx.AddStage(stage.Stage{}, path); err != nil {
 			t.Fatal(err)
 		}
 
-		_, added := idx[path]
+		_, added := idx.Stage(path)
 		if !added {
 			t.Fatal("path wasn't added to the index")
 		}
 	})
 
 	t.Run("error if already tracked", func(t *testing.T) {
-		idx := make(Index)
+		idx := New()
 		path := "foo/bar.dud"
 
 		var stg stage.Stage
-		idx[path] = &stg
+		if err := idx.AddStage(stg, path); err != nil {
+			t.Fatal(err)
+		}
 
 		if err := idx.AddStage(stg, path); err == nil {
 			t.Fatal("expected error")
@@ -40,14 +57,14 @@ func TestAdd(t *testing.T) {
 				"subDir/foo.bin": {Path: "subDir/foo.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stage.Stage{
 				WorkingDir: "subDir",
 				Outputs: map[string]*artifact.Artifact{
 					"subDir/foo.bin": {Path: "subDir/foo.bin"},
 				},
 			},
-		}
+		})
 		err := idx.AddStage(stg, "bar.yaml")
 		if err == nil {
 			t.Fatal("expected error")
@@ -64,17 +81,180 @@ func TestAdd(t *testing.T) {
 				"subDir/foo.bin": {Path: "subDir/foo.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stage.Stage{
 				WorkingDir: "subDir",
 				Outputs: map[string]*artifact.Artifact{
 					"foo.bin": {Path: "foo.bin"},
 				},
 			},
+		})
+		err := idx.AddStage(stg, "bar.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("error if new directory output owns existing outputs", func(t *testing.T) {
+		idx := newTestIndex(t, map[string]*stage.Stage{
+			"foo.yaml": {
+				Outputs: map[string]*artifact.Artifact{
+					"data/foo/bar.bin": {Path: "data/foo/bar.bin"},
+				},
+			},
+		})
+		stg := stage.Stage{
+			Outputs: map[string]*artifact.Artifact{
+				"data": {Path: "data", IsDir: true},
+			},
 		}
 		err := idx.AddStage(stg, "bar.yaml")
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		expectedError := "bar.yaml: artifact data conflicts with artifact data/foo/bar.bin owned by foo.yaml"
+		if err.Error() != expectedError {
+			t.Fatalf("\nerror want: %s\nerror got: %s", expectedError, err.Error())
+		}
+	})
+
+	t.Run("error if new output is inside existing directory output", func(t *testing.T) {
+		idx := newTestIndex(t, map[string]*stage.Stage{
+			"foo.yaml": {
+				Outputs: map[string]*artifact.Artifact{
+					"data": {Path: "data", IsDir: true},
+				},
+			},
+		})
+		stg := stage.Stage{
+			Outputs: map[string]*artifact.Artifact{
+				"data/foo/bar.bin": {Path: "data/foo/bar.bin"},
+			},
+		}
+		err := idx.AddStage(stg, "bar.yaml")
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		expectedError := "bar.yaml: artifact data/foo/bar.bin already owned by foo.yaml"
+		if err.Error() != expectedError {
+			t.Fatalf("\nerror want: %s\nerror got: %s", expectedError, err.Error())
+		}
+	})
+
+	t.Run("non-recursive directory outputs only conflict with children", func(t *testing.T) {
+		idx := newTestIndex(t, map[string]*stage.Stage{
+			"foo.yaml": {
+				Outputs: map[string]*artifact.Artifact{
+					"data/foo/bar.bin": {Path: "data/foo/bar.bin"},
+				},
+			},
+		})
+		stg := stage.Stage{
+			Outputs: map[string]*artifact.Artifact{
+				"data": {Path: "data", IsDir: true, DisableRecursion: true},
+			},
+		}
+		if err := idx.AddStage(stg, "bar.yaml"); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestRemove(t *testing.T) {
+	t.Run("removed stage no longer owns outputs", func(t *testing.T) {
+		idx := newTestIndex(t, map[string]*stage.Stage{
+			"foo.yaml": {
+				Outputs: map[string]*artifact.Artifact{
+					"data": {Path: "data", IsDir: true},
+				},
+			},
+		})
+		if err := idx.RemoveStage("foo.yaml"); err != nil {
+			t.Fatal(err)
+		}
+		if owner, _ := idx.findOwner("data/bar.bin"); owner != "" {
+			t.Fatalf("got owner = %#v, want empty string", owner)
+		}
+		stg := stage.Stage{
+			Outputs: map[string]*artifact.Artifact{
+				"data/bar.bin": {Path: "data/bar.bin"},
+			},
+		}
+		if err := idx.AddStage(stg, "bar.yaml"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("error if unknown stage", func(t *testing.T) {
+		idx := New()
+		if err := idx.RemoveStage("foo.yaml"); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestResolveTargets(t *testing.T) {
+	idx := newTestIndex(t, map[string]*stage.Stage{
+		"foo.yaml": {
+			Outputs: map[string]*artifact.Artifact{
+				"models": {Path: "models", IsDir: true},
+			},
+		},
+		"bar.yaml": {
+			Outputs: map[string]*artifact.Artifact{
+				"bar.bin": {Path: "bar.bin"},
+			},
+		},
+	})
+
+	t.Run("stages and artifacts", func(t *testing.T) {
+		got, err := idx.ResolveTargets([]string{"bar.bin", "models/model.pkl", "foo.yaml"})
 		if err != nil {
 			t.Fatal(err)
 		}
+		want := []string{"bar.yaml", "foo.yaml"}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("stage paths -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("error on unowned artifact", func(t *testing.T) {
+		_, err := idx.ResolveTargets([]string{"other.bin"})
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		expectedError := "other.bin is neither a stage nor an artifact owned by a stage"
+		if err.Error() != expectedError {
+			t.Fatalf("\nerror want: %s\nerror got: %s", expectedError, err.Error())
+		}
+	})
+}
+
+func TestOrphanInputs(t *testing.T) {
+	idx := newTestIndex(t, map[string]*stage.Stage{
+		"foo.yaml": {
+			Inputs: map[string]*artifact.Artifact{
+				"raw.csv": {Path: "raw.csv"},
+			},
+			Outputs: map[string]*artifact.Artifact{
+				"data": {Path: "data", IsDir: true},
+			},
+		},
+		"bar.yaml": {
+			Inputs: map[string]*artifact.Artifact{
+				"raw.csv":        {Path: "raw.csv"},
+				"data/train.csv": {Path: "data/train.csv"},
+				"params.yaml":    {Path: "params.yaml"},
+			},
+		},
 	})
+
+	got := idx.OrphanInputs([]string{"foo.yaml", "bar.yaml", "unknown.yaml"})
+	want := map[string][]string{
+		"raw.csv":     {"bar.yaml", "foo.yaml"},
+		"params.yaml": {"bar.yaml"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("orphan inputs -want +got:\n%s", diff)
+	}
 }
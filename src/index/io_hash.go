@@ -4,52 +4,37 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
-	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
 	"sort"
+	"strings"
 
-	"github.com/kevin-hanselman/dud/src/artifact"
-	"github.com/kevin-hanselman/dud/src/checksum"
 	"github.com/spf13/viper"
 )
 
+// OutputSet maps the paths of a Stage's outputs to their checksums.
+type OutputSet map[string]string
 
-type OutputSet map[string]string // output_path -> checksum
-
+// IoHashTable maps Stage keys (see CalcStageKey) to the outputs produced by
+// running a Stage with said key. It backs the run cache.
 type IoHashTable map[string]OutputSet
 
-
-func CalcStageKey(inputs map[string]*artifact.Artifact, command, workdir string, rootDir string) string {
-	var sums []string
-	for _, art := range inputs {
-		sum := art.Checksum
-		if sum == "" && art.Path != "" {
-			// Compute checksum from the file's contents if not already set
-			filePath := art.Path
-			if !strings.HasPrefix(filePath, "/") {
-				filePath = filepath.Join(rootDir, filePath)
-			}
-			if f, err := os.Open(filePath); err == nil {
-				if got, err := checksum.Checksum(f); err == nil {
-					sum = got
-				}
-				f.Close()
-			}
-		}
-		fmt.Printf("[DEBUG] input artifact path: %s, checksum: %s\n", art.Path, sum)
-		sums = append(sums, sum)
-	}
+// CalcStageKey returns the run cache key for a Stage with the given input
+// checksums, command, and working directory. The order of inputChecksums is
+// not significant.
+func CalcStageKey(inputChecksums []string, command, workDir string) string {
+	sums := make([]string, len(inputChecksums))
+	copy(sums, inputChecksums)
 	sort.Strings(sums)
 	h := sha256.New()
 	for _, sum := range sums {
 		h.Write([]byte(sum))
 	}
 	h.Write([]byte(command))
-	h.Write([]byte(workdir))
+	h.Write([]byte(workDir))
 	return hex.EncodeToString(h.Sum(nil))
 }
+
 func getRunCacheDir(rootDir string) string {
 	runCacheDir := viper.GetString("run_cache")
 	if runCacheDir == "" {
@@ -61,14 +46,16 @@ func getRunCacheDir(rootDir string) string {
 			runCacheDir = filepath.Join(home, runCacheDir[2:])
 		}
 	}
-	return filepath.Join(runCacheDir)
+	if !filepath.IsAbs(runCacheDir) {
+		runCacheDir = filepath.Join(rootDir, runCacheDir)
+	}
+	return runCacheDir
 }
 
+// LoadIoHashTable reads the run cache's IoHashTable. If the table doesn't
+// exist yet, an empty table is returned.
 func LoadIoHashTable(rootDir string) (IoHashTable, error) {
-	runCacheDir := getRunCacheDir(rootDir)
-	os.MkdirAll(runCacheDir, 0o755)
-	tablePath := filepath.Join(runCacheDir, "io-hash-table")
-	// fmt.Printf("[DEBUG] loading hash table from: %s\n", tablePath)
+	tablePath := filepath.Join(getRunCacheDir(rootDir), "io-hash-table")
 	f, err := os.Open(tablePath)
 	if os.IsNotExist(err) {
 		return make(IoHashTable), nil
@@ -84,31 +71,24 @@ func LoadIoHashTable(rootDir string) (IoHashTable, error) {
 	return table, nil
 }
 
+// SaveIoHashTable writes the run cache's IoHashTable.
 func SaveIoHashTable(table IoHashTable, rootDir string) error {
 	runCacheDir := getRunCacheDir(rootDir)
-	os.MkdirAll(runCacheDir, 0o755)
-	tablePath := filepath.Join(runCacheDir, "io-hash-table")
-	fmt.Printf("[DEBUG] Saving I/O hash table to: %s\n", tablePath)
-	f, err := os.Create(tablePath)
+	if err := os.MkdirAll(runCacheDir, 0o755); err != nil {
+		return err
+	}
+	// TODO: If we stop relying on the project-wide lock file, this should be
+	// flocked.
+	f, err := os.Create(filepath.Join(runCacheDir, "io-hash-table"))
 	if err != nil {
-		fmt.Printf("[DEBUG] Error creating io-hash-table: %v\n", err)
 		return err
 	}
 	defer f.Close()
 	enc := json.NewEncoder(f)
 	enc.SetIndent("", "  ")
-	if err := enc.Encode(table); err != nil {
-		fmt.Printf("[DEBUG] Error encoding io-hash-table: %v\n", err)
-		return err
-	}
-	return nil
+	return enc.Encode(table)
 }
 
-
-// IoHashTablePath is the default location for the IO hash table
-// const IoHashTablePath = ".dud/io-hash-table"
-
-
 // ComputeHashFromChecksums returns the SHA256 hash for a sorted slice of checksums.
 func ComputeHashFromChecksums(checksums []string) string {
 	sort.Strings(checksums)
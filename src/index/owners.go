@@ -0,0 +1,137 @@
+package index
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kevin-hanselman/dud/src/artifact"
+)
+
+// ownerNode is a node in a prefix tree of Stage output paths. Each node
+// represents one path component. A node with a non-nil art marks an output
+// declared by the Stage at stagePath.
+type ownerNode struct {
+	children  map[string]*ownerNode
+	stagePath string
+	art       *artifact.Artifact
+}
+
+func splitPath(path string) []string {
+	return strings.Split(filepath.Clean(path), string(filepath.Separator))
+}
+
+// owns returns true if the output at node, which is depth path components
+// above some path, owns said path. Directory outputs own everything inside
+// them unless recursion is disabled, in which case they only own their
+// immediate children.
+func (node *ownerNode) owns(depth int) bool {
+	if node.art == nil {
+		return false
+	}
+	if depth == 0 {
+		return true
+	}
+	return node.art.IsDir && (!node.art.DisableRecursion || depth == 1)
+}
+
+// find returns the Stage path and output Artifact that own artPath. If no
+// output owns artPath, find returns an empty string and a nil Artifact.
+// Ancestor directories are checked from the top down, matching
+// stage.FindDirArtifactOwnerForPath.
+func (node *ownerNode) find(artPath string) (string, *artifact.Artifact) {
+	parts := splitPath(artPath)
+	for i, part := range parts {
+		if node = node.children[part]; node == nil {
+			return "", nil
+		}
+		if node.owns(len(parts) - i - 1) {
+			return node.stagePath, node.art
+		}
+	}
+	return "", nil
+}
+
+// descendants calls fn for each output strictly below node, in sorted order.
+// depth is the number of path components between the output and node.
+func (node *ownerNode) descendants(depth int, fn func(*ownerNode, int) bool) bool {
+	names := make([]string, 0, len(node.children))
+	for name := range node.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		child := node.children[name]
+		if child.art != nil && !fn(child, depth+1) {
+			return false
+		}
+		if !child.descendants(depth+1, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// firstOwnedBy returns the first existing output that a new output art at
+// artPath would own, along with the path of the Stage that declares it. If art
+// would not own any existing outputs, firstOwnedBy returns an empty string and
+// a nil Artifact.
+func (node *ownerNode) firstOwnedBy(
+	artPath string,
+	art *artifact.Artifact,
+) (string, *artifact.Artifact) {
+	if !art.IsDir {
+		return "", nil
+	}
+	for _, part := range splitPath(artPath) {
+		if node = node.children[part]; node == nil {
+			return "", nil
+		}
+	}
+	newOutput := ownerNode{art: art}
+	var (
+		stagePath string
+		found     *artifact.Artifact
+	)
+	node.descendants(0, func(desc *ownerNode, depth int) bool {
+		if newOutput.owns(depth) {
+			stagePath, found = desc.stagePath, desc.art
+			return false
+		}
+		return true
+	})
+	return stagePath, found
+}
+
+// insert records that the Stage at stagePath declares the output art at
+// artPath.
+func (node *ownerNode) insert(stagePath, artPath string, art *artifact.Artifact) {
+	for _, part := range splitPath(artPath) {
+		child, ok := node.children[part]
+		if !ok {
+			child = new(ownerNode)
+			if node.children == nil {
+				node.children = make(map[string]*ownerNode)
+			}
+			node.children[part] = child
+		}
+		node = child
+	}
+	node.stagePath = stagePath
+	node.art = art
+}
+
+// remove deletes all outputs declared by the Stage at stagePath, pruning any
+// nodes left empty. It returns true if node itself is left empty.
+func (node *ownerNode) remove(stagePath string) bool {
+	for name, child := range node.children {
+		if child.remove(stagePath) {
+			delete(node.children, name)
+		}
+	}
+	if node.stagePath == stagePath {
+		node.stagePath = ""
+		node.art = nil
+	}
+	return node.art == nil && len(node.children) == 0
+}
@@ -3,10 +3,10 @@ package index
 import (
 	"github.com/kevin-hanselman/dud/src/agglog"
 	"github.com/kevin-hanselman/dud/src/cache"
-	"github.com/pkg/errors"
 )
 
-// Push uploads a Stage's Outputs and the Outputs of any upstream Stages.
+// Push uploads a Stage's Outputs and, if recursive, the Outputs of all
+// upstream Stages.
 func (idx Index) Push(
 	stagePath string,
 	ch cache.Cache,
@@ -14,47 +14,23 @@ func (idx Index) Push(
 	recursive bool,
 	remote string,
 	pushed map[string]bool,
-	inProgress map[string]bool,
 	logger *agglog.AggLogger,
 ) error {
-	if pushed[stagePath] {
-		return nil
-	}
-
-	if inProgress[stagePath] {
-		return errors.New("cycle detected")
-	}
-	inProgress[stagePath] = true
-
-	stg, ok := idx[stagePath]
-	if !ok {
-		return unknownStageError{stagePath}
+	order, err := idx.upstreamOrder(stagePath, recursive, false, func(path string) bool {
+		return pushed[path]
+	})
+	if err != nil {
+		return err
 	}
-
-	for artPath := range stg.Inputs {
-		ownerPath, _ := idx.findOwner(artPath)
-		if ownerPath == "" {
+	for _, path := range order {
+		if pushed[path] {
 			continue
-		} else if recursive {
-			if err := idx.Push(
-				ownerPath,
-				ch,
-				rootDir,
-				recursive,
-				remote,
-				pushed,
-				inProgress,
-				logger,
-			); err != nil {
-				return err
-			}
 		}
+		logger.Info.Printf("pushing stage %s\n", path)
+		if err := ch.Push(remote, idx.stages[path].Outputs); err != nil {
+			return err
+		}
+		pushed[path] = true
 	}
-	logger.Info.Printf("pushing stage %s\n", stagePath)
-	if err := ch.Push(remote, stg.Outputs); err != nil {
-		return err
-	}
-	pushed[stagePath] = true
-	delete(inProgress, stagePath)
 	return nil
 }
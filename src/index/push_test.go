@@ -42,17 +42,16 @@ func TestPush(t *testing.T) {
 				"bar.bin": {Path: "bar.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stgA,
 			"bar.yaml": &stgB,
-		}
+		})
 
 		mockCache := mocks.Cache{}
 
 		expectOutputsPushed(&stgA, &mockCache, rootDir, remote)
 
 		pushed := make(map[string]bool)
-		inProgress := make(map[string]bool)
 		if err := idx.Push(
 			"foo.yaml",
 			&mockCache,
@@ -60,7 +59,6 @@ func TestPush(t *testing.T) {
 			true,
 			remote,
 			pushed,
-			inProgress,
 			logger,
 		); err != nil {
 			t.Fatal(err)
@@ -95,10 +93,10 @@ func TestPush(t *testing.T) {
 				"bar.bin": {Path: "bar.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stgA,
 			"bar.yaml": &stgB,
-		}
+		})
 
 		mockCache := mocks.Cache{}
 
@@ -106,7 +104,6 @@ func TestPush(t *testing.T) {
 		expectOutputsPushed(&stgB, &mockCache, rootDir, remote)
 
 		pushed := make(map[string]bool)
-		inProgress := make(map[string]bool)
 		if err := idx.Push(
 			"bar.yaml",
 			&mockCache,
@@ -114,7 +111,6 @@ func TestPush(t *testing.T) {
 			true,
 			remote,
 			pushed,
-			inProgress,
 			logger,
 		); err != nil {
 			t.Fatal(err)
@@ -160,11 +156,11 @@ func TestPush(t *testing.T) {
 				"bosh.bin": {Path: "bosh.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"bish.yaml": &stgA,
 			"bash.yaml": &stgB,
 			"bosh.yaml": &stgC,
-		}
+		})
 
 		mockCache := mocks.Cache{}
 
@@ -173,7 +169,6 @@ func TestPush(t *testing.T) {
 		expectOutputsPushed(&stgC, &mockCache, rootDir, remote)
 
 		pushed := make(map[string]bool)
-		inProgress := make(map[string]bool)
 		if err := idx.Push(
 			"bosh.yaml",
 			&mockCache,
@@ -181,7 +176,6 @@ func TestPush(t *testing.T) {
 			true,
 			remote,
 			pushed,
-			inProgress,
 			logger,
 		); err != nil {
 			t.Fatal(err)
@@ -232,12 +226,12 @@ func TestPush(t *testing.T) {
 				"d.bin": {Path: "d.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"a.yaml": &stgA,
 			"b.yaml": &stgB,
 			"c.yaml": &stgC,
 			"d.yaml": &stgD,
-		}
+		})
 
 		mockCache := mocks.Cache{}
 		// Stage D is the only Stage that could possibly be checked out
@@ -246,7 +240,6 @@ func TestPush(t *testing.T) {
 		expectOutputsPushed(&stgD, &mockCache, rootDir, remote)
 
 		pushed := make(map[string]bool)
-		inProgress := make(map[string]bool)
 		err := idx.Push(
 			"c.yaml",
 			&mockCache,
@@ -254,25 +247,20 @@ func TestPush(t *testing.T) {
 			true,
 			remote,
 			pushed,
-			inProgress,
 			logger,
 		)
 		if err == nil {
 			t.Fatal("expected error")
 		}
 
-		expectedError := "cycle detected"
+		expectedError := "cycle detected: c.yaml -> a.yaml -> b.yaml -> c.yaml"
 		if diff := cmp.Diff(expectedError, err.Error()); diff != "" {
 			t.Fatalf("error -want +got:\n%s", diff)
 		}
 
-		expectedInProgress := map[string]bool{
-			"c.yaml": true,
-			"b.yaml": true,
-			"a.yaml": true,
-		}
-		if diff := cmp.Diff(expectedInProgress, inProgress); diff != "" {
-			t.Fatalf("inProgress -want +got:\n%s", diff)
+		// The cycle is found before any Stage is processed.
+		if len(pushed) != 0 {
+			t.Fatalf("expected no stages to be processed, got %v", pushed)
 		}
 	})
 
@@ -295,17 +283,16 @@ func TestPush(t *testing.T) {
 				"bar.bin": {Path: "bar.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stgA,
 			"bar.yaml": &stgB,
-		}
+		})
 
 		mockCache := mocks.Cache{}
 
 		expectOutputsPushed(&stgB, &mockCache, rootDir, remote)
 
 		pushed := make(map[string]bool)
-		inProgress := make(map[string]bool)
 		if err := idx.Push(
 			"bar.yaml",
 			&mockCache,
@@ -313,7 +300,6 @@ func TestPush(t *testing.T) {
 			false,
 			remote,
 			pushed,
-			inProgress,
 			logger,
 		); err != nil {
 			t.Fatal(err)
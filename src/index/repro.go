@@ -0,0 +1,207 @@
+package index
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/cache"
+	"github.com/kevin-hanselman/dud/src/strategy"
+	"github.com/pkg/errors"
+)
+
+// ReproResult describes whether a Stage output was reproduced by rerunning
+// its Stage.
+type ReproResult struct {
+	// Path is the output's path relative to the project root.
+	Path string
+	// RecordedChecksum is the checksum recorded in the Stage file.
+	RecordedChecksum string
+	// NewChecksum is the checksum of the rerun's output. It is empty if the
+	// rerun didn't produce the output.
+	NewChecksum string
+	// Added, Removed, and Modified list the files that differ between the
+	// recorded and rerun versions of a directory output.
+	Added, Removed, Modified []string
+}
+
+// Reproduced returns true if the rerun output is identical to the recorded
+// output.
+func (res ReproResult) Reproduced() bool {
+	return res.NewChecksum == res.RecordedChecksum
+}
+
+// ReproCheck reruns a Stage in a scratch copy of the workspace and compares
+// the resulting outputs against the checksums recorded in the Stage file.
+// Inputs owned by other Stages are checked out from the cache at their
+// recorded checksums; all other inputs are taken from the workspace, and must
+// match their recorded checksums. The rerun's outputs are checksummed with a
+// temporary cache, so the workspace, the Stage, and ch are left untouched. The
+// returned ReproResults are sorted by path.
+func (idx Index) ReproCheck(
+	stagePath string,
+	ch cache.Cache,
+	rootDir string,
+	logger *agglog.AggLogger,
+) ([]ReproResult, error) {
+	stg, ok := idx.stages[stagePath]
+	if !ok {
+		return nil, unknownStageError{stagePath}
+	}
+	errPrefix := fmt.Sprintf("repro-check %s", stagePath)
+	for artPath, art := range stg.Outputs {
+		if art.Checksum == "" && !art.Absent {
+			return nil, errors.Errorf("%s: output %s has no recorded checksum", errPrefix, artPath)
+		}
+	}
+
+	tempDir, err := os.MkdirTemp(filepath.Join(rootDir, ".dud"), "repro-")
+	if err != nil {
+		return nil, errors.Wrap(err, errPrefix)
+	}
+	defer os.RemoveAll(tempDir)
+	// The rerun's outputs are committed to a cache of their own, which is
+	// thrown away along with the scratch workspace.
+	scratchDir := filepath.Join(tempDir, "workspace")
+	scratchCache, err := cache.NewLocalCache(filepath.Join(tempDir, "cache"))
+	if err != nil {
+		return nil, errors.Wrap(err, errPrefix)
+	}
+
+	for artPath, art := range stg.Inputs {
+		if err := idx.stageReproInput(artPath, art, ch, rootDir, scratchDir); err != nil {
+			return nil, errors.Wrapf(err, "%s: input %s", errPrefix, artPath)
+		}
+	}
+	for artPath := range stg.Outputs {
+		parent := filepath.Join(scratchDir, filepath.Dir(artPath))
+		if err := os.MkdirAll(parent, 0o755); err != nil {
+			return nil, errors.Wrap(err, errPrefix)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(scratchDir, stg.WorkingDir), 0o755); err != nil {
+		return nil, errors.Wrap(err, errPrefix)
+	}
+
+	cmd := stg.CreateCommand()
+	cmd.Dir = filepath.Join(scratchDir, cmd.Dir)
+	logger.Debug.Printf("(in %s) %s\n", cmd.Dir, stg.Command)
+	if err := runCommand(cmd); err != nil {
+		return nil, errors.Wrapf(err, "%s: command failed", errPrefix)
+	}
+
+	results := make([]ReproResult, 0, len(stg.Outputs))
+	for artPath, art := range stg.Outputs {
+		res, err := reproOutput(art, ch, scratchCache, scratchDir, logger)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: output %s", errPrefix, artPath)
+		}
+		results = append(results, res)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Path < results[j].Path
+	})
+	return results, nil
+}
+
+// stageReproInput places a Stage input in scratchDir at its recorded
+// checksum.
+func (idx Index) stageReproInput(
+	artPath string,
+	art *artifact.Artifact,
+	ch cache.Cache,
+	rootDir string,
+	scratchDir string,
+) error {
+	if art.Checksum == "" {
+		return errors.New("no recorded checksum")
+	}
+	scratchPath := filepath.Join(scratchDir, artPath)
+	if err := os.MkdirAll(filepath.Dir(scratchPath), 0o755); err != nil {
+		return err
+	}
+
+	ownerPath, ownerArt := idx.findOwner(artPath)
+	if ownerPath == "" {
+		// Inputs not owned by a Stage are never cached, so the workspace copy
+		// is the only option.
+		input := *art
+		input.SkipCache = true
+		status, err := ch.Status(rootDir, input, true)
+		if err != nil {
+			return err
+		}
+		if !status.ContentsMatch {
+			return errors.New("workspace contents don't match the recorded checksum")
+		}
+		workspacePath, err := filepath.Abs(filepath.Join(rootDir, artPath))
+		if err != nil {
+			return err
+		}
+		return os.Symlink(workspacePath, scratchPath)
+	}
+
+	input := artifact.Artifact{Path: artPath, Checksum: art.Checksum, IsDir: art.IsDir}
+	if ownerArt.Path == artPath {
+		input.IsDir = ownerArt.IsDir
+	} else if child, err := ch.ResolveChild(*ownerArt, artPath); err == nil {
+		input.IsDir = child.IsDir
+	}
+	return ch.Checkout(scratchDir, input, strategy.CopyStrategy, nil)
+}
+
+// reproOutput commits an output produced in scratchDir to scratchCache and
+// compares it with the recorded output art, which is committed to ch.
+func reproOutput(
+	art *artifact.Artifact,
+	ch, scratchCache cache.Cache,
+	scratchDir string,
+	logger *agglog.AggLogger,
+) (res ReproResult, err error) {
+	res.Path = art.Path
+	res.RecordedChecksum = art.Checksum
+	if _, err := os.Lstat(filepath.Join(scratchDir, art.Path)); os.IsNotExist(err) {
+		return res, nil
+	} else if err != nil {
+		return res, err
+	}
+
+	newArt := *art
+	newArt.Checksum = ""
+	if err := scratchCache.Commit(scratchDir, &newArt, strategy.CopyStrategy, logger); err != nil {
+		return res, err
+	}
+	res.NewChecksum = newArt.Checksum
+	if res.Reproduced() || !art.IsDir || art.SkipCache || art.Absent {
+		return res, nil
+	}
+
+	oldFiles, err := ch.DirFiles(*art)
+	if err != nil {
+		return res, err
+	}
+	newFiles, err := scratchCache.DirFiles(newArt)
+	if err != nil {
+		return res, err
+	}
+	for path, oldFile := range oldFiles {
+		newFile, ok := newFiles[path]
+		if !ok {
+			res.Removed = append(res.Removed, path)
+		} else if newFile.Checksum != oldFile.Checksum {
+			res.Modified = append(res.Modified, path)
+		}
+	}
+	for path := range newFiles {
+		if _, ok := oldFiles[path]; !ok {
+			res.Added = append(res.Added, path)
+		}
+	}
+	sort.Strings(res.Added)
+	sort.Strings(res.Removed)
+	sort.Strings(res.Modified)
+	return res, nil
+}
@@ -0,0 +1,148 @@
+package index
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/cache"
+	"github.com/kevin-hanselman/dud/src/stage"
+	"github.com/kevin-hanselman/dud/src/strategy"
+)
+
+func TestReproCheckIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	rootDir := t.TempDir()
+	ch, err := cache.NewLocalCache(filepath.Join(rootDir, ".dud", "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rootDir, "params.txt"), []byte("3"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	idx := newTestIndex(t, map[string]*stage.Stage{
+		"gen.yaml": {
+			Command: "mkdir -p data && echo 1 > data/a.txt && echo 2 > data/b.txt",
+			Outputs: map[string]*artifact.Artifact{
+				"data": {Path: "data", IsDir: true},
+			},
+		},
+		"train.yaml": {
+			Command: "mkdir -p out && cat data/a.txt params.txt > out/model.txt && " +
+				"echo $$ > out/stamp.txt",
+			Inputs: map[string]*artifact.Artifact{
+				"data/a.txt": {Path: "data/a.txt"},
+				"params.txt": {Path: "params.txt"},
+			},
+			Outputs: map[string]*artifact.Artifact{
+				"out": {Path: "out", IsDir: true},
+			},
+		},
+	})
+	// Populate the workspace as if the Stages had been run, then commit.
+	for path, contents := range map[string]string{
+		"data/a.txt":    "1\n",
+		"data/b.txt":    "2\n",
+		"out/model.txt": "1\n3",
+		"out/stamp.txt": "0\n",
+	} {
+		path = filepath.Join(rootDir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	committed := make(map[string]bool)
+	err = idx.Commit("train.yaml", ch, rootDir, strategy.CopyStrategy, committed, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("deterministic stage reproduces", func(t *testing.T) {
+		results, err := idx.ReproCheck("gen.yaml", ch, rootDir, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || !results[0].Reproduced() {
+			t.Fatalf("expected output to be reproduced, got %#v", results)
+		}
+	})
+
+	t.Run("nondeterministic files are reported", func(t *testing.T) {
+		// Inputs owned by other Stages come from the cache, not the
+		// workspace.
+		if err := os.RemoveAll(filepath.Join(rootDir, "data")); err != nil {
+			t.Fatal(err)
+		}
+		cacheFiles := func() (files []string) {
+			cacheDir := filepath.Join(rootDir, ".dud", "cache")
+			err := filepath.WalkDir(cacheDir, func(path string, d fs.DirEntry, err error) error {
+				if err == nil && !d.IsDir() {
+					files = append(files, path)
+				}
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+		cachedBefore := cacheFiles()
+		results, err := idx.ReproCheck("train.yaml", ch, rootDir, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The rerun's outputs aren't committed to the project's cache.
+		if diff := cmp.Diff(cachedBefore, cacheFiles()); diff != "" {
+			t.Fatalf("cache files -before +after:\n%s", diff)
+		}
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result, got %#v", results)
+		}
+		res := results[0]
+		if res.Reproduced() {
+			t.Fatal("expected output not to be reproduced")
+		}
+		if diff := cmp.Diff([]string{"out/stamp.txt"}, res.Modified); diff != "" {
+			t.Fatalf("modified -want +got:\n%s", diff)
+		}
+		if len(res.Added) > 0 || len(res.Removed) > 0 {
+			t.Fatalf("unexpected added/removed files: %#v", res)
+		}
+	})
+
+	t.Run("error on modified workspace input", func(t *testing.T) {
+		if err := os.WriteFile(filepath.Join(rootDir, "params.txt"), []byte("4"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := idx.ReproCheck("train.yaml", ch, rootDir, logger); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("error on uncommitted output", func(t *testing.T) {
+		uncommitted := newTestIndex(t, map[string]*stage.Stage{
+			"foo.yaml": {
+				Command: "echo foo > foo.txt",
+				Outputs: map[string]*artifact.Artifact{
+					"foo.txt": {Path: "foo.txt"},
+				},
+			},
+		})
+		if _, err := uncommitted.ReproCheck("foo.yaml", ch, rootDir, logger); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
@@ -1,15 +1,16 @@
 package index
 
 import (
-	"os/exec"
+	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
-	"fmt"
-	
-	"github.com/kevin-hanselman/dud/src/checksum"
 
 	"github.com/kevin-hanselman/dud/src/agglog"
 	"github.com/kevin-hanselman/dud/src/cache"
+	"github.com/kevin-hanselman/dud/src/checksum"
+	"github.com/kevin-hanselman/dud/src/hook"
+	"github.com/kevin-hanselman/dud/src/stage"
 	"github.com/kevin-hanselman/dud/src/strategy"
 	"github.com/pkg/errors"
 )
@@ -19,52 +20,74 @@ var runCommand = func(cmd *exec.Cmd) error {
 	return cmd.Run()
 }
 
-// Run runs a Stage and all upstream Stages.
+// RunOptions configures Index.Run.
+type RunOptions struct {
+	// Recursive enables running upstream Stages.
+	Recursive bool
+	// Force holds the paths of Stages to run even if they are up-to-date.
+	// Forced Stages never restore their outputs from the run cache.
+	Force map[string]bool
+	// Sandbox enables running commands in a temporary directory tree that
+	// only contains each Stage's declared inputs.
+	Sandbox bool
+	// CheckWrites enables reporting files that commands create or modify
+	// outside of their Stage's declared outputs.
+	CheckWrites bool
+	// FailOnUndeclaredWrites turns the reports of CheckWrites into errors.
+	FailOnUndeclaredWrites bool
+	// Hooks holds the "pre-stage" and "post-stage" hooks to run around each
+	// Stage command. A failing pre-stage hook prevents the command from
+	// running.
+	Hooks hook.Hooks
+}
+
+// Run runs a Stage and, if opts.Recursive is set, all upstream Stages.
+// Always-run Stages run every time, and frozen Stages never run, even if they
+// are out-of-date.
 func (idx Index) Run(
 	stagePath string,
 	ch cache.Cache,
 	rootDir string,
-	recursive bool,
+	opts RunOptions,
 	ran map[string]bool,
-	inProgress map[string]bool,
 	logger *agglog.AggLogger,
 ) error {
-	if _, ok := ran[stagePath]; ok {
-		return nil
-	}
-
-    fmt.Printf("Running stage: %s\n", stagePath)
-	if inProgress[stagePath] {
-		fmt.Printf("cycle detected at stage %s\n", stagePath)
-		return errors.New("cycle detected")
+	// Stages listed in 'after' must run first, but they don't affect whether
+	// a Stage needs to run.
+	order, err := idx.upstreamOrder(stagePath, opts.Recursive, true, func(path string) bool {
+		_, ok := ran[path]
+		return ok
+	})
+	if err != nil {
+		return err
 	}
-	inProgress[stagePath] = true
-
-	stg, ok := idx[stagePath]
-	if !ok {
-		return unknownStageError{stagePath}
+	// Calls sharing ran belong to the same invocation, which only needs to
+	// write the dependency file once.
+	if len(ran) == 0 {
+		idx.writeStageDeps(rootDir, logger)
 	}
-
-	// At the end of your run function:
-	depFile, err := os.Create(filepath.Join(rootDir, ".dud", "stage_deps.txt"))
-	if err != nil {
-		logger.Error.Printf("failed to create dep file: %v\n", err)
-	} else {
-		for stagePath, stg := range idx {
-			var deps []string
-			for inp := range stg.Inputs {
-				if owner, _ := idx.findOwner(inp); owner != "" {
-					deps = append(deps, owner)
-				}
-			}
-			fmt.Fprintf(depFile, "%s:", stagePath)
-			for _, dep := range deps {
-				fmt.Fprintf(depFile, " %s", dep)
-			}
-			fmt.Fprintln(depFile)
+	for _, path := range order {
+		if _, ok := ran[path]; ok {
+			continue
+		}
+		if err := idx.runIfNeeded(path, ch, rootDir, opts, ran, logger); err != nil {
+			return err
 		}
-		depFile.Close()
 	}
+	return nil
+}
+
+// runIfNeeded runs a single Stage if it is out-of-date, and records in ran
+// whether it ran. Upstream Stages should be run first.
+func (idx Index) runIfNeeded(
+	stagePath string,
+	ch cache.Cache,
+	rootDir string,
+	opts RunOptions,
+	ran map[string]bool,
+	logger *agglog.AggLogger,
+) error {
+	stg := idx.stages[stagePath]
 
 	hasCommand := stg.Command != ""
 	checksumUpToDate := false
@@ -91,9 +114,24 @@ func (idx Index) Run(
 		doRun = true
 		runReason = "definition modified"
 	}
+	// Run if any environment dependency changed. Probes may be slow, so
+	// they're only run once here, and the results are reused to run and
+	// commit the Stage.
+	envProbes, err := stg.ProbeEnv(rootDir)
+	if err != nil {
+		return errors.Wrapf(err, "stage %s", stagePath)
+	}
+	for _, probeCmd := range stg.EnvDeps {
+		status := stage.EnvDepStatus{Recorded: stg.EnvState[probeCmd], Current: envProbes[probeCmd]}
+		if !status.Matches() {
+			doRun = true
+			runReason = "env-deps changed"
+			logger.Info.Printf("stage %s: env-dep %q %s\n", stagePath, probeCmd, status)
+		}
+	}
 	// Always check all upstream stages.
 	for artPath, art := range stg.Inputs {
-		ownerPath, _ := idx.findOwner(artPath)
+		ownerPath, ownerArt := idx.findOwner(artPath)
 		if ownerPath == "" {
 			artStatus, err := ch.Status(rootDir, *art, true)
 			if err != nil {
@@ -103,49 +141,40 @@ func (idx Index) Run(
 				doRun = true
 				runReason = "input out-of-date"
 			}
-		} else if recursive {
-			if err := idx.Run(ownerPath, ch, rootDir, recursive, ran, inProgress, logger); err != nil {
-				return err
+		} else if opts.Recursive {
+			if !ran[ownerPath] {
+				continue
 			}
-			if ran[ownerPath] {
+			// An upstream Stage with a command is committed after it runs, so
+			// its checksums are current and we only need to run if the
+			// contents of this particular input changed. An upstream Stage
+			// without a command is out-of-date, but its checksums are stale,
+			// so we must assume the input changed.
+			inputModified := true
+			if idx.stages[ownerPath].Command != "" {
+				upstreamSum, err := upstreamChecksum(ch, artPath, ownerArt)
+				if err != nil {
+					return err
+				}
+				inputModified = art.Checksum == "" || art.Checksum != upstreamSum
+			}
+			if inputModified {
 				doRun = true
 				runReason = "upstream stage out-of-date"
 			}
 		}
 	}
-		
-
-	// --- Dud Stage Run Caching ---
-	// Before running, check cache for this stage+inputs+command
-	stageKey := CalcStageKey(stg.Inputs, stg.Command, stg.WorkingDir, rootDir)
-    fmt.Printf("Calculated stage key: %s\n", stageKey)
-
-	table, _ := LoadIoHashTable(rootDir)
-
-	if outSet, ok := table[stageKey]; ok {
-		logger.Info.Printf("cache hit: restoring outputs for stage %s from local cache\n", stagePath)
 
-		for path, checksum := range outSet {
-			art, exists := stg.Outputs[path]
-			if !exists {
-				logger.Error.Printf("output %s not found in stage definition", path)
-				continue
-			}
-			art.Checksum = checksum
-			if err := ch.Checkout(rootDir, *art, strategy.LinkStrategy, nil); err != nil {
-				logger.Error.Printf("failed to check out %s from cache: %v", art.Path, err)
-				return err
-			}
-		}
-
-		ran[stagePath] = true
-		delete(inProgress, stagePath)
-		return nil
+	if stg.AlwaysRun {
+		doRun = true
+		runReason = "always-run"
 	}
 
-
-	// --- End Dud Stage Run Caching ---
-
+	forced := opts.Force[stagePath]
+	if forced {
+		doRun = true
+		runReason = "forced"
+	}
 
 	if !doRun {
 		for _, art := range stg.Outputs {
@@ -160,52 +189,14 @@ func (idx Index) Run(
 			}
 		}
 	}
-	if doRun {
+	if doRun && stg.Frozen {
+		logger.Info.Printf("skipping frozen stage %s (%s)\n", stagePath, runReason)
+		doRun = false
+	} else if doRun {
 		if hasCommand {
-			logger.Info.Printf("running stage %s (%s)\n", stagePath, runReason)
-			logger.Info.Printf("trying to cache")
-			cmd := stg.CreateCommand()
-			// Avoid cmd.Command here because it will include "sh -c ...".
-			logger.Debug.Printf("(in %s) %s\n", cmd.Dir, stg.Command)
-			if err := runCommand(cmd); err != nil {
-				fmt.Printf("command: %s\n", stg.Command)
-				fmt.Printf("command failed: %v\n", err)
-				os.Exit(1)
-			}
-			logger.Info.Printf("after runCommand")
-
-			strat := strategy.LinkStrategy
-			committed := make(map[string]bool)
-			inProgressCommit := make(map[string]bool)
-			if err := idx.Commit(stagePath, ch, rootDir, strat, committed, inProgressCommit, logger); err != nil {
+			if err := idx.runStage(stagePath, stg, ch, rootDir, runReason, envProbes, opts, logger); err != nil {
 				return err
 			}
-			for _, art := range stg.Inputs {
-				if art.Checksum == "" {
-					f, err := os.Open(filepath.Join(rootDir, art.Path))
-					if err == nil {
-						sum, err := checksum.Checksum(f)
-						if err == nil {
-							art.Checksum = sum
-						}
-						f.Close()
-					}
-				}
-			}
-
-			// After outputs are committed, save output set to cache table
-			outputSet := OutputSet{}
-			for path, art := range stg.Outputs {
-				outputSet[path] = art.Checksum
-			}
-			table[stageKey] = outputSet
-			if err := SaveIoHashTable(table, rootDir); err != nil {
-				logger.Error.Printf("failed to update io-hash-table: %v", err)
-			} else {
-				logger.Info.Printf("saved new cache state for this stage/configuration\n")
-			}
-
-
 		} else {
 			logger.Info.Printf("nothing to do for stage %s (%s, but no command)\n", stagePath, runReason)
 		}
@@ -213,6 +204,237 @@ func (idx Index) Run(
 		logger.Info.Printf("nothing to do for stage %s (up-to-date)\n", stagePath)
 	}
 	ran[stagePath] = doRun
-	delete(inProgress, stagePath)
 	return nil
 }
+
+// runStage executes a Stage's command, or restores its outputs from the run
+// cache if the Stage has already been run with identical inputs. Forced and
+// always-run Stages only update the run cache, never restore from it. Outputs
+// of an executed command are validated. Either way, the Stage is committed
+// afterwards.
+func (idx Index) runStage(
+	stagePath string,
+	stg *stage.Stage,
+	ch cache.Cache,
+	rootDir string,
+	runReason string,
+	envProbes map[string]stage.EnvProbe,
+	opts RunOptions,
+	logger *agglog.AggLogger,
+) error {
+	forced, sandbox := opts.Force[stagePath] || stg.AlwaysRun, opts.Sandbox
+	stageKey, useRunCache := idx.runCacheKey(stg, envProbes, ch, rootDir, logger)
+	table := make(IoHashTable)
+	if forced {
+		logger.Info.Printf("bypassing run cache for stage %s (%s)\n", stagePath, runReason)
+	}
+	if useRunCache {
+		var err error
+		table, err = LoadIoHashTable(rootDir)
+		if err != nil {
+			logger.Error.Printf("failed to load run cache: %v\n", err)
+			table = make(IoHashTable)
+		}
+	}
+	if useRunCache && !forced {
+		restored, err := restoreOutputs(stg, ch, rootDir, table[stageKey])
+		if err != nil {
+			// The run cache is only an optimization; fall back to running the
+			// command.
+			logger.Error.Printf("stage %s: failed to restore from run cache: %v\n", stagePath, err)
+		} else if restored {
+			logger.Info.Printf("restored stage %s from run cache (%s)\n", stagePath, runReason)
+			return idx.commitStage(stagePath, stg, ch, rootDir, strategy.LinkStrategy, envProbes, logger)
+		}
+	}
+
+	hookCtx := hook.Context{
+		RootDir:    rootDir,
+		Stage:      stagePath,
+		Command:    stg.Command,
+		WorkingDir: stg.WorkingDir,
+	}
+	if err := opts.Hooks.Run("pre-stage", hookCtx); err != nil {
+		return errors.Wrapf(err, "stage %s", stagePath)
+	}
+
+	// The workspace snapshots are taken inside the hooks, so files the hooks
+	// write aren't attributed to the command.
+	var before workspaceSnapshot
+	if opts.CheckWrites {
+		var err error
+		before, err = snapshotWorkspace(rootDir)
+		if err != nil {
+			return errors.Wrapf(err, "stage %s: snapshot workspace", stagePath)
+		}
+	}
+
+	if sandbox {
+		logger.Info.Printf("running stage %s in sandbox (%s)\n", stagePath, runReason)
+		if err := idx.runSandboxed(stagePath, stg, ch, rootDir, logger); err != nil {
+			return err
+		}
+	} else {
+		logger.Info.Printf("running stage %s (%s)\n", stagePath, runReason)
+		if err := cleanOutputs(stg, rootDir); err != nil {
+			return errors.Wrapf(err, "stage %s", stagePath)
+		}
+		cmd := stg.CreateCommand()
+		// Avoid cmd.Command here because it will include "sh -c ...".
+		logger.Debug.Printf("(in %s) %s\n", cmd.Dir, stg.Command)
+		if err := runCommand(cmd); err != nil {
+			return errors.Wrapf(err, "stage %s: command failed", stagePath)
+		}
+	}
+
+	var after workspaceSnapshot
+	if opts.CheckWrites {
+		var err error
+		after, err = snapshotWorkspace(rootDir)
+		if err != nil {
+			return errors.Wrapf(err, "stage %s: snapshot workspace", stagePath)
+		}
+	}
+
+	if err := opts.Hooks.Run("post-stage", hookCtx); err != nil {
+		return errors.Wrapf(err, "stage %s", stagePath)
+	}
+
+	if opts.CheckWrites {
+		if err := idx.undeclaredWrites(stagePath, stg, before, after); err != nil {
+			if opts.FailOnUndeclaredWrites {
+				return err
+			}
+			logger.Info.Printf("warning: %v\n", err)
+		}
+	}
+
+	if err := validateOutputs(stagePath, stg, rootDir, logger); err != nil {
+		return err
+	}
+
+	if err := idx.commitStage(stagePath, stg, ch, rootDir, strategy.LinkStrategy, envProbes, logger); err != nil {
+		return err
+	}
+
+	if !useRunCache {
+		return nil
+	}
+	outputSet := make(OutputSet, len(stg.Outputs))
+	for path, art := range stg.Outputs {
+		outputSet[path] = art.Checksum
+	}
+	table[stageKey] = outputSet
+	if err := SaveIoHashTable(table, rootDir); err != nil {
+		logger.Error.Printf("failed to update run cache: %v\n", err)
+	}
+	return nil
+}
+
+// runCacheKey returns the run cache key for the Stage (see CalcStageKey).
+// Inputs owned by other Stages are identified by their (resolved) upstream
+// checksums, all other inputs are checksummed in the workspace, and
+// environment dependencies are identified by their probe output in envProbes. If the
+// key can't be determined for any reason, ok is false and the run cache
+// should not be used. This is also the case for Stages without inputs, as
+// they should always run.
+func (idx Index) runCacheKey(
+	stg *stage.Stage,
+	envProbes map[string]stage.EnvProbe,
+	ch cache.Cache,
+	rootDir string,
+	logger *agglog.AggLogger,
+) (key string, ok bool) {
+	if len(stg.Inputs) == 0 {
+		return "", false
+	}
+	sums := make([]string, 0, len(stg.Inputs))
+	for artPath, art := range stg.Inputs {
+		var (
+			sum string
+			err error
+		)
+		ownerPath, ownerArt := idx.findOwner(artPath)
+		if ownerPath != "" {
+			sum, err = upstreamChecksum(ch, artPath, ownerArt)
+		} else if !art.IsDir {
+			sum, err = workspaceChecksum(filepath.Join(rootDir, artPath))
+		}
+		if err != nil {
+			logger.Debug.Printf("skipping run cache: %v\n", err)
+			return "", false
+		}
+		if sum == "" {
+			return "", false
+		}
+		sums = append(sums, sum)
+	}
+	// Environment dependencies are part of the key so that changes to them
+	// aren't masked by cached outputs.
+	for _, probeCmd := range stg.EnvDeps {
+		sums = append(sums, envProbes[probeCmd].Checksum)
+	}
+	return CalcStageKey(sums, stg.Command, stg.WorkingDir), true
+}
+
+func workspaceChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return checksum.Checksum(f)
+}
+
+// restoreOutputs checks out a Stage's outputs using the checksums in
+// outputSet, replacing any existing outputs (persisted or not). It returns false
+// without checking anything out if outputSet doesn't cover all of the Stage's
+// outputs.
+func restoreOutputs(
+	stg *stage.Stage,
+	ch cache.Cache,
+	rootDir string,
+	outputSet OutputSet,
+) (bool, error) {
+	if len(outputSet) == 0 {
+		return false, nil
+	}
+	for path := range stg.Outputs {
+		if _, ok := outputSet[path]; !ok {
+			return false, nil
+		}
+	}
+	for path, art := range stg.Outputs {
+		if err := os.RemoveAll(filepath.Join(rootDir, path)); err != nil {
+			return false, err
+		}
+		art.Checksum = outputSet[path]
+		// Optional outputs recorded as absent have nothing to check out.
+		if art.Checksum == "" && art.Optional {
+			continue
+		}
+		if err := ch.Checkout(rootDir, *art, strategy.LinkStrategy, nil); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// writeStageDeps writes each Stage's upstream dependencies to
+// .dud/stage_deps.txt for consumption by external tools.
+func (idx Index) writeStageDeps(rootDir string, logger *agglog.AggLogger) {
+	depFile, err := os.Create(filepath.Join(rootDir, ".dud", "stage_deps.txt"))
+	if err != nil {
+		logger.Error.Printf("failed to create dep file: %v\n", err)
+		return
+	}
+	defer depFile.Close()
+	graph := idx.DAG()
+	for _, stagePath := range graph.Nodes() {
+		fmt.Fprintf(depFile, "%s:", stagePath)
+		for _, upstream := range graph.Upstream(stagePath) {
+			fmt.Fprintf(depFile, " %s", upstream)
+		}
+		fmt.Fprintln(depFile)
+	}
+}
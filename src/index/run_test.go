@@ -2,6 +2,7 @@ package index
 
 import (
 	"log"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
@@ -11,8 +12,11 @@ import (
 	"github.com/kevin-hanselman/dud/src/agglog"
 	"github.com/kevin-hanselman/dud/src/artifact"
 	"github.com/kevin-hanselman/dud/src/fsutil"
+	"github.com/kevin-hanselman/dud/src/hook"
 	"github.com/kevin-hanselman/dud/src/mocks"
 	"github.com/kevin-hanselman/dud/src/stage"
+	"github.com/kevin-hanselman/dud/src/strategy"
+	"github.com/stretchr/testify/mock"
 )
 
 func assertCorrectCommand(stg stage.Stage, commands map[string]*exec.Cmd, t *testing.T) {
@@ -29,6 +33,21 @@ func assertCorrectCommand(stg stage.Stage, commands map[string]*exec.Cmd, t *tes
 	}
 }
 
+func expectStageCommitted(stg *stage.Stage, idx Index, mockCache *mocks.Cache, rootDir string) {
+	expectOutputsCommitted(stg, mockCache, rootDir, strategy.LinkStrategy)
+	for artPath, art := range stg.Inputs {
+		if owner, _ := idx.findOwner(artPath); owner == "" {
+			mockCache.On(
+				"Commit",
+				rootDir,
+				art,
+				strategy.LinkStrategy,
+				mock.AnythingOfType("*agglog.AggLogger"),
+			).Return(mockCommit).Once()
+		}
+	}
+}
+
 func TestRun(t *testing.T) {
 	upToDate := func() artifact.Status {
 		return artifact.Status{
@@ -48,7 +67,7 @@ func TestRun(t *testing.T) {
 		}
 	}
 
-	rootDir := "project/root"
+	var rootDir string
 
 	var commands map[string]*exec.Cmd
 	runCommandOrig := runCommand
@@ -70,14 +89,16 @@ func TestRun(t *testing.T) {
 	var infoLog strings.Builder
 	logger := agglog.NewNullLogger()
 
-	resetTestHarness := func() {
+	resetTestHarness := func(t *testing.T) {
+		// Use a fresh project root for each test to isolate the run cache.
+		rootDir = t.TempDir()
 		commands = make(map[string]*exec.Cmd)
 		infoLog = strings.Builder{}
 		logger.Info = log.New(&infoLog, "", 0)
 	}
 
 	t.Run("up-to-date stage without command doesn't suggest run", func(t *testing.T) {
-		resetTestHarness()
+		resetTestHarness(t)
 		stgA := stage.Stage{
 			WorkingDir: "a",
 			Outputs: map[string]*artifact.Artifact{
@@ -85,15 +106,14 @@ func TestRun(t *testing.T) {
 			},
 		}
 		updateChecksum(&stgA, t)
-		idx := Index{"foo.yaml": &stgA}
+		idx := newTestIndex(t, map[string]*stage.Stage{"foo.yaml": &stgA})
 
 		mockCache := mocks.Cache{}
 
 		expectStageStatusCalled(&stgA, &mockCache, rootDir, upToDate(), true)
 
 		ran := make(map[string]bool)
-		inProgress := make(map[string]bool)
-		if err := idx.Run("foo.yaml", &mockCache, rootDir, true, ran, inProgress, logger); err != nil {
+		if err := idx.Run("foo.yaml", &mockCache, rootDir, RunOptions{Recursive: true}, ran, logger); err != nil {
 			t.Fatal(err)
 		}
 
@@ -117,7 +137,7 @@ func TestRun(t *testing.T) {
 	})
 
 	t.Run("out-of-date stage without command does suggest run", func(t *testing.T) {
-		resetTestHarness()
+		resetTestHarness(t)
 		stgA := stage.Stage{
 			WorkingDir: "a",
 			Outputs: map[string]*artifact.Artifact{
@@ -125,14 +145,13 @@ func TestRun(t *testing.T) {
 			},
 		}
 		updateChecksum(&stgA, t)
-		idx := Index{"foo.yaml": &stgA}
+		idx := newTestIndex(t, map[string]*stage.Stage{"foo.yaml": &stgA})
 
 		mockCache := mocks.Cache{}
 		expectStageStatusCalled(&stgA, &mockCache, rootDir, outOfDate(), true)
 
 		ran := make(map[string]bool)
-		inProgress := make(map[string]bool)
-		if err := idx.Run("foo.yaml", &mockCache, rootDir, true, ran, inProgress, logger); err != nil {
+		if err := idx.Run("foo.yaml", &mockCache, rootDir, RunOptions{Recursive: true}, ran, logger); err != nil {
 			t.Fatal(err)
 		}
 
@@ -156,7 +175,7 @@ func TestRun(t *testing.T) {
 	})
 
 	t.Run("stage with command and no inputs always runs (outputs up-to-date)", func(t *testing.T) {
-		resetTestHarness()
+		resetTestHarness(t)
 		stgA := stage.Stage{
 			Command:    "echo 'Running Stage A'",
 			WorkingDir: "a",
@@ -165,15 +184,15 @@ func TestRun(t *testing.T) {
 			},
 		}
 		updateChecksum(&stgA, t)
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stgA,
-		}
+		})
 
 		mockCache := mocks.Cache{}
+		expectStageCommitted(&stgA, idx, &mockCache, rootDir)
 
 		ran := make(map[string]bool)
-		inProgress := make(map[string]bool)
-		if err := idx.Run("foo.yaml", &mockCache, rootDir, true, ran, inProgress, logger); err != nil {
+		if err := idx.Run("foo.yaml", &mockCache, rootDir, RunOptions{Recursive: true}, ran, logger); err != nil {
 			t.Fatal(err)
 		}
 
@@ -197,7 +216,7 @@ func TestRun(t *testing.T) {
 	})
 
 	t.Run("stage with command and no inputs always runs (outputs out-of-date)", func(t *testing.T) {
-		resetTestHarness()
+		resetTestHarness(t)
 		stg := stage.Stage{
 			Command:    "echo 'Running Stage A'",
 			WorkingDir: "a",
@@ -206,15 +225,15 @@ func TestRun(t *testing.T) {
 			},
 		}
 		updateChecksum(&stg, t)
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stg,
-		}
+		})
 
 		mockCache := mocks.Cache{}
+		expectStageCommitted(&stg, idx, &mockCache, rootDir)
 
 		ran := make(map[string]bool)
-		inProgress := make(map[string]bool)
-		if err := idx.Run("foo.yaml", &mockCache, rootDir, true, ran, inProgress, logger); err != nil {
+		if err := idx.Run("foo.yaml", &mockCache, rootDir, RunOptions{Recursive: true}, ran, logger); err != nil {
 			t.Fatal(err)
 		}
 
@@ -238,7 +257,7 @@ func TestRun(t *testing.T) {
 	})
 
 	t.Run("two stages, both up-to-date", func(t *testing.T) {
-		resetTestHarness()
+		resetTestHarness(t)
 		stgA := stage.Stage{
 			Outputs: map[string]*artifact.Artifact{
 				"foo.bin": {Path: "foo.bin"},
@@ -255,10 +274,10 @@ func TestRun(t *testing.T) {
 			},
 		}
 		updateChecksum(&stgB, t)
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stgA,
 			"bar.yaml": &stgB,
-		}
+		})
 
 		mockCache := mocks.Cache{}
 
@@ -266,8 +285,7 @@ func TestRun(t *testing.T) {
 		expectStageStatusCalled(&stgB, &mockCache, rootDir, upToDate(), true)
 
 		ran := make(map[string]bool)
-		inProgress := make(map[string]bool)
-		if err := idx.Run("bar.yaml", &mockCache, rootDir, true, ran, inProgress, logger); err != nil {
+		if err := idx.Run("bar.yaml", &mockCache, rootDir, RunOptions{Recursive: true}, ran, logger); err != nil {
 			t.Fatal(err)
 		}
 
@@ -293,7 +311,7 @@ func TestRun(t *testing.T) {
 	})
 
 	t.Run("two stages, upstream out-of-date", func(t *testing.T) {
-		resetTestHarness()
+		resetTestHarness(t)
 		stgA := stage.Stage{
 			Outputs: map[string]*artifact.Artifact{
 				"foo.bin": {Path: "foo.bin"},
@@ -310,20 +328,20 @@ func TestRun(t *testing.T) {
 			},
 		}
 		updateChecksum(&stgB, t)
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stgA,
 			"bar.yaml": &stgB,
-		}
+		})
 
 		mockCache := mocks.Cache{}
+		expectStageCommitted(&stgB, idx, &mockCache, rootDir)
 
 		expectStageStatusCalled(&stgA, &mockCache, rootDir, outOfDate(), true)
 		// Don't expect downstream Stage status to be checked, as the upstream being
 		// out-of-date will force the run.
 
 		ran := make(map[string]bool)
-		inProgress := make(map[string]bool)
-		if err := idx.Run("bar.yaml", &mockCache, rootDir, true, ran, inProgress, logger); err != nil {
+		if err := idx.Run("bar.yaml", &mockCache, rootDir, RunOptions{Recursive: true}, ran, logger); err != nil {
 			t.Fatal(err)
 		}
 
@@ -351,7 +369,7 @@ func TestRun(t *testing.T) {
 	})
 
 	t.Run("two stages, downstream out-of-date", func(t *testing.T) {
-		resetTestHarness()
+		resetTestHarness(t)
 		stgA := stage.Stage{
 			Outputs: map[string]*artifact.Artifact{
 				"foo.bin": {Path: "foo.bin"},
@@ -368,19 +386,19 @@ func TestRun(t *testing.T) {
 			},
 		}
 		updateChecksum(&stgB, t)
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stgA,
 			"bar.yaml": &stgB,
-		}
+		})
 
 		mockCache := mocks.Cache{}
+		expectStageCommitted(&stgB, idx, &mockCache, rootDir)
 
 		expectStageStatusCalled(&stgA, &mockCache, rootDir, upToDate(), true)
 		expectStageStatusCalled(&stgB, &mockCache, rootDir, outOfDate(), true)
 
 		ran := make(map[string]bool)
-		inProgress := make(map[string]bool)
-		if err := idx.Run("bar.yaml", &mockCache, rootDir, true, ran, inProgress, logger); err != nil {
+		if err := idx.Run("bar.yaml", &mockCache, rootDir, RunOptions{Recursive: true}, ran, logger); err != nil {
 			t.Fatal(err)
 		}
 
@@ -408,7 +426,7 @@ func TestRun(t *testing.T) {
 	})
 
 	t.Run("ensure all inputs are checked", func(t *testing.T) {
-		resetTestHarness()
+		resetTestHarness(t)
 		inA := stage.Stage{
 			Outputs: map[string]*artifact.Artifact{
 				"bish.bin": {Path: "bish.bin"},
@@ -432,20 +450,20 @@ func TestRun(t *testing.T) {
 			},
 		}
 		updateChecksum(&downstream, t)
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"bish.yaml": &inA,
 			"bash.yaml": &inB,
 			"bosh.yaml": &downstream,
-		}
+		})
 
 		mockCache := mocks.Cache{}
+		expectStageCommitted(&downstream, idx, &mockCache, rootDir)
 
 		expectStageStatusCalled(&inA, &mockCache, rootDir, outOfDate(), true)
 		expectStageStatusCalled(&inB, &mockCache, rootDir, upToDate(), true)
 
 		ran := make(map[string]bool)
-		inProgress := make(map[string]bool)
-		if err := idx.Run("bosh.yaml", &mockCache, rootDir, true, ran, inProgress, logger); err != nil {
+		if err := idx.Run("bosh.yaml", &mockCache, rootDir, RunOptions{Recursive: true}, ran, logger); err != nil {
 			t.Fatal(err)
 		}
 
@@ -483,7 +501,7 @@ func TestRun(t *testing.T) {
 	})
 
 	t.Run("cycles are prevented", func(t *testing.T) {
-		resetTestHarness()
+		resetTestHarness(t)
 		// stgA <-- stgB <-- stgC --> stgD
 		//    |---------------^
 		stgA := stage.Stage{
@@ -516,12 +534,12 @@ func TestRun(t *testing.T) {
 				"d.bin": {Path: "d.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"a.yaml": &stgA,
 			"b.yaml": &stgB,
 			"c.yaml": &stgC,
 			"d.yaml": &stgD,
-		}
+		})
 
 		mockCache := mocks.Cache{}
 		// Stage D is the only Stage that could possibly be ran successfully.
@@ -530,29 +548,24 @@ func TestRun(t *testing.T) {
 		expectStageStatusCalled(&stgD, &mockCache, rootDir, upToDate(), true)
 
 		ran := make(map[string]bool)
-		inProgress := make(map[string]bool)
-		err := idx.Run("c.yaml", &mockCache, rootDir, true, ran, inProgress, logger)
+		err := idx.Run("c.yaml", &mockCache, rootDir, RunOptions{Recursive: true}, ran, logger)
 		if err == nil {
 			t.Fatal("expected error")
 		}
 
-		expectedError := "cycle detected"
+		expectedError := "cycle detected: c.yaml -> a.yaml -> b.yaml -> c.yaml"
 		if diff := cmp.Diff(expectedError, err.Error()); diff != "" {
 			t.Fatalf("error -want +got:\n%s", diff)
 		}
 
-		expectedInProgress := map[string]bool{
-			"c.yaml": true,
-			"b.yaml": true,
-			"a.yaml": true,
-		}
-		if diff := cmp.Diff(expectedInProgress, inProgress); diff != "" {
-			t.Fatalf("inProgress -want +got:\n%s", diff)
+		// The cycle is found before any Stage is processed.
+		if len(ran) != 0 {
+			t.Fatalf("expected no stages to be processed, got %v", ran)
 		}
 	})
 
 	t.Run("run when any orphan input is out-of-date", func(t *testing.T) {
-		resetTestHarness()
+		resetTestHarness(t)
 		bish := upToDate()
 		bish.Artifact = artifact.Artifact{Path: "bish.bin"}
 
@@ -569,18 +582,18 @@ func TestRun(t *testing.T) {
 				"bosh.bin": {Path: "bosh.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"bosh.yaml": &stg,
-		}
+		})
 
 		mockCache := mocks.Cache{}
+		expectStageCommitted(&stg, idx, &mockCache, rootDir)
 
 		mockCache.On("Status", rootDir, bish.Artifact, true).Return(bish, nil).Once()
 		mockCache.On("Status", rootDir, bash.Artifact, true).Return(bash, nil).Once()
 
 		ran := make(map[string]bool)
-		inProgress := make(map[string]bool)
-		if err := idx.Run("bosh.yaml", &mockCache, rootDir, true, ran, inProgress, logger); err != nil {
+		if err := idx.Run("bosh.yaml", &mockCache, rootDir, RunOptions{Recursive: true}, ran, logger); err != nil {
 			t.Fatal(err)
 		}
 
@@ -606,7 +619,7 @@ func TestRun(t *testing.T) {
 	})
 
 	t.Run("can disable recursion", func(t *testing.T) {
-		resetTestHarness()
+		resetTestHarness(t)
 		stgA := stage.Stage{
 			Outputs: map[string]*artifact.Artifact{
 				"foo.bin": {Path: "foo.bin"},
@@ -622,18 +635,18 @@ func TestRun(t *testing.T) {
 			},
 		}
 		updateChecksum(&stgB, t)
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stgA,
 			"bar.yaml": &stgB,
-		}
+		})
 
 		mockCache := mocks.Cache{}
+		expectStageCommitted(&stgB, idx, &mockCache, rootDir)
 
 		expectStageStatusCalled(&stgB, &mockCache, rootDir, outOfDate(), true)
 
 		ran := make(map[string]bool)
-		inProgress := make(map[string]bool)
-		if err := idx.Run("bar.yaml", &mockCache, rootDir, false, ran, inProgress, logger); err != nil {
+		if err := idx.Run("bar.yaml", &mockCache, rootDir, RunOptions{}, ran, logger); err != nil {
 			t.Fatal(err)
 		}
 
@@ -659,7 +672,7 @@ func TestRun(t *testing.T) {
 	})
 
 	t.Run("stage with out-of-date definition does run", func(t *testing.T) {
-		resetTestHarness()
+		resetTestHarness(t)
 		stgA := stage.Stage{
 			Outputs: map[string]*artifact.Artifact{
 				"foo.bin": {Path: "foo.bin"},
@@ -677,18 +690,18 @@ func TestRun(t *testing.T) {
 				"bar.bin": {Path: "bar.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stgA,
 			"bar.yaml": &stgB,
-		}
+		})
 
 		mockCache := mocks.Cache{}
+		expectStageCommitted(&stgB, idx, &mockCache, rootDir)
 
 		expectStageStatusCalled(&stgA, &mockCache, rootDir, upToDate(), true)
 
 		ran := make(map[string]bool)
-		inProgress := make(map[string]bool)
-		if err := idx.Run("bar.yaml", &mockCache, rootDir, true, ran, inProgress, logger); err != nil {
+		if err := idx.Run("bar.yaml", &mockCache, rootDir, RunOptions{Recursive: true}, ran, logger); err != nil {
 			t.Fatal(err)
 		}
 
@@ -712,4 +725,455 @@ func TestRun(t *testing.T) {
 			t.Fatalf("log -want +got:\n%s", diff)
 		}
 	})
+
+	t.Run("upstream directory changes don't affect unchanged sub-path inputs", func(t *testing.T) {
+		resetTestHarness(t)
+		stgA := stage.Stage{
+			Command: "echo 'generating data'",
+			Outputs: map[string]*artifact.Artifact{
+				"data": {Path: "data", IsDir: true},
+			},
+		}
+		updateChecksum(&stgA, t)
+		stgB := stage.Stage{
+			Command: "echo 'training model'",
+			Inputs: map[string]*artifact.Artifact{
+				"data/train.csv": {Path: "data/train.csv", Checksum: "train_checksum"},
+			},
+			Outputs: map[string]*artifact.Artifact{
+				"model.bin": {Path: "model.bin"},
+			},
+		}
+		updateChecksum(&stgB, t)
+		idx := newTestIndex(t, map[string]*stage.Stage{
+			"a.yaml": &stgA,
+			"b.yaml": &stgB,
+		})
+
+		mockCache := mocks.Cache{}
+		expectStageCommitted(&stgA, idx, &mockCache, rootDir)
+		expectStageStatusCalled(&stgB, &mockCache, rootDir, upToDate(), true)
+		// mockCommit sets all checksums to "committed".
+		committedDir := artifact.Artifact{Path: "data", IsDir: true, Checksum: "committed"}
+		mockCache.On("ResolveChild", committedDir, "data/train.csv").Return(
+			artifact.Artifact{Path: "data/train.csv", Checksum: "train_checksum"},
+			nil,
+		)
+
+		ran := make(map[string]bool)
+		if err := idx.Run("b.yaml", &mockCache, rootDir, RunOptions{Recursive: true}, ran, logger); err != nil {
+			t.Fatal(err)
+		}
+
+		mockCache.AssertExpectations(t)
+
+		if len(commands) != 1 {
+			t.Fatalf("runCommand called %d time(s), want 1", len(commands))
+		}
+
+		assertCorrectCommand(stgA, commands, t)
+
+		expectedRan := map[string]bool{
+			"a.yaml": true,
+			"b.yaml": false,
+		}
+		if diff := cmp.Diff(expectedRan, ran); diff != "" {
+			t.Fatalf("ran -want +got:\n%s", diff)
+		}
+
+		wantLog := "running stage a.yaml (has command and no inputs)\n" +
+			"nothing to do for stage b.yaml (up-to-date)\n"
+		if diff := cmp.Diff(wantLog, infoLog.String()); diff != "" {
+			t.Fatalf("log -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("forced stage runs and bypasses the run cache", func(t *testing.T) {
+		resetTestHarness(t)
+		stgA := stage.Stage{
+			Outputs: map[string]*artifact.Artifact{
+				"foo.bin": {Path: "foo.bin", Checksum: "foo_checksum"},
+			},
+		}
+		updateChecksum(&stgA, t)
+		stgB := stage.Stage{
+			Command: "echo 'run stage B'",
+			Inputs: map[string]*artifact.Artifact{
+				"foo.bin": {Path: "foo.bin", Checksum: "foo_checksum"},
+			},
+			Outputs: map[string]*artifact.Artifact{
+				"bar.bin": {Path: "bar.bin"},
+			},
+		}
+		updateChecksum(&stgB, t)
+		idx := newTestIndex(t, map[string]*stage.Stage{
+			"foo.yaml": &stgA,
+			"bar.yaml": &stgB,
+		})
+
+		// Seed the run cache with an entry that would otherwise be restored.
+		stageKey := CalcStageKey([]string{"foo_checksum"}, stgB.Command, stgB.WorkingDir)
+		table := IoHashTable{stageKey: OutputSet{"bar.bin": "cached_checksum"}}
+		if err := SaveIoHashTable(table, rootDir); err != nil {
+			t.Fatal(err)
+		}
+
+		mockCache := mocks.Cache{}
+		expectStageStatusCalled(&stgA, &mockCache, rootDir, upToDate(), true)
+		expectStageCommitted(&stgB, idx, &mockCache, rootDir)
+
+		ran := make(map[string]bool)
+		opts := RunOptions{Recursive: true, Force: map[string]bool{"bar.yaml": true}}
+		if err := idx.Run("bar.yaml", &mockCache, rootDir, opts, ran, logger); err != nil {
+			t.Fatal(err)
+		}
+
+		mockCache.AssertExpectations(t)
+
+		if len(commands) != 1 {
+			t.Fatalf("runCommand called %d time(s), want 1", len(commands))
+		}
+		assertCorrectCommand(stgB, commands, t)
+
+		expectedRan := map[string]bool{
+			"foo.yaml": false,
+			"bar.yaml": true,
+		}
+		if diff := cmp.Diff(expectedRan, ran); diff != "" {
+			t.Fatalf("ran -want +got:\n%s", diff)
+		}
+
+		wantLog := "nothing to do for stage foo.yaml (up-to-date)\n" +
+			"bypassing run cache for stage bar.yaml (forced)\n" +
+			"running stage bar.yaml (forced)\n"
+		if diff := cmp.Diff(wantLog, infoLog.String()); diff != "" {
+			t.Fatalf("log -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("changed env-dep suggests run", func(t *testing.T) {
+		resetTestHarness(t)
+		stgA := stage.Stage{
+			EnvDeps: []string{"echo 1.0"},
+			EnvState: map[string]stage.EnvProbe{
+				"echo 1.0": {Checksum: "123456789", Output: "0.9"},
+			},
+			Outputs: map[string]*artifact.Artifact{
+				"foo.bin": {Path: "foo.bin"},
+			},
+		}
+		updateChecksum(&stgA, t)
+		idx := newTestIndex(t, map[string]*stage.Stage{"foo.yaml": &stgA})
+
+		mockCache := mocks.Cache{}
+
+		ran := make(map[string]bool)
+		if err := idx.Run("foo.yaml", &mockCache, rootDir, RunOptions{Recursive: true}, ran, logger); err != nil {
+			t.Fatal(err)
+		}
+
+		mockCache.AssertExpectations(t)
+
+		if diff := cmp.Diff(map[string]bool{"foo.yaml": true}, ran); diff != "" {
+			t.Fatalf("ran -want +got:\n%s", diff)
+		}
+
+		wantLog := "stage foo.yaml: env-dep \"echo 1.0\" changed: 0.9 -> 1.0\n" +
+			"nothing to do for stage foo.yaml (env-deps changed, but no command)\n"
+		if diff := cmp.Diff(wantLog, infoLog.String()); diff != "" {
+			t.Fatalf("log -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("env-deps are probed once per run stage", func(t *testing.T) {
+		resetTestHarness(t)
+		probeCmd := "echo probed >> probes.txt && echo 1.0"
+		stgA := stage.Stage{
+			Command: "echo 'run'",
+			EnvDeps: []string{probeCmd},
+			Outputs: map[string]*artifact.Artifact{
+				"foo.bin": {Path: "foo.bin"},
+			},
+		}
+		updateChecksum(&stgA, t)
+		idx := newTestIndex(t, map[string]*stage.Stage{"foo.yaml": &stgA})
+
+		mockCache := mocks.Cache{}
+		expectStageCommitted(&stgA, idx, &mockCache, rootDir)
+
+		ran := make(map[string]bool)
+		if err := idx.Run("foo.yaml", &mockCache, rootDir, RunOptions{Recursive: true}, ran, logger); err != nil {
+			t.Fatal(err)
+		}
+
+		mockCache.AssertExpectations(t)
+
+		probes, err := os.ReadFile(filepath.Join(rootDir, "probes.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff("probed\n", string(probes)); diff != "" {
+			t.Fatalf("probes -want +got:\n%s", diff)
+		}
+		if got := stgA.EnvState[probeCmd].Output; got != "1.0" {
+			t.Fatalf("EnvState output = %#v, want \"1.0\"", got)
+		}
+	})
+
+	t.Run("order-only upstream stage runs first without triggering a run", func(t *testing.T) {
+		resetTestHarness(t)
+		stgA := stage.Stage{
+			Command: "echo 'migrate'",
+			Outputs: map[string]*artifact.Artifact{
+				"foo.bin": {Path: "foo.bin"},
+			},
+		}
+		updateChecksum(&stgA, t)
+		stgB := stage.Stage{
+			After: []string{"foo.yaml"},
+			Outputs: map[string]*artifact.Artifact{
+				"bar.bin": {Path: "bar.bin"},
+			},
+		}
+		updateChecksum(&stgB, t)
+		idx := newTestIndex(t, map[string]*stage.Stage{
+			"foo.yaml": &stgA,
+			"bar.yaml": &stgB,
+		})
+
+		mockCache := mocks.Cache{}
+		expectStageCommitted(&stgA, idx, &mockCache, rootDir)
+		expectStageStatusCalled(&stgB, &mockCache, rootDir, upToDate(), true)
+
+		ran := make(map[string]bool)
+		if err := idx.Run("bar.yaml", &mockCache, rootDir, RunOptions{Recursive: true}, ran, logger); err != nil {
+			t.Fatal(err)
+		}
+
+		mockCache.AssertExpectations(t)
+
+		expectedRan := map[string]bool{
+			"foo.yaml": true,
+			"bar.yaml": false,
+		}
+		if diff := cmp.Diff(expectedRan, ran); diff != "" {
+			t.Fatalf("ran -want +got:\n%s", diff)
+		}
+
+		wantLog := "running stage foo.yaml (has command and no inputs)\n" +
+			"nothing to do for stage bar.yaml (up-to-date)\n"
+		if diff := cmp.Diff(wantLog, infoLog.String()); diff != "" {
+			t.Fatalf("log -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("always-run stage runs and bypasses the run cache", func(t *testing.T) {
+		resetTestHarness(t)
+		stgA := stage.Stage{
+			Outputs: map[string]*artifact.Artifact{
+				"foo.bin": {Path: "foo.bin", Checksum: "foo_checksum"},
+			},
+		}
+		updateChecksum(&stgA, t)
+		stgB := stage.Stage{
+			Command:   "echo 'fetch snapshot'",
+			AlwaysRun: true,
+			Inputs: map[string]*artifact.Artifact{
+				"foo.bin": {Path: "foo.bin", Checksum: "foo_checksum"},
+			},
+			Outputs: map[string]*artifact.Artifact{
+				"bar.bin": {Path: "bar.bin"},
+			},
+		}
+		updateChecksum(&stgB, t)
+		idx := newTestIndex(t, map[string]*stage.Stage{
+			"foo.yaml": &stgA,
+			"bar.yaml": &stgB,
+		})
+
+		// Seed the run cache with an entry that would otherwise be restored.
+		stageKey := CalcStageKey([]string{"foo_checksum"}, stgB.Command, stgB.WorkingDir)
+		table := IoHashTable{stageKey: OutputSet{"bar.bin": "cached_checksum"}}
+		if err := SaveIoHashTable(table, rootDir); err != nil {
+			t.Fatal(err)
+		}
+
+		mockCache := mocks.Cache{}
+		expectStageStatusCalled(&stgA, &mockCache, rootDir, upToDate(), true)
+		expectStageCommitted(&stgB, idx, &mockCache, rootDir)
+
+		ran := make(map[string]bool)
+		if err := idx.Run("bar.yaml", &mockCache, rootDir, RunOptions{Recursive: true}, ran, logger); err != nil {
+			t.Fatal(err)
+		}
+
+		mockCache.AssertExpectations(t)
+
+		if len(commands) != 1 {
+			t.Fatalf("runCommand called %d time(s), want 1", len(commands))
+		}
+
+		wantLog := "nothing to do for stage foo.yaml (up-to-date)\n" +
+			"bypassing run cache for stage bar.yaml (always-run)\n" +
+			"running stage bar.yaml (always-run)\n"
+		if diff := cmp.Diff(wantLog, infoLog.String()); diff != "" {
+			t.Fatalf("log -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("frozen stage never runs", func(t *testing.T) {
+		resetTestHarness(t)
+		stgA := stage.Stage{
+			Command: "echo 'expensive'",
+			Frozen:  true,
+			Outputs: map[string]*artifact.Artifact{
+				"foo.bin": {Path: "foo.bin"},
+			},
+		}
+		// Leave the checksum empty so the definition is out-of-date.
+		idx := newTestIndex(t, map[string]*stage.Stage{"foo.yaml": &stgA})
+
+		mockCache := mocks.Cache{}
+
+		ran := make(map[string]bool)
+		opts := RunOptions{Recursive: true, Force: map[string]bool{"foo.yaml": true}}
+		if err := idx.Run("foo.yaml", &mockCache, rootDir, opts, ran, logger); err != nil {
+			t.Fatal(err)
+		}
+
+		mockCache.AssertExpectations(t)
+
+		if len(commands) > 0 {
+			t.Fatal("runCommand called unexpectedly")
+		}
+
+		if diff := cmp.Diff(map[string]bool{"foo.yaml": false}, ran); diff != "" {
+			t.Fatalf("ran -want +got:\n%s", diff)
+		}
+
+		wantLog := "skipping frozen stage foo.yaml (forced)\n"
+		if diff := cmp.Diff(wantLog, infoLog.String()); diff != "" {
+			t.Fatalf("log -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("stage hooks run around the command", func(t *testing.T) {
+		// Hooks are run with the real shell.
+		if testing.Short() {
+			t.Skip()
+		}
+		resetTestHarness(t)
+		stgA := stage.Stage{
+			Command: "echo 'Running Stage A'",
+			Outputs: map[string]*artifact.Artifact{
+				"foo.bin": {Path: "foo.bin"},
+			},
+		}
+		updateChecksum(&stgA, t)
+		idx := newTestIndex(t, map[string]*stage.Stage{"foo.yaml": &stgA})
+
+		mockCache := mocks.Cache{}
+		expectStageCommitted(&stgA, idx, &mockCache, rootDir)
+
+		opts := RunOptions{
+			Recursive: true,
+			Hooks: hook.Hooks{
+				"pre-stage":  `echo "$DUD_HOOK $DUD_STAGE" >> hooks.log`,
+				"post-stage": `echo "$DUD_HOOK $DUD_STAGE" >> hooks.log`,
+			},
+		}
+		ran := make(map[string]bool)
+		if err := idx.Run("foo.yaml", &mockCache, rootDir, opts, ran, logger); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(commands) != 1 {
+			t.Fatalf("runCommand called %d time(s), want 1", len(commands))
+		}
+		hookLog, err := os.ReadFile(filepath.Join(rootDir, "hooks.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff("pre-stage foo.yaml\npost-stage foo.yaml\n", string(hookLog)); diff != "" {
+			t.Fatalf("hook log -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("files written by stage hooks aren't undeclared writes", func(t *testing.T) {
+		// Hooks are run with the real shell.
+		if testing.Short() {
+			t.Skip()
+		}
+		resetTestHarness(t)
+		stgA := stage.Stage{
+			Command: "echo 'Running Stage A'",
+			Outputs: map[string]*artifact.Artifact{
+				"foo.bin": {Path: "foo.bin"},
+			},
+		}
+		updateChecksum(&stgA, t)
+		idx := newTestIndex(t, map[string]*stage.Stage{"foo.yaml": &stgA})
+
+		mockCache := mocks.Cache{}
+		expectStageCommitted(&stgA, idx, &mockCache, rootDir)
+
+		opts := RunOptions{
+			Recursive:              true,
+			CheckWrites:            true,
+			FailOnUndeclaredWrites: true,
+			Hooks: hook.Hooks{
+				"pre-stage":  `echo "$DUD_HOOK" >> hooks.log`,
+				"post-stage": `echo "$DUD_HOOK" >> hooks.log`,
+			},
+		}
+		ran := make(map[string]bool)
+		if err := idx.Run("foo.yaml", &mockCache, rootDir, opts, ran, logger); err != nil {
+			t.Fatal(err)
+		}
+
+		mockCache.AssertExpectations(t)
+
+		hookLog, err := os.ReadFile(filepath.Join(rootDir, "hooks.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff("pre-stage\npost-stage\n", string(hookLog)); diff != "" {
+			t.Fatalf("hook log -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("failing pre-stage hook prevents the command", func(t *testing.T) {
+		// Hooks are run with the real shell.
+		if testing.Short() {
+			t.Skip()
+		}
+		resetTestHarness(t)
+		stgA := stage.Stage{
+			Command: "echo 'Running Stage A'",
+			Outputs: map[string]*artifact.Artifact{
+				"foo.bin": {Path: "foo.bin"},
+			},
+		}
+		updateChecksum(&stgA, t)
+		idx := newTestIndex(t, map[string]*stage.Stage{"foo.yaml": &stgA})
+
+		mockCache := mocks.Cache{}
+
+		opts := RunOptions{Recursive: true, Hooks: hook.Hooks{"pre-stage": "exit 1"}}
+		ran := make(map[string]bool)
+		err := idx.Run("foo.yaml", &mockCache, rootDir, opts, ran, logger)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		wantErr := "stage foo.yaml: pre-stage hook failed: exit status 1"
+		if diff := cmp.Diff(wantErr, err.Error()); diff != "" {
+			t.Fatalf("error -want +got:\n%s", diff)
+		}
+
+		mockCache.AssertExpectations(t)
+
+		if len(commands) > 0 {
+			t.Fatal("runCommand called unexpectedly")
+		}
+	})
 }
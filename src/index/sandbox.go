@@ -0,0 +1,258 @@
+package index
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/cache"
+	"github.com/kevin-hanselman/dud/src/stage"
+	"github.com/kevin-hanselman/dud/src/strategy"
+	"github.com/pkg/errors"
+)
+
+type missingOutputsError struct {
+	stagePath  string
+	missing    []string
+	undeclared []string
+}
+
+func (e missingOutputsError) Error() string {
+	msg := fmt.Sprintf(
+		"stage %s: sandboxed command did not produce declared output(s): %s",
+		e.stagePath,
+		strings.Join(e.missing, ", "),
+	)
+	if len(e.undeclared) > 0 {
+		msg += fmt.Sprintf(
+			"\nundeclared file(s) produced: %s",
+			strings.Join(e.undeclared, ", "),
+		)
+	}
+	return msg
+}
+
+// runSandboxed runs a Stage's command in a temporary directory tree that only
+// contains the Stage's declared inputs, its persisted outputs, and its working
+// directory. Committed inputs owned by other Stages are linked from the cache
+// if the workspace holds their committed version or nothing at all; other
+// inputs are copied from the workspace and made read-only. Persisted outputs
+// are copied. After the command succeeds, the declared outputs are moved back
+// into the workspace, replacing any existing outputs. If any declared output
+// (other than an optional one) is missing, an error listing the missing
+// outputs and any undeclared files is returned, and the workspace is left
+// untouched.
+//
+// The sandbox is created in the system's temporary directory, outside of the
+// project, so relative paths can't reach the workspace from inside it.
+func (idx Index) runSandboxed(
+	stagePath string,
+	stg *stage.Stage,
+	ch cache.Cache,
+	rootDir string,
+	logger *agglog.AggLogger,
+) error {
+	errPrefix := fmt.Sprintf("stage %s: sandbox", stagePath)
+	sandboxDir, err := os.MkdirTemp("", "dud-sandbox-")
+	if err != nil {
+		return errors.Wrap(err, errPrefix)
+	}
+	defer os.RemoveAll(sandboxDir)
+
+	for artPath := range stg.Inputs {
+		if err := idx.sandboxInput(artPath, ch, rootDir, sandboxDir); err != nil {
+			return errors.Wrapf(err, "%s: input %s", errPrefix, artPath)
+		}
+	}
+	// Only create the parent directories of outputs; creating the outputs
+	// themselves is the command's job. The exception is persisted outputs,
+	// which are copied in so the command can update them.
+	for artPath, art := range stg.Outputs {
+		parent := filepath.Join(sandboxDir, filepath.Dir(artPath))
+		if err := os.MkdirAll(parent, 0o755); err != nil {
+			return errors.Wrap(err, errPrefix)
+		}
+		if !art.Persist {
+			continue
+		}
+		workspacePath := filepath.Join(rootDir, artPath)
+		if _, err := os.Lstat(workspacePath); os.IsNotExist(err) {
+			continue
+		}
+		if err := copyTree(workspacePath, filepath.Join(sandboxDir, artPath)); err != nil {
+			return errors.Wrapf(err, "%s: output %s", errPrefix, artPath)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(sandboxDir, stg.WorkingDir), 0o755); err != nil {
+		return errors.Wrap(err, errPrefix)
+	}
+
+	cmd := stg.CreateCommand()
+	cmd.Dir = filepath.Join(sandboxDir, cmd.Dir)
+	logger.Debug.Printf("(in sandbox %s) %s\n", cmd.Dir, stg.Command)
+	if err := runCommand(cmd); err != nil {
+		return errors.Wrapf(err, "stage %s: command failed", stagePath)
+	}
+
+	var missing []string
+	for artPath, art := range stg.Outputs {
+		if _, err := os.Lstat(filepath.Join(sandboxDir, artPath)); os.IsNotExist(err) {
+			if art.Optional {
+				continue
+			}
+			missing = append(missing, artPath)
+		} else if err != nil {
+			return errors.Wrap(err, errPrefix)
+		}
+	}
+	undeclared, err := undeclaredFiles(stg, sandboxDir)
+	if err != nil {
+		return errors.Wrap(err, errPrefix)
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return missingOutputsError{
+			stagePath:  stagePath,
+			missing:    missing,
+			undeclared: undeclared,
+		}
+	}
+	if len(undeclared) > 0 {
+		logger.Info.Printf(
+			"stage %s produced undeclared file(s): %s\n",
+			stagePath,
+			strings.Join(undeclared, ", "),
+		)
+	}
+
+	for artPath := range stg.Outputs {
+		workspacePath := filepath.Join(rootDir, artPath)
+		if err := removeOutput(workspacePath); err != nil {
+			return errors.Wrap(err, errPrefix)
+		}
+		// Optional outputs the command didn't produce stay absent.
+		sandboxPath := filepath.Join(sandboxDir, artPath)
+		if _, err := os.Lstat(sandboxPath); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(workspacePath), 0o755); err != nil {
+			return errors.Wrap(err, errPrefix)
+		}
+		if err := moveTree(sandboxPath, workspacePath); err != nil {
+			return errors.Wrap(err, errPrefix)
+		}
+	}
+	return nil
+}
+
+// sandboxInput adds the input at artPath to the sandbox at sandboxDir. If the
+// input is owned by a Stage that committed it to the cache, and the workspace
+// holds the committed version or nothing at all, the input is linked from the
+// cache. Otherwise, the input is copied from the workspace and made read-only,
+// so the command can't modify the workspace through it.
+func (idx Index) sandboxInput(artPath string, ch cache.Cache, rootDir, sandboxDir string) error {
+	workspacePath := filepath.Join(rootDir, artPath)
+	_, err := os.Stat(workspacePath)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	ownerPath, ownerArt := idx.findOwner(artPath)
+	if ownerPath != "" && ownerArt.Checksum != "" && !ownerArt.SkipCache {
+		art := *ownerArt
+		if ownerArt.Path != artPath {
+			if art, err = ch.ResolveChild(*ownerArt, artPath); err != nil {
+				return err
+			}
+		}
+		art.Path = artPath
+		upToDate := !exists
+		if exists {
+			status, err := ch.Status(rootDir, art, true)
+			if err != nil {
+				return err
+			}
+			upToDate = status.ContentsMatch
+		}
+		if upToDate {
+			return ch.Checkout(sandboxDir, art, strategy.LinkStrategy, nil)
+		}
+	}
+	if !exists {
+		if ownerPath == "" {
+			return errors.New("missing from the workspace and not owned by any stage")
+		}
+		return fmt.Errorf("missing from the workspace and not cached by stage %s", ownerPath)
+	}
+	sandboxPath := filepath.Join(sandboxDir, artPath)
+	if err := os.MkdirAll(filepath.Dir(sandboxPath), 0o755); err != nil {
+		return err
+	}
+	if err := copyTree(workspacePath, sandboxPath); err != nil {
+		return err
+	}
+	// Directories are left writable so the sandbox can be removed afterwards.
+	return filepath.WalkDir(sandboxPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		return os.Chmod(path, 0o444)
+	})
+}
+
+// moveTree moves the file or directory at src to dst. The sandbox is usually
+// on a different filesystem than the workspace, in which case src is copied
+// and then removed.
+func moveTree(src, dst string) error {
+	err := os.Rename(src, dst)
+	var linkErr *os.LinkError
+	if !errors.As(err, &linkErr) || !errors.Is(linkErr.Err, syscall.EXDEV) {
+		return err
+	}
+	if err := copyTree(src, dst); err != nil {
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// undeclaredFiles returns the sorted paths of all files in the sandbox that
+// are neither inside inputs nor inside outputs of the Stage.
+func undeclaredFiles(stg *stage.Stage, sandboxDir string) ([]string, error) {
+	declared := func(path string) bool {
+		if _, ok := stg.Inputs[path]; ok {
+			return true
+		}
+		// Directory inputs checked out from the cache are real directories.
+		if _, ok := stage.FindDirArtifactOwnerForPath(path, stg.Inputs); ok {
+			return true
+		}
+		if _, ok := stg.Outputs[path]; ok {
+			return true
+		}
+		_, ok := stage.FindDirArtifactOwnerForPath(path, stg.Outputs)
+		return ok
+	}
+	var undeclared []string
+	err := filepath.WalkDir(sandboxDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(sandboxDir, path)
+		if err != nil {
+			return err
+		}
+		if !declared(relPath) {
+			undeclared = append(undeclared, relPath)
+		}
+		return nil
+	})
+	return undeclared, err
+}
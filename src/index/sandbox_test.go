@@ -0,0 +1,280 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/cache"
+	"github.com/kevin-hanselman/dud/src/stage"
+	"github.com/kevin-hanselman/dud/src/strategy"
+)
+
+func TestRunSandboxedIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	setup := func(t *testing.T) string {
+		// Give each test its own temporary directory to look for leftover
+		// sandboxes in.
+		t.Setenv("TMPDIR", t.TempDir())
+		rootDir := t.TempDir()
+		if err := os.Mkdir(filepath.Join(rootDir, ".dud"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for path, contents := range map[string]string{
+			"in.txt":     "hello",
+			"secret.txt": "undeclared input",
+			"out.txt":    "stale output",
+		} {
+			if err := os.WriteFile(filepath.Join(rootDir, path), []byte(contents), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rootDir
+	}
+
+	assertContents := func(t *testing.T, path, want string) {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, string(got)); diff != "" {
+			t.Fatalf("%s contents -want +got:\n%s", path, diff)
+		}
+	}
+
+	assertNoSandboxes := func(t *testing.T, rootDir string) {
+		matches, err := filepath.Glob(filepath.Join(os.TempDir(), "dud-sandbox-*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) > 0 {
+			t.Fatalf("sandbox(es) not cleaned up: %v", matches)
+		}
+	}
+
+	t.Run("outputs are moved into the workspace", func(t *testing.T) {
+		rootDir := setup(t)
+		stg := stage.Stage{
+			WorkingDir: "sub",
+			Command:    "mkdir -p ../results && cat ../in.txt > ../results/copy.txt && cat ../in.txt > ../out.txt",
+			Inputs: map[string]*artifact.Artifact{
+				"in.txt": {Path: "in.txt"},
+			},
+			Outputs: map[string]*artifact.Artifact{
+				"out.txt": {Path: "out.txt"},
+				"results": {Path: "results", IsDir: true},
+			},
+		}
+		if err := New().runSandboxed("foo.yaml", &stg, nil, rootDir, logger); err != nil {
+			t.Fatal(err)
+		}
+		assertContents(t, filepath.Join(rootDir, "out.txt"), "hello")
+		assertContents(t, filepath.Join(rootDir, "results", "copy.txt"), "hello")
+		assertNoSandboxes(t, rootDir)
+	})
+
+	t.Run("persisted outputs are copied into the sandbox", func(t *testing.T) {
+		rootDir := setup(t)
+		stg := stage.Stage{
+			Command: "printf ' updated' >> out.txt",
+			Outputs: map[string]*artifact.Artifact{
+				"out.txt": {Path: "out.txt", Persist: true},
+			},
+		}
+		if err := New().runSandboxed("foo.yaml", &stg, nil, rootDir, logger); err != nil {
+			t.Fatal(err)
+		}
+		assertContents(t, filepath.Join(rootDir, "out.txt"), "stale output updated")
+		assertNoSandboxes(t, rootDir)
+	})
+
+	t.Run("missing optional outputs are removed from the workspace", func(t *testing.T) {
+		rootDir := setup(t)
+		stg := stage.Stage{
+			Command: "true",
+			Outputs: map[string]*artifact.Artifact{
+				"out.txt": {Path: "out.txt", Optional: true},
+			},
+		}
+		if err := New().runSandboxed("foo.yaml", &stg, nil, rootDir, logger); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Lstat(filepath.Join(rootDir, "out.txt")); !os.IsNotExist(err) {
+			t.Fatalf("expected out.txt to be removed, got error %v", err)
+		}
+		assertNoSandboxes(t, rootDir)
+	})
+
+	t.Run("undeclared inputs are unavailable", func(t *testing.T) {
+		rootDir := setup(t)
+		stg := stage.Stage{
+			Command: "cat secret.txt > out.txt",
+			Outputs: map[string]*artifact.Artifact{
+				"out.txt": {Path: "out.txt"},
+			},
+		}
+		if err := New().runSandboxed("foo.yaml", &stg, nil, rootDir, logger); err == nil {
+			t.Fatal("expected error")
+		}
+		assertContents(t, filepath.Join(rootDir, "out.txt"), "stale output")
+		assertNoSandboxes(t, rootDir)
+	})
+
+	t.Run("workspace is unreachable through relative paths", func(t *testing.T) {
+		rootDir := setup(t)
+		stg := stage.Stage{
+			WorkingDir: "sub",
+			Command:    "cat ../../../secret.txt > ../out.txt",
+			Outputs: map[string]*artifact.Artifact{
+				"out.txt": {Path: "out.txt"},
+			},
+		}
+		if err := New().runSandboxed("foo.yaml", &stg, nil, rootDir, logger); err == nil {
+			t.Fatal("expected error")
+		}
+		assertContents(t, filepath.Join(rootDir, "out.txt"), "stale output")
+		assertNoSandboxes(t, rootDir)
+	})
+
+	t.Run("inputs can't modify the workspace", func(t *testing.T) {
+		rootDir := setup(t)
+		stg := stage.Stage{
+			// Writing to the input fails unless running as root, which
+			// ignores read-only files; either way, the workspace is untouched.
+			Command: "(printf changed > in.txt) 2>/dev/null; cat in.txt > out.txt",
+			Inputs: map[string]*artifact.Artifact{
+				"in.txt": {Path: "in.txt"},
+			},
+			Outputs: map[string]*artifact.Artifact{
+				"out.txt": {Path: "out.txt"},
+			},
+		}
+		if err := New().runSandboxed("foo.yaml", &stg, nil, rootDir, logger); err != nil {
+			t.Fatal(err)
+		}
+		assertContents(t, filepath.Join(rootDir, "in.txt"), "hello")
+		assertNoSandboxes(t, rootDir)
+	})
+
+	t.Run("error on missing output lists undeclared files", func(t *testing.T) {
+		rootDir := setup(t)
+		stg := stage.Stage{
+			Command: "cat in.txt > other.txt && mkdir tmp && touch tmp/scratch",
+			Inputs: map[string]*artifact.Artifact{
+				"in.txt": {Path: "in.txt"},
+			},
+			Outputs: map[string]*artifact.Artifact{
+				"out.txt": {Path: "out.txt"},
+			},
+		}
+		err := New().runSandboxed("foo.yaml", &stg, nil, rootDir, logger)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		wantErr := "stage foo.yaml: sandboxed command did not produce declared output(s): out.txt\n" +
+			"undeclared file(s) produced: other.txt, tmp/scratch"
+		if diff := cmp.Diff(wantErr, err.Error()); diff != "" {
+			t.Fatalf("error -want +got:\n%s", diff)
+		}
+		assertContents(t, filepath.Join(rootDir, "out.txt"), "stale output")
+		assertNoSandboxes(t, rootDir)
+	})
+
+	t.Run("committed inputs missing from the workspace are linked from the cache", func(t *testing.T) {
+		rootDir := setup(t)
+		ch, err := cache.NewLocalCache(filepath.Join(rootDir, ".dud", "cache"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dataDir := filepath.Join(rootDir, "data")
+		if err := os.Mkdir(dataDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for path, contents := range map[string]string{"a.txt": "a", "b.txt": "b"} {
+			if err := os.WriteFile(filepath.Join(dataDir, path), []byte(contents), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		upstream := &stage.Stage{
+			Outputs: map[string]*artifact.Artifact{
+				"data": {Path: "data", IsDir: true},
+			},
+		}
+		if err := ch.Commit(rootDir, upstream.Outputs["data"], strategy.CopyStrategy, logger); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.RemoveAll(dataDir); err != nil {
+			t.Fatal(err)
+		}
+
+		for inputPath, command := range map[string]string{
+			"data":       "cat data/a.txt data/b.txt > out.txt",
+			"data/b.txt": "cat data/b.txt > out.txt",
+		} {
+			stg := &stage.Stage{
+				Command: command,
+				Inputs: map[string]*artifact.Artifact{
+					inputPath: {Path: inputPath},
+				},
+				Outputs: map[string]*artifact.Artifact{
+					"out.txt": {Path: "out.txt"},
+				},
+			}
+			idx := newTestIndex(t, map[string]*stage.Stage{
+				"upstream.yaml": upstream,
+				"foo.yaml":      stg,
+			})
+			if err := idx.runSandboxed("foo.yaml", stg, ch, rootDir, logger); err != nil {
+				t.Fatalf("input %s: %v", inputPath, err)
+			}
+			want := "b"
+			if inputPath == "data" {
+				want = "ab"
+			}
+			assertContents(t, filepath.Join(rootDir, "out.txt"), want)
+			assertNoSandboxes(t, rootDir)
+		}
+		if _, err := os.Stat(dataDir); !os.IsNotExist(err) {
+			t.Fatalf("expected data to remain absent from the workspace, got %v", err)
+		}
+	})
+
+	t.Run("uncommitted inputs missing from the workspace are an error", func(t *testing.T) {
+		rootDir := setup(t)
+		stg := &stage.Stage{
+			Command: "cat data.txt > out.txt",
+			Inputs: map[string]*artifact.Artifact{
+				"data.txt": {Path: "data.txt"},
+			},
+			Outputs: map[string]*artifact.Artifact{
+				"out.txt": {Path: "out.txt"},
+			},
+		}
+		idx := newTestIndex(t, map[string]*stage.Stage{
+			"upstream.yaml": {
+				Outputs: map[string]*artifact.Artifact{
+					"data.txt": {Path: "data.txt"},
+				},
+			},
+			"foo.yaml": stg,
+		})
+		err := idx.runSandboxed("foo.yaml", stg, nil, rootDir, logger)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		wantErr := "stage foo.yaml: sandbox: input data.txt: missing from the workspace and not cached by stage upstream.yaml"
+		if diff := cmp.Diff(wantErr, err.Error()); diff != "" {
+			t.Fatalf("error -want +got:\n%s", diff)
+		}
+		assertContents(t, filepath.Join(rootDir, "out.txt"), "stale output")
+		assertNoSandboxes(t, rootDir)
+	})
+}
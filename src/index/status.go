@@ -15,25 +15,32 @@ func (idx Index) Status(
 	ch cache.Cache,
 	rootDir string,
 	out Status,
-	inProgress map[string]bool,
 ) error {
-	// Exit early if we've already recorded this Stage's status.
-	if _, ok := out[stagePath]; ok {
-		return nil
+	order, err := idx.upstreamOrder(stagePath, true, false, func(path string) bool {
+		_, ok := out[path]
+		return ok
+	})
+	if err != nil {
+		return err
 	}
-	// If we've visited this Stage but haven't recorded its status (the check
-	// above), then we're in a cycle.
-	if inProgress[stagePath] {
-		return errors.New("cycle detected")
-	}
-	inProgress[stagePath] = true
-
-	stg, ok := idx[stagePath]
-	if !ok {
-		return unknownStageError{stagePath}
+	for _, path := range order {
+		// Skip Stages whose status was already recorded.
+		if _, ok := out[path]; ok {
+			continue
+		}
+		if err := idx.stageStatus(path, ch, rootDir, out); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
+// stageStatus records the status of a single Stage in out.
+func (idx Index) stageStatus(stagePath string, ch cache.Cache, rootDir string, out Status) error {
+	stg := idx.stages[stagePath]
 	stageStatus := stage.NewStatus()
+	stageStatus.Frozen = stg.Frozen
+	stageStatus.AlwaysRun = stg.AlwaysRun
 	if stg.Checksum != "" {
 		stageStatus.HasChecksum = true
 		realChecksum, err := stg.CalculateChecksum()
@@ -43,18 +50,30 @@ func (idx Index) Status(
 		stageStatus.ChecksumMatches = realChecksum == stg.Checksum
 	}
 
+	if len(stg.EnvDeps) > 0 {
+		var err error
+		stageStatus.EnvDepStatus, err = stg.EnvStatus(rootDir)
+		if err != nil {
+			return errors.Wrapf(err, "status: %s", stagePath)
+		}
+	}
+
 	for artPath, art := range stg.Inputs {
 		var err error
-		ownerPath, _ := idx.findOwner(artPath)
+		ownerPath, ownerArt := idx.findOwner(artPath)
 		if ownerPath == "" {
 			stageStatus.ArtifactStatus[artPath], err = ch.Status(rootDir, *art, false)
 			if err != nil {
 				return err
 			}
 		} else {
-			if err := idx.Status(ownerPath, ch, rootDir, out, inProgress); err != nil {
-				return err
-			}
+			// If the owner's checksum can't be resolved (e.g. the owner's
+			// directory manifest hasn't been fetched, or the input is
+			// missing from it), the input is reported as not matching.
+			upstreamSum, err := upstreamChecksum(ch, artPath, ownerArt)
+			stageStatus.UpstreamInputsMatch[artPath] = err == nil &&
+				art.Checksum != "" &&
+				art.Checksum == upstreamSum
 		}
 	}
 
@@ -65,8 +84,6 @@ func (idx Index) Status(
 			return errors.Wrapf(err, "status: %s", art.Path)
 		}
 	}
-	// Record status and mark the Stage as complete.
 	out[stagePath] = stageStatus
-	delete(inProgress, stagePath)
 	return nil
 }
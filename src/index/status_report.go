@@ -0,0 +1,236 @@
+package index
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/fsutil"
+	"github.com/kevin-hanselman/dud/src/stage"
+)
+
+// StatusReportVersion is the version of the StatusReport schema. It is
+// incremented whenever a field is removed, or the meaning of a field or state
+// changes. Adding fields or states does not change the version.
+const StatusReportVersion = 1
+
+// A StatusReport is a stable, machine-readable summary of a Status. Unlike
+// Status, all collections in a StatusReport are sorted, and all states are
+// drawn from small, documented sets of strings.
+type StatusReport struct {
+	// Version is always StatusReportVersion.
+	Version int `json:"version"`
+	// UpToDate is true if all Stages are up-to-date.
+	UpToDate bool `json:"up-to-date"`
+	// Stages is sorted by path.
+	Stages []StageReport `json:"stages"`
+}
+
+// A StageReport summarizes the status of a Stage.
+type StageReport struct {
+	Path string `json:"path"`
+	// Definition is "up-to-date", "modified", or "not-checksummed".
+	Definition string `json:"definition"`
+	Frozen     bool   `json:"frozen"`
+	AlwaysRun  bool   `json:"always-run"`
+	// UpToDate is true if the Stage's definition, Artifacts, and environment
+	// dependencies are all up-to-date.
+	UpToDate bool `json:"up-to-date"`
+	// Artifacts is sorted by path.
+	Artifacts []ArtifactReport `json:"artifacts"`
+	// EnvDeps is sorted by command.
+	EnvDeps []EnvDepReport `json:"env-deps"`
+}
+
+// An ArtifactReport summarizes the status of one of a Stage's Artifacts.
+type ArtifactReport struct {
+	Path string `json:"path"`
+	// Role is "output", "input" (an input not owned by any Stage), or
+	// "upstream-input" (an input owned by another Stage).
+	Role string `json:"role"`
+	// State is one of "up-to-date", "absent" (an optional output committed
+	// as absent), "not-committed", "missing", or "modified". Upstream inputs
+	// are either "up-to-date" or "modified".
+	State    string `json:"state"`
+	UpToDate bool   `json:"up-to-date"`
+	// Description is the human-readable status, as printed by 'dud status'.
+	// It is not part of the schema's contract.
+	Description string `json:"description"`
+	// Files lists the changed files inside a directory Artifact, if requested
+	// (see StatusReportOptions).
+	Files []artifact.FileChange `json:"files,omitempty"`
+}
+
+// StatusReportOptions configures Index.StatusReport.
+type StatusReportOptions struct {
+	// Files enables listing the changed files inside directory Artifacts.
+	Files bool
+	// FileDepth and FileStates limit the listed files; see
+	// artifact.Status.ChangedFiles.
+	FileDepth  int
+	FileStates map[string]bool
+}
+
+// An EnvDepReport summarizes the status of one of a Stage's environment
+// dependencies.
+type EnvDepReport struct {
+	Command string `json:"command"`
+	// State is "up-to-date", "changed", or "not-recorded".
+	State    string `json:"state"`
+	UpToDate bool   `json:"up-to-date"`
+	Recorded string `json:"recorded"`
+	Current  string `json:"current"`
+}
+
+// StatusReport summarizes the given Status, which must have been created from
+// this Index.
+func (idx Index) StatusReport(status Status, opts StatusReportOptions) StatusReport {
+	report := StatusReport{
+		Version:  StatusReportVersion,
+		UpToDate: true,
+		Stages:   make([]StageReport, 0, len(status)),
+	}
+	for stagePath, stageStatus := range status {
+		stageReport := newStageReport(stagePath, idx.stages[stagePath], stageStatus, opts)
+		report.UpToDate = report.UpToDate && stageReport.UpToDate
+		report.Stages = append(report.Stages, stageReport)
+	}
+	sort.Slice(report.Stages, func(i, j int) bool {
+		return report.Stages[i].Path < report.Stages[j].Path
+	})
+	return report
+}
+
+func newStageReport(
+	stagePath string,
+	stg *stage.Stage,
+	status stage.Status,
+	opts StatusReportOptions,
+) StageReport {
+	report := StageReport{
+		Path:       stagePath,
+		Definition: "not-checksummed",
+		Frozen:     status.Frozen,
+		AlwaysRun:  status.AlwaysRun,
+		Artifacts:  []ArtifactReport{},
+		EnvDeps:    []EnvDepReport{},
+	}
+	if status.ChecksumMatches {
+		report.Definition = "up-to-date"
+	} else if status.HasChecksum {
+		report.Definition = "modified"
+	}
+	report.UpToDate = status.ChecksumMatches
+
+	for artPath, artStatus := range status.ArtifactStatus {
+		role := "input"
+		if stg != nil {
+			if _, ok := stg.Outputs[artPath]; ok {
+				role = "output"
+			}
+		}
+		artReport := ArtifactReport{
+			Path:        artPath,
+			Role:        role,
+			State:       artifactState(artStatus),
+			UpToDate:    artStatus.ContentsMatch,
+			Description: artStatus.String(),
+		}
+		if opts.Files && artStatus.IsDir {
+			artReport.Files = artStatus.ChangedFiles(opts.FileDepth, opts.FileStates)
+		}
+		report.UpToDate = report.UpToDate && artReport.UpToDate
+		report.Artifacts = append(report.Artifacts, artReport)
+	}
+	for artPath, matches := range status.UpstreamInputsMatch {
+		artReport := ArtifactReport{
+			Path:        artPath,
+			Role:        "upstream-input",
+			State:       "up-to-date",
+			UpToDate:    matches,
+			Description: "up-to-date with upstream",
+		}
+		if !matches {
+			artReport.State = "modified"
+			artReport.Description = "modified upstream"
+		}
+		report.UpToDate = report.UpToDate && matches
+		report.Artifacts = append(report.Artifacts, artReport)
+	}
+	sort.Slice(report.Artifacts, func(i, j int) bool {
+		return report.Artifacts[i].Path < report.Artifacts[j].Path
+	})
+
+	for probeCmd, envStatus := range status.EnvDepStatus {
+		envReport := EnvDepReport{
+			Command:  probeCmd,
+			State:    "changed",
+			UpToDate: envStatus.Matches(),
+			Recorded: envStatus.Recorded.String(),
+			Current:  envStatus.Current.String(),
+		}
+		if envReport.UpToDate {
+			envReport.State = "up-to-date"
+		} else if envStatus.Recorded.Checksum == "" {
+			envReport.State = "not-recorded"
+		}
+		report.UpToDate = report.UpToDate && envReport.UpToDate
+		report.EnvDeps = append(report.EnvDeps, envReport)
+	}
+	sort.Slice(report.EnvDeps, func(i, j int) bool {
+		return report.EnvDeps[i].Command < report.EnvDeps[j].Command
+	})
+	return report
+}
+
+// artifactState returns the StatusReport state of an Artifact.
+func artifactState(status artifact.Status) string {
+	switch {
+	case status.Absent && status.ContentsMatch:
+		return "absent"
+	case status.ContentsMatch:
+		return "up-to-date"
+	case !status.HasChecksum:
+		return "not-committed"
+	case status.WorkspaceFileStatus == fsutil.StatusAbsent:
+		return "missing"
+	default:
+		return "modified"
+	}
+}
+
+// WritePorcelain writes the StatusReport in an easy-to-parse line format. Each
+// line has tab-separated fields: the Stage path, the kind of entry, the
+// entry's state, and (for all but "definition" entries) the entry's subject.
+// The kinds are "definition", the Artifact roles of ArtifactReport, "file"
+// (for changed files inside directory Artifacts, if any), and "env-dep".
+// Lines are ordered by Stage path; within each Stage, the definition comes
+// first, followed by Artifacts sorted by path (each followed by its changed
+// files), then environment dependencies sorted by command.
+func (report StatusReport) WritePorcelain(writer io.Writer) error {
+	for _, stg := range report.Stages {
+		if _, err := fmt.Fprintf(writer, "%s\tdefinition\t%s\n", stg.Path, stg.Definition); err != nil {
+			return err
+		}
+		for _, art := range stg.Artifacts {
+			_, err := fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", stg.Path, art.Role, art.State, art.Path)
+			if err != nil {
+				return err
+			}
+			for _, file := range art.Files {
+				_, err := fmt.Fprintf(writer, "%s\tfile\t%s\t%s\n", stg.Path, file.State, file.Path)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		for _, envDep := range stg.EnvDeps {
+			_, err := fmt.Fprintf(writer, "%s\tenv-dep\t%s\t%s\n", stg.Path, envDep.State, envDep.Command)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package index
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/fsutil"
+	"github.com/kevin-hanselman/dud/src/stage"
+)
+
+func TestStatusReport(t *testing.T) {
+	upToDate := artifact.Status{
+		WorkspaceFileStatus: fsutil.StatusLink,
+		HasChecksum:         true,
+		ChecksumInCache:     true,
+		ContentsMatch:       true,
+	}
+	missing := artifact.Status{
+		WorkspaceFileStatus: fsutil.StatusAbsent,
+		HasChecksum:         true,
+		ChecksumInCache:     true,
+	}
+
+	idx := newTestIndex(t, map[string]*stage.Stage{
+		"b.yaml": {
+			Inputs: map[string]*artifact.Artifact{
+				"raw.csv":  {Path: "raw.csv"},
+				"data.bin": {Path: "data.bin"},
+			},
+			Outputs: map[string]*artifact.Artifact{
+				"model.bin": {Path: "model.bin"},
+			},
+		},
+		"a.yaml": {
+			Outputs: map[string]*artifact.Artifact{
+				"data.bin": {Path: "data.bin"},
+			},
+		},
+	})
+
+	bStatus := stage.NewStatus()
+	bStatus.HasChecksum = true
+	bStatus.ArtifactStatus["raw.csv"] = upToDate
+	bStatus.ArtifactStatus["model.bin"] = missing
+	bStatus.UpstreamInputsMatch["data.bin"] = false
+	bStatus.EnvDepStatus["python --version"] = stage.EnvDepStatus{
+		Recorded: stage.EnvProbe{Checksum: "old", Output: "Python 3.10"},
+		Current:  stage.EnvProbe{Checksum: "new", Output: "Python 3.11"},
+	}
+	aStatus := stage.NewStatus()
+	aStatus.HasChecksum = true
+	aStatus.ChecksumMatches = true
+	aStatus.ArtifactStatus["data.bin"] = upToDate
+
+	report := idx.StatusReport(Status{"b.yaml": bStatus, "a.yaml": aStatus}, StatusReportOptions{})
+
+	want := StatusReport{
+		Version:  StatusReportVersion,
+		UpToDate: false,
+		Stages: []StageReport{
+			{
+				Path:       "a.yaml",
+				Definition: "up-to-date",
+				UpToDate:   true,
+				Artifacts: []ArtifactReport{
+					{
+						Path:        "data.bin",
+						Role:        "output",
+						State:       "up-to-date",
+						UpToDate:    true,
+						Description: "up-to-date (link)",
+					},
+				},
+				EnvDeps: []EnvDepReport{},
+			},
+			{
+				Path:       "b.yaml",
+				Definition: "modified",
+				UpToDate:   false,
+				Artifacts: []ArtifactReport{
+					{
+						Path:        "data.bin",
+						Role:        "upstream-input",
+						State:       "modified",
+						Description: "modified upstream",
+					},
+					{
+						Path:        "model.bin",
+						Role:        "output",
+						State:       "missing",
+						Description: "missing from workspace",
+					},
+					{
+						Path:        "raw.csv",
+						Role:        "input",
+						State:       "up-to-date",
+						UpToDate:    true,
+						Description: "up-to-date (link)",
+					},
+				},
+				EnvDeps: []EnvDepReport{
+					{
+						Command:  "python --version",
+						State:    "changed",
+						Recorded: "Python 3.10",
+						Current:  "Python 3.11",
+					},
+				},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, report); diff != "" {
+		t.Fatalf("StatusReport -want +got:\n%s", diff)
+	}
+
+	t.Run("porcelain", func(t *testing.T) {
+		var out strings.Builder
+		if err := report.WritePorcelain(&out); err != nil {
+			t.Fatal(err)
+		}
+		wantOut := "a.yaml\tdefinition\tup-to-date\n" +
+			"a.yaml\toutput\tup-to-date\tdata.bin\n" +
+			"b.yaml\tdefinition\tmodified\n" +
+			"b.yaml\tupstream-input\tmodified\tdata.bin\n" +
+			"b.yaml\toutput\tmissing\tmodel.bin\n" +
+			"b.yaml\tinput\tup-to-date\traw.csv\n" +
+			"b.yaml\tenv-dep\tchanged\tpython --version\n"
+		if diff := cmp.Diff(wantOut, out.String()); diff != "" {
+			t.Fatalf("porcelain -want +got:\n%s", diff)
+		}
+	})
+}
+
+func TestStatusReportFiles(t *testing.T) {
+	idx := newTestIndex(t, map[string]*stage.Stage{
+		"a.yaml": {
+			Outputs: map[string]*artifact.Artifact{
+				"data":    {Path: "data", IsDir: true},
+				"sum.txt": {Path: "sum.txt"},
+			},
+		},
+	})
+
+	stageStatus := stage.NewStatus()
+	stageStatus.HasChecksum = true
+	stageStatus.ChecksumMatches = true
+	stageStatus.ArtifactStatus["data"] = artifact.Status{
+		Artifact:            artifact.Artifact{Path: "data", IsDir: true},
+		WorkspaceFileStatus: fsutil.StatusDirectory,
+		HasChecksum:         true,
+		ChecksumInCache:     true,
+		ChildrenStatus: map[string]*artifact.Status{
+			"x.csv": {
+				Artifact:            artifact.Artifact{Path: "x.csv"},
+				WorkspaceFileStatus: fsutil.StatusRegularFile,
+			},
+		},
+	}
+	stageStatus.ArtifactStatus["sum.txt"] = artifact.Status{
+		Artifact:            artifact.Artifact{Path: "sum.txt"},
+		WorkspaceFileStatus: fsutil.StatusRegularFile,
+		HasChecksum:         true,
+		ChecksumInCache:     true,
+	}
+	status := Status{"a.yaml": stageStatus}
+
+	t.Run("files omitted by default", func(t *testing.T) {
+		report := idx.StatusReport(status, StatusReportOptions{})
+		for _, art := range report.Stages[0].Artifacts {
+			if art.Files != nil {
+				t.Fatalf("artifact %s: expected no files, got %v", art.Path, art.Files)
+			}
+		}
+	})
+
+	t.Run("files listed for directories", func(t *testing.T) {
+		report := idx.StatusReport(status, StatusReportOptions{Files: true})
+		var out strings.Builder
+		if err := report.WritePorcelain(&out); err != nil {
+			t.Fatal(err)
+		}
+		wantOut := "a.yaml\tdefinition\tup-to-date\n" +
+			"a.yaml\toutput\tmodified\tdata\n" +
+			"a.yaml\tfile\tadded\tdata/x.csv\n" +
+			"a.yaml\toutput\tmodified\tsum.txt\n"
+		if diff := cmp.Diff(wantOut, out.String()); diff != "" {
+			t.Fatalf("porcelain -want +got:\n%s", diff)
+		}
+	})
+}
+
+func TestArtifactState(t *testing.T) {
+	tests := map[string]struct {
+		status artifact.Status
+		want   string
+	}{
+		"absent optional output": {
+			status: artifact.Status{
+				Artifact:            artifact.Artifact{Optional: true, Absent: true},
+				WorkspaceFileStatus: fsutil.StatusAbsent,
+				ContentsMatch:       true,
+			},
+			want: "absent",
+		},
+		"not committed": {
+			status: artifact.Status{WorkspaceFileStatus: fsutil.StatusRegularFile},
+			want:   "not-committed",
+		},
+		"modified": {
+			status: artifact.Status{
+				WorkspaceFileStatus: fsutil.StatusRegularFile,
+				HasChecksum:         true,
+				ChecksumInCache:     true,
+			},
+			want: "modified",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := artifactState(test.status); got != test.want {
+				t.Fatalf("artifactState() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
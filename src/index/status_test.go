@@ -44,7 +44,7 @@ func TestStatus(t *testing.T) {
 				"foo.bin": {Path: "foo.bin"},
 			},
 		}
-		idx := Index{"foo.yaml": &stgA}
+		idx := newTestIndex(t, map[string]*stage.Stage{"foo.yaml": &stgA})
 
 		mockCache := mocks.Cache{}
 
@@ -53,8 +53,7 @@ func TestStatus(t *testing.T) {
 		expectedStatus := Status{"foo.yaml": expectedStageStatus}
 
 		outputStatus := make(Status)
-		inProgress := make(map[string]bool)
-		err := idx.Status("foo.yaml", &mockCache, rootDir, outputStatus, inProgress)
+		err := idx.Status("foo.yaml", &mockCache, rootDir, outputStatus)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -78,7 +77,7 @@ func TestStatus(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		idx := Index{"foo.yaml": &stgA}
+		idx := newTestIndex(t, map[string]*stage.Stage{"foo.yaml": &stgA})
 
 		mockCache := mocks.Cache{}
 
@@ -88,8 +87,36 @@ func TestStatus(t *testing.T) {
 		expectedStatus := Status{"foo.yaml": expectedStageStatus}
 
 		outputStatus := make(Status)
-		inProgress := make(map[string]bool)
-		err = idx.Status("foo.yaml", &mockCache, rootDir, outputStatus, inProgress)
+		err = idx.Status("foo.yaml", &mockCache, rootDir, outputStatus)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mockCache.AssertExpectations(t)
+		if diff := cmp.Diff(expectedStatus, outputStatus); diff != "" {
+			t.Fatalf("Stage -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("sets stage status flags", func(t *testing.T) {
+		stgA := stage.Stage{
+			Frozen:    true,
+			AlwaysRun: true,
+			Outputs: map[string]*artifact.Artifact{
+				"foo.bin": {Path: "foo.bin"},
+			},
+		}
+		idx := newTestIndex(t, map[string]*stage.Stage{"foo.yaml": &stgA})
+
+		mockCache := mocks.Cache{}
+
+		expectedStageStatus := expectStageStatusCalled(&stgA, &mockCache, rootDir, upToDate, false)
+		expectedStageStatus.Frozen = true
+		expectedStageStatus.AlwaysRun = true
+		expectedStatus := Status{"foo.yaml": expectedStageStatus}
+
+		outputStatus := make(Status)
+		err := idx.Status("foo.yaml", &mockCache, rootDir, outputStatus)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -111,10 +138,10 @@ func TestStatus(t *testing.T) {
 				"bar.bin": {Path: "bar.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stgA,
 			"bar.yaml": &stgB,
-		}
+		})
 
 		mockCache := mocks.Cache{}
 
@@ -123,8 +150,7 @@ func TestStatus(t *testing.T) {
 		}
 
 		outputStatus := make(Status)
-		inProgress := make(map[string]bool)
-		err := idx.Status("foo.yaml", &mockCache, rootDir, outputStatus, inProgress)
+		err := idx.Status("foo.yaml", &mockCache, rootDir, outputStatus)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -156,15 +182,16 @@ func TestStatus(t *testing.T) {
 			"foo.yaml": expectStageStatusCalled(&stgA, &mockCache, rootDir, upToDate, false),
 			"bar.yaml": expectStageStatusCalled(&stgB, &mockCache, rootDir, upToDate, false),
 		}
+		// Neither Stage is committed, so the input doesn't match upstream.
+		expectedStatus["bar.yaml"].UpstreamInputsMatch["foo.bin"] = false
 
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"foo.yaml": &stgA,
 			"bar.yaml": &stgB,
-		}
+		})
 
 		outputStatus := make(Status)
-		inProgress := make(map[string]bool)
-		err := idx.Status("bar.yaml", &mockCache, rootDir, outputStatus, inProgress)
+		err := idx.Status("bar.yaml", &mockCache, rootDir, outputStatus)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -200,11 +227,11 @@ func TestStatus(t *testing.T) {
 				"c.bin": {Path: "c.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"a.yaml": &stgA,
 			"b.yaml": &stgB,
 			"c.yaml": &stgC,
-		}
+		})
 
 		mockCache := mocks.Cache{}
 
@@ -213,10 +240,12 @@ func TestStatus(t *testing.T) {
 			"b.yaml": expectStageStatusCalled(&stgB, &mockCache, rootDir, upToDate, false),
 			"c.yaml": expectStageStatusCalled(&stgC, &mockCache, rootDir, upToDate, false),
 		}
+		expectedStatus["b.yaml"].UpstreamInputsMatch["a.bin"] = false
+		expectedStatus["c.yaml"].UpstreamInputsMatch["a.bin"] = false
+		expectedStatus["c.yaml"].UpstreamInputsMatch["b.bin"] = false
 
 		outputStatus := make(Status)
-		inProgress := make(map[string]bool)
-		err := idx.Status("c.yaml", &mockCache, rootDir, outputStatus, inProgress)
+		err := idx.Status("c.yaml", &mockCache, rootDir, outputStatus)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -260,12 +289,12 @@ func TestStatus(t *testing.T) {
 				"d.bin": {Path: "d.bin"},
 			},
 		}
-		idx := Index{
+		idx := newTestIndex(t, map[string]*stage.Stage{
 			"a.yaml": &stgA,
 			"b.yaml": &stgB,
 			"c.yaml": &stgC,
 			"d.yaml": &stgD,
-		}
+		})
 
 		mockCache := mocks.Cache{}
 		// Stage D is the only Stage that could possibly be committed
@@ -274,24 +303,19 @@ func TestStatus(t *testing.T) {
 		expectStageStatusCalled(&stgD, &mockCache, rootDir, upToDate, false)
 
 		outputStatus := make(Status)
-		inProgress := make(map[string]bool)
-		err := idx.Status("c.yaml", &mockCache, rootDir, outputStatus, inProgress)
+		err := idx.Status("c.yaml", &mockCache, rootDir, outputStatus)
 		if err == nil {
 			t.Fatal("expected error")
 		}
 
-		expectedError := "cycle detected"
+		expectedError := "cycle detected: c.yaml -> a.yaml -> b.yaml -> c.yaml"
 		if diff := cmp.Diff(expectedError, err.Error()); diff != "" {
 			t.Fatalf("error -want +got:\n%s", diff)
 		}
 
-		expectedInProgress := map[string]bool{
-			"c.yaml": true,
-			"b.yaml": true,
-			"a.yaml": true,
-		}
-		if diff := cmp.Diff(expectedInProgress, inProgress); diff != "" {
-			t.Fatalf("inProgress -want +got:\n%s", diff)
+		// The cycle is found before any Stage is processed.
+		if len(outputStatus) != 0 {
+			t.Fatalf("expected no stages to be processed, got %v", outputStatus)
 		}
 	})
 
@@ -305,7 +329,7 @@ func TestStatus(t *testing.T) {
 				"bash.bin": {Path: "bash.bin"},
 			},
 		}
-		idx := Index{"foo.yaml": &stg}
+		idx := newTestIndex(t, map[string]*stage.Stage{"foo.yaml": &stg})
 
 		mockCache := mocks.Cache{}
 
@@ -320,8 +344,58 @@ func TestStatus(t *testing.T) {
 		mockCache.On("Status", rootDir, orphanArt, false).Return(orphanArtStatus, nil).Once()
 
 		outputStatus := make(Status)
-		inProgress := make(map[string]bool)
-		err := idx.Status("foo.yaml", &mockCache, rootDir, outputStatus, inProgress)
+		err := idx.Status("foo.yaml", &mockCache, rootDir, outputStatus)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mockCache.AssertExpectations(t)
+		if diff := cmp.Diff(expectedStatus, outputStatus); diff != "" {
+			t.Fatalf("Stage -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("inputs inside upstream directory outputs use resolved checksums", func(t *testing.T) {
+		stgA := stage.Stage{
+			Outputs: map[string]*artifact.Artifact{
+				"data": {Path: "data", IsDir: true, Checksum: "dir_checksum"},
+			},
+		}
+		stgB := stage.Stage{
+			Inputs: map[string]*artifact.Artifact{
+				"data/train.csv": {Path: "data/train.csv", Checksum: "train_checksum"},
+				"data/test.csv":  {Path: "data/test.csv", Checksum: "old_test_checksum"},
+			},
+			Outputs: map[string]*artifact.Artifact{
+				"model.bin": {Path: "model.bin"},
+			},
+		}
+		idx := newTestIndex(t, map[string]*stage.Stage{
+			"a.yaml": &stgA,
+			"b.yaml": &stgB,
+		})
+
+		mockCache := mocks.Cache{}
+
+		expectedStatus := Status{
+			"a.yaml": expectStageStatusCalled(&stgA, &mockCache, rootDir, upToDate, false),
+			"b.yaml": expectStageStatusCalled(&stgB, &mockCache, rootDir, upToDate, false),
+		}
+		expectedStatus["b.yaml"].UpstreamInputsMatch["data/train.csv"] = true
+		expectedStatus["b.yaml"].UpstreamInputsMatch["data/test.csv"] = false
+
+		dirArt := *stgA.Outputs["data"]
+		mockCache.On("ResolveChild", dirArt, "data/train.csv").Return(
+			artifact.Artifact{Path: "data/train.csv", Checksum: "train_checksum"},
+			nil,
+		).Once()
+		mockCache.On("ResolveChild", dirArt, "data/test.csv").Return(
+			artifact.Artifact{Path: "data/test.csv", Checksum: "new_test_checksum"},
+			nil,
+		).Once()
+
+		outputStatus := make(Status)
+		err := idx.Status("b.yaml", &mockCache, rootDir, outputStatus)
 		if err != nil {
 			t.Fatal(err)
 		}
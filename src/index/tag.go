@@ -0,0 +1,83 @@
+package index
+
+import (
+	"fmt"
+
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/stage"
+	"github.com/kevin-hanselman/dud/src/tag"
+	"github.com/pkg/errors"
+)
+
+// Tag returns a Tag named name that records the committed Outputs of the
+// given targets. Targets may be Stage paths, which tag all of the Stage's
+// cached Outputs, or Output paths. If targets is empty, all Stages are tagged.
+func (idx Index) Tag(name string, targets []string) (tag.Tag, error) {
+	errPrefix := "tag " + name
+	t := tag.Tag{Name: name, Stages: make(map[string]map[string]*artifact.Artifact)}
+	if len(targets) == 0 {
+		targets = idx.SortStagePaths()
+	}
+	for _, target := range targets {
+		var stagePath string
+		var arts []*artifact.Artifact
+		if stg, ok := idx.stages[target]; ok {
+			stagePath = target
+			for _, art := range stg.Outputs {
+				if !art.SkipCache {
+					arts = append(arts, art)
+				}
+			}
+		} else {
+			ownerPath, art := idx.findOwner(target)
+			if ownerPath == "" {
+				return t, fmt.Errorf("%s: %s is not a stage or owned by any stage", errPrefix, target)
+			}
+			if art.Path != target {
+				return t, fmt.Errorf(
+					"%s: %s is inside output %s; only whole outputs can be tagged",
+					errPrefix,
+					target,
+					art.Path,
+				)
+			}
+			if art.SkipCache {
+				return t, fmt.Errorf("%s: %s is not cached", errPrefix, target)
+			}
+			stagePath = ownerPath
+			arts = append(arts, art)
+		}
+		for _, art := range arts {
+			if art.Checksum == "" && !art.Absent {
+				return t, fmt.Errorf("%s: %s is not committed by stage %s", errPrefix, art.Path, stagePath)
+			}
+			if t.Stages[stagePath] == nil {
+				t.Stages[stagePath] = make(map[string]*artifact.Artifact)
+			}
+			artCopy := *art
+			t.Stages[stagePath][art.Path] = &artCopy
+		}
+	}
+	return t, nil
+}
+
+// FromTag returns an Index of the Artifacts recorded in a Tag. The Index
+// holds one Stage for each Stage in the Tag, with only the tagged Outputs.
+func FromTag(t tag.Tag) (Index, error) {
+	idx := New()
+	for stagePath, arts := range t.Stages {
+		stg := stage.Stage{
+			Inputs:  make(map[string]*artifact.Artifact),
+			Outputs: make(map[string]*artifact.Artifact, len(arts)),
+		}
+		for artPath, art := range arts {
+			artCopy := *art
+			artCopy.Path = artPath
+			stg.Outputs[artPath] = &artCopy
+		}
+		if err := idx.AddStage(stg, stagePath); err != nil {
+			return idx, errors.Wrapf(err, "load tag %s", t.Name)
+		}
+	}
+	return idx, nil
+}
@@ -0,0 +1,101 @@
+package index
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/stage"
+)
+
+func TestTag(t *testing.T) {
+	newIndex := func() Index {
+		return newTestIndex(t, map[string]*stage.Stage{
+			"prep.yaml": {
+				Outputs: map[string]*artifact.Artifact{
+					"data":      {Path: "data", IsDir: true, Checksum: "data_checksum"},
+					"stats.txt": {Path: "stats.txt", SkipCache: true},
+				},
+			},
+			"train.yaml": {
+				Inputs: map[string]*artifact.Artifact{
+					"data": {Path: "data", SkipCache: true},
+				},
+				Outputs: map[string]*artifact.Artifact{
+					"model.bin": {Path: "model.bin", Checksum: "model_checksum"},
+					"empty.txt": {Path: "empty.txt"},
+				},
+			},
+		})
+	}
+
+	t.Run("stage", func(t *testing.T) {
+		tg, err := newIndex().Tag("v1", []string{"prep.yaml"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]map[string]*artifact.Artifact{
+			"prep.yaml": {
+				"data": {Path: "data", IsDir: true, Checksum: "data_checksum"},
+			},
+		}
+		if diff := cmp.Diff(want, tg.Stages); diff != "" {
+			t.Fatalf("Tag -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("output", func(t *testing.T) {
+		tg, err := newIndex().Tag("v1", []string{"model.bin"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]map[string]*artifact.Artifact{
+			"train.yaml": {
+				"model.bin": {Path: "model.bin", Checksum: "model_checksum"},
+			},
+		}
+		if diff := cmp.Diff(want, tg.Stages); diff != "" {
+			t.Fatalf("Tag -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("error on uncommitted output", func(t *testing.T) {
+		if _, err := newIndex().Tag("v1", []string{"train.yaml"}); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("error on path inside output", func(t *testing.T) {
+		if _, err := newIndex().Tag("v1", []string{"data/train.csv"}); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("error on unowned artifact", func(t *testing.T) {
+		if _, err := newIndex().Tag("v1", []string{"other.txt"}); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("round trip through FromTag", func(t *testing.T) {
+		tg, err := newIndex().Tag("v1", []string{"prep.yaml", "model.bin"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		idx, err := FromTag(tg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]string{"prep.yaml", "train.yaml"}, idx.SortStagePaths()); diff != "" {
+			t.Fatalf("SortStagePaths -want +got:\n%s", diff)
+		}
+		ownerPath, art := idx.findOwner("data/train.csv")
+		if ownerPath != "prep.yaml" || art.Checksum != "data_checksum" {
+			t.Fatalf("findOwner() = %s, %v", ownerPath, art)
+		}
+		stg, _ := idx.Stage("train.yaml")
+		if len(stg.Inputs) != 0 || len(stg.Outputs) != 1 {
+			t.Fatalf("expected only the tagged output, got %+v", stg)
+		}
+	})
+}
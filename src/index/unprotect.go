@@ -0,0 +1,156 @@
+package index
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/cache"
+	"github.com/kevin-hanselman/dud/src/fsutil"
+	"github.com/kevin-hanselman/dud/src/stage"
+	"github.com/kevin-hanselman/dud/src/strategy"
+	"github.com/pkg/errors"
+)
+
+// cleanOutputs prepares a Stage's outputs for its command to run. Outputs
+// are deleted so that files the command no longer produces don't linger and
+// get committed. Persisted outputs are kept, but their links to the cache are
+// replaced with writable copies; committed outputs are read-only links to the
+// cache, so a command updating them would either fail or write through the
+// links and corrupt the cache. The Stage's working directory is recreated if
+// it was inside a deleted output.
+func cleanOutputs(stg *stage.Stage, rootDir string) error {
+	for artPath, art := range stg.Outputs {
+		workspacePath := filepath.Join(rootDir, artPath)
+		var err error
+		if art.Persist {
+			err = copyLinkTargets(workspacePath)
+		} else {
+			err = removeOutput(workspacePath)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "clean output %s", artPath)
+		}
+	}
+	return os.MkdirAll(filepath.Join(rootDir, stg.WorkingDir), 0o755)
+}
+
+// removeOutput deletes the output at workspacePath, if it exists. RemoveAll
+// doesn't follow symlinks, so outputs linked to the cache are safe to remove.
+func removeOutput(workspacePath string) error {
+	return os.RemoveAll(workspacePath)
+}
+
+// copyLinkTargets replaces every symlink at or below path with a writable
+// copy of the file it points to. Regular files are left alone, and a missing
+// path is not an error.
+func copyLinkTargets(path string) error {
+	return filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		tempPath := path + ".dud-tmp"
+		if err := copyFile(path, tempPath); err != nil {
+			return err
+		}
+		// Renaming over the link replaces the link itself, not its target.
+		return os.Rename(tempPath, path)
+	})
+}
+
+// copyTree copies the file or directory at src to dst, following symlinks.
+// The copies are writable.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, relPath)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		return copyFile(path, target)
+	})
+}
+
+// copyFile copies the contents of the file at src (following symlinks) to a
+// new, writable file at dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Unprotect replaces the cache links of a committed output with writable
+// copies. artPath may be an output of any Stage, or a path inside a directory
+// output. Files that aren't links to their committed versions in the cache
+// (e.g. outputs that are already unprotected) are left alone.
+func (idx Index) Unprotect(artPath string, ch cache.Cache, rootDir string) error {
+	ownerPath, ownerArt := idx.findOwner(artPath)
+	if ownerPath == "" {
+		return errors.Errorf("unprotect %s: not owned by any stage", artPath)
+	}
+	art := *ownerArt
+	if ownerArt.Path != artPath {
+		var err error
+		art, err = ch.ResolveChild(*ownerArt, artPath)
+		if err != nil {
+			return errors.Wrapf(err, "unprotect %s", artPath)
+		}
+	}
+	if art.Checksum == "" {
+		return errors.Errorf("unprotect %s: not committed", artPath)
+	}
+	art.Path = artPath
+	status, err := ch.Status(rootDir, art, false)
+	if err != nil {
+		return errors.Wrapf(err, "unprotect %s", artPath)
+	}
+	return errors.Wrapf(
+		unprotectLinks(ch, rootDir, artPath, &status),
+		"unprotect %s",
+		artPath,
+	)
+}
+
+// unprotectLinks checks out a writable copy of every file in status (at path
+// in the workspace) that is linked to its committed version in the cache.
+func unprotectLinks(ch cache.Cache, rootDir, path string, status *artifact.Status) error {
+	if status.IsDir {
+		for name, childStatus := range status.ChildrenStatus {
+			if err := unprotectLinks(ch, rootDir, filepath.Join(path, name), childStatus); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if status.WorkspaceFileStatus != fsutil.StatusLink || !status.ContentsMatch {
+		return nil
+	}
+	art := status.Artifact
+	art.Path = path
+	return ch.Checkout(rootDir, art, strategy.CopyStrategy, nil)
+}
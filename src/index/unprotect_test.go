@@ -0,0 +1,187 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/fsutil"
+	"github.com/kevin-hanselman/dud/src/mocks"
+	"github.com/kevin-hanselman/dud/src/stage"
+	"github.com/kevin-hanselman/dud/src/strategy"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCleanOutputsIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	rootDir := t.TempDir()
+	target := filepath.Join(rootDir, "cached")
+	if err := os.WriteFile(target, []byte("cached"), 0o444); err != nil {
+		t.Fatal(err)
+	}
+	mustLink := func(path string) {
+		path = filepath.Join(rootDir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustLink("linked.bin")
+	mustLink("dir/sub/linked.bin")
+	mustLink("ckpt/sub/linked.bin")
+	for _, path := range []string{"dir/regular.bin", "ckpt/regular.bin"} {
+		if err := os.WriteFile(filepath.Join(rootDir, path), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stg := stage.Stage{
+		WorkingDir: "dir/sub",
+		Outputs: map[string]*artifact.Artifact{
+			"linked.bin":  {Path: "linked.bin"},
+			"dir":         {Path: "dir", IsDir: true},
+			"ckpt":        {Path: "ckpt", IsDir: true, Persist: true},
+			"missing.bin": {Path: "missing.bin", Persist: true},
+		},
+	}
+	if err := cleanOutputs(&stg, rootDir); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]fsutil.FileStatus{
+		"linked.bin":          fsutil.StatusAbsent,
+		"dir/regular.bin":     fsutil.StatusAbsent,
+		"dir/sub":             fsutil.StatusDirectory,
+		"dir/sub/linked.bin":  fsutil.StatusAbsent,
+		"ckpt/regular.bin":    fsutil.StatusRegularFile,
+		"ckpt/sub/linked.bin": fsutil.StatusRegularFile,
+		"missing.bin":         fsutil.StatusAbsent,
+		"cached":              fsutil.StatusRegularFile,
+	} {
+		got, err := fsutil.FileStatusFromPath(filepath.Join(rootDir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("%s: status = %s, want %s", path, got, want)
+		}
+	}
+
+	copied := filepath.Join(rootDir, "ckpt", "sub", "linked.bin")
+	contents, err := os.ReadFile(copied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "cached" {
+		t.Fatalf("%s: contents = %q, want %q", copied, contents, "cached")
+	}
+	if err := os.WriteFile(copied, []byte("updated"), 0o644); err != nil {
+		t.Fatalf("persisted output is not writable: %v", err)
+	}
+}
+
+func TestUnprotect(t *testing.T) {
+	rootDir := "project/root"
+	newIndex := func(t *testing.T) Index {
+		return newTestIndex(t, map[string]*stage.Stage{
+			"foo.yaml": {
+				Outputs: map[string]*artifact.Artifact{
+					"model.bin": {Path: "model.bin", Checksum: "model_checksum"},
+					"data":      {Path: "data", IsDir: true, Checksum: "data_checksum"},
+				},
+			},
+			"bar.yaml": {
+				Outputs: map[string]*artifact.Artifact{
+					"new.bin": {Path: "new.bin"},
+				},
+			},
+		})
+	}
+
+	t.Run("output", func(t *testing.T) {
+		mockCache := mocks.Cache{}
+		art := artifact.Artifact{Path: "data", IsDir: true, Checksum: "data_checksum"}
+		linked := artifact.Artifact{Path: "train.csv", Checksum: "train_checksum"}
+		copied := artifact.Artifact{Path: "test.csv", Checksum: "test_checksum"}
+		status := artifact.Status{
+			Artifact:            art,
+			WorkspaceFileStatus: fsutil.StatusDirectory,
+			ChildrenStatus: map[string]*artifact.Status{
+				"train.csv": {
+					Artifact:            linked,
+					WorkspaceFileStatus: fsutil.StatusLink,
+					ContentsMatch:       true,
+				},
+				"test.csv": {
+					Artifact:            copied,
+					WorkspaceFileStatus: fsutil.StatusRegularFile,
+					ContentsMatch:       true,
+				},
+			},
+		}
+		mockCache.On("Status", rootDir, art, false).Return(status, nil)
+		linked.Path = "data/train.csv"
+		mockCache.On("Checkout", rootDir, linked, strategy.CopyStrategy, mock.AnythingOfType("*pb.ProgressBar")).Return(nil)
+
+		if err := newIndex(t).Unprotect("data", &mockCache, rootDir); err != nil {
+			t.Fatal(err)
+		}
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("file inside directory output", func(t *testing.T) {
+		mockCache := mocks.Cache{}
+		dirArt := artifact.Artifact{Path: "data", IsDir: true, Checksum: "data_checksum"}
+		child := artifact.Artifact{Path: "data/train.csv", Checksum: "train_checksum"}
+		mockCache.On("ResolveChild", dirArt, "data/train.csv").Return(child, nil)
+		status := artifact.Status{
+			Artifact:            child,
+			WorkspaceFileStatus: fsutil.StatusLink,
+			ContentsMatch:       true,
+		}
+		mockCache.On("Status", rootDir, child, false).Return(status, nil)
+		mockCache.On("Checkout", rootDir, child, strategy.CopyStrategy, mock.AnythingOfType("*pb.ProgressBar")).Return(nil)
+
+		if err := newIndex(t).Unprotect("data/train.csv", &mockCache, rootDir); err != nil {
+			t.Fatal(err)
+		}
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("skip outputs that are not cache links", func(t *testing.T) {
+		for _, fileStatus := range []fsutil.FileStatus{fsutil.StatusRegularFile, fsutil.StatusLink} {
+			mockCache := mocks.Cache{}
+			art := artifact.Artifact{Path: "model.bin", Checksum: "model_checksum"}
+			status := artifact.Status{
+				Artifact:            art,
+				WorkspaceFileStatus: fileStatus,
+			}
+			mockCache.On("Status", rootDir, art, false).Return(status, nil)
+
+			if err := newIndex(t).Unprotect("model.bin", &mockCache, rootDir); err != nil {
+				t.Fatal(err)
+			}
+			mockCache.AssertExpectations(t)
+		}
+	})
+
+	t.Run("error on unowned artifact", func(t *testing.T) {
+		mockCache := mocks.Cache{}
+		if err := newIndex(t).Unprotect("other.bin", &mockCache, rootDir); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("error on uncommitted output", func(t *testing.T) {
+		mockCache := mocks.Cache{}
+		if err := newIndex(t).Unprotect("new.bin", &mockCache, rootDir); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
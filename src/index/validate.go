@@ -0,0 +1,60 @@
+package index
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/stage"
+	"github.com/pkg/errors"
+)
+
+// validateOutputs runs the validation command of each of the Stage's outputs
+// in the Stage's working directory. The output's path, relative to the
+// working directory, is passed to the command in the DUD_OUTPUT environment
+// variable. Optional outputs that don't exist aren't validated. An error is
+// returned for the first output whose validation command fails.
+func validateOutputs(
+	stagePath string,
+	stg *stage.Stage,
+	rootDir string,
+	logger *agglog.AggLogger,
+) error {
+	artPaths := make([]string, 0, len(stg.Outputs))
+	for artPath, art := range stg.Outputs {
+		if art.Validate != "" {
+			artPaths = append(artPaths, artPath)
+		}
+	}
+	sort.Strings(artPaths)
+	for _, artPath := range artPaths {
+		art := stg.Outputs[artPath]
+		errPrefix := fmt.Sprintf("stage %s: output %s", stagePath, artPath)
+		if art.Optional {
+			_, err := os.Lstat(filepath.Join(rootDir, artPath))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return errors.Wrap(err, errPrefix)
+			}
+		}
+		relPath, err := filepath.Rel(filepath.Clean(stg.WorkingDir), artPath)
+		if err != nil {
+			return errors.Wrap(err, errPrefix)
+		}
+		cmd := exec.Command("sh", "-c", art.Validate)
+		cmd.Dir = filepath.Join(rootDir, stg.WorkingDir)
+		cmd.Env = append(os.Environ(), "DUD_OUTPUT="+relPath)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		logger.Debug.Printf("(in %s) %s\n", cmd.Dir, art.Validate)
+		if err := runCommand(cmd); err != nil {
+			return errors.Wrapf(err, "%s: validation failed", errPrefix)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/agglog"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/stage"
+)
+
+func TestValidateOutputsIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logger := agglog.NewNullLogger()
+
+	setup := func(t *testing.T) string {
+		rootDir := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(rootDir, "sub"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for path, contents := range map[string]string{
+			"data.csv":  "id,value\n1,2\n",
+			"empty.csv": "",
+		} {
+			if err := os.WriteFile(filepath.Join(rootDir, path), []byte(contents), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rootDir
+	}
+
+	t.Run("passing validations", func(t *testing.T) {
+		rootDir := setup(t)
+		stg := stage.Stage{
+			WorkingDir: "sub",
+			Outputs: map[string]*artifact.Artifact{
+				"data.csv": {
+					Path:     "data.csv",
+					Validate: `test "$DUD_OUTPUT" = ../data.csv && head -n1 "$DUD_OUTPUT" | grep -q '^id,value$'`,
+				},
+				"missing.csv": {
+					Path:     "missing.csv",
+					Optional: true,
+					Validate: "false",
+				},
+				"empty.csv": {Path: "empty.csv"},
+			},
+		}
+		if err := validateOutputs("foo.yaml", &stg, rootDir, logger); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("error on failing validation", func(t *testing.T) {
+		rootDir := setup(t)
+		stg := stage.Stage{
+			Outputs: map[string]*artifact.Artifact{
+				"data.csv":  {Path: "data.csv", Validate: `test -s "$DUD_OUTPUT"`},
+				"empty.csv": {Path: "empty.csv", Validate: `test -s "$DUD_OUTPUT"`},
+			},
+		}
+		err := validateOutputs("foo.yaml", &stg, rootDir, logger)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		wantErr := "stage foo.yaml: output empty.csv: validation failed: exit status 1"
+		if diff := cmp.Diff(wantErr, err.Error()); diff != "" {
+			t.Fatalf("error -want +got:\n%s", diff)
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package index
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/stage"
+)
+
+// fileStamp is the cheap fingerprint of a file used to detect writes.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// workspaceSnapshot maps every file in the workspace (relative to the project
+// root) to its fileStamp.
+type workspaceSnapshot map[string]fileStamp
+
+// snapshotWorkspace records the size and modification time of every file
+// under rootDir, ignoring the .dud directory. Symlinks are not followed.
+func snapshotWorkspace(rootDir string) (workspaceSnapshot, error) {
+	snapshot := make(workspaceSnapshot)
+	err := filepath.WalkDir(rootDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(rootDir, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if relPath == ".dud" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		snapshot[relPath] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	return snapshot, err
+}
+
+type undeclaredWritesError struct {
+	stagePath string
+	created   []string
+	modified  []string
+}
+
+func (e undeclaredWritesError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "stage %s wrote outside its declared outputs", e.stagePath)
+	if len(e.created) > 0 {
+		fmt.Fprintf(&b, "\n  created: %s", strings.Join(e.created, ", "))
+	}
+	if len(e.modified) > 0 {
+		fmt.Fprintf(&b, "\n  modified: %s", strings.Join(e.modified, ", "))
+	}
+	return b.String()
+}
+
+// undeclaredWrites compares workspace snapshots taken before and after
+// running a Stage's command. It returns an undeclaredWritesError if the command
+// created or modified any files other than the Stage's outputs (including the
+// contents of directory outputs) and the contents of directory outputs that
+// own the Stage's inputs. Otherwise, it returns nil.
+func (idx Index) undeclaredWrites(
+	stagePath string,
+	stg *stage.Stage,
+	before workspaceSnapshot,
+	after workspaceSnapshot,
+) error {
+	inputOwnerDirs := make(map[string]*artifact.Artifact)
+	for artPath := range stg.Inputs {
+		if _, ownerArt := idx.findOwner(artPath); ownerArt != nil && ownerArt.IsDir {
+			inputOwnerDirs[ownerArt.Path] = ownerArt
+		}
+	}
+	allowed := func(path string) bool {
+		if _, ok := stg.Outputs[path]; ok {
+			return true
+		}
+		if _, ok := stage.FindDirArtifactOwnerForPath(path, stg.Outputs); ok {
+			return true
+		}
+		_, ok := stage.FindDirArtifactOwnerForPath(path, inputOwnerDirs)
+		return ok
+	}
+
+	writesErr := undeclaredWritesError{stagePath: stagePath}
+	for path, stamp := range after {
+		if allowed(path) {
+			continue
+		}
+		oldStamp, existed := before[path]
+		if !existed {
+			writesErr.created = append(writesErr.created, path)
+		} else if stamp.size != oldStamp.size || !stamp.modTime.Equal(oldStamp.modTime) {
+			writesErr.modified = append(writesErr.modified, path)
+		}
+	}
+	if len(writesErr.created) == 0 && len(writesErr.modified) == 0 {
+		return nil
+	}
+	sort.Strings(writesErr.created)
+	sort.Strings(writesErr.modified)
+	return writesErr
+}
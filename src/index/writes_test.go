@@ -0,0 +1,118 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/stage"
+)
+
+func TestUndeclaredWrites(t *testing.T) {
+	stgA := stage.Stage{
+		Outputs: map[string]*artifact.Artifact{
+			"data": {Path: "data", IsDir: true},
+		},
+	}
+	stgB := stage.Stage{
+		Inputs: map[string]*artifact.Artifact{
+			"data/train.csv": {Path: "data/train.csv"},
+		},
+		Outputs: map[string]*artifact.Artifact{
+			"model.bin": {Path: "model.bin"},
+			"metrics":   {Path: "metrics", IsDir: true},
+		},
+	}
+	stgC := stage.Stage{
+		Outputs: map[string]*artifact.Artifact{
+			"other.bin": {Path: "other.bin"},
+		},
+	}
+	idx := newTestIndex(t, map[string]*stage.Stage{
+		"a.yaml": &stgA,
+		"b.yaml": &stgB,
+		"c.yaml": &stgC,
+	})
+
+	then := time.Unix(1000, 0)
+	now := time.Unix(2000, 0)
+	before := workspaceSnapshot{
+		"model.bin":      {size: 10, modTime: then},
+		"data/train.csv": {size: 10, modTime: then},
+		"other.bin":      {size: 10, modTime: then},
+		"notes.txt":      {size: 10, modTime: then},
+		"params.yaml":    {size: 10, modTime: then},
+	}
+
+	t.Run("declared writes are allowed", func(t *testing.T) {
+		after := workspaceSnapshot{
+			"model.bin":       {size: 20, modTime: now},
+			"metrics/acc.txt": {size: 1, modTime: now},
+			"data/train.csv":  {size: 20, modTime: now},
+			"data/extra.csv":  {size: 1, modTime: now},
+			"other.bin":       {size: 10, modTime: then},
+			"notes.txt":       {size: 10, modTime: then},
+			"params.yaml":     {size: 10, modTime: then},
+		}
+		if err := idx.undeclaredWrites("b.yaml", &stgB, before, after); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("undeclared writes are reported", func(t *testing.T) {
+		after := workspaceSnapshot{
+			"model.bin":      {size: 20, modTime: now},
+			"data/train.csv": {size: 10, modTime: then},
+			"other.bin":      {size: 10, modTime: now},
+			"notes.txt":      {size: 11, modTime: then},
+			"params.yaml":    {size: 10, modTime: then},
+			"scratch.tmp":    {size: 1, modTime: now},
+		}
+		err := idx.undeclaredWrites("b.yaml", &stgB, before, after)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		wantErr := "stage b.yaml wrote outside its declared outputs\n" +
+			"  created: scratch.tmp\n" +
+			"  modified: notes.txt, other.bin"
+		if diff := cmp.Diff(wantErr, err.Error()); diff != "" {
+			t.Fatalf("error -want +got:\n%s", diff)
+		}
+	})
+}
+
+func TestSnapshotWorkspaceIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+	rootDir := t.TempDir()
+	for _, path := range []string{"foo.txt", "sub/bar.txt", ".dud/cache/ignored"} {
+		fullPath := filepath.Join(rootDir, path)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fullPath, []byte(path), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshot, err := snapshotWorkspace(rootDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for path, stamp := range snapshot {
+		got = append(got, path)
+		if stamp.size != int64(len(path)) {
+			t.Fatalf("%s: size = %d, want %d", path, stamp.size, len(path))
+		}
+	}
+	sort.Strings(got)
+	if diff := cmp.Diff([]string{"foo.txt", "sub/bar.txt"}, got); diff != "" {
+		t.Fatalf("paths -want +got:\n%s", diff)
+	}
+}
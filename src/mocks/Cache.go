@@ -46,6 +46,96 @@ func (_m *Cache) Commit(workDir string, art *artifact.Artifact, s strategy.Check
 	return r0
 }
 
+// CommitObject provides a mock function with given fields: data
+func (_m *Cache) CommitObject(data []byte) (string, error) {
+	ret := _m.Called(data)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func([]byte) string); ok {
+		r0 = rf(data)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func([]byte) error); ok {
+		r1 = rf(data)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Diff provides a mock function with given fields: workDir, art
+func (_m *Cache) Diff(workDir string, art artifact.Artifact) ([]artifact.FileDiff, error) {
+	ret := _m.Called(workDir, art)
+
+	var r0 []artifact.FileDiff
+	if rf, ok := ret.Get(0).(func(string, artifact.Artifact) []artifact.FileDiff); ok {
+		r0 = rf(workDir, art)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]artifact.FileDiff)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, artifact.Artifact) error); ok {
+		r1 = rf(workDir, art)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DiffCommitted provides a mock function with given fields: oldArt, newArt
+func (_m *Cache) DiffCommitted(oldArt *artifact.Artifact, newArt *artifact.Artifact) ([]artifact.FileDiff, error) {
+	ret := _m.Called(oldArt, newArt)
+
+	var r0 []artifact.FileDiff
+	if rf, ok := ret.Get(0).(func(*artifact.Artifact, *artifact.Artifact) []artifact.FileDiff); ok {
+		r0 = rf(oldArt, newArt)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]artifact.FileDiff)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(*artifact.Artifact, *artifact.Artifact) error); ok {
+		r1 = rf(oldArt, newArt)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DirFiles provides a mock function with given fields: dirArt
+func (_m *Cache) DirFiles(dirArt artifact.Artifact) (map[string]artifact.Artifact, error) {
+	ret := _m.Called(dirArt)
+
+	var r0 map[string]artifact.Artifact
+	if rf, ok := ret.Get(0).(func(artifact.Artifact) map[string]artifact.Artifact); ok {
+		r0 = rf(dirArt)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[string]artifact.Artifact)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(artifact.Artifact) error); ok {
+		r1 = rf(dirArt)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Fetch provides a mock function with given fields: remoteSrc, arts
 func (_m *Cache) Fetch(remoteSrc string, arts map[string]*artifact.Artifact) error {
 	ret := _m.Called(remoteSrc, arts)
@@ -60,6 +150,20 @@ func (_m *Cache) Fetch(remoteSrc string, arts map[string]*artifact.Artifact) err
 	return r0
 }
 
+// FetchManifests provides a mock function with given fields: remoteSrc, arts
+func (_m *Cache) FetchManifests(remoteSrc string, arts map[string]*artifact.Artifact) error {
+	ret := _m.Called(remoteSrc, arts)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, map[string]*artifact.Artifact) error); ok {
+		r0 = rf(remoteSrc, arts)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Push provides a mock function with given fields: remoteDst, arts
 func (_m *Cache) Push(remoteDst string, arts map[string]*artifact.Artifact) error {
 	ret := _m.Called(remoteDst, arts)
@@ -74,6 +178,50 @@ func (_m *Cache) Push(remoteDst string, arts map[string]*artifact.Artifact) erro
 	return r0
 }
 
+// ReadObject provides a mock function with given fields: checksum
+func (_m *Cache) ReadObject(checksum string) ([]byte, error) {
+	ret := _m.Called(checksum)
+
+	var r0 []byte
+	if rf, ok := ret.Get(0).(func(string) []byte); ok {
+		r0 = rf(checksum)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]byte)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(checksum)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ResolveChild provides a mock function with given fields: dirArt, path
+func (_m *Cache) ResolveChild(dirArt artifact.Artifact, path string) (artifact.Artifact, error) {
+	ret := _m.Called(dirArt, path)
+
+	var r0 artifact.Artifact
+	if rf, ok := ret.Get(0).(func(artifact.Artifact, string) artifact.Artifact); ok {
+		r0 = rf(dirArt, path)
+	} else {
+		r0 = ret.Get(0).(artifact.Artifact)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(artifact.Artifact, string) error); ok {
+		r1 = rf(dirArt, path)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Status provides a mock function with given fields: workDir, art, shortCircuit
 func (_m *Cache) Status(workDir string, art artifact.Artifact, shortCircuit bool) (artifact.Status, error) {
 	ret := _m.Called(workDir, art, shortCircuit)
@@ -95,6 +243,34 @@ func (_m *Cache) Status(workDir string, art artifact.Artifact, shortCircuit bool
 	return r0, r1
 }
 
+// Verify provides a mock function with given fields: remoteDst, arts
+func (_m *Cache) Verify(remoteDst string, arts map[string]*artifact.Artifact) error {
+	ret := _m.Called(remoteDst, arts)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, map[string]*artifact.Artifact) error); ok {
+		r0 = rf(remoteDst, arts)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// VerifyLocal provides a mock function with given fields: arts
+func (_m *Cache) VerifyLocal(arts map[string]*artifact.Artifact) error {
+	ret := _m.Called(arts)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(map[string]*artifact.Artifact) error); ok {
+		r0 = rf(arts)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 type mockConstructorTestingTNewCache interface {
 	mock.TestingT
 	Cleanup(func())
@@ -0,0 +1,186 @@
+// Package snapshot records the committed state of a whole project, so it can
+// be restored later.
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kevin-hanselman/dud/src/cache"
+	"github.com/kevin-hanselman/dud/src/index"
+	"github.com/kevin-hanselman/dud/src/stage"
+	"github.com/pkg/errors"
+)
+
+// A Snapshot holds the contents of every Stage file in an Index, and thus
+// every Stage's checksum and Output checksums. Snapshots are stored in the
+// cache as content-addressed objects.
+type Snapshot struct {
+	Created time.Time `json:"created"`
+	Message string    `json:"message,omitempty"`
+	// Stages maps Stage paths to the contents of their Stage files.
+	Stages map[string]string `json:"stages"`
+}
+
+// New returns a Snapshot of the Stages in the Index.
+func New(idx index.Index, message string, created time.Time) (Snapshot, error) {
+	snap := Snapshot{
+		Created: created.UTC(),
+		Message: message,
+		Stages:  make(map[string]string, idx.Len()),
+	}
+	for _, stagePath := range idx.SortStagePaths() {
+		stg, _ := idx.Stage(stagePath)
+		var buf bytes.Buffer
+		if err := stg.Serialize(&buf); err != nil {
+			return snap, errors.Wrapf(err, "snapshot stage %s", stagePath)
+		}
+		snap.Stages[stagePath] = buf.String()
+	}
+	return snap, nil
+}
+
+// Index returns an Index of the Stages in the Snapshot.
+func (snap Snapshot) Index() (index.Index, error) {
+	idx := index.New()
+	for stagePath, contents := range snap.Stages {
+		stg, err := stage.FromBytes(stagePath, []byte(contents))
+		if err != nil {
+			return idx, errors.Wrap(err, "load snapshot")
+		}
+		if err := idx.AddStage(stg, stagePath); err != nil {
+			return idx, errors.Wrap(err, "load snapshot")
+		}
+	}
+	return idx, errors.Wrap(idx.Validate(), "load snapshot")
+}
+
+// Save commits the Snapshot to the cache and returns its checksum, which
+// identifies the Snapshot.
+func (snap Snapshot) Save(ch cache.Cache) (string, error) {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return "", errors.Wrap(err, "save snapshot")
+	}
+	cksum, err := ch.CommitObject(data)
+	return cksum, errors.Wrap(err, "save snapshot")
+}
+
+// Load reads the Snapshot with the given checksum from the cache.
+func Load(ch cache.Cache, checksum string) (snap Snapshot, err error) {
+	errPrefix := "load snapshot " + checksum
+	data, err := ch.ReadObject(checksum)
+	if err != nil {
+		return snap, errors.Wrap(err, errPrefix)
+	}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return snap, errors.Wrap(err, errPrefix)
+	}
+	return snap, nil
+}
+
+// checksumLength is the length of a full checksum in hexadecimal.
+const checksumLength = 64
+
+// An Entry describes a saved Snapshot in the snapshot log.
+type Entry struct {
+	Checksum string
+	Created  time.Time
+	Message  string
+}
+
+// AppendLog adds an entry for the Snapshot with the given checksum to the log
+// file at path, creating the file if needed. Each line of the log holds the
+// checksum, creation time, and message of a Snapshot, separated by tabs.
+func AppendLog(path, checksum string, snap Snapshot) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return errors.Wrap(err, "append to snapshot log")
+	}
+	defer file.Close()
+	// Keep each entry on one line.
+	message := strings.Join(strings.Fields(snap.Message), " ")
+	_, err = fmt.Fprintf(
+		file,
+		"%s\t%s\t%s\n",
+		checksum,
+		snap.Created.Format(time.RFC3339),
+		message,
+	)
+	return errors.Wrap(err, "append to snapshot log")
+}
+
+// ReadLog returns the entries in the log file at path, oldest first. If the
+// file doesn't exist, there are no entries.
+func ReadLog(path string) ([]Entry, error) {
+	errPrefix := "read snapshot log"
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, errPrefix)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 || len(fields[0]) != checksumLength {
+			return nil, fmt.Errorf("%s: line %d: malformed entry", errPrefix, lineNum)
+		}
+		created, err := time.Parse(time.RFC3339, fields[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: line %d", errPrefix, lineNum)
+		}
+		entries = append(entries, Entry{
+			Checksum: fields[0],
+			Created:  created,
+			Message:  fields[2],
+		})
+	}
+	return entries, errors.Wrap(scanner.Err(), errPrefix)
+}
+
+// Resolve returns the checksum of the Snapshot in entries identified by id,
+// which is a checksum or a unique prefix of one. The id "latest" names the
+// latest Snapshot. A full checksum is returned as-is, even if it isn't in
+// entries, as the Snapshot may have been saved elsewhere and pushed.
+func Resolve(entries []Entry, id string) (string, error) {
+	if id == "latest" {
+		if len(entries) == 0 {
+			return "", errors.New("no snapshots saved")
+		}
+		return entries[len(entries)-1].Checksum, nil
+	}
+	if id == "" {
+		return "", errors.New("empty snapshot id")
+	}
+	var match string
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Checksum, id) || entry.Checksum == match {
+			continue
+		}
+		if match != "" {
+			return "", fmt.Errorf("snapshot id %s is ambiguous", id)
+		}
+		match = entry.Checksum
+	}
+	if match == "" && len(id) == checksumLength {
+		return id, nil
+	}
+	if match == "" {
+		return "", fmt.Errorf("no snapshot matches %s", id)
+	}
+	return match, nil
+}
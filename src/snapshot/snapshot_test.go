@@ -0,0 +1,148 @@
+package snapshot
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/kevin-hanselman/dud/src/cache"
+	"github.com/kevin-hanselman/dud/src/index"
+	"github.com/kevin-hanselman/dud/src/stage"
+)
+
+func TestSnapshotIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	idx := index.New()
+	stages := map[string]stage.Stage{
+		"prep.yaml": {
+			Checksum:   "prep_checksum",
+			Command:    "./prep.sh",
+			WorkingDir: ".",
+			Inputs: map[string]*artifact.Artifact{
+				"raw": {Path: "raw", IsDir: true, SkipCache: true},
+			},
+			Outputs: map[string]*artifact.Artifact{
+				"data": {Path: "data", IsDir: true, Checksum: "data_checksum"},
+			},
+		},
+		"train.yaml": {
+			Command:    "./train.sh",
+			WorkingDir: ".",
+			Inputs: map[string]*artifact.Artifact{
+				"data": {Path: "data", IsDir: true, SkipCache: true},
+			},
+			Outputs: map[string]*artifact.Artifact{
+				"model.bin": {Path: "model.bin", Checksum: "model_checksum"},
+			},
+		},
+	}
+	for path, stg := range stages {
+		if err := idx.AddStage(stg, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	created := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	snap, err := New(idx, "before refactor", created)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch, err := cache.NewLocalCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cksum, err := snap.Save(ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(ch, cksum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(snap, loaded); diff != "" {
+		t.Fatalf("Load -want +got:\n%s", diff)
+	}
+
+	restored, err := loaded.Index()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range stages {
+		got, ok := restored.Stage(path)
+		if !ok {
+			t.Fatalf("stage %s missing from restored index", path)
+		}
+		if diff := cmp.Diff(want, *got); diff != "" {
+			t.Fatalf("stage %s -want +got:\n%s", path, diff)
+		}
+	}
+}
+
+func TestLogIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	logPath := filepath.Join(t.TempDir(), "snapshots")
+
+	entries, err := ReadLog(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected no entries, got %v", entries)
+	}
+
+	created := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	first := "abc1111111111111111111111111111111111111111111111111111111111111"
+	second := "abd2222222222222222222222222222222222222222222222222222222222222"
+	if err := AppendLog(logPath, first, Snapshot{Created: created, Message: "first\nline"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := AppendLog(logPath, second, Snapshot{Created: created.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err = ReadLog(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{
+		{Checksum: first, Created: created, Message: "first line"},
+		{Checksum: second, Created: created.Add(time.Hour)},
+	}
+	if diff := cmp.Diff(want, entries); diff != "" {
+		t.Fatalf("ReadLog -want +got:\n%s", diff)
+	}
+
+	t.Run("resolve", func(t *testing.T) {
+		tests := map[string]string{
+			"abc":    first,
+			"abd2":   second,
+			"latest": second,
+			first:    first,
+			// Full checksums needn't be in the log.
+			"fff4444444444444444444444444444444444444444444444444444444444444": "fff4444444444444444444444444444444444444444444444444444444444444",
+		}
+		for id, want := range tests {
+			got, err := Resolve(entries, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Fatalf("Resolve(%#v) = %#v, want %#v", id, got, want)
+			}
+		}
+		for _, id := range []string{"ab", "fff", ""} {
+			if _, err := Resolve(entries, id); err == nil {
+				t.Fatalf("Resolve(%#v): expected error", id)
+			}
+		}
+	})
+}
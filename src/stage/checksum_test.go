@@ -133,6 +133,26 @@ func TestCalculateChecksum(t *testing.T) {
 		}
 	})
 
+	t.Run("output artifact absence should not affect checksum", func(t *testing.T) {
+		stg := newStage()
+		stg.Outputs["foo.txt"].Optional = true
+		originalChecksum, err := stg.CalculateChecksum()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stg.Outputs["foo.txt"].Checksum = ""
+		stg.Outputs["foo.txt"].Absent = true
+
+		newChecksum, err := stg.CalculateChecksum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(originalChecksum, newChecksum); diff != "" {
+			t.Fatalf("CalculateChecksum -want +got:\n%s", diff)
+		}
+	})
+
 	t.Run("artifact flags should affect checksum", func(t *testing.T) {
 		stg := newStage()
 		originalChecksum, err := stg.CalculateChecksum()
@@ -150,4 +170,79 @@ func TestCalculateChecksum(t *testing.T) {
 			t.Fatal("changing stage.Inputs should have affected checksum")
 		}
 	})
+
+	t.Run("env-deps should affect checksum", func(t *testing.T) {
+		stg := newStage()
+		originalChecksum, err := stg.CalculateChecksum()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stg.EnvDeps = []string{"python --version"}
+
+		newChecksum, err := stg.CalculateChecksum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if originalChecksum == newChecksum {
+			t.Fatal("changing stage.EnvDeps should have affected checksum")
+		}
+	})
+
+	t.Run("env state should not affect checksum", func(t *testing.T) {
+		stg := newStage()
+		stg.EnvDeps = []string{"python --version"}
+		originalChecksum, err := stg.CalculateChecksum()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stg.EnvState = map[string]EnvProbe{
+			"python --version": {Checksum: "123456789", Output: "Python 3.9.1"},
+		}
+
+		newChecksum, err := stg.CalculateChecksum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(originalChecksum, newChecksum); diff != "" {
+			t.Fatalf("CalculateChecksum -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("frozen flag should not affect checksum", func(t *testing.T) {
+		stg := newStage()
+		originalChecksum, err := stg.CalculateChecksum()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stg.Frozen = true
+
+		newChecksum, err := stg.CalculateChecksum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(originalChecksum, newChecksum); diff != "" {
+			t.Fatalf("CalculateChecksum -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("always-run flag should affect checksum", func(t *testing.T) {
+		stg := newStage()
+		originalChecksum, err := stg.CalculateChecksum()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stg.AlwaysRun = true
+
+		newChecksum, err := stg.CalculateChecksum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if originalChecksum == newChecksum {
+			t.Fatal("changing stage.AlwaysRun should have affected checksum")
+		}
+	})
 }
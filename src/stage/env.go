@@ -0,0 +1,110 @@
+package stage
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/kevin-hanselman/dud/src/checksum"
+	"github.com/pkg/errors"
+)
+
+// maxProbeOutputLen is the length limit for probe output to be recorded
+// verbatim in an EnvProbe. Longer output is only recorded by its checksum.
+const maxProbeOutputLen = 100
+
+// An EnvProbe records the output of an environment dependency's probe command.
+type EnvProbe struct {
+	// Checksum is the checksum of the probe command's combined stdout and
+	// stderr.
+	Checksum string
+	// Output is the probe command's output, if it is a single short line.
+	// It is only used for reporting changes in a human-friendly way.
+	Output string `yaml:",omitempty"`
+}
+
+// String returns the probe's output, if recorded, and otherwise the
+// probe's checksum.
+func (probe EnvProbe) String() string {
+	if probe.Output != "" {
+		return probe.Output
+	}
+	if probe.Checksum == "" {
+		return "<none>"
+	}
+	return "checksum " + probe.Checksum
+}
+
+// EnvDepStatus compares the recorded and current output of an environment
+// dependency's probe command.
+type EnvDepStatus struct {
+	Recorded EnvProbe
+	Current  EnvProbe
+}
+
+// Matches returns true if the probe's current output matches its recorded
+// output.
+func (status EnvDepStatus) Matches() bool {
+	return status.Recorded.Checksum != "" && status.Recorded.Checksum == status.Current.Checksum
+}
+
+func (status EnvDepStatus) String() string {
+	if status.Matches() {
+		return "up-to-date"
+	}
+	if status.Recorded.Checksum == "" {
+		return "not recorded"
+	}
+	return fmt.Sprintf("changed: %s -> %s", status.Recorded, status.Current)
+}
+
+// ProbeEnv runs the Stage's environment dependency probes in the Stage's
+// working directory and returns their EnvProbes keyed by probe command. A
+// probe that exits with an error is not itself an error; the exit status is
+// simply recorded as part of its output.
+func (stg Stage) ProbeEnv(rootDir string) (map[string]EnvProbe, error) {
+	if len(stg.EnvDeps) == 0 {
+		return nil, nil
+	}
+	probes := make(map[string]EnvProbe, len(stg.EnvDeps))
+	for _, probeCmd := range stg.EnvDeps {
+		cmd := exec.Command("sh", "-c", probeCmd)
+		cmd.Dir = filepath.Join(rootDir, stg.WorkingDir)
+		out, err := cmd.CombinedOutput()
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			out = append(out, fmt.Sprintf("(%s)", exitErr)...)
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "env-dep %q", probeCmd)
+		}
+		probe := EnvProbe{}
+		probe.Checksum, err = checksum.Checksum(bytes.NewReader(out))
+		if err != nil {
+			return nil, errors.Wrapf(err, "env-dep %q", probeCmd)
+		}
+		trimmed := strings.TrimSpace(string(out))
+		if len(trimmed) <= maxProbeOutputLen && !strings.Contains(trimmed, "\n") {
+			probe.Output = trimmed
+		}
+		probes[probeCmd] = probe
+	}
+	return probes, nil
+}
+
+// EnvStatus returns an EnvDepStatus for each of the Stage's environment
+// dependencies, comparing the recorded probe output with the current output.
+func (stg Stage) EnvStatus(rootDir string) (map[string]EnvDepStatus, error) {
+	current, err := stg.ProbeEnv(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make(map[string]EnvDepStatus, len(current))
+	for probeCmd, probe := range current {
+		statuses[probeCmd] = EnvDepStatus{
+			Recorded: stg.EnvState[probeCmd],
+			Current:  probe,
+		}
+	}
+	return statuses, nil
+}
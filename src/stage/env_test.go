@@ -0,0 +1,97 @@
+package stage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/checksum"
+)
+
+func TestProbeEnvIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	mustChecksum := func(s string) string {
+		sum, err := checksum.Checksum(bytes.NewBufferString(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sum
+	}
+
+	stg := Stage{
+		EnvDeps: []string{
+			"echo tool 1.2.3",
+			"printf 'a==1\\nb==2\\n'",
+			"echo oops >&2; exit 3",
+		},
+	}
+	probes, err := stg.ProbeEnv(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]EnvProbe{
+		"echo tool 1.2.3": {
+			Checksum: mustChecksum("tool 1.2.3\n"),
+			Output:   "tool 1.2.3",
+		},
+		"printf 'a==1\\nb==2\\n'": {
+			Checksum: mustChecksum("a==1\nb==2\n"),
+		},
+		// A failing probe records its exit status.
+		"echo oops >&2; exit 3": {
+			Checksum: mustChecksum("oops\n(exit status 3)"),
+		},
+	}
+	if diff := cmp.Diff(want, probes); diff != "" {
+		t.Fatalf("ProbeEnv -want +got:\n%s", diff)
+	}
+}
+
+func TestEnvStatusIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	rootDir := t.TempDir()
+	stg := Stage{EnvDeps: []string{"echo 1.0"}}
+
+	statuses, err := stg.EnvStatus(rootDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := statuses["echo 1.0"]
+	if status.Matches() {
+		t.Fatal("expected unrecorded env-dep not to match")
+	}
+	if diff := cmp.Diff("not recorded", status.String()); diff != "" {
+		t.Fatalf("String() -want +got:\n%s", diff)
+	}
+
+	stg.EnvState, err = stg.ProbeEnv(rootDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses, err = stg.EnvStatus(rootDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !statuses["echo 1.0"].Matches() {
+		t.Fatal("expected env-dep to match its recorded output")
+	}
+
+	stg.EnvState["echo 1.0"] = EnvProbe{Checksum: "123456789", Output: "0.9"}
+	statuses, err = stg.EnvStatus(rootDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status = statuses["echo 1.0"]
+	if status.Matches() {
+		t.Fatal("expected changed env-dep not to match")
+	}
+	if diff := cmp.Diff("changed: 0.9 -> 1.0", status.String()); diff != "" {
+		t.Fatalf("String() -want +got:\n%s", diff)
+	}
+}
@@ -0,0 +1,79 @@
+package stage
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// frozenLine matches a top-level 'frozen' key in a Stage file, along with any
+// trailing comment.
+var frozenLine = regexp.MustCompile(`^frozen:[^#]*(#.*)?$`)
+
+// SetFrozen sets the Frozen flag in the Stage file at stagePath. To preserve
+// the user's formatting and comments, the file is edited as text: an existing
+// top-level 'frozen' line is replaced or removed, or a new line is appended.
+func SetFrozen(stagePath string, frozen bool) error {
+	errPrefix := "set frozen in " + stagePath
+	info, err := os.Stat(stagePath)
+	if err != nil {
+		return errors.Wrap(err, errPrefix)
+	}
+	data, err := os.ReadFile(stagePath)
+	if err != nil {
+		return errors.Wrap(err, errPrefix)
+	}
+	data, err = setFrozen(data, frozen)
+	if err != nil {
+		return errors.Wrap(err, errPrefix)
+	}
+	return errors.Wrap(os.WriteFile(stagePath, data, info.Mode().Perm()), errPrefix)
+}
+
+// setFrozen returns the Stage file contents in data with the Frozen flag set
+// accordingly. It verifies that the edited contents still parse and have the
+// desired flag.
+func setFrozen(data []byte, frozen bool) ([]byte, error) {
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	edited := make([][]byte, 0, len(lines)+1)
+	found := false
+	for _, line := range lines {
+		content := bytes.TrimRight(line, "\r\n")
+		match := frozenLine.FindSubmatch(content)
+		if match == nil {
+			edited = append(edited, line)
+			continue
+		}
+		found = true
+		if !frozen {
+			continue
+		}
+		newLine := []byte("frozen: true")
+		if comment := match[1]; len(comment) > 0 {
+			newLine = append(newLine, ' ')
+			newLine = append(newLine, comment...)
+		}
+		edited = append(edited, append(newLine, line[len(content):]...))
+	}
+	if frozen && !found {
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			edited = append(edited, []byte("\n"))
+		}
+		edited = append(edited, []byte("frozen: true\n"))
+	}
+	out := bytes.Join(edited, nil)
+
+	var stg Stage
+	decoder := yaml.NewDecoder(bytes.NewReader(out))
+	decoder.SetStrict(true)
+	if err := decoder.Decode(&stg); err != nil {
+		return nil, errors.Wrap(err, "edited stage file is invalid")
+	}
+	if stg.Frozen != frozen {
+		return nil, errors.New("unable to edit the 'frozen' field; please edit the file by hand")
+	}
+	return out, nil
+}
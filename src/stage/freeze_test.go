@@ -0,0 +1,101 @@
+package stage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSetFrozen(t *testing.T) {
+	tests := map[string]struct {
+		in     string
+		frozen bool
+		want   string
+	}{
+		"freeze appends flag": {
+			in:     "# Train the model.\ncommand: python train.py   # slow!\noutputs:\n  model.pkl:\n",
+			frozen: true,
+			want:   "# Train the model.\ncommand: python train.py   # slow!\noutputs:\n  model.pkl:\nfrozen: true\n",
+		},
+		"freeze adds missing newline": {
+			in:     "command: python train.py\noutputs:\n  model.pkl:",
+			frozen: true,
+			want:   "command: python train.py\noutputs:\n  model.pkl:\nfrozen: true\n",
+		},
+		"freeze replaces existing flag and keeps comment": {
+			in:     "frozen: false # see README\ncommand: python train.py\noutputs:\n  model.pkl:\n",
+			frozen: true,
+			want:   "frozen: true # see README\ncommand: python train.py\noutputs:\n  model.pkl:\n",
+		},
+		"freeze is idempotent": {
+			in:     "command: python train.py\nfrozen: true\noutputs:\n  model.pkl:\n",
+			frozen: true,
+			want:   "command: python train.py\nfrozen: true\noutputs:\n  model.pkl:\n",
+		},
+		"unfreeze removes flag": {
+			in:     "command: python train.py\nfrozen: true\noutputs:\n  model.pkl:\n",
+			frozen: false,
+			want:   "command: python train.py\noutputs:\n  model.pkl:\n",
+		},
+		"unfreeze without flag is a no-op": {
+			in:     "command: python train.py\noutputs:\n  model.pkl:\n",
+			frozen: false,
+			want:   "command: python train.py\noutputs:\n  model.pkl:\n",
+		},
+		"nested keys are ignored": {
+			in:     "command: python train.py\noutputs:\n  frozen:\n",
+			frozen: true,
+			want:   "command: python train.py\noutputs:\n  frozen:\nfrozen: true\n",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := setFrozen([]byte(test.in), test.frozen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, string(got)); diff != "" {
+				t.Fatalf("setFrozen -want +got:\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("error on flow-style stage file", func(t *testing.T) {
+		in := "{command: python train.py, frozen: true, outputs: {model.pkl: {}}}\n"
+		if _, err := setFrozen([]byte(in), false); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestSetFrozenIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	stagePath := filepath.Join(t.TempDir(), "stage.yaml")
+	contents := "command: python train.py\noutputs:\n  model.pkl:\n"
+	if err := os.WriteFile(stagePath, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetFrozen(stagePath, true); err != nil {
+		t.Fatal(err)
+	}
+	stg, err := FromFile(stagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stg.Frozen {
+		t.Fatal("expected stage to be frozen")
+	}
+	info, err := os.Stat(stagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("file mode = %v, want %v", info.Mode().Perm(), os.FileMode(0o600))
+	}
+}
@@ -0,0 +1,49 @@
+package stage
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// An ImportSource records where an imported Stage's Output comes from: an
+// Artifact committed in another Dud project.
+type ImportSource struct {
+	// Source is the path or git URL of the other project. Relative paths are
+	// relative to the project root.
+	Source string
+	// Rev is the git revision of the other project to import from. If empty,
+	// local projects are imported from their working tree, and git URLs from
+	// their default branch.
+	Rev string `yaml:",omitempty" json:",omitempty"`
+	// Commit is the git commit the Artifact was last imported from. Like
+	// Artifact checksums, it is excluded from the Stage's Checksum.
+	Commit string `yaml:",omitempty" json:",omitempty"`
+	// Path is the path of the Artifact in the other project. It may be a Stage
+	// output or a path inside a directory output.
+	Path string
+}
+
+func (stg Stage) validateImport() error {
+	imp := stg.Import
+	if imp.Source == "" {
+		return errors.New("import: source is empty")
+	}
+	if imp.Path == "" {
+		return errors.New("import: path is empty")
+	}
+	if filepath.IsAbs(imp.Path) || strings.Contains(imp.Path, "..") {
+		return errors.New("import: path must be inside the source project")
+	}
+	if stg.Command != "" {
+		return errors.New("import stages cannot have a command")
+	}
+	if len(stg.Inputs) > 0 {
+		return errors.New("import stages cannot have inputs")
+	}
+	if len(stg.Outputs) != 1 {
+		return errors.New("import stages must have exactly one output")
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package stage
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/artifact"
+)
+
+func TestImport(t *testing.T) {
+	newStage := func() Stage {
+		return Stage{
+			Import: &ImportSource{
+				Source: "../upstream",
+				Rev:    "main",
+				Commit: "abc123",
+				Path:   "data/clean",
+			},
+			Outputs: map[string]*artifact.Artifact{
+				"clean": {Path: "clean", Checksum: "123", IsDir: true},
+			},
+		}
+	}
+
+	t.Run("decodes and cleans an import stage file", func(t *testing.T) {
+		data := []byte(
+			"import:\n  source: ../upstream\n  rev: main\n  commit: abc123\n  path: ./data/clean/\n" +
+				"outputs:\n  clean:\n    checksum: \"123\"\n    is-dir: true\n",
+		)
+		stg, err := FromBytes("clean.yaml", data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := newStage()
+		want.WorkingDir = "."
+		want.Inputs = map[string]*artifact.Artifact{}
+		if diff := cmp.Diff(want, stg); diff != "" {
+			t.Fatalf("Stage -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		tests := map[string]func(*Stage){
+			"empty source": func(stg *Stage) { stg.Import.Source = "" },
+			"empty path":   func(stg *Stage) { stg.Import.Path = "" },
+			"path outside source": func(stg *Stage) {
+				stg.Import.Path = "../secret"
+			},
+			"command": func(stg *Stage) { stg.Command = "echo hi" },
+			"inputs":  func(stg *Stage) { stg.Inputs = map[string]*artifact.Artifact{"in": {Path: "in"}} },
+			"outputs": func(stg *Stage) {
+				stg.Outputs["other"] = &artifact.Artifact{Path: "other"}
+			},
+		}
+		if err := newStage().Validate("clean.yaml"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for name, modify := range tests {
+			t.Run(name, func(t *testing.T) {
+				stg := newStage()
+				modify(&stg)
+				if err := stg.Validate("clean.yaml"); err == nil {
+					t.Fatal("expected error")
+				}
+			})
+		}
+	})
+
+	t.Run("commit does not affect checksum", func(t *testing.T) {
+		stg := newStage()
+		want, err := stg.CalculateChecksum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stg.Import.Commit = "def456"
+		got, err := stg.CalculateChecksum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatal("changing the import commit should not have affected checksum")
+		}
+		if stg.Import.Commit != "def456" {
+			t.Fatal("calculating checksum changed the stage")
+		}
+	})
+
+	t.Run("rev affects checksum", func(t *testing.T) {
+		stg := newStage()
+		want, err := stg.CalculateChecksum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stg.Import.Rev = "v2"
+		got, err := stg.CalculateChecksum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got == want {
+			t.Fatal("changing the import rev should have affected checksum")
+		}
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		base := newStage()
+		ours := newStage()
+		ours.Import.Commit = "ours"
+		theirs := newStage()
+		theirs.Import.Commit = "theirs"
+		theirs.Import.Rev = "v2"
+
+		merged, err := Merge(base, ours, theirs, MergeTheirs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := &ImportSource{Source: "../upstream", Rev: "v2", Commit: "theirs", Path: "data/clean"}
+		if diff := cmp.Diff(want, merged.Import); diff != "" {
+			t.Fatalf("Import -want +got:\n%s", diff)
+		}
+
+		merged, err = Merge(base, ours, theirs, MergeUncommitted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if merged.Import.Commit != "" {
+			t.Fatalf("commit = %#v, want empty", merged.Import.Commit)
+		}
+
+		ours.Import.Rev = "v3"
+		if _, err := Merge(base, ours, theirs, MergeOurs); err == nil {
+			t.Fatal("expected a conflict")
+		}
+	})
+}
@@ -0,0 +1,267 @@
+package stage
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/kevin-hanselman/dud/src/artifact"
+)
+
+// A MergePolicy decides how Merge resolves an Artifact that was committed
+// differently on both sides of a merge.
+type MergePolicy string
+
+const (
+	// MergeOurs keeps our side's commit.
+	MergeOurs MergePolicy = "ours"
+	// MergeTheirs keeps their side's commit.
+	MergeTheirs MergePolicy = "theirs"
+	// MergeUncommitted marks the Artifact as uncommitted, so it must be
+	// re-run or re-committed after the merge.
+	MergeUncommitted MergePolicy = "uncommitted"
+)
+
+// ParseMergePolicy returns the MergePolicy named by policy.
+func ParseMergePolicy(policy string) (MergePolicy, error) {
+	switch p := MergePolicy(policy); p {
+	case MergeOurs, MergeTheirs, MergeUncommitted:
+		return p, nil
+	}
+	return "", fmt.Errorf(
+		"invalid merge policy %#v (want %#v, %#v, or %#v)",
+		policy,
+		MergeOurs,
+		MergeTheirs,
+		MergeUncommitted,
+	)
+}
+
+// A MergeConflictError lists the parts of a Stage definition that were changed
+// differently on both sides of a merge.
+type MergeConflictError struct {
+	Conflicts []string
+}
+
+func (err MergeConflictError) Error() string {
+	return "conflicting changes to " + strings.Join(err.Conflicts, ", ")
+}
+
+// commitState is the part of an Artifact recorded by committing it.
+type commitState struct {
+	Checksum string
+	Absent   bool
+}
+
+// Merge performs a three-way merge of two versions of a Stage, ours and
+// theirs, with their common ancestor base. Changes to the Stage definition
+// (its command, Artifacts, etc.) are merged as usual, and conflicting
+// changes are reported with a MergeConflictError. Changes to what was
+// committed (Artifact checksums and recorded environment state) never
+// conflict; if both sides committed different versions, policy decides the
+// outcome. The merged Stage keeps a Stage checksum only if its definition
+// matches that of the side the checksum came from.
+func Merge(base, ours, theirs Stage, policy MergePolicy) (merged Stage, err error) {
+	var conflicts []string
+	merged.Command = mergeField("command", base.Command, ours.Command, theirs.Command, &conflicts)
+	merged.WorkingDir = mergeField(
+		"working-dir",
+		base.WorkingDir,
+		ours.WorkingDir,
+		theirs.WorkingDir,
+		&conflicts,
+	)
+	merged.EnvDeps = mergeField("env-deps", base.EnvDeps, ours.EnvDeps, theirs.EnvDeps, &conflicts)
+	merged.After = mergeField("after", base.After, ours.After, theirs.After, &conflicts)
+	merged.AlwaysRun = mergeField(
+		"always-run",
+		base.AlwaysRun,
+		ours.AlwaysRun,
+		theirs.AlwaysRun,
+		&conflicts,
+	)
+	merged.Frozen = mergeField("frozen", base.Frozen, ours.Frozen, theirs.Frozen, &conflicts)
+	merged.Import = mergeImport(base.Import, ours.Import, theirs.Import, policy, &conflicts)
+
+	var ok bool
+	merged.EnvState, ok = merge3(base.EnvState, ours.EnvState, theirs.EnvState)
+	if !ok {
+		merged.EnvState = pickByPolicy(policy, ours.EnvState, theirs.EnvState, nil)
+	}
+
+	merged.Inputs = mergeArtifacts(
+		"input",
+		base.Inputs,
+		ours.Inputs,
+		theirs.Inputs,
+		policy,
+		&conflicts,
+	)
+	merged.Outputs = mergeArtifacts(
+		"output",
+		base.Outputs,
+		ours.Outputs,
+		theirs.Outputs,
+		policy,
+		&conflicts,
+	)
+
+	if len(conflicts) > 0 {
+		return merged, MergeConflictError{Conflicts: conflicts}
+	}
+
+	mergedSum, err := merged.CalculateChecksum()
+	if err != nil {
+		return merged, err
+	}
+	for _, side := range []Stage{ours, theirs} {
+		sideSum, err := side.CalculateChecksum()
+		if err != nil {
+			return merged, err
+		}
+		if sideSum == mergedSum {
+			merged.Checksum = side.Checksum
+			break
+		}
+	}
+	return merged, nil
+}
+
+func mergeArtifacts(
+	role string,
+	base, ours, theirs map[string]*artifact.Artifact,
+	policy MergePolicy,
+	conflicts *[]string,
+) map[string]*artifact.Artifact {
+	paths := make(map[string]bool)
+	for _, arts := range []map[string]*artifact.Artifact{base, ours, theirs} {
+		for path := range arts {
+			paths[path] = true
+		}
+	}
+	sortedPaths := make([]string, 0, len(paths))
+	for path := range paths {
+		sortedPaths = append(sortedPaths, path)
+	}
+	sort.Strings(sortedPaths)
+
+	merged := make(map[string]*artifact.Artifact, len(paths))
+	for _, path := range sortedPaths {
+		art, ok := merge3(
+			artifactDefinition(base[path]),
+			artifactDefinition(ours[path]),
+			artifactDefinition(theirs[path]),
+		)
+		if !ok {
+			*conflicts = append(*conflicts, fmt.Sprintf("%s %s", role, path))
+			continue
+		}
+		if art == nil {
+			continue // Removed by one side.
+		}
+		oursState, theirsState := artifactCommitState(ours[path]), artifactCommitState(theirs[path])
+		state, ok := merge3(artifactCommitState(base[path]), oursState, theirsState)
+		if !ok {
+			state = pickByPolicy(policy, oursState, theirsState, commitState{})
+		}
+		art.Checksum = state.Checksum
+		art.Absent = state.Absent
+		merged[path] = art
+	}
+	return merged
+}
+
+// mergeImport merges the ImportSources of a Stage. Like Artifact checksums,
+// the imported commit is part of what was committed, so it never conflicts.
+func mergeImport(base, ours, theirs *ImportSource, policy MergePolicy, conflicts *[]string) *ImportSource {
+	imp := mergeField(
+		"import",
+		importDefinition(base),
+		importDefinition(ours),
+		importDefinition(theirs),
+		conflicts,
+	)
+	if imp == nil {
+		return nil
+	}
+	oursCommit, theirsCommit := importCommit(ours), importCommit(theirs)
+	commit, ok := merge3(importCommit(base), oursCommit, theirsCommit)
+	if !ok {
+		commit = pickByPolicy(policy, oursCommit, theirsCommit, "")
+	}
+	imp.Commit = commit
+	return imp
+}
+
+// importDefinition returns a copy of imp without its commit, or nil if imp is
+// nil.
+func importDefinition(imp *ImportSource) *ImportSource {
+	if imp == nil {
+		return nil
+	}
+	def := *imp
+	def.Commit = ""
+	return &def
+}
+
+func importCommit(imp *ImportSource) string {
+	if imp == nil {
+		return ""
+	}
+	return imp.Commit
+}
+
+// artifactDefinition returns a copy of art without its commit state, or nil
+// if art is nil.
+func artifactDefinition(art *artifact.Artifact) *artifact.Artifact {
+	if art == nil {
+		return nil
+	}
+	def := *art
+	def.Checksum = ""
+	def.Absent = false
+	return &def
+}
+
+func artifactCommitState(art *artifact.Artifact) commitState {
+	if art == nil {
+		return commitState{}
+	}
+	return commitState{Checksum: art.Checksum, Absent: art.Absent}
+}
+
+// merge3 returns the result of merging a value changed on one or both sides of
+// a three-way merge. If both sides changed the value differently, ok is false.
+func merge3[T any](base, ours, theirs T) (result T, ok bool) {
+	switch {
+	case reflect.DeepEqual(ours, theirs):
+		return ours, true
+	case reflect.DeepEqual(base, ours):
+		return theirs, true
+	case reflect.DeepEqual(base, theirs):
+		return ours, true
+	}
+	return result, false
+}
+
+// mergeField merges a field of a Stage definition, recording name in conflicts
+// (and keeping our side) if both sides changed the field differently.
+func mergeField[T any](name string, base, ours, theirs T, conflicts *[]string) T {
+	result, ok := merge3(base, ours, theirs)
+	if !ok {
+		*conflicts = append(*conflicts, name)
+		return ours
+	}
+	return result
+}
+
+func pickByPolicy[T any](policy MergePolicy, ours, theirs, uncommitted T) T {
+	switch policy {
+	case MergeOurs:
+		return ours
+	case MergeTheirs:
+		return theirs
+	}
+	return uncommitted
+}
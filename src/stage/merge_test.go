@@ -0,0 +1,98 @@
+package stage
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/artifact"
+)
+
+func TestMerge(t *testing.T) {
+	// newStage returns a committed Stage with the given command and output
+	// checksum.
+	newStage := func(command, outChecksum string) Stage {
+		stg := Stage{
+			Command:    command,
+			WorkingDir: ".",
+			Inputs: map[string]*artifact.Artifact{
+				"in.csv": {Path: "in.csv", Checksum: "in", SkipCache: true},
+			},
+			Outputs: map[string]*artifact.Artifact{
+				"out.bin": {Path: "out.bin", Checksum: outChecksum},
+			},
+		}
+		var err error
+		stg.Checksum, err = stg.CalculateChecksum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stg
+	}
+
+	t.Run("conflicting checksums follow the policy", func(t *testing.T) {
+		base := newStage("train", "base")
+		ours := newStage("train", "ours")
+		theirs := newStage("train", "theirs")
+
+		for policy, want := range map[MergePolicy]string{
+			MergeOurs:        "ours",
+			MergeTheirs:      "theirs",
+			MergeUncommitted: "",
+		} {
+			merged, err := Merge(base, ours, theirs, policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := merged.Outputs["out.bin"].Checksum; got != want {
+				t.Fatalf("policy %s: checksum = %#v, want %#v", policy, got, want)
+			}
+			if merged.Checksum != base.Checksum {
+				t.Fatal("expected the stage checksum to be kept")
+			}
+		}
+	})
+
+	t.Run("one-sided changes merge cleanly", func(t *testing.T) {
+		base := newStage("train", "base")
+		ours := newStage("train --fast", "base")
+		theirs := newStage("train", "theirs")
+		theirs.Outputs["metrics.json"] = &artifact.Artifact{Path: "metrics.json", Checksum: "m"}
+		delete(theirs.Inputs, "in.csv")
+
+		merged, err := Merge(base, ours, theirs, MergeUncommitted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := Stage{
+			Command:    "train --fast",
+			WorkingDir: ".",
+			Inputs:     map[string]*artifact.Artifact{},
+			Outputs: map[string]*artifact.Artifact{
+				"out.bin":      {Path: "out.bin", Checksum: "theirs"},
+				"metrics.json": {Path: "metrics.json", Checksum: "m"},
+			},
+		}
+		// The merged definition matches neither side, so it isn't checksummed.
+		if diff := cmp.Diff(want, merged); diff != "" {
+			t.Fatalf("Merge -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("conflicting definitions are reported", func(t *testing.T) {
+		base := newStage("train", "base")
+		ours := newStage("train --fast", "base")
+		theirs := newStage("train --slow", "base")
+		theirs.Outputs["out.bin"].IsDir = true
+		ours.Outputs["out.bin"].Persist = true
+
+		_, err := Merge(base, ours, theirs, MergeUncommitted)
+		conflictErr, ok := err.(MergeConflictError)
+		if !ok {
+			t.Fatalf("expected a MergeConflictError, got %v", err)
+		}
+		want := []string{"command", "output out.bin"}
+		if diff := cmp.Diff(want, conflictErr.Conflicts); diff != "" {
+			t.Fatalf("conflicts -want +got:\n%s", diff)
+		}
+	})
+}
@@ -24,6 +24,10 @@ type Stage struct {
 	// checksums. This checksum is used to determine when a Stage definition
 	// has been modified by the user.
 	Checksum string `yaml:",omitempty"`
+	// EnvState records the output of each probe command in EnvDeps when the
+	// Stage was last committed. Like Artifact checksums, it is excluded from
+	// the Stage's Checksum.
+	EnvState map[string]EnvProbe `yaml:"env-state,omitempty" json:",omitempty"`
 	// Command is the string to be evaluated and executed by a shell.
 	Command string `yaml:",omitempty"`
 	// WorkingDir is the directory in which the Stage's command is executed. It
@@ -32,6 +36,23 @@ type Stage struct {
 	// directory. WorkingDir only affects the Stage's command; all inputs and
 	// outputs of the Stage should have paths relative to the project root.
 	WorkingDir string `yaml:"working-dir,omitempty"`
+	// EnvDeps is a list of probe commands (e.g. "python --version") whose
+	// output the Stage's outputs implicitly depend on. The Stage is
+	// out-of-date when any probe's output changes.
+	EnvDeps []string `yaml:"env-deps,omitempty" json:",omitempty"`
+	// After is a list of paths to Stages that must run before this Stage,
+	// even though this Stage doesn't consume any of their outputs.
+	After []string `yaml:",omitempty" json:",omitempty"`
+	// AlwaysRun marks a Stage whose command runs on every 'dud run', even if
+	// the Stage is up-to-date.
+	AlwaysRun bool `yaml:"always-run,omitempty" json:",omitempty"`
+	// Frozen marks a Stage that never runs automatically, even if it is
+	// out-of-date. It is excluded from the Stage's Checksum so freezing and
+	// unfreezing a Stage doesn't modify its definition.
+	Frozen bool `yaml:",omitempty" json:"-"`
+	// Import records the project an imported Stage's Output is copied from.
+	// Import Stages have no command; 'dud update' re-imports their Output.
+	Import *ImportSource `yaml:",omitempty" json:",omitempty"`
 	// Inputs is a set of Artifacts which the Stage's Command needs to
 	// operate. The Artifacts are keyed by their Path for faster lookup.
 	Inputs map[string]*artifact.Artifact `yaml:",omitempty"`
@@ -48,12 +69,25 @@ type Status struct {
 	// matches its Checksum field.
 	ChecksumMatches bool
 	ArtifactStatus  map[string]artifact.Status
+	// UpstreamInputsMatch maps the path of each input owned by another Stage
+	// to true if the input's checksum matches the checksum currently
+	// recorded by its owner, and false otherwise.
+	UpstreamInputsMatch map[string]bool
+	// EnvDepStatus maps each of the Stage's environment dependency probe
+	// commands to its status.
+	EnvDepStatus map[string]EnvDepStatus
+	// Frozen is true if the Stage is frozen.
+	Frozen bool
+	// AlwaysRun is true if the Stage runs on every 'dud run'.
+	AlwaysRun bool
 }
 
 // NewStatus initializes a new Status object.
 func NewStatus() Status {
 	s := Status{}
 	s.ArtifactStatus = make(map[string]artifact.Status)
+	s.UpstreamInputsMatch = make(map[string]bool)
+	s.EnvDepStatus = make(map[string]EnvDepStatus)
 	return s
 }
 
@@ -61,27 +95,35 @@ func (stg Stage) toFileFormat() (out Stage) {
 	out.Checksum = stg.Checksum
 	out.Command = stg.Command
 	out.WorkingDir = stg.WorkingDir
+	out.EnvDeps = stg.EnvDeps
+	out.EnvState = stg.EnvState
+	out.After = stg.After
+	out.AlwaysRun = stg.AlwaysRun
+	out.Frozen = stg.Frozen
+	out.Import = stg.Import
 
 	if len(stg.Inputs) > 0 {
 		out.Inputs = make(map[string]*artifact.Artifact, len(stg.Inputs))
-		for _, art := range stg.Inputs {
+		for _, inArt := range stg.Inputs {
+			// Copy the Artifact; the Stage's own Artifacts must not be
+			// modified.
+			art := *inArt
 			// SkipCache is implicitly true for all inputs. It's
 			// redundant and noisy to write it to the Stage file, so we hide
 			// it (making use of the 'omitempty' YAML directive) and set
 			// SkipCache to true when loading the file (see FromFile).
 			art.SkipCache = false
-			path := art.Path
 			art.Path = ""
-			out.Inputs[path] = art
+			out.Inputs[inArt.Path] = &art
 		}
 	}
 
 	if len(stg.Outputs) > 0 {
 		out.Outputs = make(map[string]*artifact.Artifact, len(stg.Outputs))
-		for _, art := range stg.Outputs {
-			path := art.Path
+		for _, outArt := range stg.Outputs {
+			art := *outArt
 			art.Path = ""
-			out.Outputs[path] = art
+			out.Outputs[outArt.Path] = &art
 		}
 	}
 	return
@@ -93,10 +135,13 @@ var fromYamlFile = func(path string, stg *Stage) error {
 		return err
 	}
 	defer file.Close()
-	decoder := yaml.NewDecoder(file)
+	return decodeYaml(file, path, stg)
+}
+
+func decodeYaml(reader io.Reader, path string, stg *Stage) error {
+	decoder := yaml.NewDecoder(reader)
 	decoder.SetStrict(true)
-	err = decoder.Decode(stg)
-	if err != nil {
+	if err := decoder.Decode(stg); err != nil {
 		return errors.Wrap(err, path)
 	}
 	return nil
@@ -108,8 +153,36 @@ func FromFile(stagePath string) (stg Stage, err error) {
 	if err = fromYamlFile(stagePath, &tempStage); err != nil {
 		return
 	}
+	return fromFileFormat(stagePath, tempStage)
+}
+
+// FromBytes loads a Stage from the contents of a stage file, such as a stage
+// file read from a past git revision. stagePath is used for validation and
+// error messages only.
+func FromBytes(stagePath string, data []byte) (stg Stage, err error) {
+	var tempStage Stage
+	if err = decodeYaml(bytes.NewReader(data), stagePath, &tempStage); err != nil {
+		return
+	}
+	return fromFileFormat(stagePath, tempStage)
+}
+
+// fromFileFormat converts a Stage as decoded from a stage file into its
+// in-memory form. It is the inverse of toFileFormat.
+func fromFileFormat(stagePath string, tempStage Stage) (stg Stage, err error) {
 	stg.Checksum = tempStage.Checksum
 	stg.Command = strings.TrimSpace(tempStage.Command)
+	stg.EnvDeps = tempStage.EnvDeps
+	stg.EnvState = tempStage.EnvState
+	stg.AlwaysRun = tempStage.AlwaysRun
+	stg.Frozen = tempStage.Frozen
+	stg.Import = tempStage.Import
+	if stg.Import != nil && stg.Import.Path != "" {
+		stg.Import.Path = filepath.Clean(stg.Import.Path)
+	}
+	for _, path := range tempStage.After {
+		stg.After = append(stg.After, filepath.Clean(path))
+	}
 	stg.Inputs = make(map[string]*artifact.Artifact, len(stg.Inputs))
 	stg.Outputs = make(map[string]*artifact.Artifact, len(stg.Outputs))
 
@@ -161,6 +234,34 @@ func (stg Stage) Validate(stagePath string) error {
 	if len(stg.Outputs)+len(stg.Command) == 0 {
 		return errors.New("declared no outputs and no command")
 	}
+	if stg.Import != nil {
+		if err := stg.validateImport(); err != nil {
+			return err
+		}
+	}
+	envDeps := make(map[string]bool, len(stg.EnvDeps))
+	for _, probeCmd := range stg.EnvDeps {
+		if strings.TrimSpace(probeCmd) == "" {
+			return errors.New("env-dep is empty")
+		}
+		if envDeps[probeCmd] {
+			return fmt.Errorf("env-dep %q is declared more than once", probeCmd)
+		}
+		envDeps[probeCmd] = true
+	}
+	after := make(map[string]bool, len(stg.After))
+	for _, path := range stg.After {
+		if path == "" {
+			return errors.New("after: stage path is empty")
+		}
+		if path == stagePath {
+			return errors.New("stage references itself in after")
+		}
+		if after[path] {
+			return fmt.Errorf("after: stage %s is listed more than once", path)
+		}
+		after[path] = true
+	}
 
 	// First, check for direct overlap between Outputs and Inputs.
 	// Consolidate all Artifacts into a single map to facilitate the next step.
@@ -182,6 +283,9 @@ func (stg Stage) Validate(stagePath string) error {
 		if artPath == stagePath {
 			return errors.New("stage references itself in inputs")
 		}
+		if field := outputOnlyField(art); field != "" {
+			return fmt.Errorf("input %s: %s only applies to outputs", artPath, field)
+		}
 		allArtifacts[artPath] = art
 	}
 
@@ -206,6 +310,22 @@ func (stg Stage) Validate(stagePath string) error {
 	return nil
 }
 
+// outputOnlyField returns the name of the first field set in the Artifact that
+// only applies to Stage outputs, or an empty string if there are none.
+func outputOnlyField(art *artifact.Artifact) string {
+	switch {
+	case art.Persist:
+		return "persist"
+	case art.Optional:
+		return "optional"
+	case art.Absent:
+		return "absent"
+	case art.Validate != "":
+		return "validate"
+	}
+	return ""
+}
+
 // Serialize writes a Stage to the given writer.
 func (stg *Stage) Serialize(writer io.Writer) error {
 	return yaml.NewEncoder(writer).Encode(stg.toFileFormat())
@@ -233,6 +353,14 @@ func (stg Stage) CalculateChecksum() (string, error) {
 	cleanStage := Stage{
 		Command:    stg.Command,
 		WorkingDir: stg.WorkingDir,
+		EnvDeps:    stg.EnvDeps,
+		After:      stg.After,
+		AlwaysRun:  stg.AlwaysRun,
+	}
+	if stg.Import != nil {
+		cleanImport := *stg.Import
+		cleanImport.Commit = ""
+		cleanStage.Import = &cleanImport
 	}
 	cleanStage.Inputs = make(map[string]*artifact.Artifact, len(stg.Inputs))
 	for _, art := range stg.Inputs {
@@ -244,6 +372,7 @@ func (stg Stage) CalculateChecksum() (string, error) {
 	for _, art := range stg.Outputs {
 		newArt := *art
 		newArt.Checksum = ""
+		newArt.Absent = false
 		cleanStage.Outputs[art.Path] = &newArt
 	}
 	// We can't use encoding/gob here because maps aren't serialized in
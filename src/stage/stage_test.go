@@ -370,6 +370,95 @@ func TestFromFile(t *testing.T) {
 		}
 	})
 
+	t.Run("disallow duplicate env-deps", func(t *testing.T) {
+		defer resetFromYamlFileMock()
+		stageFile := Stage{
+			Command: "python train.py",
+			EnvDeps: []string{"python --version", "python --version"},
+			Outputs: map[string]*artifact.Artifact{
+				"model.bin": {},
+			},
+		}
+
+		fromYamlFile = func(path string, output *Stage) error {
+			if path == "stage.yaml" {
+				*output = stageFile
+				return nil
+			}
+			return os.ErrNotExist
+		}
+
+		err := fromFileErr("stage.yaml")
+		if err == nil {
+			t.Fatal("expected FromFile to return error")
+		}
+
+		expectedError := `env-dep "python --version" is declared more than once`
+		if diff := cmp.Diff(expectedError, err.Error()); diff != "" {
+			t.Fatalf("error -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("disallow after referencing the stage itself", func(t *testing.T) {
+		defer resetFromYamlFileMock()
+		stageFile := Stage{
+			Command: "python train.py",
+			After:   []string{"./stage.yaml"},
+			Outputs: map[string]*artifact.Artifact{
+				"model.bin": {},
+			},
+		}
+
+		fromYamlFile = func(path string, output *Stage) error {
+			if path == "stage.yaml" {
+				*output = stageFile
+				return nil
+			}
+			return os.ErrNotExist
+		}
+
+		err := fromFileErr("stage.yaml")
+		if err == nil {
+			t.Fatal("expected FromFile to return error")
+		}
+
+		expectedError := "stage references itself in after"
+		if diff := cmp.Diff(expectedError, err.Error()); diff != "" {
+			t.Fatalf("error -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("disallow output-only fields on inputs", func(t *testing.T) {
+		defer resetFromYamlFileMock()
+		stageFile := Stage{
+			Command: "python train.py",
+			Inputs: map[string]*artifact.Artifact{
+				"checkpoint.bin": {Optional: true},
+			},
+			Outputs: map[string]*artifact.Artifact{
+				"model.bin": {},
+			},
+		}
+
+		fromYamlFile = func(path string, output *Stage) error {
+			if path == "stage.yaml" {
+				*output = stageFile
+				return nil
+			}
+			return os.ErrNotExist
+		}
+
+		err := fromFileErr("stage.yaml")
+		if err == nil {
+			t.Fatal("expected FromFile to return error")
+		}
+
+		expectedError := "input checkpoint.bin: optional only applies to outputs"
+		if diff := cmp.Diff(expectedError, err.Error()); diff != "" {
+			t.Fatalf("error -want +got:\n%s", diff)
+		}
+	})
+
 	t.Run("stage files cannot reference themselves", func(t *testing.T) {
 		defer resetFromYamlFileMock()
 		stageFile := Stage{
@@ -416,3 +505,32 @@ func TestFromFile(t *testing.T) {
 		}
 	})
 }
+
+func TestFromBytes(t *testing.T) {
+	t.Run("decodes and normalizes a stage file", func(t *testing.T) {
+		data := []byte("command: echo hi\ninputs:\n  ./in.txt:\noutputs:\n  out.txt: {}\n")
+		stg, err := FromBytes("stage.yaml", data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := Stage{
+			Command:    "echo hi",
+			WorkingDir: ".",
+			Inputs: map[string]*artifact.Artifact{
+				"in.txt": {Path: "in.txt", SkipCache: true},
+			},
+			Outputs: map[string]*artifact.Artifact{
+				"out.txt": {Path: "out.txt"},
+			},
+		}
+		if diff := cmp.Diff(want, stg); diff != "" {
+			t.Fatalf("Stage -want +got:\n%s", diff)
+		}
+	})
+
+	t.Run("rejects unknown fields", func(t *testing.T) {
+		if _, err := FromBytes("stage.yaml", []byte("bogus: true\n")); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
@@ -0,0 +1,149 @@
+// Package tag records named versions of committed Artifacts.
+package tag
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/kevin-hanselman/dud/src/artifact"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// A Tag is a named set of committed Artifacts, grouped by the Stages that own
+// them. Tags are stored as YAML files, one per Tag, which may be committed to
+// git alongside the Stage files.
+type Tag struct {
+	// Name is the Tag's name. It is stored as the name of the Tag's file, not
+	// in the file itself.
+	Name string `yaml:"-"`
+	// Message is an optional description of the Tag.
+	Message string `yaml:",omitempty"`
+	// Stages maps Stage paths to the Stage's tagged Outputs, which are keyed
+	// by Artifact path.
+	Stages map[string]map[string]*artifact.Artifact
+}
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ValidateName returns an error if name can't be used as a Tag name. Tag
+// names start with a letter or digit, and contain only letters, digits,
+// dots, dashes, and underscores.
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf(
+			"invalid tag name %#v: tag names must start with a letter or digit, "+
+				"and contain only letters, digits, '.', '-', and '_'",
+			name,
+		)
+	}
+	return nil
+}
+
+// Path returns the path of the file for the named Tag in dir.
+func Path(dir, name string) string {
+	return filepath.Join(dir, name+".yaml")
+}
+
+// Exists returns true if the named Tag exists in dir.
+func Exists(dir, name string) (bool, error) {
+	_, err := os.Stat(Path(dir, name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// FromFile loads the named Tag from dir.
+func FromFile(dir, name string) (t Tag, err error) {
+	errPrefix := "load tag " + name
+	if err := ValidateName(name); err != nil {
+		return t, errors.Wrap(err, errPrefix)
+	}
+	data, err := os.ReadFile(Path(dir, name))
+	if os.IsNotExist(err) {
+		return t, fmt.Errorf("%s: no such tag", errPrefix)
+	}
+	if err != nil {
+		return t, errors.Wrap(err, errPrefix)
+	}
+	if err := yaml.Unmarshal(data, &t); err != nil {
+		return t, errors.Wrap(err, errPrefix)
+	}
+	t.Name = name
+	for _, arts := range t.Stages {
+		for artPath, art := range arts {
+			if art == nil {
+				return t, fmt.Errorf("%s: artifact %s has no checksum", errPrefix, artPath)
+			}
+			art.Path = filepath.Clean(artPath)
+		}
+	}
+	return t, nil
+}
+
+// ToFile writes the Tag to its file in dir, creating dir if needed.
+func (t Tag) ToFile(dir string) error {
+	errPrefix := "write tag " + t.Name
+	if err := ValidateName(t.Name); err != nil {
+		return errors.Wrap(err, errPrefix)
+	}
+	// Artifact paths are stored as map keys; omit the redundant Path fields.
+	out := t
+	out.Stages = make(map[string]map[string]*artifact.Artifact, len(t.Stages))
+	for stagePath, arts := range t.Stages {
+		out.Stages[stagePath] = make(map[string]*artifact.Artifact, len(arts))
+		for artPath, art := range arts {
+			artCopy := *art
+			artCopy.Path = ""
+			out.Stages[stagePath][artPath] = &artCopy
+		}
+	}
+	data, err := yaml.Marshal(out)
+	if err != nil {
+		return errors.Wrap(err, errPrefix)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return errors.Wrap(err, errPrefix)
+	}
+	return errors.Wrap(os.WriteFile(Path(dir, t.Name), data, 0o644), errPrefix)
+}
+
+// Delete removes the named Tag from dir.
+func Delete(dir, name string) error {
+	errPrefix := "delete tag " + name
+	if err := ValidateName(name); err != nil {
+		return errors.Wrap(err, errPrefix)
+	}
+	err := os.Remove(Path(dir, name))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s: no such tag", errPrefix)
+	}
+	return errors.Wrap(err, errPrefix)
+}
+
+// List returns the sorted names of the Tags in dir. If dir doesn't exist,
+// there are no Tags.
+func List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "list tags")
+	}
+	var names []string
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".yaml")
+		if entry.IsDir() || name == entry.Name() || ValidateName(name) != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,87 @@
+package tag
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kevin-hanselman/dud/src/artifact"
+)
+
+func TestValidateName(t *testing.T) {
+	for _, name := range []string{"v3", "dataset-v3", "2024.01_final"} {
+		if err := ValidateName(name); err != nil {
+			t.Errorf("ValidateName(%#v): %v", name, err)
+		}
+	}
+	for _, name := range []string{"", ".hidden", "-v3", "data/v3", "v 3", "../v3"} {
+		if err := ValidateName(name); err == nil {
+			t.Errorf("ValidateName(%#v): expected error", name)
+		}
+	}
+}
+
+func TestFileRoundTripIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	dir := filepath.Join(t.TempDir(), "tags")
+
+	names, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Fatalf("expected no tags, got %v", names)
+	}
+
+	want := Tag{
+		Name:    "v3",
+		Message: "third version",
+		Stages: map[string]map[string]*artifact.Artifact{
+			"prep.yaml": {
+				"data": {Path: "data", IsDir: true, Checksum: "data_checksum"},
+			},
+		},
+	}
+	if err := want.ToFile(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := (Tag{Name: "v1"}).ToFile(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte("not a tag"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := FromFile(dir, "v3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("FromFile -want +got:\n%s", diff)
+	}
+
+	names, err = List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"v1", "v3"}, names); diff != "" {
+		t.Fatalf("List -want +got:\n%s", diff)
+	}
+
+	if err := Delete(dir, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := Exists(dir, "v1"); err != nil || exists {
+		t.Fatalf("Exists() = %v, %v after Delete", exists, err)
+	}
+	if err := Delete(dir, "v1"); err == nil {
+		t.Fatal("expected error deleting a missing tag")
+	}
+	if _, err := FromFile(dir, "v1"); err == nil {
+		t.Fatal("expected error loading a missing tag")
+	}
+}